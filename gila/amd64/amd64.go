@@ -0,0 +1,517 @@
+// Package amd64 translates programs to x86-64 assembly for the GNU assembler, so they can be built without LLVM.
+// Functions follow the System V ABI and the generated code is linked with the runtime library in fce.c.
+//
+// Code is generated straight from the ast. Every value is 64 bits wide: integers are kept extended
+// according to the sign of their type, reals are stored as their bits and booleans are 0 or 1.
+// Sets are 32 bytes in memory with the layout of large sets in the ir package, so fce.c can operate on them.
+//
+// Register allocation is naive: expressions are evaluated on an operand stack, whose first entries live
+// in callee-saved registers and the rest in the stack frame. Values on the operand stack survive calls,
+// so they never have to be saved.
+package amd64
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"strings"
+)
+
+// Options change the generated code.
+type Options struct {
+	// OverflowChecks stop the program on integer overflow, the same way as ir.Options do.
+	// Division by zero is always reported.
+	OverflowChecks bool
+}
+
+// pool are the registers, that hold the top of the operand stack. They are saved by every function.
+var pool = []string{"%rbx", "%r12", "%r13", "%r14", "%r15"}
+
+// savedSize is the size of the area below the frame pointer, which holds the registers of the pool.
+const savedSize = 8 * 5
+
+// setSize is the size of a set in bytes.
+const setSize = 32
+
+// runtimeNames are the functions of fce.c. Functions of the program with these names are renamed.
+var runtimeNames = map[string]bool{
+	"write_integer": true, "write_real": true, "write_boolean": true, "write_string": true, "write_newline": true,
+	"read_integer": true, "read_real": true, "read_newline": true, "runtime_error": true,
+	"set_include": true, "set_in": true, "set_union": true, "set_intersection": true, "set_difference": true,
+	"set_equal": true, "set_subset": true,
+}
+
+// symbol is a variable or a constant visible in the function being generated.
+type symbol struct {
+	// offset of the variable from the frame pointer
+	offset   int64
+	t        ast.Type
+	constant bool
+	value    int64
+}
+
+type scope struct {
+	parent  *scope
+	symbols map[string]*symbol
+}
+
+func (s *scope) lookup(name string) *symbol {
+	if v, ok := s.symbols[name]; ok {
+		return v
+	} else if s.parent != nil {
+		return s.parent.lookup(name)
+	}
+	return nil
+}
+
+type generator struct {
+	info      *checker.Info
+	options   Options
+	functions map[string]*ast.Function
+	// strings maps string literals to their labels
+	strings map[string]string
+	data    strings.Builder
+	labels  int
+
+	// The function being generated
+	function *ast.Function
+	code     strings.Builder
+	scope    *scope
+	// frame is the size of the variables and temporaries of the function
+	frame int64
+	// slots are temporaries of the operand stack, keyed by their kind and depth
+	slots map[string]int64
+	// depth is the number of values on the operand stack
+	depth int
+	// outgoing is the size of the stack arguments of calls made by the function
+	outgoing int64
+	// breaks are the labels after the enclosing loops
+	breaks []string
+	// exit is the label of the epilogue
+	exit string
+	// errors are the code, that reports runtime errors, emitted after the function
+	errors strings.Builder
+	result *symbol
+	// resultPointer holds the address, where a set result is returned
+	resultPointer int64
+}
+
+// Generate type checks a program and translates it into an assembly file. Errors in the program panic.
+func Generate(program *ast.Program, options Options) string {
+	if program.Library {
+		panic(fmt.Sprintf("Library %s can only be compiled by the llvm backend.", program.Name))
+	}
+	if program.Unit || len(program.Uses) > 0 {
+		panic(fmt.Sprintf("Units can only be compiled by the llvm backend, %s is a unit or uses units.", program.Name))
+	}
+	g := &generator{
+		info:      checker.Check(program),
+		options:   options,
+		functions: make(map[string]*ast.Function),
+		strings:   make(map[string]string),
+	}
+	var order []string
+	for _, f := range program.Functions {
+		name := f.Signature.Name
+		if _, ok := g.functions[name]; !ok {
+			order = append(order, name)
+		}
+		if f.Signature.External {
+			panic(fmt.Sprintf("External function %s is only supported by the llvm backend.", f.Signature.Name))
+		}
+		// Forward declarations are replaced by implementations
+		if f.Body != nil || g.functions[name] == nil {
+			g.functions[name] = f
+		}
+	}
+	var out strings.Builder
+	out.WriteString("\t.text\n")
+	for _, name := range order {
+		f := g.functions[name]
+		if f.Body == nil {
+			panic(fmt.Sprintf("Function %s is declared, but never implemented.", name))
+		}
+		out.WriteString(g.generateFunction(f))
+	}
+	if g.data.Len() > 0 {
+		out.WriteString("\t.section .rodata\n")
+		out.WriteString(g.data.String())
+	}
+	out.WriteString("\t.section .note.GNU-stack,\"\",@progbits\n")
+	return out.String()
+}
+
+// symbolName is the name of a function in the assembly.
+func symbolName(name string) string {
+	if runtimeNames[name] {
+		return name + "_"
+	}
+	return name
+}
+
+func (g *generator) emit(format string, args ...interface{}) {
+	g.code.WriteString("\t")
+	fmt.Fprintf(&g.code, format, args...)
+	g.code.WriteString("\n")
+}
+
+func (g *generator) label(l string) {
+	g.code.WriteString(l + ":\n")
+}
+
+func (g *generator) newLabel() string {
+	g.labels++
+	return fmt.Sprintf(".L%d", g.labels)
+}
+
+// memory returns the address of a slot in the frame.
+func memory(offset int64) string {
+	return fmt.Sprintf("%d(%%rbp)", offset)
+}
+
+// allocate reserves space in the frame and returns its offset from the frame pointer.
+func (g *generator) allocate(size int64) int64 {
+	g.frame += size
+	return -savedSize - g.frame
+}
+
+// slot returns the offset of a temporary, which is allocated the first time it is used.
+func (g *generator) slot(name string, size int64) int64 {
+	if offset, ok := g.slots[name]; ok {
+		return offset
+	}
+	offset := g.allocate(size)
+	g.slots[name] = offset
+	return offset
+}
+
+// operand returns the location of the scalar value at depth d of the operand stack.
+func (g *generator) operand(d int) string {
+	if d < len(pool) {
+		return pool[d]
+	}
+	return memory(g.slot(fmt.Sprintf("spill%d", d), 8))
+}
+
+// setOperand returns the offset of the set value at depth d of the operand stack.
+func (g *generator) setOperand(d int) int64 {
+	return g.slot(fmt.Sprintf("set%d", d), setSize)
+}
+
+// push adds a value to the operand stack and returns its depth.
+func (g *generator) push() int {
+	g.depth++
+	return g.depth - 1
+}
+
+func (g *generator) pop(n int) {
+	g.depth -= n
+}
+
+func (g *generator) top() int {
+	return g.depth - 1
+}
+
+func isMemory(location string) bool {
+	return strings.HasSuffix(location, ")")
+}
+
+// move copies 64 bits between registers and memory. Moves between two memory locations go through %rax.
+func (g *generator) move(from, to string) {
+	if from == to {
+		return
+	}
+	if isMemory(from) && isMemory(to) {
+		g.emit("movq %s, %%rax", from)
+		from = "%rax"
+	}
+	g.emit("movq %s, %s", from, to)
+}
+
+// immediate moves a constant into a location.
+func (g *generator) immediate(x int64, to string) {
+	if x >= -1<<31 && x < 1<<31 {
+		g.emit("movq $%d, %s", x, to)
+		return
+	}
+	g.emit("movabsq $%d, %%rax", x)
+	g.move("%rax", to)
+}
+
+// copySet copies a set between two places in memory, given by their addresses.
+func (g *generator) copySet(from, to func(word int) string) {
+	for i := 0; i < setSize/8; i++ {
+		g.emit("movq %s, %%rax", from(i))
+		g.emit("movq %%rax, %s", to(i))
+	}
+}
+
+// frameWord returns the address of the i-th word of a set in the frame.
+func frameWord(offset int64) func(int) string {
+	return func(i int) string {
+		return memory(offset + int64(8*i))
+	}
+}
+
+func (g *generator) generateFunction(f *ast.Function) string {
+	g.function, g.frame, g.depth, g.outgoing, g.breaks = f, 0, 0, 0, nil
+	g.slots = make(map[string]int64)
+	g.code.Reset()
+	g.errors.Reset()
+	g.scope = &scope{symbols: make(map[string]*symbol)}
+	g.exit = g.newLabel()
+	g.result = nil
+	if f.Signature.Return != ast.VOID {
+		// The result is assigned to a variable named after the function
+		g.result = g.declare(f.Signature.Name, f.Signature.Return)
+	}
+	g.receiveParameters(f.Signature)
+	g.statement(f.Body)
+
+	var out strings.Builder
+	name := symbolName(f.Signature.Name)
+	if name == "main" {
+		out.WriteString("\t.globl main\n")
+	}
+	fmt.Fprintf(&out, "\t.type %s, @function\n%s:\n", name, name)
+	// The frame keeps the stack aligned to 16 bytes for calls
+	size := (savedSize + g.frame + g.outgoing + 15) / 16 * 16
+	fmt.Fprintf(&out, "\tpushq %%rbp\n\tmovq %%rsp, %%rbp\n\tsubq $%d, %%rsp\n", size)
+	for i, r := range pool {
+		fmt.Fprintf(&out, "\tmovq %s, %d(%%rbp)\n", r, -8*(i+1))
+	}
+	out.WriteString(g.code.String())
+	g.code.Reset()
+	g.label(g.exit)
+	g.returnResult()
+	for i, r := range pool {
+		g.emit("movq %d(%%rbp), %s", -8*(i+1), r)
+	}
+	g.emit("leave")
+	g.emit("ret")
+	out.WriteString(g.code.String())
+	out.WriteString(g.errors.String())
+	fmt.Fprintf(&out, "\t.size %s, .-%s\n", name, name)
+	g.function, g.scope = nil, nil
+	return out.String()
+}
+
+// declare allocates a variable, which is zero until it is assigned.
+func (g *generator) declare(name string, t ast.Type) *symbol {
+	s := g.variable(name, t)
+	size := int64(8)
+	if isSet(t) {
+		size = setSize
+	}
+	for i := int64(0); i < size; i += 8 {
+		g.emit("movq $0, %s", memory(s.offset+i))
+	}
+	return s
+}
+
+// variable allocates a variable without initializing it.
+func (g *generator) variable(name string, t ast.Type) *symbol {
+	s := &symbol{t: t}
+	if isSet(t) {
+		s.offset = g.allocate(setSize)
+	} else {
+		s.offset = g.allocate(8)
+	}
+	g.scope.symbols[name] = s
+	return s
+}
+
+func isSet(t ast.Type) bool {
+	_, ok := t.(ast.Set)
+	return ok
+}
+
+func (g *generator) lookup(name string) *symbol {
+	if s := g.scope.lookup(name); s != nil {
+		return s
+	}
+	panic(fmt.Sprintf("Undefined symbol %s.", name))
+}
+
+func (g *generator) statement(node ast.Statement) {
+	switch n := node.(type) {
+	case *ast.Block:
+		g.scope = &scope{parent: g.scope, symbols: make(map[string]*symbol)}
+		for _, s := range n.Statements {
+			g.statement(s)
+		}
+		g.scope = g.scope.parent
+	case *ast.VariableDeclaration:
+		g.declare(n.Name, n.Type)
+	case *ast.ConstantDeclaration:
+		// Constants are replaced by their values, integer constants can still be referred to by name
+		if l, ok := n.Value.(*ast.Literal); ok {
+			g.scope.symbols[n.Name] = &symbol{t: g.info.TypeOf(l), constant: true, value: l.Value}
+		}
+	case *ast.Assignment:
+		g.assign(n)
+	case *ast.ProcedureCall:
+		g.procedureCall(n)
+	case *ast.If:
+		otherwise := g.newLabel()
+		g.condition(n.Condition, otherwise)
+		g.statement(n.Then)
+		if n.Else != nil {
+			end := g.newLabel()
+			g.emit("jmp %s", end)
+			g.label(otherwise)
+			g.statement(n.Else)
+			g.label(end)
+		} else {
+			g.label(otherwise)
+		}
+	case *ast.While:
+		g.loop(func(end string) { g.condition(n.Condition, end) }, n.Body, nil)
+	case *ast.For:
+		g.assign(n.Initial)
+		variable := g.lookup(n.Initial.Variable.Name)
+		step := int64(-1)
+		if n.Upto {
+			step = 1
+		}
+		// The loop runs while the variable differs from the target, the same way as in the ir package.
+		// Integers of all types are extended to 64 bits, so they can be compared directly.
+		condition := func(end string) {
+			d := g.push()
+			g.load(variable, d)
+			g.expression(n.Target)
+			g.emit("movq %s, %%rax", g.operand(d))
+			g.emit("cmpq %s, %%rax", g.operand(d+1))
+			g.pop(2)
+			g.emit("je %s", end)
+		}
+		update := func() {
+			g.emit("movq %s, %%rax", memory(variable.offset))
+			g.emit("addq $%d, %%rax", step)
+			g.normalize(variable.t)
+			g.emit("movq %%rax, %s", memory(variable.offset))
+		}
+		g.loop(condition, n.Body, update)
+	case *ast.Break:
+		// break outside of a loop does nothing
+		if len(g.breaks) > 0 {
+			g.emit("jmp %s", g.breaks[len(g.breaks)-1])
+		}
+	case *ast.Exit:
+		g.emit("jmp %s", g.exit)
+	default:
+		panic("Unknown statement type!")
+	}
+}
+
+// condition evaluates a boolean expression and jumps to label, when it is false.
+func (g *generator) condition(e ast.Expression, label string) {
+	d := g.depth
+	g.expression(e)
+	g.emit("cmpq $0, %s", g.operand(d))
+	g.pop(1)
+	g.emit("je %s", label)
+}
+
+// loop emits a loop. condition jumps to the label it is given, when the loop ends.
+// update is executed after every iteration, that didn't break.
+func (g *generator) loop(condition func(end string), body ast.Statement, update func()) {
+	top, end := g.newLabel(), g.newLabel()
+	g.label(top)
+	condition(end)
+	g.breaks = append(g.breaks, end)
+	g.statement(body)
+	g.breaks = g.breaks[:len(g.breaks)-1]
+	if update != nil {
+		update()
+	}
+	g.emit("jmp %s", top)
+	g.label(end)
+}
+
+func (g *generator) assign(a *ast.Assignment) {
+	variable := g.lookup(a.Variable.Name)
+	g.expression(a.Value)
+	g.convert(g.info.TypeOf(a.Value), variable.t)
+	g.store(variable, g.top())
+	g.pop(1)
+}
+
+// load pushes the value of a variable to depth d of the operand stack.
+func (g *generator) load(s *symbol, d int) {
+	switch {
+	case s.constant:
+		g.immediate(s.value, g.operand(d))
+	case isSet(s.t):
+		g.copySet(frameWord(s.offset), frameWord(g.setOperand(d)))
+	default:
+		g.move(memory(s.offset), g.operand(d))
+	}
+}
+
+// store assigns the value at depth d of the operand stack to a variable.
+func (g *generator) store(s *symbol, d int) {
+	if isSet(s.t) {
+		g.copySet(frameWord(g.setOperand(d)), frameWord(s.offset))
+		return
+	}
+	g.move(g.operand(d), memory(s.offset))
+}
+
+func (g *generator) procedureCall(pc *ast.ProcedureCall) {
+	switch {
+	case checker.Intrinsics[pc.Name]:
+		g.intrinsic(pc)
+	case pc.Name == "inc" || pc.Name == "dec":
+		variable := g.lookup(pc.Args[0].(*ast.Variable).Name)
+		instruction, op := "addq", ast.PLUS
+		if pc.Name == "dec" {
+			instruction, op = "subq", ast.MINUS
+		}
+		g.emit("movq %s, %%rax", memory(variable.offset))
+		if g.options.OverflowChecks {
+			// Variables are kept extended to 64 bits, so they are already valid operands of the step type
+			g.emit("movq $1, %%rcx")
+			g.arithmetic(op, checker.StepType(variable.t), pc.Position)
+		} else {
+			g.emit("%s $1, %%rax", instruction)
+		}
+		g.normalize(variable.t)
+		g.emit("movq %%rax, %s", memory(variable.offset))
+	default:
+		if result := g.call(pc.Name, pc.Args); result != ast.VOID {
+			g.pop(1)
+		}
+	}
+}
+
+// stringLabel returns the label of a string literal, which is defined in the read-only data.
+func (g *generator) stringLabel(s string) string {
+	if l, ok := g.strings[s]; ok {
+		return l
+	}
+	l := fmt.Sprintf(".LS%d", len(g.strings))
+	g.strings[s] = l
+	fmt.Fprintf(&g.data, "%s:\n\t.asciz %s\n", l, quote(s))
+	return l
+}
+
+// quote returns a string for the GNU assembler. Characters outside of printable ASCII are escaped in octal.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,161 @@
+package amd64
+
+import (
+	"bytes"
+	"gitlab.fit.cvut.cz/fedorgle/gila/interp"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func generate(source string, options Options) string {
+	return Generate(parser.New(lexer.New(strings.NewReader(source))).Parse(), options)
+}
+
+func Test_Generate(t *testing.T) {
+	code := generate(`program calls;
+function set_in(x: integer; y: real): real;
+begin
+	set_in := x * y;
+end;
+var i: integer; b: byte; s: set of 0..31;
+begin
+	i := 0;
+	while i < 10 do
+	begin
+		if i = 5 then break;
+		i := i + 1;
+	end;
+	b := i;
+	s := [i];
+	writeln('i = ', set_in(i, 0.5), ' ', 3 in s);
+end.`, Options{})
+	expected := []string{
+		"\t.globl main\n\t.type main, @function\nmain:\n\tpushq %rbp",
+		// Functions named like the runtime library are renamed
+		"\t.type set_in_, @function",
+		"\tmovq %rdi, -56(%rbp)\n\tmovq %xmm0, -64(%rbp)",
+		"\tcall set_in_\n",
+		"\tcall set_in@PLT\n",
+		"\tcall set_include@PLT\n",
+		// Narrow integers are normalized after every conversion
+		"\tmovzbl %al, %eax",
+		"\tcall write_string@PLT",
+		"\t.asciz \"i = \"",
+		"\tleave\n\tret\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(code, e) {
+			t.Errorf("Expected %q in the generated code:\n%s", e, code)
+		}
+	}
+	if strings.Contains(code, "runtime_error") {
+		t.Error("Overflow checks were generated without OverflowChecks.")
+	}
+	if !strings.Contains(generate("program p; var x: integer; begin x := 1; x := x + 1; end.", Options{OverflowChecks: true}), "\tcall runtime_error@PLT") {
+		t.Error("Overflow checks were not generated.")
+	}
+}
+
+// tempDir creates a directory with the runtime library. Programs are assembled and linked by cc,
+// the tests are skipped, when it is not installed or the host is not x86-64 Linux.
+func tempDir(t *testing.T) string {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("programs only run on x86-64 Linux")
+	}
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc is not installed")
+	}
+	dir, err := ioutil.TempDir("", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "fce.c"), []byte(rtl.Source), 0644)
+	return dir
+}
+
+// execute builds a program and runs it with the given input. It returns the output and the error of the program.
+func execute(t *testing.T, dir, code, input string) (string, string, error) {
+	asm, exe := filepath.Join(dir, "program.s"), filepath.Join(dir, "program")
+	ioutil.WriteFile(asm, []byte(code), 0644)
+	if out, err := exec.Command("cc", "-o", exe, asm, filepath.Join(dir, "fce.c")).CombinedOutput(); err != nil {
+		t.Fatalf("cc failed: %s\n%s", out, code)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(exe)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// Test_Samples compares the output of the programs with the output of the interpreter.
+func Test_Samples(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	samples, _ := filepath.Glob("../../samples/*.mila")
+	for _, sample := range samples {
+		source, _ := ioutil.ReadFile(sample)
+		var expected bytes.Buffer
+		program := parser.New(lexer.New(bytes.NewReader(source))).Parse()
+		interp.Run(program, strings.NewReader("7\n"), &expected, interp.Options{})
+		out, _, err := execute(t, dir, generate(string(source), Options{}), "7\n")
+		if err != nil {
+			t.Errorf("%s failed: %v", sample, err)
+		}
+		if out != expected.String() {
+			t.Errorf("%s printed %q, the interpreter printed %q", sample, out, expected.String())
+		}
+	}
+}
+
+// Test_Calls passes more arguments, than fit into registers, and sets, which are passed in memory.
+func Test_Calls(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	source := `program calls;
+function sum(a: integer; b: real; c: byte; d: int64; e: real; f: integer; g: integer; h: real; i: integer; j: real; k: real; l: real; m: real; n: real; o: word): real;
+begin
+	sum := a + b + c + d + e + f + g + h + i + j + k + l + m + n + o;
+end;
+function add(s: set of 0..255; x: integer): set of 0..255;
+begin
+	add := s + [x];
+end;
+function deep(a: integer; b: integer; c: integer): integer;
+begin
+	deep := (a + (b * (c - (a + (b - (c * (a + (b + (c - 1))))))))) mod 1000;
+end;
+begin
+	writeln(sum(1, 2.5, 300, 4, 5.5, 6, 7, 8.5, 9, 10.5, 11, 12, 13, 14.5, 70000):0:1);
+	writeln(200 in add(add([], 100), 200), 150 in add([1..3], 100));
+	writeln(deep(deep(1, 2, 3), deep(4, 5, 6), 7));
+end.`
+	var expected bytes.Buffer
+	interp.Run(parser.New(lexer.New(strings.NewReader(source))).Parse(), strings.NewReader(""), &expected, interp.Options{})
+	out, _, err := execute(t, dir, generate(source, Options{}), "")
+	if err != nil || out != expected.String() {
+		t.Errorf("The program printed %q and exited with %v, the interpreter printed %q", out, err, expected.String())
+	}
+}
+
+func Test_RuntimeErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, c := range rtl.ErrorCases {
+		_, stderr, err := execute(t, dir, generate(c.Source, Options{OverflowChecks: c.OverflowChecks}), "")
+		if message := strings.TrimSpace(stderr); message != c.Message() {
+			t.Errorf("%q reported %q, expected %q", c.Source, message, c.Message())
+		}
+		if (err == nil) != (c.Error == nil) {
+			t.Errorf("%q exited with %v", c.Source, err)
+		}
+	}
+}
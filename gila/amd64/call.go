@@ -0,0 +1,321 @@
+package amd64
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+)
+
+// Arguments are passed according to the System V ABI: integers and booleans in general purpose registers,
+// reals in SSE registers and the rest on the stack in the order of parameters. Sets are passed on the stack
+// like structures of 32 bytes and returned through a pointer, which is passed as a hidden first argument.
+
+var integerRegisters = []string{"%rdi", "%rsi", "%rdx", "%rcx", "%r8", "%r9"}
+
+const sseRegisters = 8
+
+// location is the place of an argument. Arguments without a register are passed at an offset
+// from the start of the stack arguments.
+type location struct {
+	register string
+	stack    int64
+}
+
+// classify assigns locations to the parameters of a function. It also returns the size of the stack arguments.
+func classify(signature *ast.Signature) ([]location, int64) {
+	integers, reals := 0, 0
+	if isSet(signature.Return) {
+		integers = 1
+	}
+	var stack int64
+	locations := make([]location, len(signature.Parameters))
+	for i, p := range signature.Parameters {
+		switch {
+		case isSet(p.Type):
+			locations[i].stack = stack
+			stack += setSize
+		case p.Type == ast.REAL && reals < sseRegisters:
+			locations[i].register = fmt.Sprintf("%%xmm%d", reals)
+			reals++
+		case p.Type != ast.REAL && integers < len(integerRegisters):
+			locations[i].register = integerRegisters[integers]
+			integers++
+		default:
+			locations[i].stack = stack
+			stack += 8
+		}
+	}
+	return locations, stack
+}
+
+// receiveParameters copies the arguments of the function into its variables.
+func (g *generator) receiveParameters(signature *ast.Signature) {
+	locations, _ := classify(signature)
+	if isSet(signature.Return) {
+		g.resultPointer = g.allocate(8)
+		g.emit("movq %%rdi, %s", memory(g.resultPointer))
+	}
+	for i, p := range signature.Parameters {
+		s := g.variable(p.Name, p.Type)
+		l := locations[i]
+		// Stack arguments are above the return address and the saved frame pointer
+		incoming := 16 + l.stack
+		switch {
+		case isSet(p.Type):
+			g.copySet(frameWord(incoming), frameWord(s.offset))
+		case l.register == "":
+			g.move(memory(incoming), memory(s.offset))
+		default:
+			g.emit("movq %s, %s", l.register, memory(s.offset))
+		}
+	}
+}
+
+// returnResult loads the result of the current function into the registers, in which it is returned.
+func (g *generator) returnResult() {
+	switch {
+	case g.function.Signature.Name == "main":
+		g.emit("xorl %%eax, %%eax")
+	case g.result == nil:
+	case isSet(g.result.t):
+		g.emit("movq %s, %%rdx", memory(g.resultPointer))
+		g.copySet(frameWord(g.result.offset), func(i int) string {
+			return fmt.Sprintf("%d(%%rdx)", 8*i)
+		})
+		g.emit("movq %%rdx, %%rax")
+	case g.result.t == ast.REAL:
+		g.emit("movq %s, %%xmm0", memory(g.result.offset))
+	default:
+		g.emit("movq %s, %%rax", memory(g.result.offset))
+	}
+}
+
+// call generates a call of a function, converting its arguments to the types of the parameters.
+// The result is pushed to the operand stack. It returns the type of the result.
+func (g *generator) call(name string, args []ast.Expression) ast.Type {
+	f, ok := g.functions[name]
+	if !ok {
+		panic(fmt.Sprintf("Call to an undefined function %s.", name))
+	}
+	locations, size := classify(f.Signature)
+	if size > g.outgoing {
+		g.outgoing = size
+	}
+	base := g.depth
+	for i, a := range args {
+		g.expression(a)
+		g.convert(g.info.TypeOf(a), f.Signature.Parameters[i].Type)
+	}
+	for i, l := range locations {
+		d := base + i
+		switch {
+		case isSet(f.Signature.Parameters[i].Type):
+			g.copySet(frameWord(g.setOperand(d)), func(word int) string {
+				return fmt.Sprintf("%d(%%rsp)", l.stack+int64(8*word))
+			})
+		case l.register == "":
+			g.move(g.operand(d), fmt.Sprintf("%d(%%rsp)", l.stack))
+		default:
+			g.emit("movq %s, %s", g.operand(d), l.register)
+		}
+	}
+	g.pop(len(args))
+	result := f.Signature.Return
+	if result == ast.VOID {
+		g.emit("call %s", symbolName(name))
+		return result
+	}
+	d := g.push()
+	if isSet(result) {
+		g.emit("leaq %s, %%rdi", memory(g.setOperand(d)))
+	}
+	g.emit("call %s", symbolName(name))
+	switch {
+	case isSet(result):
+	case result == ast.REAL:
+		g.emit("movq %%xmm0, %s", g.operand(d))
+	default:
+		g.move("%rax", g.operand(d))
+	}
+	return result
+}
+
+func (g *generator) intrinsic(pc *ast.ProcedureCall) {
+	switch pc.Name {
+	case "write", "writeln":
+		for _, a := range pc.Args {
+			g.write(a)
+		}
+		if pc.Name == "writeln" {
+			g.emit("call write_newline@PLT")
+		}
+	case "read", "readln":
+		for _, a := range pc.Args {
+			variable := g.lookup(a.(*ast.Variable).Name)
+			g.emit("leaq %s, %%rdi", memory(variable.offset))
+			if variable.t == ast.REAL {
+				g.emit("call read_real@PLT")
+				continue
+			}
+			// The number is read as int64 and truncated to the type of the variable
+			g.emit("call read_integer@PLT")
+			g.emit("movq %s, %%rax", memory(variable.offset))
+			g.normalize(variable.t)
+			g.emit("movq %%rax, %s", memory(variable.offset))
+		}
+		if pc.Name == "readln" {
+			g.emit("call read_newline@PLT")
+		}
+	}
+}
+
+func (g *generator) write(a ast.Expression) {
+	format, formatted := a.(*ast.Format)
+	if formatted {
+		a = format.Value
+	}
+	base := g.depth
+	t := g.info.TypeOf(a)
+	s, isString := a.(ast.StringLiteral)
+	if !isString {
+		g.expression(a)
+	}
+	// Field widths are passed to the runtime as 32-bit integers
+	width, precision := "$0", "$-1"
+	if formatted {
+		g.expression(format.Width)
+		g.convert(g.info.TypeOf(format.Width), ast.INT)
+		width = g.operand(g.top())
+		if t == ast.REAL && format.Precision != nil {
+			g.expression(format.Precision)
+			g.convert(g.info.TypeOf(format.Precision), ast.INT)
+			precision = g.operand(g.top())
+		}
+	}
+	switch {
+	case isString:
+		g.emit("leaq %s(%%rip), %%rdi", g.stringLabel(s.Value))
+		g.emit("movq %s, %%rsi", width)
+		g.emit("call write_string@PLT")
+	case t == ast.REAL:
+		g.emit("movq %s, %%xmm0", g.operand(base))
+		g.emit("movq %s, %%rdi", width)
+		g.emit("movq %s, %%rsi", precision)
+		g.emit("call write_real@PLT")
+	case t == ast.BOOLEAN:
+		g.emit("movq %s, %%rdi", g.operand(base))
+		g.emit("movq %s, %%rsi", width)
+		g.emit("call write_boolean@PLT")
+	case ast.IsInteger(t):
+		g.emit("movq %s, %%rdi", g.operand(base))
+		g.emit("movq %s, %%rsi", width)
+		g.emit("call write_integer@PLT")
+	default:
+		panic(fmt.Sprintf("Can not write %v.", a))
+	}
+	g.pop(g.depth - base)
+}
+
+// capacity is the number of elements, that the LLVM representation of a set type can hold.
+func capacity(s ast.Set) int64 {
+	switch {
+	case s.High < 32:
+		return 32
+	case s.High < 64:
+		return 64
+	default:
+		return ast.MaxSetElement + 1
+	}
+}
+
+// truncateSet drops elements of the set at depth d, that its representation in the ir package can't hold.
+func (g *generator) truncateSet(s ast.Set, d int) {
+	c := capacity(s)
+	if c > ast.MaxSetElement {
+		return
+	}
+	offset := g.setOperand(d)
+	if c == 32 {
+		g.emit("movl $0, %s", memory(offset+4))
+	}
+	for i := int64(8); i < setSize; i += 8 {
+		g.emit("movq $0, %s", memory(offset+i))
+	}
+}
+
+// setConstructor fills a set with fce.c. Elements are converted to integers, the same way as in the ir package.
+func (g *generator) setConstructor(s *ast.SetConstructor) {
+	d := g.push()
+	offset := g.setOperand(d)
+	for i := int64(0); i < setSize; i += 8 {
+		g.emit("movq $0, %s", memory(offset+i))
+	}
+	for _, e := range s.Elements {
+		g.expression(e.Low)
+		g.convert(g.info.TypeOf(e.Low), ast.INT)
+		high := g.top()
+		if e.High != nil {
+			g.expression(e.High)
+			g.convert(g.info.TypeOf(e.High), ast.INT)
+			high = g.top()
+		}
+		g.emit("leaq %s, %%rdi", memory(offset))
+		g.emit("movq %s, %%rsi", g.operand(d+1))
+		g.emit("movq %s, %%rdx", g.operand(high))
+		g.emit("call set_include@PLT")
+		g.pop(g.depth - d - 1)
+	}
+}
+
+func (g *generator) in(e *ast.Binary) {
+	g.expression(e.Left)
+	g.convert(g.info.TypeOf(e.Left), ast.INT)
+	g.expression(e.Right)
+	d := g.top() - 1
+	g.emit("movq %s, %%rdi", g.operand(d))
+	g.emit("leaq %s, %%rsi", memory(g.setOperand(d+1)))
+	g.emit("call set_in@PLT")
+	g.emit("testl %%eax, %%eax")
+	g.setFlag("ne")
+	g.storeResult()
+}
+
+// setBinary performs an operation on sets. Both operands are truncated to the representation of the result.
+func (g *generator) setBinary(e *ast.Binary, t ast.Set) {
+	g.expression(e.Left)
+	g.convert(g.info.TypeOf(e.Left), t)
+	g.expression(e.Right)
+	g.convert(g.info.TypeOf(e.Right), t)
+	d := g.top() - 1
+	left, right := memory(g.setOperand(d)), memory(g.setOperand(d+1))
+	switch e.Operation {
+	case ast.PLUS, ast.MULTIPLY, ast.MINUS:
+		name := map[ast.Operation]string{ast.PLUS: "set_union", ast.MULTIPLY: "set_intersection", ast.MINUS: "set_difference"}[e.Operation]
+		g.emit("leaq %s, %%rdi", left)
+		g.emit("leaq %s, %%rsi", left)
+		g.emit("leaq %s, %%rdx", right)
+		g.emit("call %s@PLT", name)
+		g.pop(1)
+		return
+	case ast.EQUALS, ast.NOTEQUALS:
+		g.emit("leaq %s, %%rdi", left)
+		g.emit("leaq %s, %%rsi", right)
+		g.emit("call set_equal@PLT")
+	case ast.LESSEQ:
+		g.emit("leaq %s, %%rdi", left)
+		g.emit("leaq %s, %%rsi", right)
+		g.emit("call set_subset@PLT")
+	case ast.GREATEREQ:
+		g.emit("leaq %s, %%rdi", right)
+		g.emit("leaq %s, %%rsi", left)
+		g.emit("call set_subset@PLT")
+	default:
+		panic("Invalid operation on sets.")
+	}
+	g.emit("testl %%eax, %%eax")
+	if e.Operation == ast.NOTEQUALS {
+		g.setFlag("e")
+	} else {
+		g.setFlag("ne")
+	}
+	g.storeResult()
+}
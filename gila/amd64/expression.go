@@ -0,0 +1,362 @@
+package amd64
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"math"
+)
+
+// Binary operations load their operands into %rax and %rcx, compute the result in %rax
+// and store it in place of the left operand.
+
+// expression evaluates an expression and pushes its value to the operand stack.
+func (g *generator) expression(expression ast.Expression) {
+	switch e := expression.(type) {
+	case *ast.Literal:
+		g.immediate(e.Value, g.operand(g.push()))
+	case *ast.RealLiteral:
+		g.immediate(int64(math.Float64bits(e.Value)), g.operand(g.push()))
+	case ast.StringLiteral:
+		panic(fmt.Sprintf("String %q can only be written.", e.Value))
+	case *ast.Variable:
+		g.load(g.lookup(e.Name), g.push())
+	case *ast.Binary:
+		g.binary(e)
+	case *ast.Unary:
+		g.unary(e)
+	case *ast.FunctionCall:
+		g.call(e.Name, e.Args)
+	case *ast.SetConstructor:
+		g.setConstructor(e)
+	default:
+		panic("Not all expressions are implemented yet!")
+	}
+}
+
+// normalize truncates the integer in %rax to the width of type t and extends it back according to its sign.
+func (g *generator) normalize(t ast.Type) {
+	switch t {
+	case ast.BYTE:
+		g.emit("movzbl %%al, %%eax")
+	case ast.SHORTINT:
+		g.emit("movsbq %%al, %%rax")
+	case ast.WORD:
+		g.emit("movzwl %%ax, %%eax")
+	case ast.INT:
+		g.emit("movslq %%eax, %%rax")
+	case ast.CARDINAL:
+		g.emit("movl %%eax, %%eax")
+	}
+}
+
+// convert changes the value on top of the operand stack from type from to type to, the same way an assignment does.
+func (g *generator) convert(from, to ast.Type) {
+	if s, ok := to.(ast.Set); ok {
+		g.truncateSet(s, g.top())
+		return
+	}
+	if from == to {
+		return
+	}
+	location := g.operand(g.top())
+	switch {
+	case ast.IsInteger(to) && ast.IsInteger(from):
+		g.emit("movq %s, %%rax", location)
+		g.normalize(to)
+		g.emit("movq %%rax, %s", location)
+	case to == ast.REAL && ast.IsInteger(from):
+		// Integers are extended to 64 bits, so they are converted as int64
+		g.emit("cvtsi2sdq %s, %%xmm0", location)
+		g.emit("movq %%xmm0, %s", location)
+	}
+}
+
+// operands loads the two values on top of the operand stack into %rax and %rcx.
+func (g *generator) operands() {
+	d := g.top() - 1
+	g.emit("movq %s, %%rax", g.operand(d))
+	g.emit("movq %s, %%rcx", g.operand(d+1))
+}
+
+// storeResult stores %rax in place of the two operands of a binary operation.
+func (g *generator) storeResult() {
+	g.pop(1)
+	g.emit("movq %%rax, %s", g.operand(g.top()))
+}
+
+// setFlag stores a condition code as a boolean in %rax.
+func (g *generator) setFlag(condition string) {
+	g.emit("set%s %%al", condition)
+	g.emit("movzbl %%al, %%eax")
+}
+
+// fail jumps to code, that reports a runtime error at pos, when a condition holds.
+func (g *generator) fail(condition string, code int, pos token.Position) {
+	g.emit("j%s %s", condition, g.errorLabel(code, pos))
+}
+
+var conditions = map[ast.Operation][2]string{
+	ast.EQUALS: {"e", "e"}, ast.NOTEQUALS: {"ne", "ne"},
+	ast.LESS: {"l", "b"}, ast.LESSEQ: {"le", "be"}, ast.GREATER: {"g", "a"}, ast.GREATEREQ: {"ge", "ae"},
+}
+
+func (g *generator) binary(e *ast.Binary) {
+	leftType, rightType := g.info.TypeOf(e.Left), g.info.TypeOf(e.Right)
+	if e.Operation == ast.IN {
+		g.in(e)
+		return
+	}
+	if _, ok := leftType.(ast.Set); ok {
+		g.setBinary(e, checker.UnionType(leftType.(ast.Set), rightType.(ast.Set)))
+		return
+	}
+	if leftType == ast.BOOLEAN {
+		// Both operands are evaluated, the same way as in the ir package
+		g.expression(e.Left)
+		g.expression(e.Right)
+		g.operands()
+		switch e.Operation {
+		case ast.AND:
+			g.emit("andq %%rcx, %%rax")
+		case ast.OR:
+			g.emit("orq %%rcx, %%rax")
+		case ast.XOR:
+			g.emit("xorq %%rcx, %%rax")
+		case ast.EQUALS, ast.NOTEQUALS:
+			g.emit("cmpq %%rcx, %%rax")
+			g.setFlag(conditions[e.Operation][0])
+		default:
+			panic("Invalid operation on booleans.")
+		}
+		g.storeResult()
+		return
+	}
+	t := checker.CommonType(leftType, rightType)
+	if t == ast.REAL || e.Operation == ast.DIVIDE {
+		g.realBinary(e)
+		return
+	}
+	if e.Operation == ast.SHL || e.Operation == ast.SHR {
+		g.shift(e)
+		return
+	}
+	g.expression(e.Left)
+	g.convert(leftType, t)
+	g.expression(e.Right)
+	g.convert(rightType, t)
+	g.operands()
+	switch e.Operation {
+	case ast.PLUS, ast.MINUS, ast.MULTIPLY:
+		g.arithmetic(e.Operation, t, e.Position)
+	case ast.DIV, ast.MOD:
+		g.division(e.Operation, t, e.Position)
+	case ast.AND:
+		g.emit("andq %%rcx, %%rax")
+	case ast.OR:
+		g.emit("orq %%rcx, %%rax")
+	case ast.XOR:
+		g.emit("xorq %%rcx, %%rax")
+	default:
+		c, ok := conditions[e.Operation]
+		if !ok {
+			panic(fmt.Sprintf("Invalid operation %v on integers.", e.Operation))
+		}
+		// Both operands are extended to 64 bits, so they are compared as 64-bit integers
+		g.emit("cmpq %%rcx, %%rax")
+		if ast.IsUnsigned(t) {
+			g.setFlag(c[1])
+		} else {
+			g.setFlag(c[0])
+		}
+	}
+	g.storeResult()
+}
+
+// arithmetic adds, subtracts or multiplies %rax and %rcx in type t. The result wraps around on overflow,
+// unless overflow checks are enabled.
+func (g *generator) arithmetic(op ast.Operation, t ast.Type, pos token.Position) {
+	instruction := map[ast.Operation]string{ast.PLUS: "add", ast.MINUS: "sub", ast.MULTIPLY: "imul"}[op]
+	// Signed overflow sets the overflow flag, unsigned overflow the carry flag
+	overflow := "o"
+	switch {
+	case t == ast.INT64:
+		g.emit("%sq %%rcx, %%rax", instruction)
+	case op == ast.MULTIPLY && ast.IsUnsigned(t):
+		// The product of two cardinals fits into 64 bits, it overflows, when its upper half isn't zero
+		g.emit("imulq %%rcx, %%rax")
+		if g.options.OverflowChecks {
+			g.emit("movq %%rax, %%rdx")
+			g.emit("shrq $32, %%rdx")
+			overflow = "nz"
+		}
+	default:
+		g.emit("%sl %%ecx, %%eax", instruction)
+		if ast.IsUnsigned(t) {
+			overflow = "c"
+		}
+	}
+	if g.options.OverflowChecks {
+		g.fail(overflow, rtl.ErrorOverflow, pos)
+	}
+	g.normalize(t)
+}
+
+// division divides %rax by %rcx in type t.
+func (g *generator) division(op ast.Operation, t ast.Type, pos token.Position) {
+	g.emit("testq %%rcx, %%rcx")
+	g.fail("z", rtl.ErrorDivisionByZero, pos)
+	end := g.newLabel()
+	if !ast.IsUnsigned(t) {
+		// The smallest integer divided by -1 doesn't fit into its type
+		divide := g.newLabel()
+		g.emit("cmpq $-1, %%rcx")
+		g.emit("jne %s", divide)
+		if t == ast.INT64 {
+			g.emit("movabsq $%d, %%rdx", int64(math.MinInt64))
+		} else {
+			g.emit("movq $%d, %%rdx", math.MinInt32)
+		}
+		g.emit("cmpq %%rdx, %%rax")
+		g.emit("jne %s", divide)
+		if g.options.OverflowChecks {
+			g.emit("jmp %s", g.errorLabel(rtl.ErrorOverflow, pos))
+		}
+		if op == ast.MOD {
+			g.emit("xorl %%eax, %%eax")
+		}
+		g.emit("jmp %s", end)
+		g.label(divide)
+	}
+	switch {
+	case t == ast.INT64:
+		g.emit("cqto")
+		g.emit("idivq %%rcx")
+	case ast.IsUnsigned(t):
+		g.emit("xorl %%edx, %%edx")
+		g.emit("divl %%ecx")
+	default:
+		g.emit("cltd")
+		g.emit("idivl %%ecx")
+	}
+	if op == ast.MOD {
+		g.emit("movq %%rdx, %%rax")
+	}
+	g.normalize(t)
+	g.label(end)
+}
+
+// errorLabel returns a label of code, that reports a runtime error at pos.
+func (g *generator) errorLabel(code int, pos token.Position) string {
+	l := g.newLabel()
+	fmt.Fprintf(&g.errors, "%s:\n\tmovl $%d, %%edi\n\tmovl $%d, %%esi\n\tmovl $%d, %%edx\n\tcall runtime_error@PLT\n",
+		l, code, pos.Line, pos.Col)
+	return l
+}
+
+// shift shifts an integer. The count is masked to the width of the result by the instruction itself,
+// the same way as in the ir package. shr is a logical shift, the sign bit is not extended.
+func (g *generator) shift(e *ast.Binary) {
+	t := g.info.TypeOf(e)
+	g.expression(e.Left)
+	g.convert(g.info.TypeOf(e.Left), t)
+	g.expression(e.Right)
+	g.operands()
+	instruction := "shl"
+	if e.Operation == ast.SHR {
+		instruction = "shr"
+	}
+	if t == ast.INT64 {
+		g.emit("%sq %%cl, %%rax", instruction)
+	} else {
+		g.emit("%sl %%cl, %%eax", instruction)
+		g.normalize(t)
+	}
+	g.storeResult()
+}
+
+func (g *generator) realBinary(e *ast.Binary) {
+	g.expression(e.Left)
+	g.convert(g.info.TypeOf(e.Left), ast.REAL)
+	g.expression(e.Right)
+	g.convert(g.info.TypeOf(e.Right), ast.REAL)
+	d := g.top() - 1
+	g.emit("movq %s, %%xmm0", g.operand(d))
+	g.emit("movq %s, %%xmm1", g.operand(d+1))
+	g.pop(1)
+	switch e.Operation {
+	case ast.PLUS, ast.MINUS, ast.MULTIPLY, ast.DIVIDE:
+		instruction := map[ast.Operation]string{ast.PLUS: "addsd", ast.MINUS: "subsd", ast.MULTIPLY: "mulsd", ast.DIVIDE: "divsd"}[e.Operation]
+		g.emit("%s %%xmm1, %%xmm0", instruction)
+		g.emit("movq %%xmm0, %s", g.operand(d))
+		return
+	// Comparisons with NaN are unordered and false, except for <>
+	case ast.EQUALS:
+		g.emit("ucomisd %%xmm1, %%xmm0")
+		g.emit("sete %%al")
+		g.emit("setnp %%cl")
+		g.emit("andb %%cl, %%al")
+	case ast.NOTEQUALS:
+		g.emit("ucomisd %%xmm1, %%xmm0")
+		g.emit("setne %%al")
+		g.emit("setp %%cl")
+		g.emit("orb %%cl, %%al")
+	case ast.GREATER:
+		g.emit("ucomisd %%xmm1, %%xmm0")
+		g.emit("seta %%al")
+	case ast.GREATEREQ:
+		g.emit("ucomisd %%xmm1, %%xmm0")
+		g.emit("setae %%al")
+	case ast.LESS:
+		g.emit("ucomisd %%xmm0, %%xmm1")
+		g.emit("seta %%al")
+	case ast.LESSEQ:
+		g.emit("ucomisd %%xmm0, %%xmm1")
+		g.emit("setae %%al")
+	default:
+		panic("Invalid operation on reals.")
+	}
+	g.emit("movzbl %%al, %%eax")
+	g.emit("movq %%rax, %s", g.operand(d))
+}
+
+func (g *generator) unary(u *ast.Unary) {
+	t := g.info.TypeOf(u)
+	g.expression(u.Operand)
+	location := g.operand(g.top())
+	switch u.Operation {
+	case ast.PLUS:
+		return
+	case ast.MINUS:
+		if t == ast.REAL {
+			// The sign bit is flipped
+			g.emit("btcq $63, %s", location)
+			return
+		}
+		g.convert(g.info.TypeOf(u.Operand), t)
+		g.emit("movq %s, %%rax", location)
+		if t == ast.INT64 {
+			g.emit("negq %%rax")
+		} else {
+			g.emit("negl %%eax")
+		}
+		if g.options.OverflowChecks {
+			g.fail("o", rtl.ErrorOverflow, u.Position)
+		}
+		g.normalize(t)
+	case ast.NOT:
+		if t == ast.BOOLEAN {
+			g.emit("xorq $1, %s", location)
+			return
+		}
+		g.convert(g.info.TypeOf(u.Operand), t)
+		g.emit("movq %s, %%rax", location)
+		g.emit("notq %%rax")
+		g.normalize(t)
+	default:
+		panic("Invalid operation type inside Unary node.")
+	}
+	g.emit("movq %%rax, %s", location)
+}
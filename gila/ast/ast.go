@@ -2,6 +2,8 @@ package ast
 
 import (
 	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"strings"
 )
 
 // ast definitions for Abstract Syntax Tree nodes.
@@ -15,15 +17,23 @@ type Node interface {
 type Program struct {
 	Name      string
 	Functions []*Function
+	// Library is set for libraries and Unit for units, neither has a main function. Exports are the names
+	// of functions, that can be called from C, or from programs using the unit, which are listed in Uses.
+	Library bool
+	Unit    bool
+	Exports []string
+	Uses    []string
 }
 
 // Function is a top level declaration of a function.
+// Position is the position of the function keyword, or of the body of the main program.
 type (
 	Function struct {
 		Signature *Signature
 		Body      Statement
-		Variables map[string]struct{}
-		Constants map[string]Literal
+		Variables map[string]Type
+		Constants map[string]Expression
+		Position  token.Position
 	}
 
 	// Signature is the type of a function
@@ -31,6 +41,15 @@ type (
 		Name       string
 		Return     Type
 		Parameters []Variable
+		// Inline is set by the inline directive, which asks the optimizer to inline every call
+		Inline bool
+		// External functions are implemented in C, Library is linked to the program, when it is not empty
+		External bool
+		Library  string
+		// Cdecl and Varargs are set by the directives of the same name, varargs functions accept
+		// any number of arguments after their parameters, like printf
+		Cdecl   bool
+		Varargs bool
 	}
 
 	FunctionBody struct {
@@ -50,10 +69,12 @@ type (
 		Statements []Statement
 	}
 
-	// Assignment represents an assignment of a new value to a variable
+	// Assignment represents an assignment of a new value to a variable.
+	// Statements keep the position of their first token, backends use it to map code back to the source.
 	Assignment struct {
 		Variable Variable
 		Value    Expression
+		Position token.Position
 	}
 
 	// If represents a conditional branching statement
@@ -61,42 +82,53 @@ type (
 		Condition Expression
 		Then      Statement
 		Else      Statement
+		Position  token.Position
 	}
 	While struct {
 		Condition Expression
 		Body      Statement
+		Position  token.Position
 	}
 
 	For struct {
-		Initial *Assignment
-		Upto    bool
-		Target  Expression
-		Body    *Block
+		Initial  *Assignment
+		Upto     bool
+		Target   Expression
+		Body     *Block
+		Position token.Position
 	}
 
 	// Break the current loop
-	Break struct{}
+	Break struct {
+		Position token.Position
+	}
 
 	// Exit is a return statement
-	Exit struct{}
+	Exit struct {
+		Position token.Position
+	}
 
 	// ProcedureCall is a call to a function, that doesn't return a value.
 	ProcedureCall struct {
-		Name string
-		Args []Expression
+		Name     string
+		Args     []Expression
+		Position token.Position
 	}
 
 	VariableDeclaration struct {
 		Name string
+		Type Type
 	}
 
 	ParameterDeclaration struct {
 		Name string
 	}
 
+	// ConstantDeclaration declares a named constant. The parser replaces references to it by its Value,
+	// which is the literal, to which the constant expression was evaluated.
 	ConstantDeclaration struct {
-		Name    string
-		Literal Literal
+		Name  string
+		Value Expression
 	}
 )
 
@@ -107,36 +139,65 @@ type (
 		isExpression()
 	}
 
-	// Literal is a literal value inside the source code.
+	// Literal is an integer literal inside the source code. Type is the declared type of a typed constant,
+	// it is nil for numbers, whose type is derived from their value.
 	Literal struct {
 		Value int64
+		Type  Type
 	}
 
 	StringLiteral struct {
 		Value string
 	}
 
+	// RealLiteral is a floating point literal, e.g. 3.14 or 1e-3.
+	RealLiteral struct {
+		Value float64
+	}
+
 	// Variable represents a symbol, referencing a value in a program.
+	// Type is only filled in for parameters, where the variable is being declared.
 	Variable struct {
 		Name string
+		Type Type
 	}
 
 	// Binary represents an operation with 2 operands.
+	// Position is the position of the operator, which is reported by runtime errors.
 	Binary struct {
 		Left, Right Expression
 		Operation   Operation
+		Position    token.Position
 	}
 
 	// Unary represents an operation with 1 operand.
 	Unary struct {
 		Operand   Expression
 		Operation Operation
+		Position  token.Position
 	}
 
 	// FunctionCall represents a call to a function, that returns something.
 	FunctionCall struct {
-		Name string
-		Args []Expression
+		Name     string
+		Args     []Expression
+		Position token.Position
+	}
+
+	// SetConstructor builds a set out of single elements and ranges, e.g. [1, 3..5].
+	SetConstructor struct {
+		Elements []SetElement
+	}
+
+	// Format is an argument of write or writeln with a field width and an optional precision, e.g. x:10:2.
+	// Precision is nil, when it is not specified.
+	Format struct {
+		Value, Width, Precision Expression
+	}
+
+	// SetElement is a single element of a set constructor. High is nil, unless the element is a range.
+	SetElement struct {
+		Low, High Expression
 	}
 )
 
@@ -151,6 +212,12 @@ func (l Literal) String() string {
 func (_ StringLiteral) isNode()       {}
 func (_ StringLiteral) isExpression() {}
 func (l StringLiteral) String() string {
+	return fmt.Sprintf("'%v'", l.Value)
+}
+
+func (_ RealLiteral) isNode()       {}
+func (_ RealLiteral) isExpression() {}
+func (l RealLiteral) String() string {
 	return fmt.Sprintf("%v", l.Value)
 }
 
@@ -178,6 +245,29 @@ func (f FunctionCall) String() string {
 	return fmt.Sprintf("(%s(%v))", f.Name, f.Args)
 }
 
+func (_ Format) isNode()       {}
+func (_ Format) isExpression() {}
+func (f Format) String() string {
+	if f.Precision != nil {
+		return fmt.Sprintf("%v:%v:%v", f.Value, f.Width, f.Precision)
+	}
+	return fmt.Sprintf("%v:%v", f.Value, f.Width)
+}
+
+func (_ SetConstructor) isNode()       {}
+func (_ SetConstructor) isExpression() {}
+func (s SetConstructor) String() string {
+	var elements []string
+	for _, e := range s.Elements {
+		if e.High != nil {
+			elements = append(elements, fmt.Sprintf("%v..%v", e.Low, e.High))
+		} else {
+			elements = append(elements, fmt.Sprintf("%v", e.Low))
+		}
+	}
+	return fmt.Sprintf("[%s]", strings.Join(elements, ", "))
+}
+
 func (_ Block) isNode()      {}
 func (_ Block) isStatement() {}
 
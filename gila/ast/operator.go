@@ -30,6 +30,18 @@ func (o Operation) String() string {
 		return "and"
 	case OR:
 		return "or"
+	case IN:
+		return "in"
+	case XOR:
+		return "xor"
+	case SHL:
+		return "shl"
+	case SHR:
+		return "shr"
+	case NOT:
+		return "not"
+	case DIVIDE:
+		return "/"
 
 	default:
 		panic("Invalid Operation value.")
@@ -50,4 +62,10 @@ const (
 	GREATEREQ
 	AND
 	OR
+	IN
+	XOR
+	SHL
+	SHR
+	NOT
+	DIVIDE
 )
@@ -0,0 +1,118 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Fprint writes an indented outline of the program, one node per line. Expressions are printed on a single line.
+func Fprint(w io.Writer, program *Program) {
+	p := printer{w: w}
+	kind := "Program"
+	if program.Library {
+		kind = "Library"
+	} else if program.Unit {
+		kind = "Unit"
+	}
+	p.line("%s %s", kind, program.Name)
+	p.depth++
+	if len(program.Uses) > 0 {
+		p.line("Uses %s", strings.Join(program.Uses, ", "))
+	}
+	for _, f := range program.Functions {
+		p.function(f)
+	}
+	if len(program.Exports) > 0 {
+		p.line("Exports %s", strings.Join(program.Exports, ", "))
+	}
+}
+
+type printer struct {
+	w     io.Writer
+	depth int
+}
+
+func (p *printer) line(format string, args ...interface{}) {
+	fmt.Fprintf(p.w, "%s%s\n", strings.Repeat("  ", p.depth), fmt.Sprintf(format, args...))
+}
+
+func (p *printer) function(f *Function) {
+	var params []string
+	for _, param := range f.Signature.Parameters {
+		params = append(params, fmt.Sprintf("%s: %v", param.Name, param.Type))
+	}
+	header := fmt.Sprintf("Function %s(%s)", f.Signature.Name, strings.Join(params, "; "))
+	if f.Signature.Return != VOID {
+		header += fmt.Sprintf(": %v", f.Signature.Return)
+	}
+	if f.Signature.External {
+		header += " external"
+		if f.Signature.Library != "" {
+			header += fmt.Sprintf(" %q", f.Signature.Library)
+		}
+		p.line("%s", header)
+		return
+	}
+	if f.Body == nil {
+		p.line("%s forward", header)
+		return
+	}
+	p.line("%s", header)
+	p.depth++
+	p.statement(f.Body)
+	p.depth--
+}
+
+func (p *printer) statement(s Statement) {
+	switch n := s.(type) {
+	case *Block:
+		p.line("Block")
+		p.depth++
+		for _, s := range n.Statements {
+			p.statement(s)
+		}
+		p.depth--
+	case *VariableDeclaration:
+		p.line("Var %s: %v", n.Name, n.Type)
+	case *ConstantDeclaration:
+		p.line("Const %s = %v", n.Name, n.Value)
+	case *Assignment:
+		p.line("Assignment %v", n)
+	case *ProcedureCall:
+		var args []string
+		for _, a := range n.Args {
+			args = append(args, fmt.Sprint(a))
+		}
+		p.line("Call %s(%s)", n.Name, strings.Join(args, ", "))
+	case *If:
+		p.line("If %v", n.Condition)
+		p.branch("Then", n.Then)
+		if n.Else != nil {
+			p.branch("Else", n.Else)
+		}
+	case *While:
+		p.line("While %v", n.Condition)
+		p.branch("Do", n.Body)
+	case *For:
+		direction := "downto"
+		if n.Upto {
+			direction = "to"
+		}
+		p.line("For %v %s %v", n.Initial, direction, n.Target)
+		p.branch("Do", n.Body)
+	case *Break:
+		p.line("Break")
+	case *Exit:
+		p.line("Exit")
+	default:
+		p.line("%T", n)
+	}
+}
+
+func (p *printer) branch(name string, s Statement) {
+	p.line("%s", name)
+	p.depth++
+	p.statement(s)
+	p.depth--
+}
@@ -1,15 +1,88 @@
 package ast
 
+import "fmt"
+
 // Type of a literal or a variable
-type Type int
+type Type interface {
+	isType()
+}
+
+// Basic is one of the scalar types built into the language.
+type Basic int
 
 const (
-	INT Type = iota
+	INT Basic = iota
 	REAL
 	STRING
 	VOID
+	BOOLEAN
+	BYTE
+	SHORTINT
+	WORD
+	CARDINAL
+	INT64
 )
 
+// Set is a `set of Low..High` type. Every element is stored as a bit, indexed by its ordinal value.
+type Set struct {
+	Low, High int64
+}
+
+// MaxSetElement is the largest ordinal value, that can be stored inside a set.
+const MaxSetElement = 255
+
+func (_ Basic) isType() {}
+func (b Basic) String() string {
+	switch b {
+	case INT:
+		return "integer"
+	case REAL:
+		return "real"
+	case STRING:
+		return "string"
+	case VOID:
+		return "void"
+	case BOOLEAN:
+		return "boolean"
+	case BYTE:
+		return "byte"
+	case SHORTINT:
+		return "shortint"
+	case WORD:
+		return "word"
+	case CARDINAL:
+		return "cardinal"
+	case INT64:
+		return "int64"
+	default:
+		panic("Invalid Basic type value.")
+	}
+}
+
+// IsInteger reports whether t is one of the integer types.
+func IsInteger(t Type) bool {
+	switch t {
+	case INT, BYTE, SHORTINT, WORD, CARDINAL, INT64:
+		return true
+	}
+	return false
+}
+
+// IsNumeric reports whether arithmetic operations are defined for t.
+func IsNumeric(t Type) bool {
+	return IsInteger(t) || t == REAL
+}
+
+// IsUnsigned reports whether t is an integer type without a sign.
+func IsUnsigned(t Type) bool {
+	return t == BYTE || t == WORD || t == CARDINAL
+}
+
+func (_ Set) isType() {}
+func (s Set) String() string {
+	return fmt.Sprintf("set of %d..%d", s.Low, s.High)
+}
+
 type Value interface {
 	isValue()
 	GetInt() int64
@@ -21,7 +94,7 @@ type Value interface {
 	We create wrappers, so that they can implement a common interface.
 	This interface will be used as a union type to store any of the following types.
 */
-type MilaInt int
+type MilaInt int64
 
 func (m MilaInt) GetInt() int64 {
 	return int64(m)
@@ -32,6 +105,15 @@ func (m MilaInt) GetFloat() float64 {
 }
 
 type MilaString string
+
+func (m MilaString) GetInt() int64 {
+	panic("Wrong type. Expected int, received string.")
+}
+
+func (m MilaString) GetFloat() float64 {
+	panic("Wrong type. Expected float, received string.")
+}
+
 type MilaReal float64
 
 func (r MilaReal) GetInt() int64 {
@@ -42,6 +124,42 @@ func (r MilaReal) GetFloat() float64 {
 	return float64(r)
 }
 
-func (m MilaInt) isValue()    {}
-func (m MilaString) isValue() {}
-func (m MilaReal) isValue()   {}
+// MilaBoolean is a result of a comparison or of a logical operation.
+type MilaBoolean bool
+
+func (b MilaBoolean) GetInt() int64 {
+	panic("Wrong type. Expected int, received boolean.")
+}
+
+func (b MilaBoolean) GetFloat() float64 {
+	panic("Wrong type. Expected float, received boolean.")
+}
+
+// MilaSet holds a bit for every element from 0 to MaxSetElement.
+type MilaSet [(MaxSetElement + 1) / 64]uint64
+
+func (s MilaSet) GetInt() int64 {
+	panic("Wrong type. Expected int, received set.")
+}
+
+func (s MilaSet) GetFloat() float64 {
+	panic("Wrong type. Expected float, received set.")
+}
+
+// Contains reports whether x is an element of the set.
+func (s MilaSet) Contains(x int64) bool {
+	return x >= 0 && x <= MaxSetElement && s[x/64]&(1<<uint(x%64)) != 0
+}
+
+// Include adds x to the set, elements out of range are ignored.
+func (s *MilaSet) Include(x int64) {
+	if x >= 0 && x <= MaxSetElement {
+		s[x/64] |= 1 << uint(x%64)
+	}
+}
+
+func (m MilaInt) isValue()     {}
+func (m MilaString) isValue()  {}
+func (m MilaReal) isValue()    {}
+func (b MilaBoolean) isValue() {}
+func (s MilaSet) isValue()     {}
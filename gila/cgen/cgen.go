@@ -0,0 +1,486 @@
+// Package cgen translates programs to portable C99, so they can be built with just a C compiler.
+// The generated code is linked with the runtime library in fce.c. Functions keep their names,
+// control flow is mapped to structured C and #line directives point back to the source.
+//
+// Integers wrap around the same way as in the code emitted by the ir package: arithmetic is performed
+// on unsigned integers, which can't overflow in C, and converted back to the type of the operation.
+package cgen
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"sort"
+	"strings"
+)
+
+// Options change the generated code.
+type Options struct {
+	// OverflowChecks stop the program on integer overflow, the same way as ir.Options do.
+	// Division by zero is always reported.
+	OverflowChecks bool
+	// File is the name of the source file used in #line directives. No directives are emitted, when it is empty.
+	File string
+}
+
+// symbol is a variable or a constant visible in the function being generated.
+type symbol struct {
+	name     string
+	t        ast.Type
+	constant bool
+	value    int64
+}
+
+type scope struct {
+	parent  *scope
+	symbols map[string]*symbol
+}
+
+func (s *scope) lookup(name string) *symbol {
+	if v, ok := s.symbols[name]; ok {
+		return v
+	} else if s.parent != nil {
+		return s.parent.lookup(name)
+	}
+	return nil
+}
+
+type generator struct {
+	info      *checker.Info
+	options   Options
+	functions map[string]*ast.Function
+	// helpers are the definitions of static functions used by the generated code, keyed by their names
+	helpers map[string]string
+	code    strings.Builder
+	indent  int
+	// function is the function being generated
+	function *ast.Function
+	scope    *scope
+	loops    int
+	temps    int
+	// pre are the statements, that have to be executed before the current one, so that its
+	// expressions are evaluated from left to right
+	pre []string
+}
+
+// Generate type checks a program and translates it into a C source file. Errors in the program panic.
+func Generate(program *ast.Program, options Options) string {
+	if program.Library {
+		panic(fmt.Sprintf("Library %s can only be compiled by the llvm backend.", program.Name))
+	}
+	if program.Unit || len(program.Uses) > 0 {
+		panic(fmt.Sprintf("Units can only be compiled by the llvm backend, %s is a unit or uses units.", program.Name))
+	}
+	g := &generator{
+		info:      checker.Check(program),
+		options:   options,
+		functions: make(map[string]*ast.Function),
+		helpers:   make(map[string]string),
+	}
+	var order []string
+	for _, f := range program.Functions {
+		name := f.Signature.Name
+		if _, ok := g.functions[name]; !ok {
+			order = append(order, name)
+		}
+		if f.Signature.External {
+			panic(fmt.Sprintf("External function %s is only supported by the llvm backend.", f.Signature.Name))
+		}
+		// Forward declarations are replaced by implementations
+		if f.Body != nil || g.functions[name] == nil {
+			g.functions[name] = f
+		}
+	}
+	for _, name := range order {
+		if f := g.functions[name]; f.Body != nil {
+			g.generateFunction(f)
+		}
+	}
+
+	var out strings.Builder
+	if options.File != "" {
+		fmt.Fprintf(&out, "/* Generated by gila from %s. Link it with fce.c. */\n", options.File)
+	} else {
+		fmt.Fprintf(&out, "/* Generated by gila. Link it with fce.c. */\n")
+	}
+	out.WriteString(prelude)
+	if len(g.helpers) > 0 {
+		names := make([]string, 0, len(g.helpers))
+		for name := range g.helpers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			out.WriteString("\n")
+			out.WriteString(g.helpers[name])
+		}
+	}
+	out.WriteString("\n")
+	for _, name := range order {
+		if name != "main" {
+			fmt.Fprintf(&out, "%s;\n", g.signature(g.functions[name].Signature))
+		}
+	}
+	out.WriteString(g.code.String())
+	return out.String()
+}
+
+// cType is the C type of a value of type t.
+func cType(t ast.Type) string {
+	if _, ok := t.(ast.Set); ok {
+		return "gila_set"
+	}
+	switch t {
+	case ast.INT:
+		return "int32_t"
+	case ast.BYTE:
+		return "uint8_t"
+	case ast.SHORTINT:
+		return "int8_t"
+	case ast.WORD:
+		return "uint16_t"
+	case ast.CARDINAL:
+		return "uint32_t"
+	case ast.INT64:
+		return "int64_t"
+	case ast.REAL:
+		return "double"
+	case ast.BOOLEAN:
+		return "bool"
+	case ast.VOID:
+		return "void"
+	default:
+		panic(fmt.Sprintf("Type %v can not be represented in C.", t))
+	}
+}
+
+// reserved are identifiers, which can't be used for functions and variables in C, or are used by the generated code.
+var reserved = map[string]bool{}
+
+func init() {
+	for _, name := range strings.Fields(`auto break case char const continue default do double else enum extern float for
+		goto if inline int long register restrict return short signed sizeof static struct switch typedef union unsigned
+		void volatile while _Bool _Complex _Imaginary bool true false
+		int8_t uint8_t int16_t uint16_t int32_t uint32_t int64_t uint64_t
+		write_integer write_real write_boolean write_string write_newline read_integer read_real read_newline runtime_error`) {
+		reserved[name] = true
+	}
+}
+
+// identifier maps a name from the source to a valid C identifier. Names, that are reserved in C or prefixed
+// with gila_, which is used by the generated code, get an underscore appended.
+func identifier(name string) string {
+	if reserved[name] || strings.HasPrefix(name, "gila_") {
+		return name + "_"
+	}
+	return name
+}
+
+// local is the C name of a variable. Variables can't shadow functions, because they might be called.
+func (g *generator) local(name string) string {
+	if _, ok := g.functions[name]; ok {
+		return name + "_"
+	}
+	return identifier(name)
+}
+
+func (g *generator) signature(s *ast.Signature) string {
+	var params []string
+	for _, p := range s.Parameters {
+		params = append(params, cType(p.Type)+" "+g.local(p.Name))
+	}
+	if len(params) == 0 {
+		params = []string{"void"}
+	}
+	return fmt.Sprintf("%s %s(%s)", cType(s.Return), identifier(s.Name), strings.Join(params, ", "))
+}
+
+func (g *generator) line(format string, args ...interface{}) {
+	g.code.WriteString(strings.Repeat("    ", g.indent))
+	fmt.Fprintf(&g.code, format, args...)
+	g.code.WriteString("\n")
+}
+
+// lineDirective maps the following code to a position in the source.
+func (g *generator) lineDirective(pos token.Position) {
+	if g.options.File != "" && pos.Line > 0 {
+		fmt.Fprintf(&g.code, "#line %d %s\n", pos.Line, quote(g.options.File))
+	}
+}
+
+// flush emits the statements, that the current statement depends on.
+func (g *generator) flush() {
+	for _, s := range g.pre {
+		g.line("%s", s)
+	}
+	g.pre = nil
+}
+
+func (g *generator) generateFunction(f *ast.Function) {
+	g.function, g.loops, g.temps = f, 0, 0
+	g.scope = &scope{symbols: make(map[string]*symbol)}
+	for _, p := range f.Signature.Parameters {
+		g.scope.symbols[p.Name] = &symbol{name: g.local(p.Name), t: p.Type}
+	}
+	g.code.WriteString("\n")
+	g.lineDirective(f.Position)
+	if f.Signature.Name == "main" {
+		g.line("int main(void) {")
+	} else {
+		g.line("%s {", g.signature(f.Signature))
+	}
+	g.indent++
+	if f.Signature.Return != ast.VOID {
+		// The result is assigned to a variable named after the function
+		g.scope.symbols[f.Signature.Name] = &symbol{name: "gila_result", t: f.Signature.Return}
+		g.line("%s gila_result = %s;", cType(f.Signature.Return), zero(f.Signature.Return))
+	}
+	g.statement(f.Body)
+	g.line("%s", g.returnStatement())
+	g.indent--
+	g.line("}")
+	g.function, g.scope = nil, nil
+}
+
+func (g *generator) returnStatement() string {
+	switch {
+	case g.function.Signature.Name == "main":
+		return "return 0;"
+	case g.function.Signature.Return != ast.VOID:
+		return "return gila_result;"
+	default:
+		return "return;"
+	}
+}
+
+func zero(t ast.Type) string {
+	if _, ok := t.(ast.Set); ok {
+		return "gila_set_empty()"
+	}
+	switch t {
+	case ast.REAL:
+		return "0.0"
+	case ast.BOOLEAN:
+		return "false"
+	default:
+		return "0"
+	}
+}
+
+func (g *generator) lookup(name string) *symbol {
+	if s := g.scope.lookup(name); s != nil {
+		return s
+	}
+	panic(fmt.Sprintf("Undefined symbol %s.", name))
+}
+
+func (g *generator) statement(node ast.Statement) {
+	switch n := node.(type) {
+	case *ast.Block:
+		g.line("{")
+		g.indent++
+		g.blockStatements(n)
+		g.indent--
+		g.line("}")
+	case *ast.VariableDeclaration:
+		s := &symbol{name: g.local(n.Name), t: n.Type}
+		g.scope.symbols[n.Name] = s
+		g.line("%s %s = %s;", cType(n.Type), s.name, zero(n.Type))
+	case *ast.ConstantDeclaration:
+		// Constants are replaced by their values, integer constants can still be referred to by name
+		if l, ok := n.Value.(*ast.Literal); ok {
+			g.scope.symbols[n.Name] = &symbol{t: g.info.TypeOf(l), constant: true, value: l.Value}
+		}
+	case *ast.Assignment:
+		g.lineDirective(n.Position)
+		g.assign(n)
+	case *ast.ProcedureCall:
+		g.lineDirective(n.Position)
+		g.procedureCall(n)
+	case *ast.If:
+		g.lineDirective(n.Position)
+		condition := g.expression(n.Condition)
+		g.flush()
+		g.line("if (%s) {", unparen(condition))
+		g.body(n.Then)
+		if n.Else != nil {
+			g.line("} else {")
+			g.body(n.Else)
+		}
+		g.line("}")
+	case *ast.While:
+		g.lineDirective(n.Position)
+		condition := g.expression(n.Condition)
+		g.loop(condition, n.Body, "")
+	case *ast.For:
+		g.lineDirective(n.Position)
+		g.assign(n.Initial)
+		variable := g.lookup(n.Initial.Variable.Name)
+		target := g.expression(n.Target)
+		// The loop runs while the variable differs from the target, the same way as in the ir package
+		condition := fmt.Sprintf("(%s != %s)", variable.name, target)
+		if targetType := g.info.TypeOf(n.Target); targetType != variable.t {
+			condition = fmt.Sprintf("((int64_t)%s != (int64_t)%s)", variable.name, target)
+		}
+		op := "-"
+		if n.Upto {
+			op = "+"
+		}
+		g.loop(condition, n.Body, fmt.Sprintf("%s = %s", variable.name, wrapping(cType(variable.t), variable.name, op, "1")))
+	case *ast.Break:
+		// break outside of a loop does nothing
+		if g.loops > 0 {
+			g.lineDirective(n.Position)
+			g.line("break;")
+		}
+	case *ast.Exit:
+		g.lineDirective(n.Position)
+		g.line("%s", g.returnStatement())
+	default:
+		panic("Unknown statement type!")
+	}
+}
+
+func (g *generator) blockStatements(b *ast.Block) {
+	g.scope = &scope{parent: g.scope, symbols: make(map[string]*symbol)}
+	for _, s := range b.Statements {
+		g.statement(s)
+	}
+	g.scope = g.scope.parent
+}
+
+// body emits a statement nested in braces of an if or a loop.
+func (g *generator) body(s ast.Statement) {
+	g.indent++
+	if b, ok := s.(*ast.Block); ok {
+		g.blockStatements(b)
+	} else {
+		g.statement(s)
+	}
+	g.indent--
+}
+
+// loop emits a loop with a condition, which has just been generated. update is executed after every iteration,
+// that didn't break. Conditions, that need statements to be evaluated in order, are checked inside of the loop.
+func (g *generator) loop(condition string, body ast.Statement, update string) {
+	g.loops++
+	if len(g.pre) == 0 {
+		if update != "" {
+			g.line("for (; %s; %s) {", unparen(condition), update)
+		} else {
+			g.line("while (%s) {", unparen(condition))
+		}
+		g.body(body)
+	} else {
+		g.line("for (;;) {")
+		g.indent++
+		g.flush()
+		g.line("if (!%s) {", condition)
+		g.line("    break;")
+		g.line("}")
+		g.indent--
+		g.body(body)
+		if update != "" {
+			g.line("    %s;", update)
+		}
+	}
+	g.line("}")
+	g.loops--
+}
+
+func (g *generator) assign(a *ast.Assignment) {
+	value := g.convert(g.expression(a.Value), g.info.TypeOf(a.Value), g.info.TypeOf(&a.Variable))
+	g.flush()
+	g.line("%s = %s;", g.lookup(a.Variable.Name).name, unparen(value))
+}
+
+func (g *generator) procedureCall(pc *ast.ProcedureCall) {
+	switch {
+	case checker.Intrinsics[pc.Name]:
+		g.intrinsic(pc)
+	case pc.Name == "inc" || pc.Name == "dec":
+		variable := g.lookup(pc.Args[0].(*ast.Variable).Name)
+		op, operation := "+", ast.PLUS
+		if pc.Name == "dec" {
+			op, operation = "-", ast.MINUS
+		}
+		if g.options.OverflowChecks {
+			t := checker.StepType(variable.t)
+			sum := g.checked(operation, g.convert(variable.name, variable.t, t), "1", t, pc.Position)
+			g.line("%s = %s;", variable.name, unparen(g.convert(sum, t, variable.t)))
+			return
+		}
+		g.line("%s = %s;", variable.name, wrapping(cType(variable.t), variable.name, op, "1"))
+	default:
+		call := g.call(pc.Name, pc.Args)
+		g.flush()
+		g.line("%s;", call)
+	}
+}
+
+func (g *generator) intrinsic(pc *ast.ProcedureCall) {
+	switch pc.Name {
+	case "write", "writeln":
+		for _, a := range pc.Args {
+			g.write(a)
+		}
+		if pc.Name == "writeln" {
+			g.line("write_newline();")
+		}
+	case "read", "readln":
+		for _, a := range pc.Args {
+			variable := g.lookup(a.(*ast.Variable).Name)
+			if variable.t == ast.REAL {
+				g.line("read_real(&%s);", variable.name)
+			} else {
+				// The variable stays the same, when there is no number to read
+				g.line("{")
+				g.line("    long long gila_read = %s;", variable.name)
+				g.line("    read_integer(&gila_read);")
+				g.line("    %s = (%s)gila_read;", variable.name, cType(variable.t))
+				g.line("}")
+			}
+		}
+		if pc.Name == "readln" {
+			g.line("read_newline();")
+		}
+	}
+}
+
+func (g *generator) write(a ast.Expression) {
+	operands := []ast.Expression{a}
+	format, formatted := a.(*ast.Format)
+	if formatted {
+		operands = []ast.Expression{format.Value, format.Width}
+		if format.Precision != nil {
+			operands = append(operands, format.Precision)
+		}
+		a = format.Value
+	}
+	args := g.ordered(operands)
+	width, precision := "0", "-1"
+	if formatted {
+		width = fmt.Sprintf("(int)%s", args[1])
+		if format.Precision != nil {
+			precision = fmt.Sprintf("(int)%s", args[2])
+		}
+	}
+	g.flush()
+	t := g.info.TypeOf(a)
+	if _, ok := a.(ast.StringLiteral); ok {
+		t = ast.STRING
+	}
+	switch {
+	case t == ast.REAL:
+		g.line("write_real(%s, %s, %s);", unparen(args[0]), width, precision)
+	case t == ast.BOOLEAN:
+		g.line("write_boolean(%s, %s);", unparen(args[0]), width)
+	case t == ast.STRING:
+		g.line("write_string(%s, %s);", args[0], width)
+	case ast.IsInteger(t):
+		g.line("write_integer((long long)%s, %s);", args[0], width)
+	default:
+		panic(fmt.Sprintf("Can not write %v.", a))
+	}
+}
@@ -0,0 +1,120 @@
+package cgen
+
+import (
+	"bytes"
+	"gitlab.fit.cvut.cz/fedorgle/gila/interp"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func generate(source string, options Options) string {
+	return Generate(parser.New(lexer.New(strings.NewReader(source))).Parse(), options)
+}
+
+func Test_Generate(t *testing.T) {
+	code := generate(`program names;
+function int(x: integer): integer;
+begin
+	int := x * 2;
+end;
+procedure show(x: integer);
+begin
+	writeln(x);
+end;
+var show2, gila_x: integer;
+begin
+	show2 := int(1) + int(2);
+	gila_x := show2;
+	show(gila_x);
+end.`, Options{File: "names.mila"})
+	expected := []string{
+		`#line 2 "names.mila"`,
+		"int32_t int_(int32_t x) {",
+		"void show(int32_t x);",
+		`#line 12 "names.mila"`,
+		// Calls are evaluated from left to right
+		"int32_t gila_t1 = int_(1);",
+		"show2 = (int32_t)((uint64_t)gila_t1 + (uint64_t)int_(2));",
+		"int32_t gila_x_ = 0;",
+		"int main(void) {",
+	}
+	for _, e := range expected {
+		if !strings.Contains(code, e) {
+			t.Errorf("Expected %q in the generated code:\n%s", e, code)
+		}
+	}
+	if strings.Contains(generate("program p; begin writeln(1); end.", Options{}), "#line") {
+		t.Error("Line directives were generated without a file name.")
+	}
+}
+
+// compile builds C code with the runtime library and returns the path to the executable.
+func compile(t *testing.T, dir, code string) string {
+	c, exe := filepath.Join(dir, "program.c"), filepath.Join(dir, "program")
+	ioutil.WriteFile(c, []byte(code), 0644)
+	out, err := exec.Command("cc", "-std=c99", "-pedantic-errors", "-o", exe, c, filepath.Join(dir, "fce.c")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("cc failed: %s\n%s", out, code)
+	}
+	return exe
+}
+
+func tempDir(t *testing.T) string {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc is not installed")
+	}
+	dir, err := ioutil.TempDir("", "cgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "fce.c"), []byte(rtl.Source), 0644)
+	return dir
+}
+
+// Test_Samples compares the output of compiled C code with the output of the interpreter.
+func Test_Samples(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	samples, _ := filepath.Glob("../../samples/*.mila")
+	for _, sample := range samples {
+		source, _ := ioutil.ReadFile(sample)
+		var expected bytes.Buffer
+		program := parser.New(lexer.New(bytes.NewReader(source))).Parse()
+		interp.Run(program, strings.NewReader("7\n"), &expected, interp.Options{})
+		exe := compile(t, dir, generate(string(source), Options{File: sample}))
+		cmd := exec.Command(exe)
+		cmd.Stdin = strings.NewReader("7\n")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Errorf("%s failed: %v", sample, err)
+		}
+		if string(out) != expected.String() {
+			t.Errorf("%s printed %q, the interpreter printed %q", sample, out, expected.String())
+		}
+	}
+}
+
+func Test_RuntimeErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, c := range rtl.ErrorCases {
+		exe := compile(t, dir, generate(c.Source, Options{OverflowChecks: c.OverflowChecks}))
+		var stderr bytes.Buffer
+		cmd := exec.Command(exe)
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if message := strings.TrimSpace(stderr.String()); message != c.Message() {
+			t.Errorf("%q reported %q, expected %q", c.Source, message, c.Message())
+		}
+		if (err == nil) != (c.Error == nil) {
+			t.Errorf("%q exited with %v", c.Source, err)
+		}
+	}
+}
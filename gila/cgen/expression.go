@@ -0,0 +1,315 @@
+package cgen
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Expressions are generated either as a primary expression or in parentheses, so they can be used as operands
+// without regard to the precedence of C operators.
+
+func (g *generator) expression(expression ast.Expression) string {
+	switch e := expression.(type) {
+	case *ast.Literal:
+		return literal(e.Value)
+	case *ast.RealLiteral:
+		return realLiteral(e.Value)
+	case ast.StringLiteral:
+		return quote(e.Value)
+	case *ast.Variable:
+		s := g.lookup(e.Name)
+		if s.constant {
+			return literal(s.value)
+		}
+		return s.name
+	case *ast.Binary:
+		return g.binary(e)
+	case *ast.Unary:
+		return g.unary(e)
+	case *ast.FunctionCall:
+		return g.call(e.Name, e.Args)
+	case *ast.SetConstructor:
+		return g.setConstructor(e)
+	default:
+		panic("Not all expressions are implemented yet!")
+	}
+}
+
+func literal(x int64) string {
+	switch {
+	case x == math.MinInt64:
+		return "(-INT64_C(9223372036854775807) - 1)"
+	case x < math.MinInt32 || x > math.MaxInt32:
+		return fmt.Sprintf("INT64_C(%d)", x)
+	case x == math.MinInt32:
+		return "(-2147483647 - 1)"
+	case x < 0:
+		return fmt.Sprintf("(%d)", x)
+	default:
+		return strconv.FormatInt(x, 10)
+	}
+}
+
+func realLiteral(x float64) string {
+	s := strconv.FormatFloat(x, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	if x < 0 {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// quote returns a C string literal. Characters outside of printable ASCII are escaped in octal.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\' || c == '?':
+			// ? is escaped to avoid trigraphs
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unparen removes parentheses around a whole expression.
+func unparen(s string) string {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return s
+	}
+	depth := 0
+	for i := 0; i < len(s)-1; i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '"':
+			return s
+		}
+		if depth == 0 {
+			return s
+		}
+	}
+	return s[1 : len(s)-1]
+}
+
+// effects reports whether evaluating an expression can be observed, because it calls a function or stops the
+// program with a runtime error. Such expressions have to be evaluated in the order, in which they are written.
+func (g *generator) effects(expression ast.Expression) bool {
+	switch e := expression.(type) {
+	case *ast.FunctionCall:
+		return true
+	case *ast.Binary:
+		if ast.IsInteger(g.info.TypeOf(e)) {
+			switch e.Operation {
+			case ast.DIV, ast.MOD:
+				return true
+			case ast.PLUS, ast.MINUS, ast.MULTIPLY:
+				if g.options.OverflowChecks {
+					return true
+				}
+			}
+		}
+		return g.effects(e.Left) || g.effects(e.Right)
+	case *ast.Unary:
+		if e.Operation == ast.MINUS && g.options.OverflowChecks && ast.IsInteger(g.info.TypeOf(e)) {
+			return true
+		}
+		return g.effects(e.Operand)
+	case *ast.SetConstructor:
+		for _, element := range e.Elements {
+			if g.effects(element.Low) || (element.High != nil && g.effects(element.High)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ordered generates operands, which C may evaluate in any order. When more than one of them has effects,
+// all but the last one are evaluated into temporaries, which are declared before the current statement.
+func (g *generator) ordered(operands []ast.Expression) []string {
+	last := -1
+	count := 0
+	for i, o := range operands {
+		if g.effects(o) {
+			last = i
+			count++
+		}
+	}
+	res := make([]string, len(operands))
+	for i, o := range operands {
+		res[i] = g.expression(o)
+		if count > 1 && i < last && g.effects(o) {
+			g.temps++
+			temp := fmt.Sprintf("gila_t%d", g.temps)
+			g.pre = append(g.pre, fmt.Sprintf("%s %s = %s;", cType(g.info.TypeOf(o)), temp, unparen(res[i])))
+			res[i] = temp
+		}
+	}
+	return res
+}
+
+// convert changes a value of type from to type to, the same way an assignment does.
+func (g *generator) convert(code string, from, to ast.Type) string {
+	if s, ok := to.(ast.Set); ok {
+		return g.truncateSet(code, s)
+	}
+	if from == to {
+		return code
+	}
+	if ast.IsInteger(to) || (to == ast.REAL && ast.IsInteger(from)) {
+		return fmt.Sprintf("((%s)%s)", cType(to), code)
+	}
+	return code
+}
+
+// wrapping performs an arithmetic operation in type t, which wraps around on overflow.
+func wrapping(t, left, op, right string) string {
+	return fmt.Sprintf("((%s)((uint64_t)%s %s (uint64_t)%s))", t, left, op, right)
+}
+
+var operators = map[ast.Operation]string{
+	ast.PLUS: "+", ast.MINUS: "-", ast.MULTIPLY: "*", ast.DIVIDE: "/",
+	ast.AND: "&", ast.OR: "|", ast.XOR: "^",
+	ast.EQUALS: "==", ast.NOTEQUALS: "!=", ast.LESS: "<", ast.LESSEQ: "<=", ast.GREATER: ">", ast.GREATEREQ: ">=",
+}
+
+func (g *generator) binary(e *ast.Binary) string {
+	leftType, rightType := g.info.TypeOf(e.Left), g.info.TypeOf(e.Right)
+	operands := g.ordered([]ast.Expression{e.Left, e.Right})
+	left, right := operands[0], operands[1]
+	if e.Operation == ast.IN {
+		g.useSets()
+		return fmt.Sprintf("gila_set_in((int64_t)%s, %s)", left, right)
+	}
+	if _, ok := leftType.(ast.Set); ok {
+		return g.setBinary(e.Operation, left, right, checker.UnionType(leftType.(ast.Set), rightType.(ast.Set)))
+	}
+	if leftType == ast.BOOLEAN {
+		// Both operands are evaluated, the same way as in the ir package
+		switch e.Operation {
+		case ast.AND, ast.OR, ast.XOR, ast.EQUALS, ast.NOTEQUALS:
+			return fmt.Sprintf("(%s %s %s)", left, operators[e.Operation], right)
+		default:
+			panic("Invalid operation on booleans.")
+		}
+	}
+	t := checker.CommonType(leftType, rightType)
+	if t == ast.REAL || e.Operation == ast.DIVIDE {
+		op, ok := operators[e.Operation]
+		if !ok {
+			panic("Invalid operation on reals.")
+		}
+		return fmt.Sprintf("(%s %s %s)", g.convert(left, leftType, ast.REAL), op, g.convert(right, rightType, ast.REAL))
+	}
+	if e.Operation == ast.SHL || e.Operation == ast.SHR {
+		return shift(e.Operation, g.convert(left, leftType, g.info.TypeOf(e)), right, g.info.TypeOf(e))
+	}
+	left, right = g.convert(left, leftType, t), g.convert(right, rightType, t)
+	switch e.Operation {
+	case ast.PLUS, ast.MINUS, ast.MULTIPLY:
+		if g.options.OverflowChecks {
+			return g.checked(e.Operation, left, right, t, e.Position)
+		}
+		return wrapping(cType(t), left, operators[e.Operation], right)
+	case ast.DIV, ast.MOD:
+		return g.checked(e.Operation, left, right, t, e.Position)
+	case ast.AND, ast.OR, ast.XOR:
+		return fmt.Sprintf("((%s)(%s %s %s))", cType(t), left, operators[e.Operation], right)
+	}
+	op, ok := operators[e.Operation]
+	if !ok {
+		panic(fmt.Sprintf("Invalid operation %v on integers.", e.Operation))
+	}
+	return fmt.Sprintf("(%s %s %s)", left, op, right)
+}
+
+// unsignedType is the unsigned C type with the width of an integer type.
+func unsignedType(t ast.Type) string {
+	return "u" + strings.TrimPrefix(cType(t), "u")
+}
+
+// width is the number of bits of an integer type.
+func width(t ast.Type) int {
+	switch t {
+	case ast.BYTE, ast.SHORTINT:
+		return 8
+	case ast.WORD:
+		return 16
+	case ast.INT64:
+		return 64
+	default:
+		return 32
+	}
+}
+
+// shift shifts an integer of type t. The count is masked to the width of t, the same way as in the ir package.
+// shr is a logical shift, the sign bit is not extended.
+func shift(op ast.Operation, x, count string, t ast.Type) string {
+	mask := width(t) - 1
+	if op == ast.SHL {
+		return fmt.Sprintf("((%s)((uint64_t)(%s)%s << (%s & %d)))", cType(t), unsignedType(t), x, count, mask)
+	}
+	return fmt.Sprintf("((%s)((%s)%s >> (%s & %d)))", cType(t), unsignedType(t), x, count, mask)
+}
+
+func (g *generator) unary(u *ast.Unary) string {
+	t := g.info.TypeOf(u)
+	operand := g.expression(u.Operand)
+	switch u.Operation {
+	case ast.PLUS:
+		return operand
+	case ast.MINUS:
+		if t == ast.REAL {
+			return fmt.Sprintf("(-%s)", operand)
+		}
+		operand = g.convert(operand, g.info.TypeOf(u.Operand), t)
+		if g.options.OverflowChecks {
+			return g.checked(ast.MINUS, "0", operand, t, u.Position)
+		}
+		return wrapping(cType(t), "0", "-", operand)
+	case ast.NOT:
+		if t == ast.BOOLEAN {
+			return fmt.Sprintf("(!%s)", operand)
+		}
+		return fmt.Sprintf("((%s)~%s)", cType(t), g.convert(operand, g.info.TypeOf(u.Operand), t))
+	default:
+		panic("Invalid operation type inside Unary node.")
+	}
+}
+
+// call generates a call of a function, converting its arguments to the types of the parameters.
+func (g *generator) call(name string, args []ast.Expression) string {
+	f, ok := g.functions[name]
+	if !ok {
+		panic(fmt.Sprintf("Call to an undefined function %s.", name))
+	}
+	values := g.ordered(args)
+	for i, a := range args {
+		values[i] = unparen(g.convert(values[i], g.info.TypeOf(a), f.Signature.Parameters[i].Type))
+	}
+	return fmt.Sprintf("%s(%s)", identifier(name), strings.Join(values, ", "))
+}
+
+// checked calls a helper, that performs an operation on integers of type t and stops the program with a runtime error at pos.
+func (g *generator) checked(op ast.Operation, left, right string, t ast.Type, pos token.Position) string {
+	name := g.arithmeticHelper(op, t)
+	return fmt.Sprintf("%s(%s, %s, %d, %d)", name, unparen(left), unparen(right), pos.Line, pos.Col)
+}
@@ -0,0 +1,252 @@
+package cgen
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"strings"
+)
+
+// prelude declares the functions of fce.c. It is the same for every program, helpers follow it.
+const prelude = `#include <stdbool.h>
+#include <stdint.h>
+
+int write_integer(long long x, int width);
+int write_real(double x, int width, int precision);
+int write_boolean(int x, int width);
+int write_string(char *x, int width);
+int write_newline(void);
+int read_integer(long long *x);
+int read_real(double *x);
+int read_newline(void);
+int runtime_error(int code, int line, int col);
+`
+
+// setSupport represents every set by 256 bits. Sets are truncated to the number of elements,
+// that their representation in the ir package can hold, when they are converted.
+const setSupport = `typedef struct {
+    uint64_t w[4];
+} gila_set;
+
+static inline gila_set gila_set_empty(void) {
+    gila_set r = {{0, 0, 0, 0}};
+    return r;
+}
+
+static inline gila_set gila_set_range(gila_set s, int64_t low, int64_t high) {
+    for (int64_t i = low < 0 ? 0 : low; i <= high && i < 256; i++) {
+        s.w[i / 64] |= (uint64_t)1 << (i % 64);
+    }
+    return s;
+}
+
+static inline gila_set gila_set_element(gila_set s, int64_t x) {
+    return gila_set_range(s, x, x);
+}
+
+static inline gila_set gila_set_truncate(gila_set s, int capacity) {
+    for (int i = 0; i < 4; i++) {
+        int bits = capacity - i * 64;
+        if (bits <= 0) {
+            s.w[i] = 0;
+        } else if (bits < 64) {
+            s.w[i] &= ((uint64_t)1 << bits) - 1;
+        }
+    }
+    return s;
+}
+
+static inline bool gila_set_in(int64_t x, gila_set s) {
+    return x >= 0 && x < 256 && (s.w[x / 64] >> (x % 64) & 1);
+}
+
+static inline gila_set gila_set_union(gila_set a, gila_set b) {
+    for (int i = 0; i < 4; i++) {
+        a.w[i] |= b.w[i];
+    }
+    return a;
+}
+
+static inline gila_set gila_set_intersection(gila_set a, gila_set b) {
+    for (int i = 0; i < 4; i++) {
+        a.w[i] &= b.w[i];
+    }
+    return a;
+}
+
+static inline gila_set gila_set_difference(gila_set a, gila_set b) {
+    for (int i = 0; i < 4; i++) {
+        a.w[i] &= ~b.w[i];
+    }
+    return a;
+}
+
+static inline bool gila_set_equal(gila_set a, gila_set b) {
+    for (int i = 0; i < 4; i++) {
+        if (a.w[i] != b.w[i]) {
+            return false;
+        }
+    }
+    return true;
+}
+
+static inline bool gila_set_subset(gila_set a, gila_set b) {
+    for (int i = 0; i < 4; i++) {
+        if (a.w[i] & ~b.w[i]) {
+            return false;
+        }
+    }
+    return true;
+}
+`
+
+// useSets adds the set type and its functions to the generated code. It is sorted before other helpers.
+func (g *generator) useSets() {
+	g.helpers["gila_set"] = setSupport
+}
+
+// capacity is the number of elements, that the LLVM representation of a set type can hold.
+func capacity(s ast.Set) int64 {
+	switch {
+	case s.High < 32:
+		return 32
+	case s.High < 64:
+		return 64
+	default:
+		return ast.MaxSetElement + 1
+	}
+}
+
+func (g *generator) truncateSet(code string, s ast.Set) string {
+	g.useSets()
+	if c := capacity(s); c <= ast.MaxSetElement {
+		return fmt.Sprintf("gila_set_truncate(%s, %d)", code, c)
+	}
+	return code
+}
+
+func (g *generator) setConstructor(s *ast.SetConstructor) string {
+	g.useSets()
+	var operands []ast.Expression
+	for _, e := range s.Elements {
+		operands = append(operands, e.Low)
+		if e.High != nil {
+			operands = append(operands, e.High)
+		}
+	}
+	values := g.ordered(operands)
+	res := "gila_set_empty()"
+	for _, e := range s.Elements {
+		if e.High != nil {
+			res = fmt.Sprintf("gila_set_range(%s, %s, %s)", res, unparen(values[0]), unparen(values[1]))
+			values = values[2:]
+		} else {
+			res = fmt.Sprintf("gila_set_element(%s, %s)", res, unparen(values[0]))
+			values = values[1:]
+		}
+	}
+	return res
+}
+
+// setBinary performs an operation on sets. Both operands are truncated to the representation of the result.
+func (g *generator) setBinary(op ast.Operation, left, right string, t ast.Set) string {
+	left, right = unparen(g.truncateSet(left, t)), unparen(g.truncateSet(right, t))
+	switch op {
+	case ast.PLUS:
+		return fmt.Sprintf("gila_set_union(%s, %s)", left, right)
+	case ast.MULTIPLY:
+		return fmt.Sprintf("gila_set_intersection(%s, %s)", left, right)
+	case ast.MINUS:
+		return fmt.Sprintf("gila_set_difference(%s, %s)", left, right)
+	case ast.EQUALS:
+		return fmt.Sprintf("gila_set_equal(%s, %s)", left, right)
+	case ast.NOTEQUALS:
+		return fmt.Sprintf("(!gila_set_equal(%s, %s))", left, right)
+	case ast.LESSEQ:
+		return fmt.Sprintf("gila_set_subset(%s, %s)", left, right)
+	case ast.GREATEREQ:
+		return fmt.Sprintf("gila_set_subset(%s, %s)", right, left)
+	default:
+		panic("Invalid operation on sets.")
+	}
+}
+
+var helperNames = map[ast.Operation]string{
+	ast.PLUS: "add", ast.MINUS: "sub", ast.MULTIPLY: "mul", ast.DIV: "div", ast.MOD: "mod",
+}
+
+// arithmeticHelper defines a function, that performs an operation on integers of type t and reports runtime errors.
+// It returns the name of the function.
+func (g *generator) arithmeticHelper(op ast.Operation, t ast.Type) string {
+	ct := cType(t)
+	name := fmt.Sprintf("gila_%s_%s", helperNames[op], strings.TrimSuffix(ct, "_t"))
+	if _, ok := g.helpers[name]; ok {
+		return name
+	}
+	var body string
+	switch op {
+	case ast.DIV, ast.MOD:
+		body = g.divisionBody(op, t)
+	default:
+		body = arithmeticBody(op, t)
+	}
+	g.helpers[name] = fmt.Sprintf("static inline %s %s(%s a, %s b, int line, int col) {\n%s}\n", ct, name, ct, ct, body)
+	return name
+}
+
+func arithmeticBody(op ast.Operation, t ast.Type) string {
+	ct, o := cType(t), operators[op]
+	if width(t) < 64 {
+		// The operation can't overflow in 64 bits
+		wide := "int64_t"
+		if ast.IsUnsigned(t) {
+			wide = "uint64_t"
+		}
+		return fmt.Sprintf(`    %s r = (%s)a %s b;
+    if (r != (%s)r) {
+        runtime_error(%d, line, col);
+    }
+    return (%s)r;
+`, wide, wide, o, ct, rtl.ErrorOverflow, ct)
+	}
+	var overflow string
+	switch op {
+	case ast.PLUS:
+		overflow = "(a >= 0) == (b >= 0) && (r >= 0) != (a >= 0)"
+	case ast.MINUS:
+		overflow = "(a >= 0) != (b >= 0) && (r >= 0) != (a >= 0)"
+	default:
+		overflow = "a != 0 && ((a == -1 && b == INT64_MIN) || r / a != b)"
+	}
+	return fmt.Sprintf(`    int64_t r = (int64_t)((uint64_t)a %s (uint64_t)b);
+    if (%s) {
+        runtime_error(%d, line, col);
+    }
+    return r;
+`, o, overflow, rtl.ErrorOverflow)
+}
+
+func (g *generator) divisionBody(op ast.Operation, t ast.Type) string {
+	o := "/"
+	if op == ast.MOD {
+		o = "%"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "    if (b == 0) {\n        runtime_error(%d, line, col);\n    }\n", rtl.ErrorDivisionByZero)
+	if !ast.IsUnsigned(t) {
+		// The smallest integer divided by -1 doesn't fit into its type
+		min := fmt.Sprintf("INT%d_MIN", width(t))
+		fmt.Fprintf(&b, "    if (b == -1 && a == %s) {\n", min)
+		if g.options.OverflowChecks {
+			fmt.Fprintf(&b, "        runtime_error(%d, line, col);\n", rtl.ErrorOverflow)
+		}
+		if op == ast.DIV {
+			b.WriteString("        return a;\n")
+		} else {
+			b.WriteString("        return 0;\n")
+		}
+		b.WriteString("    }\n")
+	}
+	fmt.Fprintf(&b, "    return a %s b;\n", o)
+	return b.String()
+}
@@ -0,0 +1,562 @@
+package checker
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"math"
+)
+
+// Info holds the results of type checking a program.
+type Info struct {
+	// Types maps every checked expression to its type.
+	// Assignment targets are recorded as well, keyed by a pointer to Assignment.Variable.
+	Types map[ast.Expression]ast.Type
+}
+
+// TypeOf returns the type of an expression. Expressions, that were synthesized after checking, are integers.
+func (i *Info) TypeOf(e ast.Expression) ast.Type {
+	if t, ok := i.Types[e]; ok {
+		return t
+	}
+	return ast.INT
+}
+
+type scope struct {
+	parent    *scope
+	symbols   map[string]ast.Type
+	constants map[string]bool
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, symbols: make(map[string]ast.Type), constants: make(map[string]bool)}
+}
+
+func (s *scope) lookup(name string) ast.Type {
+	if t, ok := s.symbols[name]; ok {
+		return t
+	} else if s.parent != nil {
+		return s.parent.lookup(name)
+	}
+	return nil
+}
+
+// isConstant reports whether a name refers to a constant, which can't be assigned.
+func (s *scope) isConstant(name string) bool {
+	if _, ok := s.symbols[name]; ok {
+		return s.constants[name]
+	} else if s.parent != nil {
+		return s.parent.isConstant(name)
+	}
+	return false
+}
+
+type checker struct {
+	info      *Info
+	functions map[string]*ast.Signature
+	scope     *scope
+}
+
+// Builtins are the signatures of pre-defined functions, emitted by the ir package.
+// Parameters of type int64 accept any integer.
+var Builtins = map[string]*ast.Signature{
+	"inc": {Name: "inc", Return: ast.VOID, Parameters: []ast.Variable{{Name: "x", Type: ast.INT64}}},
+	"dec": {Name: "dec", Return: ast.VOID, Parameters: []ast.Variable{{Name: "x", Type: ast.INT64}}},
+}
+
+// referenceBuiltins accept their argument by reference, so it has to be a variable.
+var referenceBuiltins = map[string]bool{
+	"inc": true,
+	"dec": true,
+}
+
+// Intrinsics are built-in procedures, that accept any number of arguments of mixed types.
+// They have no signature, every argument is lowered by the ir package according to its type.
+var Intrinsics = map[string]bool{
+	"write":   true,
+	"writeln": true,
+	"read":    true,
+	"readln":  true,
+}
+
+// Check resolves types of all expressions in a program. It panics on the first type error.
+func Check(program *ast.Program) *Info {
+	c := &checker{
+		info:      &Info{Types: make(map[ast.Expression]ast.Type)},
+		functions: make(map[string]*ast.Signature),
+	}
+	for name, b := range Builtins {
+		c.functions[name] = b
+	}
+	external, implemented := make(map[string]bool), make(map[string]bool)
+	for _, f := range program.Functions {
+		checkSignature(f.Signature)
+		c.functions[f.Signature.Name] = f.Signature
+		external[f.Signature.Name] = external[f.Signature.Name] || f.Signature.External
+		implemented[f.Signature.Name] = implemented[f.Signature.Name] || f.Body != nil
+	}
+	for name := range external {
+		if external[name] && implemented[name] {
+			panic(fmt.Sprintf("External function %s can not be implemented.", name))
+		}
+	}
+	exported := make(map[string]bool)
+	for _, name := range program.Exports {
+		if exported[name] {
+			panic(fmt.Sprintf("Function %s is exported twice.", name))
+		}
+		exported[name] = true
+		if !implemented[name] {
+			panic(fmt.Sprintf("Exported function %s is not implemented.", name))
+		}
+		if program.Library {
+			checkExport(c.functions[name])
+		}
+	}
+	for _, f := range program.Functions {
+		if f.Body != nil {
+			c.checkFunction(f)
+		}
+	}
+	return c.info
+}
+
+// checkSignature checks the types of parameters and of the result. Strings can only be passed to external functions,
+// which receive them as pointers to characters, and external functions can't exchange sets with C.
+func checkSignature(s *ast.Signature) {
+	for _, p := range s.Parameters {
+		if _, ok := p.Type.(ast.Set); ok && s.External {
+			panic(fmt.Sprintf("Parameter %s of external function %s can not be a set.", p.Name, s.Name))
+		}
+		if p.Type == ast.STRING && !s.External {
+			panic(fmt.Sprintf("Parameter %s of %s can not be a string, only external functions accept strings.", p.Name, s.Name))
+		}
+	}
+	if _, ok := s.Return.(ast.Set); s.Return == ast.STRING || ok && s.External {
+		panic(fmt.Sprintf("Function %s can not return %v.", s.Name, s.Return))
+	}
+}
+
+// checkExport checks, that an exported function can be declared in C, which has no sets.
+func checkExport(s *ast.Signature) {
+	for _, p := range s.Parameters {
+		if _, ok := p.Type.(ast.Set); ok {
+			panic(fmt.Sprintf("Parameter %s of exported function %s can not be a set.", p.Name, s.Name))
+		}
+	}
+	if _, ok := s.Return.(ast.Set); ok {
+		panic(fmt.Sprintf("Exported function %s can not return %v.", s.Name, s.Return))
+	}
+}
+
+func (c *checker) checkFunction(f *ast.Function) {
+	c.scope = newScope(nil)
+	if f.Signature.Return != ast.VOID {
+		c.scope.symbols[f.Signature.Name] = f.Signature.Return
+	}
+	for _, p := range f.Signature.Parameters {
+		c.scope.symbols[p.Name] = p.Type
+	}
+	c.checkStatement(f.Body)
+	c.scope = nil
+}
+
+func (c *checker) checkStatement(node ast.Statement) {
+	switch n := node.(type) {
+	case *ast.Block:
+		c.scope = newScope(c.scope)
+		for _, s := range n.Statements {
+			c.checkStatement(s)
+		}
+		c.scope = c.scope.parent
+	case *ast.VariableDeclaration:
+		if n.Type == ast.STRING {
+			panic(fmt.Sprintf("Variable %s can not be a string.", n.Name))
+		}
+		c.scope.symbols[n.Name] = n.Type
+	case *ast.ConstantDeclaration:
+		c.scope.symbols[n.Name] = c.checkExpression(n.Value)
+		c.scope.constants[n.Name] = true
+	case *ast.Assignment:
+		c.checkAssignment(n)
+	case *ast.ProcedureCall:
+		if Intrinsics[n.Name] {
+			c.checkIntrinsic(n)
+		} else {
+			c.checkCall(n.Name, n.Args)
+		}
+	case *ast.If:
+		c.checkCondition(n.Condition)
+		c.checkStatement(n.Then)
+		if n.Else != nil {
+			c.checkStatement(n.Else)
+		}
+	case *ast.While:
+		c.checkCondition(n.Condition)
+		c.checkStatement(n.Body)
+	case *ast.For:
+		c.checkAssignment(n.Initial)
+		if t := c.info.Types[&n.Initial.Variable]; !ast.IsInteger(t) {
+			panic(fmt.Sprintf("For loop variable %s must be an integer, got %v.", n.Initial.Variable.Name, t))
+		}
+		if t := c.checkExpression(n.Target); !ast.IsInteger(t) {
+			panic(fmt.Sprintf("For loop bound must be an integer, got %v.", t))
+		}
+		c.checkStatement(n.Body)
+	case *ast.Break, *ast.Exit:
+	default:
+		panic("Unknown statement type!")
+	}
+}
+
+func (c *checker) checkAssignment(a *ast.Assignment) {
+	target := c.lookup(a.Variable.Name)
+	if c.scope.isConstant(a.Variable.Name) {
+		panic(fmt.Sprintf("Can not assign to constant %s.", a.Variable.Name))
+	}
+	value := c.checkExpression(a.Value)
+	if !assignable(target, value) {
+		panic(fmt.Sprintf("Can not assign %v to %s of type %v.", value, a.Variable.Name, target))
+	}
+	c.info.Types[&a.Variable] = target
+}
+
+func (c *checker) checkCondition(e ast.Expression) {
+	if t := c.checkExpression(e); t != ast.BOOLEAN {
+		panic(fmt.Sprintf("Condition %v must be a boolean expression, got %v.", e, t))
+	}
+}
+
+func (c *checker) checkCall(name string, args []ast.Expression) ast.Type {
+	signature, ok := c.functions[name]
+	if !ok {
+		panic(fmt.Sprintf("Call to an undefined function %s.", name))
+	}
+	if signature.Varargs && len(args) < len(signature.Parameters) {
+		panic(fmt.Sprintf("Function %s expects at least %d arguments, got %d.", name, len(signature.Parameters), len(args)))
+	}
+	if !signature.Varargs && len(args) != len(signature.Parameters) {
+		panic(fmt.Sprintf("Function %s expects %d arguments, got %d.", name, len(signature.Parameters), len(args)))
+	}
+	for i, a := range args {
+		if i >= len(signature.Parameters) {
+			if t := c.checkExpression(a); VariadicType(t) == nil {
+				panic(fmt.Sprintf("Can not pass %v as a variable argument of %s.", t, name))
+			}
+			continue
+		}
+		if _, ok := a.(*ast.Variable); referenceBuiltins[name] && !ok {
+			panic(fmt.Sprintf("Argument of %s must be a variable.", name))
+		}
+		parameter := signature.Parameters[i]
+		if t := c.checkExpression(a); !assignable(parameter.Type, t) {
+			panic(fmt.Sprintf("Can not pass %v as parameter %s of %s, expected %v.", t, parameter.Name, name, parameter.Type))
+		}
+	}
+	return signature.Return
+}
+
+func (c *checker) checkIntrinsic(call *ast.ProcedureCall) {
+	for _, a := range call.Args {
+		if call.Name == "read" || call.Name == "readln" {
+			c.checkRead(a)
+		} else {
+			c.checkWrite(a)
+		}
+	}
+}
+
+// checkWrite checks an argument of write or writeln. Numbers, booleans and strings can be written.
+func (c *checker) checkWrite(a ast.Expression) {
+	format, formatted := a.(*ast.Format)
+	if formatted {
+		a = format.Value
+	}
+	t := c.checkExpression(a)
+	if !ast.IsNumeric(t) && t != ast.BOOLEAN && t != ast.STRING {
+		panic(fmt.Sprintf("Can not write a value of type %v.", t))
+	}
+	if !formatted {
+		return
+	}
+	if wt := c.checkExpression(format.Width); !ast.IsInteger(wt) {
+		panic(fmt.Sprintf("Field width must be an integer, got %v.", wt))
+	}
+	if format.Precision != nil {
+		if t != ast.REAL {
+			panic(fmt.Sprintf("Precision can only be specified for reals, got %v.", t))
+		}
+		if pt := c.checkExpression(format.Precision); !ast.IsInteger(pt) {
+			panic(fmt.Sprintf("Precision must be an integer, got %v.", pt))
+		}
+	}
+}
+
+// checkRead checks an argument of read or readln, which has to be a numeric variable.
+func (c *checker) checkRead(a ast.Expression) {
+	if _, ok := a.(*ast.Variable); !ok {
+		panic(fmt.Sprintf("Argument of read must be a variable, got %v.", a))
+	}
+	if t := c.checkExpression(a); !ast.IsNumeric(t) {
+		panic(fmt.Sprintf("Can not read a value of type %v.", t))
+	}
+}
+
+func (c *checker) lookup(name string) ast.Type {
+	t := c.scope.lookup(name)
+	if t == nil {
+		panic(fmt.Sprintf("Undefined symbol %s.", name))
+	}
+	return t
+}
+
+func (c *checker) checkExpression(expression ast.Expression) ast.Type {
+	var t ast.Type
+	switch e := expression.(type) {
+	case *ast.Literal:
+		t = e.Type
+		if t == nil {
+			t = LiteralType(e.Value)
+		}
+	case ast.StringLiteral:
+		t = ast.STRING
+	case *ast.RealLiteral:
+		t = ast.REAL
+	case *ast.Variable:
+		t = c.lookup(e.Name)
+	case *ast.Binary:
+		t = c.checkBinary(e)
+	case *ast.Unary:
+		t = c.checkUnary(e)
+	case *ast.FunctionCall:
+		t = c.checkCall(e.Name, e.Args)
+		if t == ast.VOID {
+			panic(fmt.Sprintf("Procedure %s does not return a value.", e.Name))
+		}
+	case *ast.SetConstructor:
+		t = c.checkSetConstructor(e)
+	case *ast.Format:
+		panic(fmt.Sprintf("Field width of %v is only allowed inside write and writeln.", e.Value))
+	default:
+		panic("Not all expressions are implemented yet!")
+	}
+	c.info.Types[expression] = t
+	return t
+}
+
+func (c *checker) checkBinary(e *ast.Binary) ast.Type {
+	left := c.checkExpression(e.Left)
+	right := c.checkExpression(e.Right)
+	if left == ast.CARDINAL {
+		right = c.adaptLiteral(e.Right, right)
+	} else if right == ast.CARDINAL {
+		left = c.adaptLiteral(e.Left, left)
+	}
+	leftSet, leftIsSet := left.(ast.Set)
+	rightSet, rightIsSet := right.(ast.Set)
+	integers := ast.IsInteger(left) && ast.IsInteger(right)
+	numbers := ast.IsNumeric(left) && ast.IsNumeric(right)
+	switch e.Operation {
+	case ast.IN:
+		if ast.IsInteger(left) && rightIsSet {
+			return ast.BOOLEAN
+		}
+	case ast.PLUS, ast.MINUS, ast.MULTIPLY:
+		if numbers {
+			return CommonType(left, right)
+		}
+		if leftIsSet && rightIsSet {
+			return UnionType(leftSet, rightSet)
+		}
+	case ast.DIVIDE:
+		// Division of any two numbers is real
+		if numbers {
+			return ast.REAL
+		}
+	case ast.DIV, ast.MOD:
+		if integers {
+			return CommonType(left, right)
+		}
+	case ast.SHL, ast.SHR:
+		if integers {
+			return CommonType(left, left)
+		}
+	case ast.EQUALS, ast.NOTEQUALS:
+		if (left == ast.BOOLEAN && right == ast.BOOLEAN) || numbers || (leftIsSet && rightIsSet) {
+			return ast.BOOLEAN
+		}
+	case ast.LESSEQ, ast.GREATEREQ:
+		if numbers || (leftIsSet && rightIsSet) {
+			return ast.BOOLEAN
+		}
+	case ast.LESS, ast.GREATER:
+		if numbers {
+			return ast.BOOLEAN
+		}
+	case ast.AND, ast.OR, ast.XOR:
+		// Logical on booleans, bitwise on integers
+		if left == ast.BOOLEAN && right == ast.BOOLEAN {
+			return ast.BOOLEAN
+		}
+		if integers {
+			return CommonType(left, right)
+		}
+	}
+	panic(fmt.Sprintf("Operation %v is not defined for %v and %v.", e.Operation, left, right))
+}
+
+// adaptLiteral makes a non-negative literal a cardinal, so that operations with cardinals are not widened to int64.
+func (c *checker) adaptLiteral(e ast.Expression, t ast.Type) ast.Type {
+	if l, ok := e.(*ast.Literal); ok && l.Type == nil && l.Value >= 0 && l.Value <= math.MaxUint32 {
+		c.info.Types[e] = ast.CARDINAL
+		return ast.CARDINAL
+	}
+	return t
+}
+
+func (c *checker) checkUnary(u *ast.Unary) ast.Type {
+	t := c.checkExpression(u.Operand)
+	switch {
+	case u.Operation == ast.NOT && t == ast.BOOLEAN:
+		return ast.BOOLEAN
+	case u.Operation != ast.NOT && t == ast.REAL:
+		return ast.REAL
+	case !ast.IsInteger(t):
+		panic(fmt.Sprintf("Operation %v is not defined for %v.", u.Operation, t))
+	case u.Operation == ast.MINUS && t == ast.CARDINAL:
+		// Negated cardinals may not fit into an integer
+		return ast.INT64
+	default:
+		return CommonType(t, t)
+	}
+}
+
+// checkSetConstructor computes the smallest set type, that can hold all elements known at compile time.
+// Constructors with elements computed at runtime may hold any element.
+func (c *checker) checkSetConstructor(s *ast.SetConstructor) ast.Type {
+	t := ast.Set{Low: 0, High: 0}
+	constant := true
+	for i, e := range s.Elements {
+		high := e.High
+		if high == nil {
+			high = e.Low
+		}
+		for _, bound := range []ast.Expression{e.Low, high} {
+			if bt := c.checkExpression(bound); !ast.IsInteger(bt) {
+				panic(fmt.Sprintf("Set elements must be integers, got %v.", bt))
+			}
+		}
+		low, lowOk := ConstantValue(e.Low)
+		highValue, highOk := ConstantValue(high)
+		if !lowOk || !highOk {
+			constant = false
+			continue
+		}
+		if low < 0 || highValue > ast.MaxSetElement {
+			panic(fmt.Sprintf("Set element %v is out of range 0..%d.", e.Low, ast.MaxSetElement))
+		}
+		if i == 0 || low < t.Low {
+			t.Low = low
+		}
+		if highValue > t.High {
+			t.High = highValue
+		}
+	}
+	if !constant {
+		return ast.Set{Low: 0, High: ast.MaxSetElement}
+	}
+	return t
+}
+
+// ConstantValue returns the value of an integer expression, if it is a literal.
+func ConstantValue(e ast.Expression) (int64, bool) {
+	switch n := e.(type) {
+	case *ast.Literal:
+		return n.Value, true
+	case *ast.Unary:
+		if v, ok := ConstantValue(n.Operand); ok {
+			switch n.Operation {
+			case ast.MINUS:
+				return -v, true
+			case ast.NOT:
+				return ^v, true
+			}
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// CommonType is the type, in which an arithmetic operation on numbers of types a and b is performed.
+// Integers mixed with reals become reals. Integers narrower than 32 bits are widened to integer,
+// mixing cardinals with signed integers requires int64.
+func CommonType(a, b ast.Type) ast.Type {
+	switch {
+	case a == ast.REAL || b == ast.REAL:
+		return ast.REAL
+	case a == ast.INT64 || b == ast.INT64:
+		return ast.INT64
+	case a == ast.CARDINAL || b == ast.CARDINAL:
+		if ast.IsUnsigned(a) && ast.IsUnsigned(b) {
+			return ast.CARDINAL
+		}
+		return ast.INT64
+	default:
+		return ast.INT
+	}
+}
+
+// StepType is the type, in which inc and dec of a variable of type t are performed. It is the type of t + 1,
+// the literal is a cardinal next to a cardinal. With overflow checks, inc(v) and dec(v) are checked like
+// v := v + 1 and v := v - 1: the step is checked in this type and the result wraps around to the type of v,
+// so byte, shortint and word variables never overflow.
+func StepType(t ast.Type) ast.Type {
+	if t == ast.CARDINAL {
+		return ast.CARDINAL
+	}
+	return CommonType(t, ast.INT)
+}
+
+// LiteralType returns the narrowest of integer and int64, that can hold the value.
+func LiteralType(value int64) ast.Type {
+	if value >= math.MinInt32 && value <= math.MaxInt32 {
+		return ast.INT
+	}
+	return ast.INT64
+}
+
+// UnionType is the smallest set type, that can hold elements of both a and b.
+func UnionType(a, b ast.Set) ast.Set {
+	if b.Low < a.Low {
+		a.Low = b.Low
+	}
+	if b.High > a.High {
+		a.High = b.High
+	}
+	return a
+}
+
+// VariadicType returns the type, as which an argument is passed to a varargs function after its parameters.
+// Integers smaller than integer are promoted, the same way C promotes them. Booleans and sets have
+// no counterpart in C, the result is nil for them.
+func VariadicType(t ast.Type) ast.Type {
+	switch {
+	case t == ast.BYTE || t == ast.SHORTINT || t == ast.WORD:
+		return ast.INT
+	case ast.IsNumeric(t) || t == ast.STRING:
+		return t
+	}
+	return nil
+}
+
+// assignable reports whether a value of type from may be stored into a variable of type to.
+// Sets of different ranges are compatible, elements out of the target range are dropped.
+// The same goes for integers, which are truncated to the width of the target.
+// Integers may be assigned to reals, but not the other way around.
+func assignable(to, from ast.Type) bool {
+	if to == from || (ast.IsInteger(to) && ast.IsInteger(from)) || (to == ast.REAL && ast.IsInteger(from)) {
+		return true
+	}
+	_, toIsSet := to.(ast.Set)
+	_, fromIsSet := from.(ast.Set)
+	return toIsSet && fromIsSet
+}
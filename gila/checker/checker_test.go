@@ -0,0 +1,255 @@
+package checker
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"strings"
+	"testing"
+)
+
+func check(source string) (*ast.Program, *Info) {
+	program := parser.New(lexer.New(strings.NewReader(source))).Parse()
+	return program, Check(program)
+}
+
+func Test_SetTypes(t *testing.T) {
+	program, info := check(`
+program sets;
+var s: set of 0..31; b: set of 0..255; x: integer;
+begin
+	s := [1, 3..5];
+	b := s + [x, 100];
+	if x in s * b then writeln(x);
+end.`)
+	body := program.Functions[0].Body.(*ast.Block).Statements
+	if tt := info.TypeOf(body[3].(*ast.Assignment).Value); tt != (ast.Set{Low: 1, High: 5}) {
+		t.Errorf("Constant set constructor has type %v", tt)
+	}
+	if tt := info.TypeOf(body[4].(*ast.Assignment).Value); tt != (ast.Set{Low: 0, High: ast.MaxSetElement}) {
+		t.Errorf("Union with a runtime constructor has type %v", tt)
+	}
+	if tt := info.TypeOf(body[5].(*ast.If).Condition); tt != ast.BOOLEAN {
+		t.Errorf("In operator has type %v", tt)
+	}
+}
+
+func Test_TypeErrors(t *testing.T) {
+	invalid := []string{
+		"program e; var s: set of 0..31; x: integer; begin x := s; end.",
+		"program e; var s: set of 0..31; begin if s in s then writeln(1); end.",
+		"program e; var s: set of 0..31; begin if s < s then writeln(1); end.",
+		"program e; var x: integer; begin if x then writeln(1); end.",
+		"program e; var x: integer; begin x := y; end.",
+		"program e; var x: integer; begin inc(x, 2); end.",
+		"program e; var x: integer; begin if (x = 1) and x then writeln(1); end.",
+		"program e; var s: set of 0..31; begin s := not s; end.",
+	}
+	for _, source := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a type error in %q", source)
+				}
+			}()
+			check(source)
+		}()
+	}
+}
+
+func Test_BitwiseTypes(t *testing.T) {
+	program, info := check(`
+program bits;
+var x: integer; s: set of 0..31;
+begin
+	x := not (x shl 2) xor x;
+	if not (x in s) and (x = 1) then writeln(x);
+end.`)
+	body := program.Functions[0].Body.(*ast.Block).Statements
+	assignment := body[2].(*ast.Assignment).Value.(*ast.Binary)
+	if tt := info.TypeOf(assignment); tt != ast.INT {
+		t.Errorf("Bitwise xor on integers has type %v", tt)
+	}
+	condition := body[3].(*ast.If).Condition.(*ast.Binary)
+	if tt := info.TypeOf(condition.Left); tt != ast.BOOLEAN {
+		t.Errorf("Logical not has type %v", tt)
+	}
+	if tt := info.TypeOf(condition); tt != ast.BOOLEAN {
+		t.Errorf("Logical and has type %v", tt)
+	}
+}
+
+func Test_CommonType(t *testing.T) {
+	cases := []struct {
+		a, b, expected ast.Type
+	}{
+		{ast.BYTE, ast.BYTE, ast.INT},
+		{ast.SHORTINT, ast.WORD, ast.INT},
+		{ast.CARDINAL, ast.BYTE, ast.CARDINAL},
+		{ast.CARDINAL, ast.INT, ast.INT64},
+		{ast.INT64, ast.CARDINAL, ast.INT64},
+	}
+	for _, c := range cases {
+		if res := CommonType(c.a, c.b); res != c.expected {
+			t.Errorf("Common type of %v and %v is %v, expected %v", c.a, c.b, res, c.expected)
+		}
+	}
+}
+
+func Test_IntegerTypes(t *testing.T) {
+	program, info := check(`
+program ints;
+var b: byte; c: cardinal; l: longint;
+begin
+	b := c + l;
+	l := 5000000000;
+end.`)
+	body := program.Functions[0].Body.(*ast.Block).Statements
+	if tt := info.TypeOf(body[3].(*ast.Assignment).Value); tt != ast.INT64 {
+		t.Errorf("Cardinal plus longint has type %v", tt)
+	}
+	if tt := info.TypeOf(body[4].(*ast.Assignment).Value); tt != ast.INT64 {
+		t.Errorf("Wide literal has type %v", tt)
+	}
+}
+
+func Test_Reals(t *testing.T) {
+	program, info := check(`
+program reals;
+var r: real; x: integer;
+begin
+	r := x / 2;
+	r := -r * x + 1.5;
+	if r < x then writeln(r:8:2, x:4, ' ', r > 0);
+	readln(r, x);
+end.`)
+	body := program.Functions[0].Body.(*ast.Block).Statements
+	if tt := info.TypeOf(body[2].(*ast.Assignment).Value); tt != ast.REAL {
+		t.Errorf("Division of integers has type %v", tt)
+	}
+	if tt := info.TypeOf(body[3].(*ast.Assignment).Value); tt != ast.REAL {
+		t.Errorf("Real arithmetic has type %v", tt)
+	}
+	invalid := []string{
+		"program e; var r: real; x: integer; begin x := r; end.",
+		"program e; var r: real; begin r := r div 2; end.",
+		"program e; var x: integer; begin writeln(x:4:2); end.",
+		"program e; var s: set of 0..31; begin writeln(s); end.",
+		"program e; begin readln(1); end.",
+		"program e; var x: integer; begin inc(x:2); end.",
+	}
+	for _, source := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a type error in %q", source)
+				}
+			}()
+			check(source)
+		}()
+	}
+}
+
+func Test_Constants(t *testing.T) {
+	program, info := check(`
+program constants;
+const
+	N = 10;
+	M = N * 2 + 1;
+	B: byte = 200;
+	R = M / 4;
+	C = chr(ord('a') + 1);
+	H = high(word) + sizeof(int64);
+var s: set of 0..N;
+begin
+	writeln(M, B, R, C, H);
+end.`)
+	body := program.Functions[0].Body.(*ast.Block).Statements
+	expected := []struct {
+		value string
+		t     ast.Type
+	}{{"10", ast.INT}, {"21", ast.INT}, {"200", ast.BYTE}, {"5.25", ast.REAL}, {"'b'", ast.STRING}, {"65543", ast.INT}}
+	for i, e := range expected {
+		value := body[i].(*ast.ConstantDeclaration).Value
+		if value.(fmt.Stringer).String() != e.value || info.TypeOf(value) != e.t {
+			t.Errorf("Constant %d is %v of type %v, expected %s of type %v", i, value, info.TypeOf(value), e.value, e.t)
+		}
+	}
+	if s := body[6].(*ast.VariableDeclaration).Type; s != (ast.Set{Low: 0, High: 10}) {
+		t.Errorf("Constant set bounds resulted in %v", s)
+	}
+	invalid := []string{
+		"program e; const N = 1; begin N := 2; end.",
+		"program e; const B: byte = 256; begin end.",
+		"program e; const N = 1 div 0; begin end.",
+		"program e; const N = 1 < 2; begin end.",
+		"program e; var x: integer; const N = x + 1; begin end.",
+		"program e; const N = high(real); begin end.",
+	}
+	for _, source := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected an error in %q", source)
+				}
+			}()
+			check(source)
+		}()
+	}
+}
+
+func Test_ExternalFunctions(t *testing.T) {
+	program, info := check(`
+program ext;
+function printf(format: string): integer; cdecl; varargs; external;
+var b: byte;
+begin
+	printf('%d %f %s', b, 1.5, 'text');
+end.`)
+	call := program.Functions[1].Body.(*ast.Block).Statements[1].(*ast.ProcedureCall)
+	if VariadicType(info.TypeOf(call.Args[1])) != ast.INT || VariadicType(info.TypeOf(call.Args[2])) != ast.REAL {
+		t.Errorf("Wrong types of variable arguments %v", call.Args)
+	}
+	invalid := []string{
+		"program e; function printf(format: string): integer; cdecl; varargs; external; begin printf(); end.",
+		"program e; function printf(format: string): integer; cdecl; varargs; external; begin printf('%d', 1 = 1); end.",
+		"program e; function abs(x: integer): integer; external; begin abs(1, 2); end.",
+		"program e; function f(s: set of 0..7): integer; external; begin end.",
+		"program e; function f(): string; external; begin end.",
+		"program e; function f(s: string): integer; begin f := 0; end; begin end.",
+		"program e; function f(x: integer): integer; external; function f(x: integer): integer; begin f := x; end; begin end.",
+		"program e; var s: string; begin end.",
+	}
+	for _, source := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected an error in %q", source)
+				}
+			}()
+			check(source)
+		}()
+	}
+}
+
+func Test_Exports(t *testing.T) {
+	check("library l; function sq(x: integer): integer; begin sq := x * x; end; exports sq; end.")
+	invalid := []string{
+		"library l; function sq(x: integer): integer; begin sq := x * x; end; exports sq, sq; end.",
+		"library l; exports sq; end.",
+		"library l; function sq(x: integer): integer; forward; exports sq; end.",
+		"library l; function abs(x: integer): integer; external; exports abs; end.",
+		"library l; procedure p(s: set of 0..7); begin end; exports p; end.",
+	}
+	for _, source := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected an error in %q", source)
+				}
+			}()
+			check(source)
+		}()
+	}
+}
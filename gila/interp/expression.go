@@ -0,0 +1,280 @@
+package interp
+
+import (
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"math"
+)
+
+func (ip *interpreter) expression(expression ast.Expression) ast.Value {
+	switch e := expression.(type) {
+	case *ast.Literal:
+		return ast.MilaInt(e.Value)
+	case *ast.RealLiteral:
+		return ast.MilaReal(e.Value)
+	case ast.StringLiteral:
+		return ast.MilaString(e.Value)
+	case *ast.Variable:
+		return *ip.lookup(e.Name)
+	case *ast.Binary:
+		return ip.binary(e)
+	case *ast.Unary:
+		return ip.unary(e)
+	case *ast.FunctionCall:
+		return ip.call(e.Name, e.Args, e.Position)
+	case *ast.SetConstructor:
+		return ip.setConstructor(e)
+	default:
+		panic("Not all expressions are implemented yet!")
+	}
+}
+
+// convert changes a value of type from to type to, the same way an assignment does.
+func (ip *interpreter) convert(v ast.Value, from, to ast.Type) ast.Value {
+	if s, ok := to.(ast.Set); ok {
+		return truncateSet(v.(ast.MilaSet), s)
+	}
+	if ast.IsInteger(to) {
+		return ast.MilaInt(wrap(v.GetInt(), to))
+	}
+	if to == ast.REAL && ast.IsInteger(from) {
+		return ast.MilaReal(float64(v.GetInt()))
+	}
+	return v
+}
+
+// width is the number of bits of an integer type, the same as in its LLVM representation.
+func width(t ast.Type) uint {
+	switch t {
+	case ast.BYTE, ast.SHORTINT:
+		return 8
+	case ast.WORD:
+		return 16
+	case ast.INT64:
+		return 64
+	default:
+		return 32
+	}
+}
+
+// wrap truncates an integer to the width of type t and extends it back according to the sign of t.
+// Integers of every type are kept in int64, so that operations on them can be performed without a conversion.
+func wrap(x int64, t ast.Type) int64 {
+	shift := 64 - width(t)
+	if ast.IsUnsigned(t) {
+		return int64(uint64(x<<shift) >> shift)
+	}
+	return x << shift >> shift
+}
+
+// minInt returns the smallest value of a signed integer type t.
+func minInt(t ast.Type) int64 {
+	return -1 << (width(t) - 1)
+}
+
+func (ip *interpreter) binary(e *ast.Binary) ast.Value {
+	leftType, rightType := ip.info.TypeOf(e.Left), ip.info.TypeOf(e.Right)
+	if e.Operation == ast.IN {
+		element, set := ip.expression(e.Left).GetInt(), ip.expression(e.Right).(ast.MilaSet)
+		return ast.MilaBoolean(set.Contains(element))
+	}
+	if _, ok := leftType.(ast.Set); ok {
+		return ip.setBinary(e)
+	}
+	left, right := ip.expression(e.Left), ip.expression(e.Right)
+	if e.Operation == ast.SHL || e.Operation == ast.SHR {
+		return ast.MilaInt(shift(e.Operation, left.GetInt(), right.GetInt(), ip.info.TypeOf(e)))
+	}
+	if leftType == ast.BOOLEAN {
+		return ast.MilaBoolean(logical(e.Operation, bool(left.(ast.MilaBoolean)), bool(right.(ast.MilaBoolean))))
+	}
+	t := checker.CommonType(leftType, rightType)
+	if t == ast.REAL || e.Operation == ast.DIVIDE {
+		return realBinary(e.Operation, ip.convert(left, leftType, ast.REAL).GetFloat(), ip.convert(right, rightType, ast.REAL).GetFloat())
+	}
+	// Integers are kept extended to int64, so they don't need to be converted to the common type
+	return ip.integerBinary(e.Operation, left.GetInt(), right.GetInt(), t, e.Position)
+}
+
+func logical(op ast.Operation, left, right bool) bool {
+	switch op {
+	case ast.AND:
+		return left && right
+	case ast.OR:
+		return left || right
+	case ast.XOR, ast.NOTEQUALS:
+		return left != right
+	case ast.EQUALS:
+		return left == right
+	default:
+		panic("Invalid operation on booleans.")
+	}
+}
+
+func realBinary(op ast.Operation, left, right float64) ast.Value {
+	switch op {
+	case ast.PLUS:
+		return ast.MilaReal(left + right)
+	case ast.MINUS:
+		return ast.MilaReal(left - right)
+	case ast.MULTIPLY:
+		return ast.MilaReal(left * right)
+	case ast.DIVIDE:
+		return ast.MilaReal(left / right)
+	// Comparisons with NaN are false, except for <>
+	case ast.EQUALS:
+		return ast.MilaBoolean(left == right)
+	case ast.NOTEQUALS:
+		return ast.MilaBoolean(left != right)
+	case ast.LESS:
+		return ast.MilaBoolean(left < right)
+	case ast.LESSEQ:
+		return ast.MilaBoolean(left <= right)
+	case ast.GREATER:
+		return ast.MilaBoolean(left > right)
+	case ast.GREATEREQ:
+		return ast.MilaBoolean(left >= right)
+	default:
+		panic("Invalid operation on reals.")
+	}
+}
+
+// compare evaluates a comparison from the results of less and equal.
+func compare(op ast.Operation, less, equal bool) ast.MilaBoolean {
+	switch op {
+	case ast.EQUALS:
+		return ast.MilaBoolean(equal)
+	case ast.NOTEQUALS:
+		return ast.MilaBoolean(!equal)
+	case ast.LESS:
+		return ast.MilaBoolean(less)
+	case ast.LESSEQ:
+		return ast.MilaBoolean(less || equal)
+	case ast.GREATER:
+		return ast.MilaBoolean(!less && !equal)
+	case ast.GREATEREQ:
+		return ast.MilaBoolean(!less)
+	default:
+		panic("Invalid comparison.")
+	}
+}
+
+// integerBinary performs an operation on integers in type t. The result wraps around on overflow,
+// unless overflow checks are enabled.
+func (ip *interpreter) integerBinary(op ast.Operation, left, right int64, t ast.Type, pos token.Position) ast.Value {
+	var res int64
+	switch op {
+	case ast.PLUS, ast.MINUS, ast.MULTIPLY:
+		return ast.MilaInt(ip.arithmetic(op, left, right, t, pos))
+	case ast.DIV, ast.MOD:
+		if right == 0 {
+			ip.fail(rtl.ErrorDivisionByZero, pos)
+		}
+		// The smallest integer divided by -1 doesn't fit into its type
+		if !ast.IsUnsigned(t) && right == -1 && left == minInt(t) {
+			if ip.options.OverflowChecks {
+				ip.fail(rtl.ErrorOverflow, pos)
+			}
+			if op == ast.DIV {
+				return ast.MilaInt(left)
+			}
+			return ast.MilaInt(0)
+		}
+		if op == ast.DIV {
+			res = left / right
+		} else {
+			res = left % right
+		}
+	case ast.AND:
+		res = left & right
+	case ast.OR:
+		res = left | right
+	case ast.XOR:
+		res = left ^ right
+	default:
+		return compare(op, left < right, left == right)
+	}
+	return ast.MilaInt(wrap(res, t))
+}
+
+// arithmetic adds, subtracts or multiplies integers of type t.
+func (ip *interpreter) arithmetic(op ast.Operation, left, right int64, t ast.Type, pos token.Position) int64 {
+	var res int64
+	var overflow bool
+	switch {
+	case ast.IsUnsigned(t):
+		// Unsigned operands are below 2^32, so neither the result nor the borrow is lost in uint64
+		var u uint64
+		switch op {
+		case ast.PLUS:
+			u = uint64(left) + uint64(right)
+		case ast.MINUS:
+			u = uint64(left) - uint64(right)
+		case ast.MULTIPLY:
+			u = uint64(left) * uint64(right)
+		}
+		res, overflow = int64(u), u > math.MaxUint32
+	case width(t) == 64:
+		switch op {
+		case ast.PLUS:
+			res = left + right
+			overflow = (left >= 0) == (right >= 0) && (res >= 0) != (left >= 0)
+		case ast.MINUS:
+			res = left - right
+			overflow = (left >= 0) != (right >= 0) && (res >= 0) != (left >= 0)
+		case ast.MULTIPLY:
+			res = left * right
+			overflow = left != 0 && (res/left != right || (left == -1 && right == math.MinInt64))
+		}
+	default:
+		// Narrower signed integers don't overflow int64
+		switch op {
+		case ast.PLUS:
+			res = left + right
+		case ast.MINUS:
+			res = left - right
+		case ast.MULTIPLY:
+			res = left * right
+		}
+		overflow = wrap(res, t) != res
+	}
+	if overflow && ip.options.OverflowChecks {
+		ip.fail(rtl.ErrorOverflow, pos)
+	}
+	return wrap(res, t)
+}
+
+// shift shifts an integer of type t. The count is masked to the width of t, the same way as in the ir package.
+func shift(op ast.Operation, x, count int64, t ast.Type) int64 {
+	w := width(t)
+	count &= int64(w - 1)
+	if op == ast.SHL {
+		return wrap(x<<uint(count), t)
+	}
+	// shr is a logical shift, the sign bit is not extended
+	mask := uint64(math.MaxUint64) >> (64 - w)
+	return wrap(int64((uint64(x)&mask)>>uint(count)), t)
+}
+
+func (ip *interpreter) unary(u *ast.Unary) ast.Value {
+	t := ip.info.TypeOf(u)
+	operand := ip.expression(u.Operand)
+	switch u.Operation {
+	case ast.PLUS:
+		return operand
+	case ast.MINUS:
+		if t == ast.REAL {
+			return ast.MilaReal(-operand.GetFloat())
+		}
+		return ast.MilaInt(ip.arithmetic(ast.MINUS, 0, operand.GetInt(), t, u.Position))
+	case ast.NOT:
+		if t == ast.BOOLEAN {
+			return !operand.(ast.MilaBoolean)
+		}
+		return ast.MilaInt(wrap(^operand.GetInt(), t))
+	default:
+		panic("Invalid operation type inside Unary node.")
+	}
+}
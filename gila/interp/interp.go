@@ -0,0 +1,294 @@
+// Package interp executes a program by walking its ast. It follows the semantics of the code emitted by
+// the ir package: integers wrap around at the width of their type, sets keep as many elements as their
+// LLVM representation and builtins behave the same as the runtime library in fce.c.
+package interp
+
+import (
+	"bufio"
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"io"
+)
+
+// Options change the behaviour of the interpreted program.
+type Options struct {
+	// OverflowChecks stop the program on integer overflow, the same way as ir.Options do.
+	// Division by zero is always reported.
+	OverflowChecks bool
+	// MaxCallDepth limits recursion, a deeper call stops the program with runtime error 202.
+	// Zero stands for DefaultMaxCallDepth.
+	MaxCallDepth int
+}
+
+// DefaultMaxCallDepth is about as deep, as compiled programs can recurse with a stack of 8 MB.
+const DefaultMaxCallDepth = 200000
+
+// callsPerStack is the number of calls executed on the stack of one goroutine. Deeper calls continue
+// on a new goroutine, so recursion isn't limited by the maximum size of a Go stack, only by MaxCallDepth.
+const callsPerStack = 50000
+
+// control tells the enclosing statements how to continue after a statement.
+type control int
+
+const (
+	proceed control = iota
+	breakLoop
+	exitFunction
+)
+
+type scope struct {
+	parent  *scope
+	symbols map[string]*ast.Value
+}
+
+func (s *scope) lookup(name string) *ast.Value {
+	if v, ok := s.symbols[name]; ok {
+		return v
+	} else if s.parent != nil {
+		return s.parent.lookup(name)
+	}
+	return nil
+}
+
+type interpreter struct {
+	functions map[string]*ast.Function
+	info      *checker.Info
+	options   Options
+	scope     *scope
+	// loops is the number of loops around the current statement, break outside of a loop does nothing
+	loops int
+	// depth is the number of calls, that have not returned yet
+	depth  int
+	input  *rtl.Input
+	output *bufio.Writer
+}
+
+// Run type checks and executes a program, reading the standard input from in and writing the standard output to out.
+// It returns a *rtl.RuntimeError, when the program is stopped by a runtime error. Errors in the program panic.
+func Run(program *ast.Program, in io.Reader, out io.Writer, options Options) (err error) {
+	if program.Library {
+		panic(fmt.Sprintf("Library %s can only be compiled by the llvm backend.", program.Name))
+	}
+	if program.Unit || len(program.Uses) > 0 {
+		panic(fmt.Sprintf("Units can only be compiled by the llvm backend, %s is a unit or uses units.", program.Name))
+	}
+	if options.MaxCallDepth == 0 {
+		options.MaxCallDepth = DefaultMaxCallDepth
+	}
+	ip := &interpreter{
+		functions: make(map[string]*ast.Function),
+		info:      checker.Check(program),
+		options:   options,
+		input:     rtl.NewInput(in),
+		output:    bufio.NewWriter(out),
+	}
+	for _, f := range program.Functions {
+		if f.Signature.External {
+			panic(fmt.Sprintf("External function %s is only supported by the llvm backend.", f.Signature.Name))
+		}
+		// Forward declarations are replaced by implementations
+		if f.Body != nil || ip.functions[f.Signature.Name] == nil {
+			ip.functions[f.Signature.Name] = f
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			runtimeError, ok := r.(*rtl.RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = runtimeError
+		}
+		if flushErr := ip.output.Flush(); err == nil {
+			err = flushErr
+		}
+	}()
+	ip.call("main", nil, token.Position{})
+	return nil
+}
+
+func (ip *interpreter) fail(code int, pos token.Position) {
+	panic(&rtl.RuntimeError{Code: code, Position: pos})
+}
+
+// call executes a function called at pos.
+func (ip *interpreter) call(name string, args []ast.Expression, pos token.Position) ast.Value {
+	f := ip.functions[name]
+	if f.Body == nil {
+		panic(fmt.Sprintf("Function %s is declared, but never implemented.", name))
+	}
+	callee := &scope{symbols: make(map[string]*ast.Value)}
+	// Arguments are evaluated in the scope of the caller
+	for i, a := range args {
+		p := f.Signature.Parameters[i]
+		v := ip.convert(ip.expression(a), ip.info.TypeOf(a), p.Type)
+		callee.symbols[p.Name] = &v
+	}
+	if f.Signature.Return != ast.VOID {
+		v := zero(f.Signature.Return)
+		callee.symbols[name] = &v
+	}
+	if ip.depth == ip.options.MaxCallDepth {
+		ip.fail(rtl.ErrorStackOverflow, pos)
+	}
+	caller, loops := ip.scope, ip.loops
+	ip.scope, ip.loops = callee, 0
+	ip.depth++
+	if ip.depth%callsPerStack == 0 {
+		ip.onNewStack(f.Body)
+	} else {
+		ip.statement(f.Body)
+	}
+	ip.depth--
+	ip.scope, ip.loops = caller, loops
+	if f.Signature.Return == ast.VOID {
+		return nil
+	}
+	return *callee.symbols[name]
+}
+
+// onNewStack executes the body of a function on a new goroutine and waits for it to finish.
+// Runtime errors and other panics are passed on to the caller.
+func (ip *interpreter) onNewStack(body ast.Statement) {
+	done := make(chan interface{})
+	go func() {
+		defer func() { done <- recover() }()
+		ip.statement(body)
+	}()
+	if r := <-done; r != nil {
+		panic(r)
+	}
+}
+
+// zero is the value of variables, that have not been assigned yet.
+func zero(t ast.Type) ast.Value {
+	switch t {
+	case ast.REAL:
+		return ast.MilaReal(0)
+	case ast.BOOLEAN:
+		return ast.MilaBoolean(false)
+	case ast.STRING:
+		return ast.MilaString("")
+	}
+	if _, ok := t.(ast.Set); ok {
+		return ast.MilaSet{}
+	}
+	return ast.MilaInt(0)
+}
+
+func (ip *interpreter) lookup(name string) *ast.Value {
+	if v := ip.scope.lookup(name); v != nil {
+		return v
+	}
+	panic(fmt.Sprintf("Undefined symbol %s.", name))
+}
+
+func (ip *interpreter) statement(node ast.Statement) control {
+	switch n := node.(type) {
+	case *ast.Block:
+		// The enclosing scope is restored from a variable, since a runtime error unwinds calls without restoring theirs
+		enclosing := ip.scope
+		ip.scope = &scope{parent: enclosing, symbols: make(map[string]*ast.Value)}
+		defer func() { ip.scope = enclosing }()
+		for _, s := range n.Statements {
+			if c := ip.statement(s); c != proceed {
+				return c
+			}
+		}
+	case *ast.VariableDeclaration:
+		v := zero(n.Type)
+		ip.scope.symbols[n.Name] = &v
+	case *ast.ConstantDeclaration:
+		v := ip.expression(n.Value)
+		ip.scope.symbols[n.Name] = &v
+	case *ast.Assignment:
+		ip.assign(n)
+	case *ast.ProcedureCall:
+		ip.procedureCall(n)
+	case *ast.If:
+		if ip.condition(n.Condition) {
+			return ip.statement(n.Then)
+		} else if n.Else != nil {
+			return ip.statement(n.Else)
+		}
+	case *ast.While:
+		return ip.loop(func() bool { return ip.condition(n.Condition) }, n.Body, nil)
+	case *ast.For:
+		ip.assign(n.Initial)
+		t := ip.info.TypeOf(&n.Initial.Variable)
+		step := int64(-1)
+		if n.Upto {
+			step = 1
+		}
+		// The loop runs while the variable differs from the target, the same way as in the ir package
+		condition := func() bool {
+			return (*ip.lookup(n.Initial.Variable.Name)).GetInt() != ip.expression(n.Target).GetInt()
+		}
+		update := func() {
+			v := ip.lookup(n.Initial.Variable.Name)
+			*v = ast.MilaInt(wrap((*v).GetInt()+step, t))
+		}
+		return ip.loop(condition, n.Body, update)
+	case *ast.Break:
+		if ip.loops > 0 {
+			return breakLoop
+		}
+	case *ast.Exit:
+		return exitFunction
+	default:
+		panic("Unknown statement type!")
+	}
+	return proceed
+}
+
+// loop runs the body while the condition holds. update is called after every iteration, that didn't break.
+func (ip *interpreter) loop(condition func() bool, body ast.Statement, update func()) control {
+	ip.loops++
+	defer func() { ip.loops-- }()
+	for condition() {
+		switch ip.statement(body) {
+		case breakLoop:
+			return proceed
+		case exitFunction:
+			return exitFunction
+		}
+		if update != nil {
+			update()
+		}
+	}
+	return proceed
+}
+
+func (ip *interpreter) condition(e ast.Expression) bool {
+	return bool(ip.expression(e).(ast.MilaBoolean))
+}
+
+func (ip *interpreter) assign(a *ast.Assignment) {
+	v := ip.lookup(a.Variable.Name)
+	*v = ip.convert(ip.expression(a.Value), ip.info.TypeOf(a.Value), ip.info.TypeOf(&a.Variable))
+}
+
+func (ip *interpreter) procedureCall(pc *ast.ProcedureCall) {
+	switch {
+	case checker.Intrinsics[pc.Name]:
+		ip.intrinsic(pc)
+	case pc.Name == "inc" || pc.Name == "dec":
+		variable := pc.Args[0].(*ast.Variable)
+		step, op := int64(1), ast.PLUS
+		if pc.Name == "dec" {
+			step, op = -1, ast.MINUS
+		}
+		v := ip.lookup(variable.Name)
+		t := ip.info.TypeOf(variable)
+		if ip.options.OverflowChecks {
+			*v = ast.MilaInt(wrap(ip.arithmetic(op, (*v).GetInt(), 1, checker.StepType(t), pc.Position), t))
+			return
+		}
+		*v = ast.MilaInt(wrap((*v).GetInt()+step, t))
+	default:
+		ip.call(pc.Name, pc.Args, pc.Position)
+	}
+}
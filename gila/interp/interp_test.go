@@ -0,0 +1,168 @@
+package interp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ir"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func interpret(source, input string, options Options) (string, error) {
+	program := parser.New(lexer.New(strings.NewReader(source))).Parse()
+	var out bytes.Buffer
+	err := Run(program, strings.NewReader(input), &out, options)
+	return out.String(), err
+}
+
+func Test_Programs(t *testing.T) {
+	cases := []struct {
+		name, source, input, output string
+	}{
+		{"recursion", `
+program fact;
+function fact(n: integer): integer;
+begin
+	if n = 0 then fact := 1 else fact := n * fact(n - 1);
+end;
+begin
+	writeln(fact(10));
+end.`, "", "3628800\n"},
+		{"wrapping", `
+program wrap;
+var b: byte; s: shortint; x: integer; c: cardinal;
+begin
+	b := 255; inc(b);
+	s := -128; dec(s);
+	x := 2147483647; x := x + 1;
+	c := 0; dec(c);
+	writeln(b, ' ', s, ' ', x, ' ', c, ' ', -8 shr 28, ' ', 1 shl 33);
+end.`, "", "0 127 -2147483648 4294967295 15 2\n"},
+		{"loops", `
+program loops;
+var i, s: integer;
+begin
+	s := 0;
+	for i := 0 to 10 do begin if i = 5 then break; s := s + i; end;
+	while s < 100 do s := s * 2;
+	break;
+	writeln(s, ' ', i);
+end.`, "", "160 5\n"},
+		{"sets", `
+program sets;
+var s: set of 0..31; l: set of 0..255;
+begin
+	s := [1, 3..5];
+	l := s + [200];
+	s := l;
+	writeln(4 in s, ' ', 200 in l, ' ', 200 in s, ' ', [1] <= s, ' ', s = [1, 3, 4, 5]);
+end.`, "", "TRUE TRUE FALSE TRUE TRUE\n"},
+		{"io", `
+program io;
+var x: integer; r: real;
+begin
+	readln(x, r);
+	readln(x);
+	writeln(x:4, r:8:3, r / 4, ' ', x > 2:6);
+end.`, "7 2.5 ignored\n 42\n", "  42   2.500 6.250000000000000E-01   TRUE\n"},
+		{"infinity", `
+program infinity;
+var x: real;
+begin
+	x := 0;
+	writeln(1 / x, ' ', -1 / x:7:2, ' ', 1 / x:0:1, ' ', -1 / x);
+end.`, "", " INF    -inf inf -INF\n"},
+	}
+	for _, c := range cases {
+		out, err := interpret(c.source, c.input, Options{})
+		if err != nil {
+			t.Errorf("%s failed: %v", c.name, err)
+		}
+		if out != c.output {
+			t.Errorf("%s printed %q, expected %q", c.name, out, c.output)
+		}
+	}
+}
+
+func Test_RuntimeErrors(t *testing.T) {
+	for _, c := range rtl.ErrorCases {
+		_, err := interpret(c.Source, "", Options{OverflowChecks: c.OverflowChecks})
+		var runtimeError *rtl.RuntimeError
+		if c.Error == nil && err != nil {
+			t.Errorf("%q stopped with %v", c.Source, err)
+		} else if c.Error != nil && (!errors.As(err, &runtimeError) || *runtimeError != *c.Error) {
+			t.Errorf("Expected %v in %q, got %v", c.Error, c.Source, err)
+		}
+	}
+	// Runaway recursion is stopped at the call, that is too deep, instead of crashing the interpreter
+	recursion := "program e;\nfunction f(n: integer): integer;\nbegin\n\tif n >= 0 then f := f(n + 1);\nend;\nbegin\n\twriteln(f(0));\nend."
+	_, err := interpret(recursion, "", Options{MaxCallDepth: 1000})
+	var runtimeError *rtl.RuntimeError
+	if !errors.As(err, &runtimeError) || runtimeError.Code != rtl.ErrorStackOverflow || runtimeError.Position != (token.Position{Line: 4, Col: 22}) {
+		t.Errorf("Expected runtime error %d at line 4, column 22, got %v", rtl.ErrorStackOverflow, err)
+	}
+	// Deep recursion continues on new goroutines, so it isn't limited by the Go stack,
+	// and errors at its bottom still stop the program
+	deep := "program e;\nfunction f(n: integer): integer;\nbegin\n\tif n > 0 then f := f(n - 1) + 1 else f := %s;\nend;\nbegin\n\twriteln(f(100000));\nend."
+	if out, err := interpret(fmt.Sprintf(deep, "0"), "", Options{}); err != nil || out != "100000\n" {
+		t.Errorf("Recursion 100000 calls deep printed %q, %v", out, err)
+	}
+	_, err = interpret(fmt.Sprintf(deep, "100 div n"), "", Options{})
+	if !errors.As(err, &runtimeError) || runtimeError.Code != rtl.ErrorDivisionByZero || runtimeError.Position != (token.Position{Line: 4, Col: 48}) {
+		t.Errorf("Expected runtime error %d at line 4, column 48, got %v", rtl.ErrorDivisionByZero, err)
+	}
+	// Only the smallest integer overflows, when it is divided by -1
+	out, err := interpret("program e; var x, y: integer; begin x := 0; y := -1; writeln(x div y, ' ', x mod y); end.", "", Options{OverflowChecks: true})
+	if err != nil || out != "0 0\n" {
+		t.Errorf("Dividing 0 by -1 printed %q, %v", out, err)
+	}
+}
+
+// Test_CompiledSamples compares the output of the interpreter with the output of compiled samples.
+func Test_CompiledSamples(t *testing.T) {
+	llc, llcErr := exec.LookPath("llc")
+	cc, ccErr := exec.LookPath("cc")
+	if llcErr != nil || ccErr != nil {
+		t.Skip("llc or cc is not installed")
+	}
+	dir, err := ioutil.TempDir("", "interp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runtime := filepath.Join(dir, "fce.c")
+	ioutil.WriteFile(runtime, []byte(rtl.Source), 0644)
+	samples, _ := filepath.Glob("../../samples/*.mila")
+	for _, sample := range samples {
+		source, _ := ioutil.ReadFile(sample)
+		// The ir package modifies for loops, so each backend gets its own ast
+		module := ir.NewModule(parser.New(lexer.New(bytes.NewReader(source))).Parse())
+		ll, exe := filepath.Join(dir, "sample.ll"), filepath.Join(dir, "sample")
+		ioutil.WriteFile(ll, []byte(module.String()), 0644)
+		if out, err := exec.Command(llc, "-relocation-model=pic", "-filetype=obj", "-o", ll+".o", ll).CombinedOutput(); err != nil {
+			t.Fatalf("llc failed on %s: %s", sample, out)
+		}
+		if out, err := exec.Command(cc, ll+".o", runtime, "-o", exe).CombinedOutput(); err != nil {
+			t.Fatalf("cc failed on %s: %s", sample, out)
+		}
+		cmd := exec.Command(exe)
+		cmd.Stdin = strings.NewReader("7\n")
+		expected, _ := cmd.Output()
+		out, err := interpret(string(source), "7\n", Options{})
+		if err != nil {
+			t.Errorf("%s failed: %v", sample, err)
+		}
+		if out != string(expected) {
+			t.Errorf("%s printed %q, the compiled program printed %q", sample, out, expected)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package interp
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+)
+
+// intrinsic executes write, writeln, read and readln, formatting values the same way as fce.c does.
+func (ip *interpreter) intrinsic(pc *ast.ProcedureCall) {
+	switch pc.Name {
+	case "write", "writeln":
+		for _, a := range pc.Args {
+			ip.write(a)
+		}
+		if pc.Name == "writeln" {
+			ip.output.WriteByte('\n')
+		}
+	case "read", "readln":
+		for _, a := range pc.Args {
+			ip.read(a.(*ast.Variable))
+		}
+		if pc.Name == "readln" {
+			ip.input.SkipLine()
+		}
+	}
+}
+
+func (ip *interpreter) write(a ast.Expression) {
+	width, precision := int64(0), int64(-1)
+	format, formatted := a.(*ast.Format)
+	if formatted {
+		a = format.Value
+	}
+	v := ip.expression(a)
+	if formatted {
+		width = ip.expression(format.Width).GetInt()
+		if format.Precision != nil {
+			precision = ip.expression(format.Precision).GetInt()
+		}
+	}
+	// Field widths are passed to the runtime as 32-bit integers
+	w := int32(width)
+	switch x := v.(type) {
+	case ast.MilaInt:
+		rtl.WriteInteger(ip.output, int64(x), w)
+	case ast.MilaReal:
+		rtl.WriteReal(ip.output, float64(x), w, int32(precision))
+	case ast.MilaBoolean:
+		rtl.WriteBoolean(ip.output, bool(x), w)
+	case ast.MilaString:
+		rtl.WriteString(ip.output, string(x), w)
+	default:
+		panic(fmt.Sprintf("Can not write %v.", v))
+	}
+}
+
+// read reads a number into a variable. The variable stays the same, when there is no number to read.
+func (ip *interpreter) read(variable *ast.Variable) {
+	v := ip.lookup(variable.Name)
+	t := ip.info.TypeOf(variable)
+	if t == ast.REAL {
+		if x, ok := ip.input.Real(); ok {
+			*v = ast.MilaReal(x)
+		}
+		return
+	}
+	if x, ok := ip.input.Integer(); ok {
+		*v = ast.MilaInt(wrap(x, t))
+	}
+}
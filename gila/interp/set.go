@@ -0,0 +1,78 @@
+package interp
+
+import (
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+)
+
+// capacity is the number of elements, that the LLVM representation of a set type can hold.
+// Elements above it are dropped, when a set is converted to the type.
+func capacity(s ast.Set) int64 {
+	switch {
+	case s.High < 32:
+		return 32
+	case s.High < 64:
+		return 64
+	default:
+		return ast.MaxSetElement + 1
+	}
+}
+
+func truncateSet(v ast.MilaSet, t ast.Set) ast.MilaSet {
+	var res ast.MilaSet
+	for i := int64(0); i < capacity(t); i++ {
+		if v.Contains(i) {
+			res.Include(i)
+		}
+	}
+	return res
+}
+
+func (ip *interpreter) setConstructor(s *ast.SetConstructor) ast.Value {
+	var res ast.MilaSet
+	for _, e := range s.Elements {
+		low := ip.expression(e.Low).GetInt()
+		high := low
+		if e.High != nil {
+			high = ip.expression(e.High).GetInt()
+		}
+		for i := low; i <= high && i <= ast.MaxSetElement; i++ {
+			res.Include(i)
+		}
+	}
+	return res
+}
+
+func (ip *interpreter) setBinary(e *ast.Binary) ast.Value {
+	t := checker.UnionType(ip.info.TypeOf(e.Left).(ast.Set), ip.info.TypeOf(e.Right).(ast.Set))
+	left := truncateSet(ip.expression(e.Left).(ast.MilaSet), t)
+	right := truncateSet(ip.expression(e.Right).(ast.MilaSet), t)
+	var res ast.MilaSet
+	subset, superset := true, true
+	for i := range res {
+		switch e.Operation {
+		case ast.PLUS:
+			res[i] = left[i] | right[i]
+		case ast.MULTIPLY:
+			res[i] = left[i] & right[i]
+		case ast.MINUS:
+			res[i] = left[i] &^ right[i]
+		}
+		subset = subset && left[i]&^right[i] == 0
+		superset = superset && right[i]&^left[i] == 0
+	}
+	switch e.Operation {
+	case ast.PLUS, ast.MULTIPLY, ast.MINUS:
+		return res
+	case ast.EQUALS:
+		return ast.MilaBoolean(left == right)
+	case ast.NOTEQUALS:
+		return ast.MilaBoolean(left != right)
+	case ast.LESSEQ:
+		return ast.MilaBoolean(subset)
+	case ast.GREATEREQ:
+		return ast.MilaBoolean(superset)
+	default:
+		panic("Invalid operation on sets.")
+	}
+}
@@ -0,0 +1,67 @@
+package ir
+
+import (
+	"fmt"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ssa"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+)
+
+// Runtime error codes, the same as in Free Pascal.
+const (
+	errorDivisionByZero = 200
+	errorOverflow       = 215
+)
+
+// emitCheckedArithmetic emits an addition, a subtraction or a multiplication using LLVM intrinsics,
+// that report overflow. The program is stopped with a runtime error, when the result overflows.
+func (f *Function) emitCheckedArithmetic(op ssa.Op, left, right value.Value, unsigned bool, pos token.Position) value.Value {
+	t := left.Type().(*types.IntType)
+	sign := "s"
+	if unsigned {
+		sign = "u"
+	}
+	name := map[ssa.Op]string{
+		ssa.Add: "add",
+		ssa.Sub: "sub",
+		ssa.Mul: "mul",
+	}[op]
+	intrinsic := f.declare(fmt.Sprintf("llvm.%s%s.with.overflow.i%d", sign, name, t.BitSize), types.NewStruct(t, types.I1), t, t)
+	res := f.block.NewCall(intrinsic, left, right)
+	f.emitRuntimeCheck(f.block.NewExtractValue(res, 1), errorOverflow, pos)
+	return f.block.NewExtractValue(res, 0)
+}
+
+// emitDivisionCheck stops the program, when the divisor is zero. Signed division of the smallest integer by -1
+// does not fit into the type, so it is reported as an overflow.
+func (f *Function) emitDivisionCheck(left, right value.Value, unsigned bool, pos token.Position) {
+	t := right.Type().(*types.IntType)
+	f.emitRuntimeCheck(f.block.NewICmp(enum.IPredEQ, right, constant.NewInt(t, 0)), errorDivisionByZero, pos)
+	minimum := constant.NewInt(t, -1<<(t.BitSize-1))
+	if c, ok := left.(*constant.Int); unsigned || (ok && c.X.Cmp(minimum.X) != 0) {
+		return
+	}
+	isMinimum := f.block.NewICmp(enum.IPredEQ, left, minimum)
+	isMinusOne := f.block.NewICmp(enum.IPredEQ, right, constant.NewInt(t, -1))
+	f.emitRuntimeCheck(f.block.NewAnd(isMinimum, isMinusOne), errorOverflow, pos)
+}
+
+// emitRuntimeCheck calls the runtime error routine with the source position, when failed is true.
+// Code generation continues in a new block, where the check has passed.
+func (f *Function) emitRuntimeCheck(failed value.Value, code int64, pos token.Position) {
+	errorLabel := f.NewBlock("")
+	contLabel := f.NewBlock("")
+	f.block.NewCondBr(failed, errorLabel, contLabel)
+	runtimeError := f.runtime("runtime_error", types.I32, types.I32, types.I32)
+	errorLabel.NewCall(
+		runtimeError,
+		constant.NewInt(types.I32, code),
+		constant.NewInt(types.I32, int64(pos.Line)),
+		constant.NewInt(types.I32, int64(pos.Col)),
+	)
+	errorLabel.NewUnreachable()
+	f.block = contLabel
+}
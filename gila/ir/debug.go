@@ -0,0 +1,232 @@
+package ir
+
+import (
+	"fmt"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ssa"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// debugInfo describes the source of a module in DWARF metadata: a compile unit for the file, a subprogram
+// for every function, local variables for slots and a location for every instruction. Variables are described
+// by their slots, so the ones promoted by mem2reg are not visible in a debugger.
+type debugInfo struct {
+	file    *metadata.DIFile
+	unit    *metadata.DICompileUnit
+	types   *debugTypes
+	declare *ir.Func
+	// definitions are appended to the module, once the function they describe is emitted,
+	// or right away for the ones of the whole module
+	definitions []metadata.Definition
+	module      *ir.Module
+}
+
+// debugTypes are shared by the functions of a module. All types are described before functions are emitted,
+// the lock only guards against a type, that was left out.
+type debugTypes struct {
+	sync.Mutex
+	described map[ast.Type]metadata.Field
+}
+
+func newDebugInfo(m *ir.Module, path string) *debugInfo {
+	d := &debugInfo{module: m, types: &debugTypes{described: make(map[ast.Type]metadata.Field)}}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	d.file = &metadata.DIFile{MetadataID: -1, Filename: filepath.Base(path), Directory: filepath.Dir(path)}
+	d.unit = &metadata.DICompileUnit{
+		MetadataID:   -1,
+		Distinct:     true,
+		Language:     enum.DwarfLangPascal83,
+		File:         d.file,
+		Producer:     "gila",
+		EmissionKind: enum.EmissionKindFullDebug,
+	}
+	d.add(d.file, d.unit)
+	m.NamedMetadataDefs["llvm.dbg.cu"] = &metadata.NamedDef{Name: "llvm.dbg.cu", Nodes: []metadata.Node{d.unit}}
+	flags := &metadata.NamedDef{Name: "llvm.module.flags"}
+	for _, flag := range []struct {
+		behavior int64
+		name     string
+		value    int64
+	}{{7, "Dwarf Version", 4}, {2, "Debug Info Version", 3}} {
+		tuple := &metadata.Tuple{MetadataID: -1, Fields: []metadata.Field{
+			constant.NewInt(types.I32, flag.behavior), &metadata.String{Value: flag.name}, constant.NewInt(types.I32, flag.value),
+		}}
+		d.add(tuple)
+		flags.Nodes = append(flags.Nodes, tuple)
+	}
+	m.NamedMetadataDefs["llvm.module.flags"] = flags
+	d.declare = m.NewFunc("llvm.dbg.declare", types.Void,
+		ir.NewParam("", types.Metadata), ir.NewParam("", types.Metadata), ir.NewParam("", types.Metadata))
+	return d
+}
+
+// add appends definitions to the module, they are numbered when the module is printed.
+// Debug information of a function keeps them, until they are flushed.
+func (d *debugInfo) add(definitions ...metadata.Definition) {
+	if d.module == nil {
+		d.definitions = append(d.definitions, definitions...)
+		return
+	}
+	d.module.MetadataDefs = append(d.module.MetadataDefs, definitions...)
+}
+
+// function returns the debug information of a single function, which can be emitted in parallel with others.
+func (d *debugInfo) function() *debugInfo {
+	return &debugInfo{file: d.file, unit: d.unit, types: d.types, declare: d.declare}
+}
+
+// flush appends the definitions of a function to the module.
+func (d *debugInfo) flush(m *ir.Module) {
+	m.MetadataDefs = append(m.MetadataDefs, d.definitions...)
+	d.definitions = nil
+}
+
+// describe describes the types of the parameters, results and slots of the functions, which are emitted,
+// so that functions only read the shared types and the numbering of metadata doesn't depend on their order.
+func (d *debugInfo) describe(program *ssa.Program) {
+	for _, f := range program.Functions {
+		if len(f.Blocks) == 0 {
+			continue
+		}
+		if f.Signature.Return != ast.VOID {
+			d.typ(f.Signature.Return)
+		}
+		for _, p := range f.Parameters {
+			d.typ(p.T)
+		}
+		for _, b := range f.Blocks {
+			for _, i := range b.Instructions {
+				if i.Op == ssa.Alloc {
+					d.typ(i.T)
+				}
+			}
+		}
+	}
+}
+
+// subprogram describes a function, which is defined in the module.
+func (d *debugInfo) subprogram(f *ssa.Function) *metadata.DISubprogram {
+	// The first type is the result, procedures have none
+	signature := &metadata.Tuple{MetadataID: -1, Fields: []metadata.Field{metadata.Null}}
+	if f.Signature.Return != ast.VOID {
+		signature.Fields[0] = d.typ(f.Signature.Return)
+	}
+	for _, p := range f.Parameters {
+		signature.Fields = append(signature.Fields, d.typ(p.T))
+	}
+	subroutine := &metadata.DISubroutineType{MetadataID: -1, Types: signature}
+	sp := &metadata.DISubprogram{
+		MetadataID: -1,
+		Distinct:   true,
+		Scope:      d.file,
+		Name:       f.Name(),
+		File:       d.file,
+		Line:       int64(f.Position.Line),
+		Type:       subroutine,
+		ScopeLine:  int64(f.Position.Line),
+		Flags:      enum.DIFlagPrototyped,
+		SPFlags:    enum.DISPFlagDefinition,
+		Unit:       d.unit,
+	}
+	d.add(signature, subroutine, sp)
+	return sp
+}
+
+// typ describes a type of the language. Strings are pointers to characters and large sets are arrays of words.
+func (d *debugInfo) typ(t ast.Type) metadata.Field {
+	d.types.Lock()
+	defer d.types.Unlock()
+	return d.describeType(t)
+}
+
+func (d *debugInfo) describeType(t ast.Type) metadata.Field {
+	if described, ok := d.types.described[t]; ok {
+		return described
+	}
+	var described metadata.Definition
+	switch {
+	case t == ast.STRING:
+		char := &metadata.DIBasicType{MetadataID: -1, Tag: enum.DwarfTagBaseType, Name: "char", Size: 8, Encoding: enum.DwarfAttEncodingSignedChar}
+		d.add(char)
+		described = &metadata.DIDerivedType{MetadataID: -1, Tag: enum.DwarfTagPointerType, BaseType: char, Size: 64}
+	case t == ast.REAL:
+		described = basicType(t, 64, enum.DwarfAttEncodingFloat)
+	case t == ast.BOOLEAN:
+		described = basicType(t, 8, enum.DwarfAttEncodingBoolean)
+	case ast.IsUnsigned(t):
+		described = basicType(t, llvmType(t).(*types.IntType).BitSize, enum.DwarfAttEncodingUnsigned)
+	case ast.IsInteger(t):
+		described = basicType(t, llvmType(t).(*types.IntType).BitSize, enum.DwarfAttEncodingSigned)
+	default:
+		if bits, ok := llvmType(t).(*types.IntType); ok {
+			described = basicType(t, bits.BitSize, enum.DwarfAttEncodingUnsigned)
+			break
+		}
+		word := d.describeType(ast.CARDINAL)
+		count := &metadata.DISubrange{MetadataID: -1, Count: metadata.IntLit(setWords)}
+		elements := &metadata.Tuple{MetadataID: -1, Fields: []metadata.Field{count}}
+		d.add(count, elements)
+		described = &metadata.DICompositeType{
+			MetadataID: -1, Tag: enum.DwarfTagArrayType, Name: fmt.Sprint(t), BaseType: word, Size: setWords * 32, Elements: elements,
+		}
+	}
+	d.add(described)
+	d.types.described[t] = described
+	return described
+}
+
+func basicType(t ast.Type, bits uint64, encoding enum.DwarfAttEncoding) *metadata.DIBasicType {
+	return &metadata.DIBasicType{MetadataID: -1, Tag: enum.DwarfTagBaseType, Name: fmt.Sprint(t), Size: bits, Encoding: encoding}
+}
+
+// variable describes a slot. Parameters are numbered from 1, other variables have the argument 0.
+func (d *debugInfo) variable(slot *ssa.Instruction, arg int, scope *metadata.DISubprogram) *metadata.DILocalVariable {
+	v := &metadata.DILocalVariable{
+		MetadataID: -1,
+		Scope:      scope,
+		Name:       slot.Name,
+		Arg:        uint64(arg),
+		File:       d.file,
+		Line:       int64(slot.Position.Line),
+		Type:       d.typ(slot.T),
+	}
+	d.add(v)
+	return v
+}
+
+// declareVariable tells the debugger, that a variable lives in the memory allocated by alloca.
+func (d *debugInfo) declareVariable(b *ir.Block, alloca value.Value, v *metadata.DILocalVariable) *ir.InstCall {
+	return b.NewCall(d.declare,
+		&metadata.Value{Value: alloca}, &metadata.Value{Value: v}, &metadata.Value{Value: &metadata.DIExpression{MetadataID: -1}})
+}
+
+func location(pos token.Position, scope *metadata.DISubprogram) *metadata.DILocation {
+	return &metadata.DILocation{MetadataID: -1, Line: int64(pos.Line), Column: int64(pos.Col), Scope: scope}
+}
+
+// setLocation attaches a location to an instruction or a terminator, unless it already has one.
+// All of them embed ir.Metadata, which has no setter.
+func setLocation(inst interface{}, loc *metadata.DILocation) {
+	field := reflect.ValueOf(inst).Elem().FieldByName("Metadata")
+	if !field.IsValid() {
+		return
+	}
+	attachments := field.Interface().(ir.Metadata)
+	for _, a := range attachments {
+		if a.Name == "dbg" {
+			return
+		}
+	}
+	field.Set(reflect.ValueOf(append(attachments, &metadata.Attachment{Name: "dbg", Node: loc})))
+}
@@ -1,343 +1,402 @@
 package ir
 
 import (
-	"fmt"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
 	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
 	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ssa"
+	"sync"
 )
 
-type Context struct {
-	*ir.Block
-	parent      *Context
-	breakTarget *Context
-	function    *Function
-	symbols     map[string]value.Value
+// Function lowers a function in ssa to LLVM IR. Every ssa block becomes an LLVM block,
+// runtime checks split it further, so the block, in which an ssa block ends, is tracked for phis.
+type Function struct {
+	*ir.Func
+	function *ssa.Function
+	// functions are the functions of the program, declarations are shared with the other functions of the module
+	functions    map[string]*Function
+	declarations *declarations
+	options      Options
+	// block is the LLVM block, into which instructions are emitted
+	block  *ir.Block
+	blocks map[*ssa.Block]*ir.Block
+	ends   map[*ssa.Block]*ir.Block
+	values map[ssa.Value]value.Value
+	// debug is nil, unless debug information is emitted, scope describes the function
+	debug *debugInfo
+	scope *metadata.DISubprogram
+	// declared are the declarations, which the function uses, in the order of their first use, and globals
+	// are its string literals. They are added to the module after the function is emitted.
+	declared []*Function
+	globals  []*ir.Global
 }
 
-func (c *Context) lookup(name string) value.Value {
-	if v, ok := c.symbols[name]; ok {
-		return v
-	} else if c.parent != nil {
-		return c.parent.lookup(name)
-	}
-	return nil
+// declarations are the functions of the runtime library and the intrinsics, which are declared on their first use
+// by any function of a module.
+type declarations struct {
+	sync.Mutex
+	functions map[string]*Function
 }
 
-func (c *Context) newChildContext(name string) *Context {
-	return &Context{
-		Block:    c.function.NewBlock(name),
-		parent:   c,
-		breakTarget: c.breakTarget,
-		function: c.function,
-		symbols:  map[string]value.Value{},
+func (f *Function) emit() {
+	f.blocks = make(map[*ssa.Block]*ir.Block)
+	f.ends = make(map[*ssa.Block]*ir.Block)
+	f.values = make(map[ssa.Value]value.Value)
+	for i, b := range f.function.Blocks {
+		name := ""
+		if i == 0 {
+			name = "entry"
+		}
+		f.blocks[b] = f.NewBlock(name)
 	}
-}
-
-func (c *Context) newChildScope(name string) *Context {
-	return &Context{
-		Block:    c.Block,
-		parent:   c,
-		breakTarget: c.breakTarget,
-		function: c.function,
-		symbols:  map[string]value.Value{},
+	for i, p := range f.function.Parameters {
+		f.values[p] = f.Params[i]
 	}
-}
-
-type Function struct {
-	*ir.Func
-	context   *Context
-	functions map[string]*Function
-}
-
-func (f *Function) newContext(name string) *Context {
-	return &Context{
-		Block:    f.NewBlock(name),
-		parent:   nil,
-		function: f,
-		symbols:  map[string]value.Value{},
+	if f.debug != nil {
+		f.scope = f.debug.subprogram(f.function)
+		f.Metadata = append(f.Metadata, &metadata.Attachment{Name: "dbg", Node: f.scope})
 	}
-}
-
-func (f *Function) emit(functionTree *ast.Function) {
-	f.context = f.newContext("entry")
-	f.emitVariableDeclaration(&ast.VariableDeclaration{Name: f.Name()})
-	for _, param := range f.Params {
-		stackParam := f.context.NewAlloca(types.I32)
-		f.context.NewStore(param, stackParam)
-		f.context.symbols[param.Name()] = stackParam
+	// Phis are filled in at the end, since their values may be defined later in the function
+	var phis []*ssa.Instruction
+	for _, b := range emissionOrder(f.function) {
+		f.block = f.blocks[b]
+		for _, i := range b.Instructions {
+			if i.Op == ssa.Phi {
+				phi := &ir.InstPhi{Typ: llvmType(i.T)}
+				f.block.Insts = append(f.block.Insts, phi)
+				f.values[i] = phi
+				phis = append(phis, i)
+				continue
+			}
+			block, start, blocks := f.block, len(f.block.Insts), len(f.Blocks)
+			if v := f.emitInstruction(i); v != nil {
+				f.values[i] = v
+			}
+			if f.debug != nil {
+				f.locate(i, block, start, blocks)
+			}
+		}
+		f.ends[b] = f.block
 	}
-	f.emitStatement(functionTree.Body)
-	if functionTree.Signature.Return == ast.VOID {
-		f.context.NewRet(constant.NewInt(types.I32, 0))
-	} else {
-		// This lookup is safe, since return value is guaranteed to be allocated
-		l := f.context.NewLoad(types.I32, f.context.lookup(functionTree.Signature.Name))
-		f.context.NewRet(l)
+	for _, i := range phis {
+		phi := f.values[i].(*ir.InstPhi)
+		for j, a := range i.Args {
+			phi.Incs = append(phi.Incs, ir.NewIncoming(f.value(a), f.ends[i.Block.Preds[j]]))
+		}
 	}
 }
 
-func (f *Function) emitStatement(node ast.Statement) {
-	switch n := node.(type) {
-	case *ast.Block:
-		f.emitBlock(n)
-	case *ast.VariableDeclaration:
-		f.emitVariableDeclaration(n)
-	case *ast.ConstantDeclaration:
-		f.emitConstantDeclaration(n)
-	case *ast.ProcedureCall:
-		f.emitProcedureCall(n)
-	case *ast.If:
-		f.emitIf(n)
-	case *ast.While:
-		f.emitWhile(n)
-	case *ast.Assignment:
-		f.emitAssignment(n)
-	case *ast.For:
-		f.emitFor(n)
-	case *ast.Break:
-		if f.context.breakTarget != nil { // Break outside of a loop is noop
-			f.context.NewBr(f.context.breakTarget.Block)
+// locate attaches the position of an ssa instruction to the LLVM instructions, which were emitted for it:
+// the rest of the block, where its emission started, and the blocks added by runtime checks.
+func (f *Function) locate(i *ssa.Instruction, block *ir.Block, start, blocks int) {
+	loc := location(i.Position, f.scope)
+	for _, inst := range block.Insts[start:] {
+		setLocation(inst, loc)
+	}
+	if block.Term != nil {
+		setLocation(block.Term, loc)
+	}
+	for _, b := range f.Blocks[blocks:] {
+		for _, inst := range b.Insts {
+			setLocation(inst, loc)
 		}
-	case *ast.Exit:
-		if ret := f.context.lookup(f.Name()); ret != nil {
-			f.context.NewRet(f.context.NewLoad(types.I32, ret))
-		} else {
-			f.context.NewRet(constant.NewInt(types.I32, 0))
+		if b.Term != nil {
+			setLocation(b.Term, loc)
 		}
-	default:
-		panic("Unknown statement type!")
 	}
 }
 
-func (f *Function) emitBlock(b *ast.Block) {
-	old := f.context
-	newContext := f.context.newChildScope("")
-	f.context = newContext
-	for _, s := range b.Statements {
-		f.emitStatement(s)
+// emissionOrder returns the reachable blocks in reverse postorder, so values are emitted before their uses
+// in the blocks they dominate, followed by the unreachable blocks. The layout of blocks doesn't change.
+func emissionOrder(f *ssa.Function) []*ssa.Block {
+	order := ssa.ReversePostorder(f)
+	reachable := make(map[*ssa.Block]bool)
+	for _, b := range order {
+		reachable[b] = true
 	}
-	if f.context == newContext {
-		f.context = old
+	for _, b := range f.Blocks {
+		if !reachable[b] {
+			order = append(order, b)
+		}
 	}
+	return order
 }
 
-func (f *Function) emitVariableDeclaration(declaration *ast.VariableDeclaration) value.Value {
-	a := f.context.NewAlloca(types.I32)
-	f.context.symbols[declaration.Name] = a
-	return a
-}
-
-func (f *Function) emitConstantDeclaration(declaration *ast.ConstantDeclaration) {
-	f.context.symbols[declaration.Name] = constant.NewInt(types.I32, declaration.Literal.Value)
+// value returns the LLVM value of an operand.
+func (f *Function) value(v ssa.Value) value.Value {
+	if c, ok := v.(*ssa.Const); ok {
+		return f.emitConstant(c)
+	}
+	if lowered, ok := f.values[v]; ok {
+		return lowered
+	}
+	panic("Value is used before it is defined.")
 }
 
-func (f *Function) emitProcedureCall(pc *ast.ProcedureCall) {
-	pointerFunctions := map[string]bool{
-		"readln": true,
-		"inc":    true,
-		"dec":    true,
-	}
-	callee := f.functions[pc.Name]
-	var args []value.Value
-	// Check if calle is one of the 3 built-in functions, that accept params by reference
-	if pointerFunctions[callee.Name()] {
-		if ptr, ok := pc.Args[0].(*ast.Variable); ok {
-			args = append(args, f.emitVariablePointer(ptr))
-		} else {
-			panic("Syntax error inside built-in reference function call.")
+func (f *Function) emitInstruction(i *ssa.Instruction) value.Value {
+	switch i.Op {
+	case ssa.Alloc:
+		alloca := f.Blocks[0].NewAlloca(llvmType(i.T))
+		if f.debug != nil {
+			variable := f.debug.variable(i, f.argument(i), f.scope)
+			setLocation(f.debug.declareVariable(f.Blocks[0], alloca, variable), location(i.Position, f.scope))
 		}
-	} else if callee.Name() == "write" {
-		tok, ok := pc.Args[0].(ast.StringLiteral)
-		if !ok {
-			panic("Trying to print not a string.")
+		return alloca
+	case ssa.Load:
+		return f.block.NewLoad(llvmType(i.T), f.value(i.Args[0]))
+	case ssa.Store:
+		f.block.NewStore(f.value(i.Args[1]), f.value(i.Args[0]))
+	case ssa.Neg, ssa.Not:
+		return f.emitUnary(i)
+	case ssa.Convert:
+		return f.emitConversion(f.value(i.Args[0]), i.Args[0].Type(), i.T)
+	case ssa.SetMake:
+		return f.emitSetMake(i)
+	case ssa.In:
+		return f.emitIn(f.value(i.Args[0]), f.value(i.Args[1]))
+	case ssa.Call:
+		var args []value.Value
+		for _, a := range i.Args {
+			args = append(args, f.value(a))
 		}
-		constStr := constant.NewCharArrayFromString(tok.Value)
-		str := f.Parent.NewGlobalDef("", constStr)
-		startPtr := f.context.NewGetElementPtr(constStr.Type(), str, constant.NewInt(types.I3, 0), constant.NewInt(types.I3, 0))
-		f.context.NewCall(callee, startPtr)
-		return
-	} else {
-		for _, a := range pc.Args {
-			args = append(args, f.emitExpression(a))
+		call := f.block.NewCall(f.functions[i.Callee.Name()], args...)
+		switch i.Tail {
+		case ssa.MayTail:
+			call.Tail = enum.TailTail
+		case ssa.MustTail:
+			call.Tail = enum.TailMustTail
 		}
+		return call
+	case ssa.Write:
+		f.emitWrite(i)
+	case ssa.WriteNewline:
+		f.block.NewCall(f.runtime("write_newline"))
+	case ssa.Read:
+		return f.emitRead(f.value(i.Args[0]), i.T)
+	case ssa.ReadNewline:
+		f.block.NewCall(f.runtime("read_newline"))
+	case ssa.Jump:
+		f.block.NewBr(f.blocks[i.Block.Succs[0]])
+	case ssa.Branch:
+		f.block.NewCondBr(f.value(i.Args[0]), f.blocks[i.Block.Succs[0]], f.blocks[i.Block.Succs[1]])
+	case ssa.Return:
+		// Procedures return 0, so that main is a valid entry point
+		if len(i.Args) == 0 {
+			f.block.NewRet(constant.NewInt(types.I32, 0))
+		} else {
+			f.block.NewRet(f.value(i.Args[0]))
+		}
+	default:
+		return f.emitBinary(i)
 	}
-	f.context.NewCall(callee, args...)
+	return nil
 }
 
-func (f *Function) emitIf(ifStatement *ast.If) {
-	cond := f.emitExpression(ifStatement.Condition)
-	thenLabel := f.context.newChildContext("")
-	// This function is WAY longer than it is supposed to be, because I wanted to keep IR labels in the same order
-	// in which they appear in the source code.
-	if ifStatement.Else != nil {
-		elseLabel := f.context.newChildContext("")
-		contLabel := f.context.newChildContext("")
-		f.context.NewCondBr(cond, thenLabel.Block, elseLabel.Block)
-		f.context = thenLabel
-		f.emitStatement(ifStatement.Then)
-		if f.context.Term == nil {
-			f.context.NewBr(contLabel.Block)
-		}
-		f.context = elseLabel
-		f.emitStatement(ifStatement.Else)
-		if f.context.Term == nil {
-			f.context.NewBr(contLabel.Block)
+// argument returns the number of the parameter, which is stored into a slot at the start of the function, or 0.
+func (f *Function) argument(slot *ssa.Instruction) int {
+	for _, i := range f.function.Entry().Instructions {
+		if i.Op == ssa.Store && i.Args[0] == slot {
+			for n, p := range f.function.Parameters {
+				if i.Args[1] == p {
+					return n + 1
+				}
+			}
 		}
-		f.context = contLabel
-	} else {
-		contLabel := f.context.newChildContext("")
-		//thenLabel.breakTarget = contLabel
-		f.context.NewCondBr(cond, thenLabel.Block, contLabel.Block)
-		f.context = thenLabel
-		f.emitStatement(ifStatement.Then)
-		if f.context.Term == nil {
-			f.context.NewBr(contLabel.Block)
-		}
-		f.context = contLabel
 	}
+	return 0
 }
 
-func (f *Function) emitWhile(while *ast.While) {
-	cond := f.emitExpression(while.Condition)
-	loopLabel := f.context.newChildContext("")
-	contLabel := f.context.newChildContext("")
-	loopLabel.breakTarget = contLabel
-	f.context.NewCondBr(cond, loopLabel.Block, contLabel.Block)
-	f.context = loopLabel
-	f.emitStatement(while.Body)
-	if f.context.Term == nil {
-		f.context.NewCondBr(f.emitExpression(while.Condition), loopLabel.Block, contLabel.Block)
+// emitConstant returns the LLVM constant for a constant in ssa.
+func (f *Function) emitConstant(c *ssa.Const) value.Value {
+	switch v := c.Value.(type) {
+	case ast.MilaInt:
+		// LLVM prints integer constants as signed
+		t := llvmType(c.T).(*types.IntType)
+		return constant.NewInt(t, convertConstant(int64(v), t.BitSize, false, t.BitSize))
+	case ast.MilaBoolean:
+		return constant.NewBool(bool(v))
+	case ast.MilaReal:
+		return constant.NewFloat(types.Double, float64(v))
+	case ast.MilaString:
+		return f.emitStringLiteral(string(v))
+	case ast.MilaSet:
+		return setConstant(c.T.(ast.Set), v)
 	}
-	f.context = contLabel
+	panic("Unknown constant.")
 }
 
-func (f *Function) emitFor(forLoop *ast.For) {
-	f.emitAssignment(forLoop.Initial)
-	cond := ast.Binary{
-		Left:      &forLoop.Initial.Variable,
-		Right:     forLoop.Target,
-		Operation: ast.NOTEQUALS,
+func (f *Function) emitBinary(i *ssa.Instruction) value.Value {
+	left, right := f.value(i.Args[0]), f.value(i.Args[1])
+	t := i.Args[0].Type()
+	if _, ok := t.(ast.Set); ok {
+		return f.emitSetBinary(i.Op, left, right)
 	}
-	var updateFunctionName = "dec"
-	if forLoop.Upto {
-		updateFunctionName = "inc"
+	if t == ast.REAL {
+		return f.emitRealBinary(i.Op, left, right)
 	}
-	forLoop.Body.Statements = append(forLoop.Body.Statements, &ast.ProcedureCall{
-		Name: updateFunctionName,
-		Args: []ast.Expression{&forLoop.Initial.Variable},
-	})
-	f.emitWhile(&ast.While{
-		Condition: &cond,
-		Body:      forLoop.Body,
-	})
-}
-
-func (f *Function) emitExpression(expression ast.Expression) value.Value {
-	switch e := expression.(type) {
-	case *ast.Literal:
-		return f.emitLiteral(e)
-	case *ast.Variable:
-		return f.emitVariable(e)
-	case *ast.Binary:
-		return f.emitBinary(e)
-	case *ast.Unary:
-		return f.emitUnary(e)
-	case *ast.FunctionCall:
-		return f.emitFunctionCall(e)
+	unsigned := ast.IsUnsigned(t)
+	if i.Checked {
+		switch i.Op {
+		case ssa.Add, ssa.Sub, ssa.Mul:
+			return f.emitCheckedArithmetic(i.Op, left, right, unsigned, i.Position)
+		case ssa.Div, ssa.Mod:
+			f.emitDivisionCheck(left, right, unsigned, i.Position)
+		}
+	}
+	switch i.Op {
+	case ssa.Add:
+		return f.block.NewAdd(left, right)
+	case ssa.Sub:
+		return f.block.NewSub(left, right)
+	case ssa.Mul:
+		return f.block.NewMul(left, right)
+	case ssa.Eq:
+		return f.block.NewICmp(enum.IPredEQ, left, right)
+	case ssa.Ne:
+		return f.block.NewICmp(enum.IPredNE, left, right)
+	case ssa.Lt:
+		return f.block.NewICmp(comparison(enum.IPredSLT, enum.IPredULT, unsigned), left, right)
+	case ssa.Le:
+		return f.block.NewICmp(comparison(enum.IPredSLE, enum.IPredULE, unsigned), left, right)
+	case ssa.Gt:
+		return f.block.NewICmp(comparison(enum.IPredSGT, enum.IPredUGT, unsigned), left, right)
+	case ssa.Ge:
+		return f.block.NewICmp(comparison(enum.IPredSGE, enum.IPredUGE, unsigned), left, right)
+	// Booleans are i1, so the same instructions are logical on booleans and bitwise on integers
+	case ssa.And:
+		return f.block.NewAnd(left, right)
+	case ssa.Or:
+		return f.block.NewOr(left, right)
+	case ssa.Xor:
+		return f.block.NewXor(left, right)
+	case ssa.Mod:
+		if unsigned {
+			return f.block.NewURem(left, right)
+		}
+		return f.block.NewSRem(left, right)
+	case ssa.Div:
+		if unsigned {
+			return f.block.NewUDiv(left, right)
+		}
+		return f.block.NewSDiv(left, right)
+	case ssa.Shl, ssa.Shr:
+		return f.emitShift(i.Op, left, right)
 	default:
-		panic("Not all expressions are implemented yet!")
+		panic("Invalid operation type inside Binary node.")
 	}
 }
 
-func (f *Function) emitAssignment(a *ast.Assignment) {
-	if variable := f.context.lookup(a.Variable.Name); variable != nil {
-		val := f.emitExpression(a.Value)
-		f.context.NewStore(val, variable)
-	} else {
-		panic("Undefined symbol in assignment")
+func comparison(signed, unsigned enum.IPred, isUnsigned bool) enum.IPred {
+	if isUnsigned {
+		return unsigned
 	}
+	return signed
 }
 
-func (f *Function) emitLiteral(l *ast.Literal) value.Value {
-	return constant.NewInt(types.I32, l.Value)
+// emitShift emits shl and shr. Shift count is masked to the width of the shifted value, the same way x86 does,
+// since shifting by the width or more is poison in LLVM.
+func (f *Function) emitShift(op ssa.Op, left, count value.Value) value.Value {
+	width := left.Type().(*types.IntType)
+	count = f.block.NewAnd(count, constant.NewInt(width, int64(width.BitSize-1)))
+	if op == ssa.Shl {
+		return f.block.NewShl(left, count)
+	}
+	// shr is a logical shift, the sign bit is not extended
+	return f.block.NewLShr(left, count)
 }
 
-func (f *Function) emitVariable(variable *ast.Variable) value.Value {
-	if v := f.context.lookup(variable.Name); v != nil {
-		switch symbol := v.(type) {
-		case constant.Constant:
-			return symbol
-		default:
-			return f.context.NewLoad(types.I32, v)
+func (f *Function) emitUnary(i *ssa.Instruction) value.Value {
+	operand := f.value(i.Args[0])
+	switch {
+	case i.Op == ssa.Neg && i.T == ast.REAL:
+		return f.block.NewFNeg(operand)
+	case i.Op == ssa.Neg:
+		zero := constant.NewInt(llvmType(i.T).(*types.IntType), 0)
+		if i.Checked {
+			return f.emitCheckedArithmetic(ssa.Sub, zero, operand, ast.IsUnsigned(i.T), i.Position)
 		}
-	} else {
-		errorMessage := fmt.Sprintf("Undefined symbol %T.", variable.Name)
-		panic(errorMessage)
+		return f.block.NewSub(zero, operand)
+	case i.T == ast.BOOLEAN:
+		return f.block.NewXor(operand, constant.True)
+	default:
+		return f.block.NewXor(operand, constant.NewInt(llvmType(i.T).(*types.IntType), -1))
 	}
 }
 
-func (f *Function) emitVariablePointer(variable *ast.Variable) value.Value {
-	if v := f.context.lookup(variable.Name); v != nil {
-		return v
-	} else {
-		errorMessage := fmt.Sprintf("Undefined symbol %T.", variable.Name)
-		panic(errorMessage)
+// emitConversion converts a value of type from to the type to.
+func (f *Function) emitConversion(v value.Value, from, to ast.Type) value.Value {
+	if s, ok := to.(ast.Set); ok {
+		return f.convertSet(v, s)
+	}
+	if ast.IsInteger(to) {
+		return f.convertInteger(v, from, to)
 	}
+	if to == ast.REAL {
+		return f.convertToReal(v, from)
+	}
+	return v
 }
 
-func (f *Function) emitBinary(e *ast.Binary) value.Value {
-	switch e.Operation {
-	case ast.PLUS:
-		return f.context.NewAdd(f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.MINUS:
-		return f.context.NewSub(f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.MULTIPLY:
-		return f.context.NewMul(f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.EQUALS:
-		return f.context.NewICmp(enum.IPredEQ, f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.NOTEQUALS:
-		return f.context.NewICmp(enum.IPredNE, f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.LESS:
-		return f.context.NewICmp(enum.IPredSLT, f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.LESSEQ:
-		return f.context.NewICmp(enum.IPredSLE, f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.GREATER:
-		return f.context.NewICmp(enum.IPredSGT, f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.GREATEREQ:
-		return f.context.NewICmp(enum.IPredSGE, f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.AND:
-		return f.context.NewAnd(f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.OR:
-		return f.context.NewOr(f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.MOD:
-		return f.context.NewSRem(f.emitExpression(e.Left), f.emitExpression(e.Right))
-	case ast.DIV:
-		return f.context.NewSDiv(f.emitExpression(e.Left), f.emitExpression(e.Right))
+// convertInteger extends or truncates an integer of type from to the width of type to.
+func (f *Function) convertInteger(v value.Value, from, to ast.Type) value.Value {
+	fromType, toType := v.Type().(*types.IntType), llvmType(to).(*types.IntType)
+	if c, ok := v.(*constant.Int); ok {
+		return constant.NewInt(toType, convertConstant(c.X.Int64(), fromType.BitSize, ast.IsUnsigned(from), toType.BitSize))
+	}
+	switch {
+	case fromType.BitSize == toType.BitSize:
+		return v
+	case fromType.BitSize > toType.BitSize:
+		return f.block.NewTrunc(v, toType)
+	case ast.IsUnsigned(from):
+		return f.block.NewZExt(v, toType)
 	default:
-		panic("Invalid operation type inside Binary node.")
+		return f.block.NewSExt(v, toType)
 	}
 }
 
-func (f *Function) emitUnary(u *ast.Unary) value.Value {
-	switch u.Operation {
-	case ast.PLUS:
-		return f.emitExpression(u.Operand)
-	case ast.MINUS:
-		return f.context.NewSub(constant.NewInt(types.I32, 0), f.emitExpression(u.Operand))
-	default:
-		panic("Invalid operation type inside Unary node.")
+// convertConstant converts an integer constant the same way an extension or a truncation would.
+// The result is sign extended, since LLVM prints integer constants as signed.
+func convertConstant(x int64, fromBits uint64, unsigned bool, toBits uint64) int64 {
+	if shift := 64 - fromBits; unsigned {
+		x = int64(uint64(x<<shift) >> shift)
+	} else {
+		x = x << shift >> shift
 	}
+	shift := 64 - toBits
+	return x << shift >> shift
+}
+
+// runtime returns a helper function from the runtime library, declaring it on the first use.
+func (f *Function) runtime(name string, params ...types.Type) *Function {
+	return f.declare(name, types.I32, params...)
 }
 
-func (f *Function) emitFunctionCall(pc *ast.FunctionCall) value.Value {
-	callee := f.functions[pc.Name]
-	var args []value.Value
-	for _, a := range pc.Args {
-		args = append(args, f.emitExpression(a))
+// declare returns an external function, declaring it on the first use.
+func (f *Function) declare(name string, ret types.Type, params ...types.Type) *Function {
+	if fn, ok := f.functions[name]; ok {
+		return fn
+	}
+	f.declarations.Lock()
+	defer f.declarations.Unlock()
+	fn, ok := f.declarations.functions[name]
+	if !ok {
+		var irParams []*ir.Param
+		for _, p := range params {
+			irParams = append(irParams, ir.NewParam("", p))
+		}
+		fn = &Function{Func: ir.NewFunc(name, ret, irParams...)}
+		f.declarations.functions[name] = fn
+	}
+	for _, d := range f.declared {
+		if d == fn {
+			return fn
+		}
 	}
-	return f.context.NewCall(callee, args...)
+	f.declared = append(f.declared, fn)
+	return fn
 }
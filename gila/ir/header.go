@@ -0,0 +1,47 @@
+package ir
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"strings"
+)
+
+// cTypes are the C types, which have the same representation as the types of parameters and results.
+var cTypes = map[ast.Type]string{
+	ast.INT:      "int32_t",
+	ast.BYTE:     "uint8_t",
+	ast.SHORTINT: "int8_t",
+	ast.WORD:     "uint16_t",
+	ast.CARDINAL: "uint32_t",
+	ast.INT64:    "int64_t",
+	ast.REAL:     "double",
+	ast.VOID:     "void",
+}
+
+// Header returns a C header, which declares the functions exported by a library, so C programs can call them.
+// Programs export nothing, their header is empty.
+func (m *Module) Header() string {
+	if !m.program.Library {
+		return ""
+	}
+	guard := strings.ToUpper(m.program.Name) + "_H"
+	var out strings.Builder
+	fmt.Fprintf(&out, "/* Generated by gila from the library %s. */\n", m.program.Name)
+	fmt.Fprintf(&out, "#ifndef %s\n#define %s\n\n", guard, guard)
+	out.WriteString("#include <stdint.h>\n\n")
+	out.WriteString("#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+	for _, name := range m.program.Exports {
+		s := m.functions[name].function.Signature
+		var params []string
+		for _, p := range s.Parameters {
+			params = append(params, cTypes[p.Type]+" "+p.Name)
+		}
+		if len(params) == 0 {
+			params = []string{"void"}
+		}
+		fmt.Fprintf(&out, "%s %s(%s);\n", cTypes[s.Return], s.Name, strings.Join(params, ", "))
+	}
+	out.WriteString("\n#ifdef __cplusplus\n}\n#endif\n\n")
+	fmt.Fprintf(&out, "#endif /* %s */\n", guard)
+	return out.String()
+}
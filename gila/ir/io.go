@@ -0,0 +1,61 @@
+package ir
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ssa"
+)
+
+// emitWrite prints a single value by calling the runtime function for its type.
+// Values are right aligned to the field width, if it is given. Reals without a precision are printed
+// in scientific notation.
+func (f *Function) emitWrite(i *ssa.Instruction) {
+	t := i.Args[0].Type()
+	arg := f.value(i.Args[0])
+	name, params := "write_string", []types.Type{types.I8Ptr}
+	switch {
+	case ast.IsInteger(t):
+		// Integers of all widths are written as int64
+		name, params = "write_integer", []types.Type{types.I64}
+	case t == ast.REAL:
+		name, params = "write_real", []types.Type{types.Double}
+	case t == ast.BOOLEAN:
+		arg = f.block.NewZExt(arg, types.I32)
+		name, params = "write_boolean", []types.Type{types.I32}
+	}
+	args := []value.Value{arg}
+	for _, a := range i.Args[1:] {
+		args = append(args, f.value(a))
+		params = append(params, types.I32)
+	}
+	f.block.NewCall(f.runtime(name, params...), args...)
+}
+
+// emitRead reads a single number of type t. current is returned, when there is nothing to read.
+func (f *Function) emitRead(current value.Value, t ast.Type) value.Value {
+	if t == ast.REAL {
+		tmp := f.spill(current)
+		f.block.NewCall(f.runtime("read_real", types.NewPointer(types.Double)), tmp)
+		return f.block.NewLoad(types.Double, tmp)
+	}
+	// Integers of all widths are read as int64
+	tmp := f.spill(f.convertInteger(current, t, ast.INT64))
+	f.block.NewCall(f.runtime("read_integer", types.I64Ptr), tmp)
+	return f.convertInteger(f.block.NewLoad(types.I64, tmp), ast.INT64, t)
+}
+
+// emitStringLiteral stores a null terminated string into a global constant and returns a pointer to its first character.
+// The constant is private, so it doesn't clash with the ones of other objects linked into the same program.
+func (f *Function) emitStringLiteral(s string) value.Value {
+	str := constant.NewCharArrayFromString(s + "\x00")
+	global := ir.NewGlobalDef("", str)
+	f.globals = append(f.globals, global)
+	global.Linkage = enum.LinkagePrivate
+	global.Immutable = true
+	zero := constant.NewInt(types.I64, 0)
+	return constant.NewGetElementPtr(str.Typ, global, zero, zero)
+}
@@ -67,3 +67,350 @@ func Test_Final(t *testing.T) {
 		fmt.Printf("%v is fine\n", filename)
 	}
 }
+
+func Test_Sets(t *testing.T) {
+	input := `
+program sets;
+var small: set of 0..31; big: set of 0..255; x: integer;
+begin
+	small := [1, 3..5];
+	big := small + [x];
+	if (x in small) and (big >= small) then writeln(x);
+end.
+`
+	m := NewModule(parser.New(lexer.New(strings.NewReader(input))).Parse())
+	out := m.String()
+	for _, expected := range []string{"store i32 58, i32*", "call i32 @set_include", "call i32 @set_subset", "alloca [8 x i32]"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in emitted IR:\n%s", expected, out)
+		}
+	}
+}
+
+func Test_IntegerWidths(t *testing.T) {
+	input := `
+program ints;
+var b: byte; c: cardinal; q: int64;
+begin
+	q := c div 3;
+	if c < 10 then writeln(b);
+	q := b + 5000000000;
+end.
+`
+	m := NewModule(parser.New(lexer.New(strings.NewReader(input))).Parse())
+	out := m.String()
+	for _, expected := range []string{"alloca i8", "udiv i32", "icmp ult i32", "zext i8", "add i64 %", "5000000000"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in emitted IR:\n%s", expected, out)
+		}
+	}
+}
+
+func Test_WriteAndRead(t *testing.T) {
+	input := `
+program io;
+var r: real; b: byte; q: int64;
+begin
+	readln(r, b);
+	r := r / 3 + b;
+	writeln('r = ', r:10:3, b:4, q, r > 1);
+end.
+`
+	m := NewModule(parser.New(lexer.New(strings.NewReader(input))).Parse())
+	out := m.String()
+	expected := []string{
+		"call i32 @read_real(double*", "call i32 @read_integer(i64*", "call i32 @read_newline()",
+		"fdiv double %14, 3.0", "uitofp i8", "fcmp ogt double",
+		"call i32 @write_string(", "call i32 @write_real(double", "call i32 @write_integer(i64",
+		"call i32 @write_boolean(i32", "call i32 @write_newline()", `c"r = \00"`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in emitted IR:\n%s", e, out)
+		}
+	}
+}
+
+func Test_OverflowChecks(t *testing.T) {
+	input := `
+program checks;
+var x: integer; c: cardinal;
+begin
+	x := x * 2;
+	c := c + 1;
+	writeln(100 div x);
+end.
+`
+	m := NewModuleWithOptions(parser.New(lexer.New(strings.NewReader(input))).Parse(), Options{OverflowChecks: true})
+	out := m.String()
+	expected := []string{
+		"call { i32, i1 } @llvm.smul.with.overflow.i32(i32 %",
+		"call { i32, i1 } @llvm.uadd.with.overflow.i32(i32 %",
+		"call i32 @runtime_error(i32 215, i32 5, i32 9)",
+		"call i32 @runtime_error(i32 200, i32 7, i32 14)",
+		"unreachable",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in emitted IR:\n%s", e, out)
+		}
+	}
+}
+
+func Test_Mem2Reg(t *testing.T) {
+	input := `
+program promoted;
+var i, s: integer;
+begin
+	s := 0;
+	for i := 1 to 10 do
+	begin
+		s := s + i;
+	end;
+	writeln(s);
+end.
+`
+	m := NewModuleWithOptions(parser.New(lexer.New(strings.NewReader(input))).Parse(), Options{Passes: []string{"mem2reg"}})
+	out := m.String()
+	for _, e := range []string{"= phi i32 [ 0, %entry ], [ %", "= phi i32 [ 1, %entry ], [ %"} {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in emitted IR:\n%s", e, out)
+		}
+	}
+	if strings.Contains(out, "alloca") || strings.Contains(out, "load") {
+		t.Errorf("Variables were not promoted:\n%s", out)
+	}
+}
+
+func Test_ConstantExpressions(t *testing.T) {
+	input := `
+program constants;
+const N = 10; M = N * 2 + 1;
+var x: integer; s: set of 0..M;
+begin
+	x := 2 + 3;
+	s := [N - 1..M];
+	writeln(x * M, high(s));
+end.
+`
+	out := NewModule(parser.New(lexer.New(strings.NewReader(input))).Parse()).String()
+	for _, e := range []string{"store i32 5, i32* %", "store i32 4193792, i32* %", "mul i32 %2, 21", "i64 21, i32 0)"} {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in emitted IR:\n%s", e, out)
+		}
+	}
+	if strings.Contains(out, "add i32 2, 3") || strings.Contains(out, "mul i32 10, 2") {
+		t.Errorf("Constant expressions were not folded:\n%s", out)
+	}
+}
+
+func Test_TailCalls(t *testing.T) {
+	input := `
+program tails;
+function down(n: integer): integer; forward;
+function start(n: integer): integer;
+begin
+	start := down(n);
+end;
+function down(n: integer): integer;
+begin
+	if n = 0 then down := 0 else down := down(n - 1);
+end;
+begin
+	writeln(start(1000000));
+end.
+`
+	m := NewModuleWithOptions(parser.New(lexer.New(strings.NewReader(input))).Parse(), Options{Passes: []string{"mem2reg", "tailcall", "dce"}})
+	out := m.String()
+	// The recursive call in down became a loop
+	if !strings.Contains(out, "musttail call i32 @down(i32 %n)") || strings.Count(out, "call i32 @down(") != 1 {
+		t.Errorf("Expected a single musttail call of down in:\n%s", out)
+	}
+}
+
+func Test_DebugInformation(t *testing.T) {
+	input := `program debug;
+function add(a: integer; b: integer): integer;
+var c: integer;
+begin
+	c := a + b;
+	add := c div 0;
+end;
+begin
+	writeln(add(1, 2));
+end.
+`
+	options := Options{Debug: true, File: "/src/debug.mila", OverflowChecks: true}
+	out := NewModuleWithOptions(parser.New(lexer.New(strings.NewReader(input))).Parse(), options).String()
+	expected := []string{
+		`!DIFile(filename: "debug.mila", directory: "/src")`,
+		"!llvm.dbg.cu = !{",
+		`!DISubprogram(name: "add", scope: !0, file: !0, line: 2,`,
+		`!DILocalVariable(name: "b", arg: 2,`,
+		`!DILocalVariable(name: "c", scope:`,
+		`call void @llvm.dbg.declare(metadata i32* %`,
+		"@llvm.sadd.with.overflow.i32(i32 %4, i32 %5), !dbg !DILocation(line: 5, column: 9,",
+		"call i32 @add(i32 1, i32 2), !dbg !DILocation(line: 9, column: 2,",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in:\n%s", e, out)
+		}
+	}
+	// Instructions of runtime checks are located at the division
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "\t") && !strings.Contains(line, "!dbg") {
+			t.Errorf("%q has no location", line)
+		}
+	}
+}
+
+func Test_Targets(t *testing.T) {
+	program := parser.New(lexer.New(strings.NewReader("program p; begin writeln(1); end."))).Parse()
+	for triple, layout := range Targets {
+		m := NewModuleWithOptions(program, Options{Target: triple})
+		if m.TargetTriple != triple || m.DataLayout != layout {
+			t.Errorf("Module for %s has triple %q and layout %q", triple, m.TargetTriple, m.DataLayout)
+		}
+	}
+	if m := NewModule(program); m.TargetTriple != "" || m.DataLayout != "" {
+		t.Errorf("Module without a target has triple %q and layout %q", m.TargetTriple, m.DataLayout)
+	}
+	if host := HostTriple(); host != "" && Targets[host] == "" {
+		t.Errorf("Host triple %s is not a known target", host)
+	}
+}
+
+func Test_ExternalFunctions(t *testing.T) {
+	input := `program ext;
+function sqrt(x: real): real; external 'm';
+function pow(x: real; y: real): real; external 'm';
+function printf(format: string): integer; cdecl; varargs; external;
+var w: word;
+begin
+	printf('%f %d', sqrt(pow(2, 2)), w);
+end.
+`
+	m := NewModule(parser.New(lexer.New(strings.NewReader(input))).Parse())
+	out := m.String()
+	expected := []string{
+		"declare double @sqrt(double %x)",
+		"declare ccc i32 @printf(i8* %format, ...)",
+		"call i32 (i8*, ...) @printf(i8* getelementptr",
+		// The word is promoted to an integer
+		"zext i16 %3 to i32",
+		"double %2, i32 %4)",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in:\n%s", e, out)
+		}
+	}
+	if len(m.Libraries) != 1 || m.Libraries[0] != "m" {
+		t.Errorf("Expected the library m, got %v", m.Libraries)
+	}
+}
+
+func Test_Library(t *testing.T) {
+	input := `library numeric;
+function twice(x: integer): integer;
+begin
+	twice := 2 * x;
+end;
+function scale(x: real; n: byte): real;
+begin
+	scale := x * twice(n);
+end;
+procedure hello();
+begin
+	writeln('hello');
+end;
+exports scale, hello;
+end.
+`
+	m := NewModule(parser.New(lexer.New(strings.NewReader(input))).Parse())
+	out := m.String()
+	expected := []string{
+		"define internal i32 @twice(i32 %x)",
+		"define double @scale(double %x, i8 %n)",
+		"define i32 @hello()",
+		"private constant [6 x i8]",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in:\n%s", e, out)
+		}
+	}
+	header := m.Header()
+	expected = []string{
+		"#ifndef NUMERIC_H",
+		"#include <stdint.h>",
+		"double scale(double x, uint8_t n);\nvoid hello(void);\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(header, e) {
+			t.Errorf("Expected %q in:\n%s", e, header)
+		}
+	}
+	if strings.Contains(header, "twice") {
+		t.Errorf("Header declares a function, that is not exported:\n%s", header)
+	}
+}
+
+func Test_ParallelLowering(t *testing.T) {
+	var input strings.Builder
+	input.WriteString("program many;\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&input, `function f%d(x: integer; s: set of 0..%d): real;
+var r: real;
+begin
+	writeln('f%d');
+	if %d in s then r := x * %d else r := x / 2;
+	f%d := r;
+end;
+`, i, 40+i, i, i, i, i)
+	}
+	input.WriteString("begin\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&input, "\twriteln(f%d(%d, [1, %d]));\n", i, i, i)
+	}
+	input.WriteString("end.\n")
+	program := parser.New(lexer.New(strings.NewReader(input.String()))).Parse()
+	lower := func(jobs int) string {
+		p := Build(program, Options{OverflowChecks: true, Debug: true, File: "many.mila"})
+		return Lower(p, Options{OverflowChecks: true, Debug: true, File: "many.mila", Jobs: jobs}).String()
+	}
+	serial := lower(1)
+	for _, jobs := range []int{2, 8, 0, 8} {
+		if parallel := lower(jobs); parallel != serial {
+			t.Errorf("Module lowered by %d jobs differs from the serial one", jobs)
+		}
+	}
+	// Declarations follow the functions of the program in the order of their first use
+	str, real := strings.Index(serial, "declare i32 @write_string("), strings.Index(serial, "declare i32 @write_real(")
+	if str < strings.Index(serial, "define double @f19(") || real < str {
+		t.Errorf("Declarations are out of order in:\n%s", serial)
+	}
+}
+
+func Test_Parallel(t *testing.T) {
+	calls := make([]int, 100)
+	Parallel(len(calls), 4, func(i int) {
+		calls[i]++
+	})
+	for i, c := range calls {
+		if c != 1 {
+			t.Errorf("Index %d was called %d times", i, c)
+		}
+	}
+	defer func() {
+		if r := recover(); r != "Failed at 3." {
+			t.Errorf("Parallel panicked with %v", r)
+		}
+	}()
+	Parallel(50, 8, func(i int) {
+		if i%3 == 0 && i > 0 {
+			panic(fmt.Sprintf("Failed at %d.", i))
+		}
+	})
+}
@@ -1,80 +1,216 @@
 package ir
 
 import (
+	"fmt"
 	"github.com/llir/llvm/ir"
-	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
 	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ssa"
 	"os"
+	"runtime"
+	"sync"
 )
 
 type Module struct {
 	*ir.Module
+	program   *ssa.Program
 	functions map[string]*Function
+	options   Options
+	// Warnings are reported by the ssa passes
+	Warnings []ssa.Warning
+	// Libraries are linked to the program for its external functions, in the order of declarations
+	Libraries []string
+}
+
+// Options change the way code is generated.
+type Options struct {
+	// OverflowChecks make integer arithmetic stop the program on overflow and division by zero,
+	// the same way as {$Q+} does in Pascal.
+	OverflowChecks bool
+	// Passes are the names of ssa passes, that are run in order, before the program is lowered.
+	Passes []string
+	// Debug adds DWARF debug information, which refers to the source file File.
+	Debug bool
+	File  string
+	// Target is the triple of the platform, for which code is generated, one of Targets.
+	// The module has no triple and data layout, when it is empty.
+	Target string
+	// Jobs is the number of functions lowered in parallel, all processors are used, when it is 0.
+	// The module is the same for any number.
+	Jobs int
 }
 
 func NewModule(program *ast.Program) *Module {
-	module := &Module{ir.NewModule(), make(map[string]*Function)}
+	return NewModuleWithOptions(program, Options{})
+}
+
+func NewModuleWithOptions(program *ast.Program, options Options) *Module {
+	return Lower(Build(program, options), options)
+}
+
+// Build type checks a program, translates it into ssa and runs the passes selected by options.
+func Build(program *ast.Program, options Options) *ssa.Program {
+	p := ssa.Build(program, checker.Check(program), ssa.Options{OverflowChecks: options.OverflowChecks})
+	passes, err := ssa.NewPassManager(options.Passes...)
+	if err != nil {
+		panic(err.Error())
+	}
+	passes.Run(p)
+	return p
+}
+
+// Lower translates a program in ssa into an LLVM module. All functions are declared first,
+// so they can be called before they are emitted, then they are emitted by up to options.Jobs goroutines.
+// The declarations, string literals and debug information, that functions add to the module, are collected
+// separately for each function and appended in the order of the program.
+func Lower(program *ssa.Program, options Options) *Module {
+	module := &Module{Module: ir.NewModule(), program: program, functions: make(map[string]*Function), options: options, Warnings: program.Warnings()}
 	module.SourceFilename = program.Name
-	module.declareStl()
+	if options.Target != "" {
+		layout, ok := Targets[options.Target]
+		if !ok {
+			panic(fmt.Sprintf("Unknown target %s.", options.Target))
+		}
+		module.TargetTriple, module.DataLayout = options.Target, layout
+	}
+	var debug *debugInfo
+	if options.Debug {
+		module.SourceFilename = options.File
+		debug = newDebugInfo(module.Module, options.File)
+		debug.describe(program)
+	}
+	declared := &declarations{functions: make(map[string]*Function)}
+	var emitted []*Function
 	for _, f := range program.Functions {
-		module.functions[f.Signature.Name] = module.newFunction(f)
+		if library := f.Signature.Library; library != "" && !contains(module.Libraries, library) {
+			module.Libraries = append(module.Libraries, library)
+		}
+		function := &Function{
+			Func:         module.createFuncFromSignature(f.Signature),
+			function:     f,
+			functions:    module.functions,
+			declarations: declared,
+			options:      options,
+		}
+		module.functions[f.Name()] = function
+		if len(f.Blocks) == 0 {
+			continue
+		}
+		if debug != nil {
+			function.debug = debug.function()
+		}
+		// Functions of libraries and units, that are not exported, can't clash with functions of other modules
+		if (program.Library || program.Unit) && !contains(program.Exports, f.Name()) {
+			function.Linkage = enum.LinkageInternal
+		}
+		emitted = append(emitted, function)
+	}
+	Parallel(len(emitted), options.Jobs, func(i int) {
+		emitted[i].emit()
+	})
+	added := make(map[*Function]bool)
+	for _, f := range emitted {
+		for _, d := range f.declared {
+			if !added[d] {
+				added[d] = true
+				d.Parent = module.Module
+				module.Funcs = append(module.Funcs, d.Func)
+			}
+		}
+		module.Globals = append(module.Globals, f.globals...)
+		if f.debug != nil {
+			f.debug.flush(module.Module)
+		}
 	}
 	return module
 }
 
-func (m *Module) newFunction(function *ast.Function) *Function {
-	var f *Function
-	// If function has already been declared, but hasn't been implemented yet.
-	if val, ok := m.functions[function.Signature.Name]; ok {
-		f = val
-	} else {
-		f = &Function{
-			Func:      m.createFuncFromSignature(function.Signature),
-			functions: m.functions,
-			context:   nil,
+// Parallel calls f for every index below n, running up to jobs calls at a time, or one for every processor,
+// when jobs is 0. When calls panic, the panic of the lowest index is passed on to the caller, so errors
+// are reported the same way as by a loop.
+func Parallel(n, jobs int, f func(i int)) {
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	if jobs > n {
+		jobs = n
+	}
+	panics := make([]interface{}, n)
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				func() {
+					defer func() {
+						panics[i] = recover()
+					}()
+					f(i)
+				}()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	for _, p := range panics {
+		if p != nil {
+			panic(p)
 		}
-		m.functions[f.Name()] = f
 	}
-	if function.Body != nil {
-		f.emit(function)
+}
+
+func (m *Module) createFuncFromSignature(s *ast.Signature) *ir.Func {
+	var params []*ir.Param
+	for _, p := range s.Parameters {
+		params = append(params, ir.NewParam(p.Name, llvmType(p.Type)))
+	}
+	f := m.NewFunc(s.Name, llvmType(s.Return), params...)
+	f.Sig.Variadic = s.Varargs
+	if s.Cdecl {
+		// It is the default of LLVM, the directive only makes it explicit
+		f.CallingConv = enum.CallingConvC
 	}
 	return f
 }
 
-// declareStl simply emits ir for pre-defined functions
-func (m *Module) declareStl() {
-	i32 := types.I32
-
-	wl := m.NewFunc("writeln", i32, ir.NewParam("x", i32))
-	m.functions["writeln"] = &Function{Func: wl}
-
-	w := m.NewFunc("write", i32, ir.NewParam("x", types.I8Ptr))
-	m.functions["write"] = &Function{Func: w}
-
-	rl := m.NewFunc("readln", i32, ir.NewParam("x", types.I32Ptr))
-	m.functions["readln"] = &Function{Func: rl}
-
-	// Manual implementations of increment and decrement functions
-	inc := m.NewFunc("inc", i32, ir.NewParam("x", types.I32Ptr))
-	incBody := inc.NewBlock("entry")
-	incBody.NewStore(incBody.NewAdd(incBody.NewLoad(i32, inc.Params[0]), constant.NewInt(i32, 1)), inc.Params[0])
-	incBody.NewRet(constant.NewInt(i32, 0))
-	m.functions["inc"] = &Function{Func: inc}
-
-	dec := m.NewFunc("dec", i32, ir.NewParam("x", types.I32Ptr))
-	decBody := dec.NewBlock("entry")
-	decBody.NewStore(decBody.NewSub(decBody.NewLoad(i32, dec.Params[0]), constant.NewInt(i32, 1)), dec.Params[0])
-	decBody.NewRet(constant.NewInt(i32, 0))
-	m.functions["dec"] = &Function{Func: dec}
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
 }
 
-func (m *Module) createFuncFromSignature(s *ast.Signature) *ir.Func {
-	var params []*ir.Param
-	for _, p := range s.Parameters {
-		params = append(params, ir.NewParam(p.Name, types.I32))
+// llvmType maps types of the language to their LLVM representation.
+func llvmType(t ast.Type) types.Type {
+	switch t {
+	case ast.INT, ast.CARDINAL, ast.VOID:
+		return types.I32
+	case ast.BYTE, ast.SHORTINT:
+		return types.I8
+	case ast.WORD:
+		return types.I16
+	case ast.INT64:
+		return types.I64
+	case ast.REAL:
+		return types.Double
+	case ast.BOOLEAN:
+		return types.I1
+	case ast.STRING:
+		return types.I8Ptr
+	}
+	if s, ok := t.(ast.Set); ok {
+		return setType(s)
 	}
-	return m.NewFunc(s.Name, types.I32, params...)
+	panic(fmt.Sprintf("Type %v can not be represented in LLVM.", t))
 }
 
 func (m Module) String() string {
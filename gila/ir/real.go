@@ -0,0 +1,55 @@
+package ir
+
+import (
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ssa"
+)
+
+// Reals are IEEE 754 doubles.
+
+// convertToReal converts a number of type from to a real.
+func (f *Function) convertToReal(v value.Value, from ast.Type) value.Value {
+	if from == ast.REAL {
+		return v
+	}
+	t := v.Type().(*types.IntType)
+	if c, ok := v.(*constant.Int); ok {
+		return constant.NewFloat(types.Double, float64(convertConstant(c.X.Int64(), t.BitSize, ast.IsUnsigned(from), 64)))
+	}
+	if ast.IsUnsigned(from) {
+		return f.block.NewUIToFP(v, types.Double)
+	}
+	return f.block.NewSIToFP(v, types.Double)
+}
+
+func (f *Function) emitRealBinary(op ssa.Op, left, right value.Value) value.Value {
+	switch op {
+	case ssa.Add:
+		return f.block.NewFAdd(left, right)
+	case ssa.Sub:
+		return f.block.NewFSub(left, right)
+	case ssa.Mul:
+		return f.block.NewFMul(left, right)
+	case ssa.Quo:
+		return f.block.NewFDiv(left, right)
+	case ssa.Eq:
+		return f.block.NewFCmp(enum.FPredOEQ, left, right)
+	case ssa.Ne:
+		// Unordered, so that NaN is not equal to anything
+		return f.block.NewFCmp(enum.FPredUNE, left, right)
+	case ssa.Lt:
+		return f.block.NewFCmp(enum.FPredOLT, left, right)
+	case ssa.Le:
+		return f.block.NewFCmp(enum.FPredOLE, left, right)
+	case ssa.Gt:
+		return f.block.NewFCmp(enum.FPredOGT, left, right)
+	case ssa.Ge:
+		return f.block.NewFCmp(enum.FPredOGE, left, right)
+	default:
+		panic("Invalid operation on reals.")
+	}
+}
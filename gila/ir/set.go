@@ -0,0 +1,174 @@
+package ir
+
+import (
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ssa"
+)
+
+// Sets with up to 64 elements are lowered to a bitset integer.
+// Larger sets are arrays of 32-bit words, which are manipulated by runtime helpers from fce.c.
+const setWords = (ast.MaxSetElement + 1) / 32
+
+var largeSet = types.NewArray(setWords, types.I32)
+
+func setType(s ast.Set) types.Type {
+	switch {
+	case s.High < 32:
+		return types.I32
+	case s.High < 64:
+		return types.I64
+	default:
+		return largeSet
+	}
+}
+
+func isLargeSet(t types.Type) bool {
+	return t.Equal(largeSet)
+}
+
+// spill stores a value into a temporary stack slot, so that it can be passed by reference.
+// Temporaries are allocated inside the entry block, so that loops don't grow the stack.
+func (f *Function) spill(v value.Value) value.Value {
+	slot := f.Blocks[0].NewAlloca(v.Type())
+	f.block.NewStore(v, slot)
+	return slot
+}
+
+// convertSet changes representation of a set value to the one used by the set type to.
+func (f *Function) convertSet(v value.Value, to ast.Set) value.Value {
+	from, target := v.Type(), setType(to)
+	switch {
+	case from.Equal(target):
+		return v
+	case isLargeSet(target):
+		var res value.Value = constant.NewZeroInitializer(largeSet)
+		if from.Equal(types.I64) {
+			res = f.block.NewInsertValue(res, f.block.NewTrunc(v, types.I32), 0)
+			high := f.block.NewLShr(v, constant.NewInt(types.I64, 32))
+			return f.block.NewInsertValue(res, f.block.NewTrunc(high, types.I32), 1)
+		}
+		return f.block.NewInsertValue(res, v, 0)
+	case isLargeSet(from):
+		low := f.block.NewExtractValue(v, 0)
+		if target.Equal(types.I32) {
+			return low
+		}
+		high := f.block.NewShl(f.block.NewZExt(f.block.NewExtractValue(v, 1), types.I64), constant.NewInt(types.I64, 32))
+		return f.block.NewOr(f.block.NewZExt(low, types.I64), high)
+	case target.Equal(types.I64):
+		return f.block.NewZExt(v, target)
+	default:
+		return f.block.NewTrunc(v, target)
+	}
+}
+
+// setConstant returns the representation of a constant set of type t.
+func setConstant(t ast.Set, s ast.MilaSet) constant.Constant {
+	switch setType(t) {
+	case types.I32:
+		return constant.NewInt(types.I32, int64(int32(s[0])))
+	case types.I64:
+		return constant.NewInt(types.I64, int64(s[0]))
+	}
+	if s == (ast.MilaSet{}) {
+		return constant.NewZeroInitializer(largeSet)
+	}
+	var words []constant.Constant
+	for i := 0; i < setWords; i++ {
+		words = append(words, constant.NewInt(types.I32, int64(int32(s[i/2]>>(32*uint(i%2))))))
+	}
+	return constant.NewArray(largeSet, words...)
+}
+
+// emitSetMake fills a set with elements, that are only known at runtime, using the runtime library.
+func (f *Function) emitSetMake(i *ssa.Instruction) value.Value {
+	res := f.Blocks[0].NewAlloca(largeSet)
+	f.block.NewStore(constant.NewZeroInitializer(largeSet), res)
+	include := f.runtime("set_include", types.NewPointer(largeSet), types.I32, types.I32)
+	for j := 0; j < len(i.Args); j += 2 {
+		f.block.NewCall(include, res, f.value(i.Args[j]), f.value(i.Args[j+1]))
+	}
+	return f.convertSet(f.block.NewLoad(largeSet, res), i.T.(ast.Set))
+}
+
+// emitIn tests, whether an integer is an element of a set.
+func (f *Function) emitIn(element, set value.Value) value.Value {
+	if isLargeSet(set.Type()) {
+		in := f.runtime("set_in", types.I32, types.NewPointer(largeSet))
+		return f.block.NewICmp(enum.IPredNE, f.block.NewCall(in, element, f.spill(set)), constant.NewInt(types.I32, 0))
+	}
+	t := set.Type().(*types.IntType)
+	if t.BitSize == 64 {
+		element = f.block.NewZExt(element, t)
+	}
+	// Shifting by the width of the set or more is undefined, so elements out of range are checked separately.
+	// Unsigned comparison also takes care of negative elements.
+	inRange := f.block.NewICmp(enum.IPredULT, element, constant.NewInt(t, int64(t.BitSize)))
+	shift := f.block.NewAnd(element, constant.NewInt(t, int64(t.BitSize-1)))
+	bit := f.block.NewAnd(f.block.NewLShr(set, shift), constant.NewInt(t, 1))
+	isSet := f.block.NewICmp(enum.IPredNE, bit, constant.NewInt(t, 0))
+	return f.block.NewSelect(inRange, isSet, constant.False)
+}
+
+// emitSetBinary performs an operation on two sets of the same type.
+func (f *Function) emitSetBinary(op ssa.Op, left, right value.Value) value.Value {
+	if isLargeSet(left.Type()) {
+		return f.emitLargeSetBinary(op, left, right)
+	}
+	zero := constant.NewInt(left.Type().(*types.IntType), 0)
+	allOnes := constant.NewInt(left.Type().(*types.IntType), -1)
+	switch op {
+	case ssa.Add:
+		return f.block.NewOr(left, right)
+	case ssa.Mul:
+		return f.block.NewAnd(left, right)
+	case ssa.Sub:
+		return f.block.NewAnd(left, f.block.NewXor(right, allOnes))
+	case ssa.Eq:
+		return f.block.NewICmp(enum.IPredEQ, left, right)
+	case ssa.Ne:
+		return f.block.NewICmp(enum.IPredNE, left, right)
+	case ssa.Le:
+		// left is a subset of right, when it has no elements outside of right
+		return f.block.NewICmp(enum.IPredEQ, f.block.NewAnd(left, f.block.NewXor(right, allOnes)), zero)
+	case ssa.Ge:
+		return f.block.NewICmp(enum.IPredEQ, f.block.NewAnd(right, f.block.NewXor(left, allOnes)), zero)
+	default:
+		panic("Invalid operation on sets.")
+	}
+}
+
+func (f *Function) emitLargeSetBinary(op ssa.Op, left, right value.Value) value.Value {
+	setPtr := types.NewPointer(largeSet)
+	zero := constant.NewInt(types.I32, 0)
+	switch op {
+	case ssa.Add, ssa.Mul, ssa.Sub:
+		name := map[ssa.Op]string{
+			ssa.Add: "set_union",
+			ssa.Mul: "set_intersection",
+			ssa.Sub: "set_difference",
+		}[op]
+		res := f.Blocks[0].NewAlloca(largeSet)
+		f.block.NewCall(f.runtime(name, setPtr, setPtr, setPtr), res, f.spill(left), f.spill(right))
+		return f.block.NewLoad(largeSet, res)
+	case ssa.Eq, ssa.Ne:
+		pred := enum.IPredNE
+		if op == ssa.Ne {
+			pred = enum.IPredEQ
+		}
+		equal := f.block.NewCall(f.runtime("set_equal", setPtr, setPtr), f.spill(left), f.spill(right))
+		return f.block.NewICmp(pred, equal, zero)
+	case ssa.Le:
+		subset := f.block.NewCall(f.runtime("set_subset", setPtr, setPtr), f.spill(left), f.spill(right))
+		return f.block.NewICmp(enum.IPredNE, subset, zero)
+	case ssa.Ge:
+		subset := f.block.NewCall(f.runtime("set_subset", setPtr, setPtr), f.spill(right), f.spill(left))
+		return f.block.NewICmp(enum.IPredNE, subset, zero)
+	default:
+		panic("Invalid operation on sets.")
+	}
+}
@@ -0,0 +1,44 @@
+package ir
+
+import (
+	"runtime"
+	"sort"
+)
+
+// Targets are the data layouts of the platforms, for which modules can be generated, by their target triples.
+// The layouts are the ones LLVM uses for the triples, so llc doesn't have to guess.
+var Targets = map[string]string{
+	"x86_64-pc-linux-gnu":           "e-m:e-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:128-n8:16:32:64-S128",
+	"i686-pc-linux-gnu":             "e-m:e-p:32:32-p270:32:32-p271:32:32-p272:64:64-f64:32:64-f80:32-n8:16:32-S128",
+	"aarch64-unknown-linux-gnu":     "e-m:e-i8:8:32-i16:16:32-i64:64-i128:128-n32:64-S128",
+	"armv7-unknown-linux-gnueabihf": "e-m:e-p:32:32-Fi8-i64:64-v128:64:128-a:0:32-n32-S64",
+	"riscv64-unknown-linux-gnu":     "e-m:e-p:64:64-i64:64-i128:128-n64-S128",
+	"x86_64-apple-macosx":           "e-m:o-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:128-n8:16:32:64-S128",
+	"arm64-apple-macosx":            "e-m:o-i64:64-i128:128-n32:64-S128",
+}
+
+// hostTriples map the platforms, on which Go runs, to their triples.
+var hostTriples = map[string]string{
+	"linux/amd64":   "x86_64-pc-linux-gnu",
+	"linux/386":     "i686-pc-linux-gnu",
+	"linux/arm64":   "aarch64-unknown-linux-gnu",
+	"linux/arm":     "armv7-unknown-linux-gnueabihf",
+	"linux/riscv64": "riscv64-unknown-linux-gnu",
+	"darwin/amd64":  "x86_64-apple-macosx",
+	"darwin/arm64":  "arm64-apple-macosx",
+}
+
+// HostTriple returns the triple of the platform, on which the compiler runs, or "", when it is not one of Targets.
+func HostTriple() string {
+	return hostTriples[runtime.GOOS+"/"+runtime.GOARCH]
+}
+
+// TargetTriples returns the known triples in alphabetical order.
+func TargetTriples() []string {
+	var triples []string
+	for t := range Targets {
+		triples = append(triples, t)
+	}
+	sort.Strings(triples)
+	return triples
+}
@@ -27,8 +27,8 @@ func New(r io.Reader) *Lexer {
 	l.advance()
 	l.advance()
 	l.Position = token.Position{
-		Line: 0,
-		Col:  0,
+		Line: 1,
+		Col:  1,
 	}
 	if l.current == rune(0) || l.next == rune(0) {
 		panic("Given text is too small to contain any program!!")
@@ -61,62 +61,77 @@ func (l *Lexer) skipWhitespace() {
 
 func (l *Lexer) NextToken() token.Token {
 	l.skipWhitespace()
+	// Tokens are positioned at their first character
+	pos := l.Position
 	switch l.current {
 	case '(':
 		l.advance()
-		return token.Token{Kind: token.LPAREN, Position: l.Position}
+		return token.Token{Kind: token.LPAREN, Position: pos}
 	case ')':
 		l.advance()
-		return token.Token{Kind: token.RPAREN, Position: l.Position}
+		return token.Token{Kind: token.RPAREN, Position: pos}
 	case ';':
 		l.advance()
-		return token.Token{Kind: token.SEMICOLON, Position: l.Position}
+		return token.Token{Kind: token.SEMICOLON, Position: pos}
 	case rune(0):
-		return token.Token{Kind: token.EOF, Position: l.Position}
+		return token.Token{Kind: token.EOF, Position: pos}
 	case '+':
 		l.advance()
-		return token.Token{Kind: token.PLUS, Position: l.Position}
+		return token.Token{Kind: token.PLUS, Position: pos}
 	case '-':
 		l.advance()
-		return token.Token{Kind: token.MINUS, Position: l.Position}
+		return token.Token{Kind: token.MINUS, Position: pos}
 	case '=':
 		l.advance()
-		return token.Token{Kind: token.EQUALS, Position: l.Position}
+		return token.Token{Kind: token.EQUALS, Position: pos}
 	case '*':
 		l.advance()
-		return token.Token{Kind: token.MULTIPLY, Position: l.Position}
+		return token.Token{Kind: token.MULTIPLY, Position: pos}
+	case '/':
+		l.advance()
+		return token.Token{Kind: token.SLASH, Position: pos}
 	case '.':
 		l.advance()
-		return token.Token{Kind: token.DOT, Position: l.Position}
+		if l.current == '.' {
+			l.advance()
+			return token.Token{Kind: token.DOTDOT, Position: pos}
+		}
+		return token.Token{Kind: token.DOT, Position: pos}
+	case '[':
+		l.advance()
+		return token.Token{Kind: token.LBRACKET, Position: pos}
+	case ']':
+		l.advance()
+		return token.Token{Kind: token.RBRACKET, Position: pos}
 	case ',':
 		l.advance()
-		return token.Token{Kind: token.COMA, Position: l.Position}
+		return token.Token{Kind: token.COMA, Position: pos}
 	case ':':
 		l.advance()
 		if l.current == '=' {
 			l.advance()
-			return token.Token{Kind: token.ASSIGN, Position: l.Position}
+			return token.Token{Kind: token.ASSIGN, Position: pos}
 		} else {
-			return token.Token{Kind: token.COLON, Position: l.Position}
+			return token.Token{Kind: token.COLON, Position: pos}
 		}
 	case '<':
 		l.advance()
 		if l.current == '=' {
 			l.advance()
-			return token.Token{Kind: token.LESSEQ, Position: l.Position}
+			return token.Token{Kind: token.LESSEQ, Position: pos}
 		} else if l.current == '>' {
 			l.advance()
-			return token.Token{Kind: token.NOTEQUALS, Position: l.Position}
+			return token.Token{Kind: token.NOTEQUALS, Position: pos}
 		} else {
-			return token.Token{Kind: token.LESS, Position: l.Position}
+			return token.Token{Kind: token.LESS, Position: pos}
 		}
 	case '>':
 		l.advance()
 		if l.current == '=' {
 			l.advance()
-			return token.Token{Kind: token.GREATEREQ, Position: l.Position}
+			return token.Token{Kind: token.GREATEREQ, Position: pos}
 		} else {
-			return token.Token{Kind: token.GREATER, Position: l.Position}
+			return token.Token{Kind: token.GREATER, Position: pos}
 		}
 	case '\'':
 		l.advance()
@@ -129,7 +144,7 @@ func (l *Lexer) NextToken() token.Token {
 		return token.Token{
 			Kind:     token.STRLIT,
 			Value:    strLit.String(),
-			Position: l.Position,
+			Position: pos,
 		}
 	default:
 		if unicode.IsLetter(l.current) || l.current == '_' {
@@ -142,6 +157,7 @@ func (l *Lexer) NextToken() token.Token {
 	}
 }
 func (l *Lexer) identifierOrKeyword() token.Token {
+	pos := l.Position
 	var val strings.Builder
 	for unicode.IsLetter(l.current) || unicode.IsDigit(l.current) || l.current == '_' {
 		val.WriteRune(l.current)
@@ -151,18 +167,15 @@ func (l *Lexer) identifierOrKeyword() token.Token {
 	tt := token.IsKeywordOrIdent(lit)
 	if tt == token.IDENT {
 		// Not a keyword requires a literal desc
-		pos := l.Position
-		pos.Col -= len(lit)
 		return token.Token{Kind: tt, Value: lit, Position: pos}
 	} else {
 		// Keywords' literals equal to themselves, no need to specify
-		pos := l.Position
-		pos.Col -= len(tt.String())
 		return token.Token{Kind: tt, Position: pos}
 	}
 }
 
 func (l *Lexer) numberLiteral() token.Token {
+	pos := l.Position
 	var val strings.Builder
 	var base = 10
 	if l.current == '&' {
@@ -172,14 +185,62 @@ func (l *Lexer) numberLiteral() token.Token {
 		base = 16
 		l.advance()
 	}
+	if base == 10 {
+		return l.decimalLiteral(pos)
+	}
 	for unicode.IsDigit(l.current) || unicode.IsLetter(l.current) {
 		val.WriteRune(l.current)
 		l.advance()
 	}
-	if base10, err := strconv.ParseUint(val.String(), base, 32); err == nil {
-		return token.Token{Kind: token.NUMBER, Value: strconv.FormatUint(base10, 10), Position: l.Position}
+	if base10, err := strconv.ParseInt(val.String(), base, 64); err == nil {
+		return token.Token{Kind: token.NUMBER, Value: strconv.FormatInt(base10, 10), Position: pos}
 	} else {
 		errMsg := fmt.Sprintf("Invalid number literal %s in base %v", val.String(), base)
 		panic(errMsg)
 	}
 }
+
+// decimalLiteral reads an integer or a real number, e.g. 42, 3.14 or 1e-3.
+func (l *Lexer) decimalLiteral(pos token.Position) token.Token {
+	var val strings.Builder
+	l.digits(&val)
+	real := false
+	// A dot not followed by a digit is a range, e.g. 1..5
+	if l.current == '.' && unicode.IsDigit(l.next) {
+		real = true
+		val.WriteRune(l.current)
+		l.advance()
+		l.digits(&val)
+	}
+	if l.current == 'e' || l.current == 'E' {
+		real = true
+		val.WriteRune(l.current)
+		l.advance()
+		if l.current == '+' || l.current == '-' {
+			val.WriteRune(l.current)
+			l.advance()
+		}
+		if !unicode.IsDigit(l.current) {
+			panic(fmt.Sprintf("Invalid exponent in number literal %s", val.String()))
+		}
+		l.digits(&val)
+	}
+	if unicode.IsLetter(l.current) {
+		panic(fmt.Sprintf("Invalid number literal %s%c", val.String(), l.current))
+	}
+	if real {
+		return token.Token{Kind: token.REALLIT, Value: val.String(), Position: pos}
+	}
+	base10, err := strconv.ParseInt(val.String(), 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid number literal %s in base 10", val.String()))
+	}
+	return token.Token{Kind: token.NUMBER, Value: strconv.FormatInt(base10, 10), Position: pos}
+}
+
+func (l *Lexer) digits(val *strings.Builder) {
+	for unicode.IsDigit(l.current) {
+		val.WriteRune(l.current)
+		l.advance()
+	}
+}
@@ -82,3 +82,54 @@ func Test_MathExpr(t *testing.T) {
 		fmt.Println(tok)
 	}
 }
+
+func Test_SetTokens(t *testing.T) {
+	l := New(strings.NewReader("s := [1, 3..5] + s; x in s; set of 0..31"))
+	expected := []token.Type{
+		token.IDENT, token.ASSIGN, token.LBRACKET, token.NUMBER, token.COMA, token.NUMBER, token.DOTDOT,
+		token.NUMBER, token.RBRACKET, token.PLUS, token.IDENT, token.SEMICOLON, token.IDENT, token.IN,
+		token.IDENT, token.SEMICOLON, token.SET, token.OF, token.NUMBER, token.DOTDOT, token.NUMBER, token.EOF,
+	}
+	for _, e := range expected {
+		if tok := l.NextToken(); tok.Kind != e {
+			t.Errorf("Expected %v, got %v", e, tok)
+		}
+	}
+}
+
+func Test_WideNumberLiterals(t *testing.T) {
+	l := New(strings.NewReader("5000000000 $7FFFFFFFFFFFFFFF"))
+	if tok := l.NextToken(); tok.Kind != token.NUMBER || tok.Value != "5000000000" {
+		t.Errorf("Lexer fails to read a 64-bit number, got %v", tok)
+	}
+	if tok := l.NextToken(); tok.Kind != token.NUMBER || tok.Value != "9223372036854775807" {
+		t.Errorf("Lexer fails to read a 64-bit hexadecimal number, got %v", tok)
+	}
+}
+
+func Test_TokenPositions(t *testing.T) {
+	l := New(strings.NewReader("x := y\n  div 10;"))
+	expected := []token.Position{{Line: 1, Col: 1}, {Line: 1, Col: 3}, {Line: 1, Col: 6}, {Line: 2, Col: 3}, {Line: 2, Col: 7}}
+	for _, e := range expected {
+		if tok := l.NextToken(); tok.Position != e {
+			t.Errorf("Expected token at %v, got %v", e, tok)
+		}
+	}
+}
+
+func Test_RealLiterals(t *testing.T) {
+	l := New(strings.NewReader("3.14 1e-3 2.5E+2 1..5"))
+	expected := []token.Token{
+		{Kind: token.REALLIT, Value: "3.14"},
+		{Kind: token.REALLIT, Value: "1e-3"},
+		{Kind: token.REALLIT, Value: "2.5E+2"},
+		{Kind: token.NUMBER, Value: "1"},
+		{Kind: token.DOTDOT},
+		{Kind: token.NUMBER, Value: "5"},
+	}
+	for _, e := range expected {
+		if tok := l.NextToken(); tok.Kind != e.Kind || tok.Value != e.Value {
+			t.Errorf("Expected %v, got %v", e, tok)
+		}
+	}
+}
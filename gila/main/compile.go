@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/amd64"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/cgen"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/interp"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ir"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ssa"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"gitlab.fit.cvut.cz/fedorgle/gila/units"
+	"gitlab.fit.cvut.cz/fedorgle/gila/vm"
+	"gitlab.fit.cvut.cz/fedorgle/gila/wasm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var emitKinds = []string{"tokens", "ast", "ssa", "ir", "c", "asm", "obj", "exe", "lib", "h", "bytecode", "wat"}
+
+// emitExtensions are appended to the name of the input file, when no output file is given.
+var emitExtensions = map[string]string{
+	"tokens":   ".tokens",
+	"ast":      ".ast",
+	"ssa":      ".ssa",
+	"ir":       ".ll",
+	"c":        ".c",
+	"asm":      ".s",
+	"obj":      ".o",
+	"exe":      "",
+	"lib":      ".so",
+	"h":        ".h",
+	"bytecode": ".gbc",
+	"wat":      ".wat",
+}
+
+// compileError is an error in the compiled program.
+type compileError struct {
+	file    string
+	message string
+}
+
+func (e *compileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.file, e.message)
+}
+
+// catch turns panics, which the compiler uses to report errors in a program, into a compileError.
+// Runtime errors are bugs in the compiler, so they keep panicking.
+func catch(file string, f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = &compileError{file, fmt.Sprint(r)}
+		}
+	}()
+	f()
+	return nil
+}
+
+func readSource(file string) (string, error) {
+	var source []byte
+	var err error
+	if file == "-" {
+		source, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		source, err = ioutil.ReadFile(file)
+	}
+	return string(source), err
+}
+
+func writeOutput(file string, data []byte) error {
+	if file == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// defaultOutput derives the name of the output file from the input file, e.g. gcd.mila becomes gcd.ll.
+func defaultOutput(input, emit string) string {
+	if input == "-" {
+		if emit == "exe" {
+			return "a.out"
+		}
+		return "a" + emitExtensions[emit]
+	}
+	return strings.TrimSuffix(input, filepath.Ext(input)) + emitExtensions[emit]
+}
+
+func parse(file string) (*ast.Program, error) {
+	source, err := readSource(file)
+	if err != nil {
+		return nil, err
+	}
+	var program *ast.Program
+	err = catch(file, func() {
+		program = parser.New(lexer.New(strings.NewReader(source))).Parse()
+	})
+	return program, err
+}
+
+// load parses a program and the units it uses, whose interfaces are imported into the program. Units are searched
+// for in the directory of the program first, then in paths. Units, that are up to date in cache, are not parsed,
+// when it is not nil.
+func load(file string, paths []string, cache *units.Cache) (*ast.Program, []*units.Unit, error) {
+	program, err := parse(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := "."
+	if file != "-" {
+		dir = filepath.Dir(file)
+	}
+	resolver := units.Resolver{Paths: append([]string{dir}, paths...), Cache: cache}
+	used, err := resolver.Resolve(file, program)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = catch(file, func() {
+		units.Import(program, used)
+	})
+	return program, used, err
+}
+
+func check(file string, paths []string) error {
+	program, _, err := load(file, paths, nil)
+	if err != nil {
+		return err
+	}
+	return catch(file, func() {
+		checker.Check(program)
+	})
+}
+
+// generated is the output of a native backend.
+type generated struct {
+	name string
+	code string
+	// libraries are linked for external functions
+	libraries []string
+	// header declares the functions exported by a library in C, it is empty for programs
+	header string
+	// units are the modules of the units, which are linked with the program, in the order of compilation
+	units []*generated
+	// cache keeps the objects of units between builds, when it is not nil. Units, whose object is up to date,
+	// have no code, only the object. Others are compiled into object and their unit is stored in the cache.
+	cache  *units.Cache
+	object string
+	unit   *units.Unit
+}
+
+// generate translates a program with the native backend selected by --backend: into LLVM IR for llvm
+// and into assembly for amd64. Libraries can only be built into objects and shared libraries, units only
+// into objects. Executables and shared libraries are linked with the units they use, so these are generated too,
+// unless the cache in --cache-dir has their objects from an earlier build.
+func generate(file string, f buildFlags) (*generated, error) {
+	var cache *units.Cache
+	if f.cacheDir != "" && (f.emit == "exe" || f.emit == "lib") && f.backend == "llvm" {
+		if err := os.MkdirAll(f.cacheDir, 0755); err != nil {
+			return nil, err
+		}
+		// Objects don't depend on the number of jobs
+		options := irOptions(f)
+		options.Jobs = 0
+		cache = &units.Cache{Dir: f.cacheDir, Options: fmt.Sprintf("%+v cpu=%s", options, f.cpu)}
+	}
+	program, used, err := load(file, f.unitPaths, cache)
+	if err != nil {
+		return nil, err
+	}
+	g := &generated{name: program.Name, cache: cache}
+	err = catch(file, func() {
+		switch {
+		case program.Library && f.emit == "exe":
+			panic(fmt.Sprintf("%s is a library, build it with --emit=lib or --emit=obj.", program.Name))
+		case program.Unit && (f.emit == "exe" || f.emit == "lib"):
+			panic(fmt.Sprintf("%s is a unit, build it with --emit=obj or build a program, that uses it.", program.Name))
+		case !program.Library && (f.emit == "lib" || f.emit == "h"):
+			panic(fmt.Sprintf("%s is not a library, only libraries can be built with --emit=%s.", program.Name, f.emit))
+		}
+		if f.backend == "amd64" {
+			g.code = amd64.Generate(program, amd64.Options{OverflowChecks: f.checks == "overflow"})
+			return
+		}
+		module := lower(program, file, f)
+		g.code, g.libraries, g.header = module.String(), module.Libraries, module.Header()
+	})
+	if err != nil || f.emit != "exe" && f.emit != "lib" {
+		return g, err
+	}
+	for _, u := range used {
+		for _, l := range u.Interface.Libraries {
+			if !contains(g.libraries, l) {
+				g.libraries = append(g.libraries, l)
+			}
+		}
+		unit := &generated{name: u.Interface.Name, unit: u}
+		if cache != nil {
+			unit.object = cache.Object(u)
+		}
+		g.units = append(g.units, unit)
+	}
+	// Units are lowered in parallel, the error of the first one is reported
+	errs := make([]error, len(used))
+	ir.Parallel(len(used), f.jobs, func(i int) {
+		u := used[i]
+		if u.Compiled {
+			return
+		}
+		errs[i] = catch(u.File, func() {
+			units.Import(u.Program, used)
+			g.units[i].code = lower(u.Program, u.File, f).String()
+		})
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// lower translates a program, whose units are imported, into an LLVM module with the options selected by flags.
+func lower(program *ast.Program, file string, f buildFlags) *ir.Module {
+	options := irOptions(f)
+	options.File = file
+	module := ir.NewModuleWithOptions(program, options)
+	warn(file, module.Warnings)
+	return module
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// warn prints warnings about the program, which don't stop the compilation.
+func warn(file string, warnings []ssa.Warning) {
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %v\n", file, w)
+	}
+}
+
+// optimizationLevels are the ssa passes, that are run at each level of -O<n>.
+var optimizationLevels = [][]string{
+	0: nil,
+	1: {"inline", "mem2reg", "constfold", "tailcall", "dce"},
+	2: {"inline", "rotate", "mem2reg", "constfold", "licm", "strength", "tailcall", "dce"},
+}
+
+func irOptions(f buildFlags) ir.Options {
+	var passes []string
+	for _, p := range optimizationLevels[f.optimize] {
+		if p != "inline" || !f.noInline {
+			passes = append(passes, p)
+		}
+	}
+	return ir.Options{OverflowChecks: f.checks == "overflow", Passes: passes, Debug: f.debug, Target: targetTriple(f), Jobs: f.jobs}
+}
+
+// targetTriple returns the triple of the target selected by --target, native is the platform gila runs on.
+func targetTriple(f buildFlags) string {
+	if f.target == "native" {
+		return ir.HostTriple()
+	}
+	return f.target
+}
+
+func compileBytecode(file string, f buildFlags) (*vm.Program, error) {
+	program, err := parse(file)
+	if err != nil {
+		return nil, err
+	}
+	options := vm.Options{OverflowChecks: f.checks == "overflow"}
+	var p *vm.Program
+	err = catch(file, func() {
+		p = vm.Compile(program, options)
+	})
+	return p, err
+}
+
+// build compiles a file into the output requested by --emit.
+func build(file string, f buildFlags) error {
+	switch f.emit {
+	case "tokens":
+		source, err := readSource(file)
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		err = catch(file, func() {
+			l := lexer.New(strings.NewReader(source))
+			for t := l.NextToken(); t.Kind != token.EOF; t = l.NextToken() {
+				fmt.Fprintln(&out, t)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return writeOutput(f.output, out.Bytes())
+	case "ast":
+		program, err := parse(file)
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		ast.Fprint(&out, program)
+		return writeOutput(f.output, out.Bytes())
+	case "ssa":
+		program, _, err := load(file, f.unitPaths, nil)
+		if err != nil {
+			return err
+		}
+		var code string
+		err = catch(file, func() {
+			p := ir.Build(program, irOptions(f))
+			warn(file, p.Warnings())
+			code = p.String()
+		})
+		if err != nil {
+			return err
+		}
+		return writeOutput(f.output, []byte(code))
+	case "c":
+		program, err := parse(file)
+		if err != nil {
+			return err
+		}
+		options := cgen.Options{OverflowChecks: f.checks == "overflow", File: file}
+		if file == "-" {
+			options.File = "stdin"
+		}
+		var code string
+		err = catch(file, func() {
+			code = cgen.Generate(program, options)
+		})
+		if err != nil {
+			return err
+		}
+		return writeOutput(f.output, []byte(code))
+	case "wat":
+		program, err := parse(file)
+		if err != nil {
+			return err
+		}
+		var code string
+		err = catch(file, func() {
+			code = wasm.Generate(program, wasm.Options{OverflowChecks: f.checks == "overflow"})
+		})
+		if err != nil {
+			return err
+		}
+		return writeOutput(f.output, []byte(code))
+	case "bytecode":
+		p, err := compileBytecode(file, f)
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		if err := p.Encode(&out); err != nil {
+			return err
+		}
+		return writeOutput(f.output, out.Bytes())
+	}
+	g, err := generate(file, f)
+	if err != nil {
+		return err
+	}
+	// Both are the output of the backend, that needs no external tools
+	if f.emit == "ir" || f.emit == "asm" && f.backend == "amd64" {
+		return writeOutput(f.output, []byte(g.code))
+	}
+	if f.emit == "h" {
+		return writeOutput(f.output, []byte(g.header))
+	}
+	t, err := newToolchain(f)
+	if err != nil {
+		return err
+	}
+	defer t.close()
+	t.libraries = g.libraries
+	if err := t.compile(g, f.emit, f.output); err != nil {
+		return err
+	}
+	// Objects and shared libraries of libraries come with a header named after the library
+	if g.header != "" && f.output != "-" {
+		header := filepath.Join(filepath.Dir(f.output), g.name+emitExtensions["h"])
+		return writeOutput(header, []byte(g.header))
+	}
+	return nil
+}
+
+// run builds an executable into a temporary directory and runs it with the given arguments.
+// It returns the exit code of the program.
+func run(file string, f buildFlags, args []string) (int, error) {
+	g, err := generate(file, f)
+	if err != nil {
+		return 0, err
+	}
+	t, err := newToolchain(f)
+	if err != nil {
+		return 0, err
+	}
+	defer t.close()
+	t.libraries = g.libraries
+	exe := filepath.Join(t.dir, "program")
+	if err := t.compile(g, "exe", exe); err != nil {
+		return 0, err
+	}
+	return t.execute(exe, args)
+}
+
+// interpretProgram executes a program with the interpreter and returns its exit code.
+// Command line arguments are accepted for compatibility with run, the language has no way to read them.
+func interpretProgram(file string, f buildFlags, _ []string) (int, error) {
+	program, err := parse(file)
+	if err != nil {
+		return 0, err
+	}
+	options := interp.Options{OverflowChecks: f.checks == "overflow", MaxCallDepth: f.maxDepth}
+	var runErr error
+	err = catch(file, func() {
+		runErr = interp.Run(program, os.Stdin, os.Stdout, options)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return programExit(runErr)
+}
+
+// runBytecode executes a program with the vm and returns its exit code.
+// The file is either a .gbc file built by gila build --emit=bytecode, or a source file, that is compiled first.
+func runBytecode(file string, f buildFlags, _ []string) (int, error) {
+	var p *vm.Program
+	var err error
+	if filepath.Ext(file) == emitExtensions["bytecode"] {
+		p, err = readBytecode(file)
+	} else {
+		p, err = compileBytecode(file, f)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return programExit(vm.Run(p, os.Stdin, os.Stdout))
+}
+
+func readBytecode(file string) (*vm.Program, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	p, err := vm.Decode(in)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return p, nil
+}
+
+// programExit reports a runtime error of an interpreted program and returns the exit code of the program.
+func programExit(err error) (int, error) {
+	var runtimeError *rtl.RuntimeError
+	if errors.As(err, &runtimeError) {
+		fmt.Fprintln(os.Stderr, runtimeError)
+		return runtimeError.Code, nil
+	}
+	return 0, err
+}
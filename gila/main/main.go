@@ -1,21 +1,405 @@
+// Command gila compiles Mila programs.
+//
+// Usage:
+//
+//	gila build [flags] file.mila         compile a program into an executable
+//	gila run [flags] file.mila [-- args] compile a program and run it, or interpret it with --interp or --vm
+//	gila check file.mila...              report errors without generating code
+//	gila emit [flags] file.mila          print tokens, ast, ssa, ir, C, WebAssembly or assembly of a program
+//
+// The input file - stands for the standard input. gila run executes .gbc files, built with --emit=bytecode, on the vm.
+// build and emit produce a WebAssembly text module with --target=wasm. --backend=amd64 generates x86-64 assembly
+// without LLVM, so programs are built with just a C compiler. -O1 runs optimization passes over the ssa, from which
+// LLVM IR is generated, -O2 adds loop optimizations and -fno-inline leaves out inlining. -g adds DWARF debug
+// information to the IR, so programs can be debugged in gdb or lldb. --target also accepts target triples, like
+// aarch64-unknown-linux-gnu, for which the llvm backend cross-compiles, and --cpu selects the processor.
+// Libraries are built into shared libraries with --emit=lib, their C header is written with --emit=h.
+// Units named in uses clauses are searched for in the directory of the program and in the directories given by -I,
+// they are compiled into separate modules and linked with the program. Compiled units are kept in --cache-dir
+// with interface files, which describe their exports, so that only units, whose sources or used units changed,
+// are parsed and compiled again. -j sets the number of functions and units, that are compiled in parallel.
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/interp"
 	"gitlab.fit.cvut.cz/fedorgle/gila/ir"
-	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
-	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"io"
 	"os"
-	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 )
 
-func main()  {
-	// A hack to make lexer wotk with the test script
-	bytes, _ := ioutil.ReadAll(os.Stdin)
-	l := lexer.New(strings.NewReader(string(bytes)))
-	p := parser.New(l)
-	program := p.Parse()
-	m := ir.NewModule(program)
-	fmt.Println(m)
+// Exit codes of gila. gila run exits with the exit code of the program instead, once it has been built.
+const (
+	exitCompileError = 1
+	exitUsage        = 2
+	exitToolchain    = 3
+)
+
+type command struct {
+	name, usage, description string
+	execute                  func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"build", "build [flags] file.mila", "compile a program into an executable", buildCommand},
+		{"run", "run [flags] file.mila [-- args]", "compile a program and run it", runCommand},
+		{"check", "check file.mila...", "report errors without generating code", checkCommand},
+		{"emit", "emit [flags] file.mila", "print tokens, ast, ssa, ir, C, WebAssembly or assembly of a program", emitCommand},
+	}
+}
+
+func main() {
+	os.Exit(execute(os.Args[1:]))
+}
+
+func execute(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stdout)
+		return 0
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.execute(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "gila: unknown command %s\n", args[0])
+	usage(os.Stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  gila %-32s %s\n", c.usage, c.description)
+	}
+	fmt.Fprintln(w, "Run gila <command> -h to list flags of a command.")
+}
+
+// buildFlags are shared by the commands, that generate code.
+type buildFlags struct {
+	output  string
+	emit    string
+	checks  string
+	target  string
+	backend string
+	// optimize is the optimization level selected by -O<n>
+	optimize int
+	noInline bool
+	// debug adds DWARF debug information to LLVM IR
+	debug bool
+	// cpu is passed to llc, which tunes the code for the processor
+	cpu string
+	// unitPaths are searched for units after the directory of the program
+	unitPaths pathList
+	// cacheDir keeps compiled units and their interfaces between builds, caching is disabled, when it is empty
+	cacheDir string
+	// jobs is the number of functions and units compiled in parallel
+	jobs int
+	// maxDepth limits recursion in the interpreter
+	maxDepth int
+}
+
+// targets are the platforms, for which code can be generated. The llvm backend also accepts the triples in ir.Targets.
+var targets = []string{"native", "wasm"}
+
+// backends generate native code. llvm needs llc, amd64 only a C compiler to assemble and link.
+var backends = []string{"llvm", "amd64"}
+
+// wasmEmitKinds can be produced for the wasm target.
+var wasmEmitKinds = map[string]bool{"tokens": true, "ast": true, "wat": true}
+
+func newFlagSet(name string, f *buildFlags, defaultEmit string) *flag.FlagSet {
+	fs := flag.NewFlagSet("gila "+name, flag.ContinueOnError)
+	if f != nil {
+		fs.StringVar(&f.output, "o", "", "output file, - stands for the standard output")
+		fs.StringVar(&f.emit, "emit", defaultEmit, "what to produce: "+strings.Join(emitKinds, ", "))
+		fs.StringVar(&f.checks, "checks", "", "runtime checks to emit, overflow stops the program on integer overflow and division by zero")
+		fs.StringVar(&f.target, "target", "native", "platform to generate code for: "+strings.Join(targets, ", ")+", wasm emits wat by default,\nor a target triple of the llvm backend: "+strings.Join(ir.TargetTriples(), ", "))
+		fs.StringVar(&f.cpu, "cpu", "", "processor to generate code for with the llvm backend, e.g. skylake or cortex-a72")
+		fs.StringVar(&f.backend, "backend", "llvm", "code generator for the native target: llvm or amd64 (amd64 emits assembly instead of LLVM IR)")
+		for level, passes := range optimizationLevels {
+			usage := "don't optimize the program"
+			if level > 0 {
+				usage = "optimize ssa with " + strings.Join(passes, ", ") + " before it is lowered to ir"
+			}
+			fs.Var(levelFlag{&f.optimize, level}, fmt.Sprintf("O%d", level), usage)
+		}
+		fs.BoolVar(&f.noInline, "fno-inline", false, "don't inline functions, not even the ones declared inline")
+		fs.BoolVar(&f.debug, "g", false, "emit debug information, so the program can be stepped through in gdb or lldb")
+		fs.Var(&f.unitPaths, "I", unitPathUsage)
+		fs.IntVar(&f.jobs, "j", runtime.NumCPU(), "number of functions and units compiled in parallel, the output doesn't depend on it")
+		fs.StringVar(&f.cacheDir, "cache-dir", defaultCacheDir(), "directory to keep compiled units in, so only changed units are recompiled,\nan empty one disables caching")
+	}
+	return fs
+}
+
+// defaultCacheDir returns the gila directory in the cache directory of the user, or nothing, when it is not known.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gila")
+}
+
+const unitPathUsage = "directory to search for units, after the one of the program, can be repeated"
+
+// pathList is a flag, which can be repeated, every occurrence adds a directory.
+type pathList []string
+
+func (p *pathList) String() string {
+	return strings.Join(*p, string(filepath.ListSeparator))
+}
+
+func (p *pathList) Set(dir string) error {
+	*p = append(*p, dir)
+	return nil
+}
+
+// levelFlag is a boolean flag -O<n>, which selects the optimization level n.
+type levelFlag struct {
+	level *int
+	value int
+}
+
+func (l levelFlag) String() string {
+	return ""
+}
+
+func (l levelFlag) Set(s string) error {
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		*l.level = l.value
+	} else if *l.level == l.value {
+		*l.level = 0
+	}
+	return nil
+}
+
+func (l levelFlag) IsBoolFlag() bool {
+	return true
+}
+
+// parseArgs parses flags, which may appear anywhere among positional arguments.
+// Arguments after -- are not parsed and are returned as rest.
+func parseArgs(fs *flag.FlagSet, args []string) (positional, rest []string, err error) {
+	for i, a := range args {
+		if a == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
+	}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, rest, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseCommand parses arguments of a command, that expects a single input file.
+// It returns the input file, or an exit code, when the arguments are invalid.
+func parseCommand(fs *flag.FlagSet, f *buildFlags, args []string) (string, []string, int) {
+	files, rest, err := parseArgs(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return "", nil, 0
+	} else if err != nil {
+		return "", nil, exitUsage
+	}
+	if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "%s: a single input file is required\n", fs.Name())
+		return "", nil, exitUsage
+	}
+	if f.target == "wasm" && !isSet(fs, "emit") {
+		f.emit = "wat"
+	}
+	// gila emit prints the output of the backend
+	if f.backend == "amd64" && f.emit == "ir" && !isSet(fs, "emit") {
+		f.emit = "asm"
+	}
+	if err := f.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Name(), err)
+		return "", nil, exitUsage
+	}
+	return files[0], rest, -1
+}
+
+func (f *buildFlags) validate() error {
+	if _, ok := emitExtensions[f.emit]; !ok {
+		return fmt.Errorf("unknown --emit=%s, expected one of %s", f.emit, strings.Join(emitKinds, ", "))
+	}
+	if f.checks != "" && f.checks != "overflow" {
+		return fmt.Errorf("unknown --checks=%s", f.checks)
+	}
+	if f.jobs < 1 {
+		return fmt.Errorf("-j must be at least 1, got %d", f.jobs)
+	}
+	switch f.target {
+	case "native":
+	case "wasm":
+		if !wasmEmitKinds[f.emit] {
+			return fmt.Errorf("--emit=%s is not supported for --target=wasm", f.emit)
+		}
+		if f.debug || f.cpu != "" {
+			return errors.New("-g and --cpu are not supported for --target=wasm")
+		}
+	default:
+		if _, ok := ir.Targets[f.target]; !ok {
+			return fmt.Errorf("unknown --target=%s, expected one of %s", f.target, strings.Join(append(targets, ir.TargetTriples()...), ", "))
+		}
+	}
+	switch f.backend {
+	case "llvm":
+	case "amd64":
+		if f.target != "native" {
+			return fmt.Errorf("--backend=amd64 is not supported for --target=%s", f.target)
+		}
+		if f.emit == "ir" {
+			return errors.New("--emit=ir is not supported for --backend=amd64")
+		}
+		if f.debug {
+			return errors.New("-g is not supported for --backend=amd64")
+		}
+		if f.cpu != "" {
+			return errors.New("--cpu is not supported for --backend=amd64")
+		}
+	default:
+		return fmt.Errorf("unknown --backend=%s, expected one of %s", f.backend, strings.Join(backends, ", "))
+	}
+	return nil
+}
+
+// isSet reports whether a flag was given on the command line.
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func buildCommand(args []string) int {
+	var f buildFlags
+	fs := newFlagSet("build", &f, "exe")
+	file, _, code := parseCommand(fs, &f, args)
+	if code >= 0 {
+		return code
+	}
+	if f.output == "" {
+		f.output = defaultOutput(file, f.emit)
+	}
+	return exitCode(build(file, f))
+}
+
+func emitCommand(args []string) int {
+	var f buildFlags
+	fs := newFlagSet("emit", &f, "ir")
+	file, _, code := parseCommand(fs, &f, args)
+	if code >= 0 {
+		return code
+	}
+	if f.output == "" {
+		// Binary outputs are not written to a terminal
+		if f.emit == "obj" || f.emit == "exe" || f.emit == "lib" || f.emit == "bytecode" {
+			f.output = defaultOutput(file, f.emit)
+		} else {
+			f.output = "-"
+		}
+	}
+	return exitCode(build(file, f))
+}
+
+func runCommand(args []string) int {
+	var f buildFlags
+	fs := newFlagSet("run", &f, "exe")
+	interpret := fs.Bool("interp", false, "execute the program with the interpreter, which doesn't need llc and a C compiler")
+	useVM := fs.Bool("vm", false, "compile the program to bytecode and execute it with the vm, which doesn't need llc and a C compiler")
+	fs.IntVar(&f.maxDepth, "max-depth", interp.DefaultMaxCallDepth, "deepest recursion of the interpreter, a deeper call stops the program with runtime error 202")
+	file, programArgs, code := parseCommand(fs, &f, args)
+	if code >= 0 {
+		return code
+	}
+	if f.maxDepth < 1 {
+		fmt.Fprintf(os.Stderr, "%s: --max-depth must be at least 1, got %d\n", fs.Name(), f.maxDepth)
+		return exitUsage
+	}
+	if f.emit != "exe" || f.output != "" || f.target != "native" {
+		fmt.Fprintf(os.Stderr, "%s: -o, --emit and --target are not supported\n", fs.Name())
+		return exitUsage
+	}
+	if *interpret && *useVM {
+		fmt.Fprintf(os.Stderr, "%s: --interp and --vm can not be combined\n", fs.Name())
+		return exitUsage
+	}
+	run := run
+	switch {
+	case *interpret:
+		run = interpretProgram
+	case *useVM || filepath.Ext(file) == emitExtensions["bytecode"]:
+		run = runBytecode
+	}
+	exit, err := run(file, f, programArgs)
+	if err != nil {
+		return exitCode(err)
+	}
+	return exit
+}
+
+func checkCommand(args []string) int {
+	fs := newFlagSet("check", nil, "")
+	var paths pathList
+	fs.Var(&paths, "I", unitPathUsage)
+	files, _, err := parseArgs(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return exitUsage
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: at least one input file is required\n", fs.Name())
+		return exitUsage
+	}
+	code := 0
+	for _, file := range files {
+		if c := exitCode(check(file, paths)); c != 0 {
+			code = c
+		}
+	}
+	return code
+}
+
+// exitCode reports an error and returns the exit code, that corresponds to it.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "gila: %v\n", err)
+	var toolchain *toolchainError
+	if errors.As(err, &toolchain) {
+		return exitToolchain
+	}
+	return exitCompileError
 }
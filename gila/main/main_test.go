@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseArgs(t *testing.T) {
+	var f buildFlags
+	fs := newFlagSet("build", &f, "exe")
+	files, rest, err := parseArgs(fs, []string{"a.mila", "-o", "out", "--emit=ir", "--", "-x", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{"a.mila"}) || !reflect.DeepEqual(rest, []string{"-x", "y"}) {
+		t.Errorf("Wrong positional arguments %v and rest %v", files, rest)
+	}
+	if f.output != "out" || f.emit != "ir" {
+		t.Errorf("Flags after the input file are not parsed, got %+v", f)
+	}
+}
+
+func Test_DefaultOutput(t *testing.T) {
+	cases := map[[2]string]string{
+		{"samples/gcd.mila", "exe"}: "samples/gcd",
+		{"gcd.mila", "ir"}:          "gcd.ll",
+		{"-", "exe"}:                "a.out",
+		{"-", "obj"}:                "a.o",
+	}
+	for in, expected := range cases {
+		if out := defaultOutput(in[0], in[1]); out != expected {
+			t.Errorf("Output of %v is %s, expected %s", in, out, expected)
+		}
+	}
+}
+
+func Test_ExitCodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gila")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	valid := filepath.Join(dir, "valid.mila")
+	invalid := filepath.Join(dir, "invalid.mila")
+	ioutil.WriteFile(valid, []byte("program valid; var x: integer; begin x := 1; writeln(x); end."), 0644)
+	ioutil.WriteFile(invalid, []byte("program invalid; begin x := 1; end."), 0644)
+	recursive := filepath.Join(dir, "recursive.mila")
+	ioutil.WriteFile(recursive, []byte("program recursive; procedure p(); begin p(); end; begin p(); end."), 0644)
+	out := filepath.Join(dir, "valid.ll")
+	wat := filepath.Join(dir, "valid.wat")
+	asm := filepath.Join(dir, "valid.s")
+	ssa := filepath.Join(dir, "valid.ssa")
+	optimized := filepath.Join(dir, "optimized.ll")
+	debug := filepath.Join(dir, "debug.ll")
+	cross := filepath.Join(dir, "cross.ll")
+	cases := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"check", valid}, 0},
+		{[]string{"check", valid, invalid}, exitCompileError},
+		{[]string{"check", filepath.Join(dir, "missing.mila")}, exitCompileError},
+		{[]string{"emit", "-o", out, valid}, 0},
+		{[]string{"emit", "--emit=wasm", valid}, exitUsage},
+		{[]string{"emit", "--emit=ssa", "-o", ssa, valid}, 0},
+		{[]string{"emit", "-O1", "-o", optimized, valid}, 0},
+		{[]string{"emit", "-O1", "-fno-inline", "-o", optimized, valid}, 0},
+		{[]string{"emit", "-g", "-o", debug, valid}, 0},
+		{[]string{"emit", "-g", "--backend=amd64", valid}, exitUsage},
+		{[]string{"build", "-g", "--target=wasm", valid}, exitUsage},
+		{[]string{"emit", "--target=aarch64-unknown-linux-gnu", "--cpu=cortex-a72", "-o", cross, valid}, 0},
+		{[]string{"emit", "--backend=amd64", "--cpu=skylake", valid}, exitUsage},
+		{[]string{"run", "--target=aarch64-unknown-linux-gnu", valid}, exitUsage},
+		{[]string{"build", "--target=wasm", "-o", wat, valid}, 0},
+		{[]string{"build", "--target=wasm", "--emit=obj", valid}, exitUsage},
+		{[]string{"emit", "--target=arm", valid}, exitUsage},
+		{[]string{"run", "--target=wasm", valid}, exitUsage},
+		{[]string{"emit", "--backend=amd64", "--emit=asm", "-o", asm, valid}, 0},
+		{[]string{"emit", "--backend=amd64", "--emit=ir", valid}, exitUsage},
+		{[]string{"build", "--backend=amd64", "--target=wasm", valid}, exitUsage},
+		{[]string{"build", "--backend=gcc", valid}, exitUsage},
+		{[]string{"emit", "-j", "0", valid}, exitUsage},
+		{[]string{"emit", "-j", "3", "-g", "-O2", "-o", debug, valid}, 0},
+		{[]string{"run", "--interp", "--max-depth", "0", valid}, exitUsage},
+		{[]string{"run", "--interp", "--max-depth", "10", recursive}, 202},
+		{[]string{"build", valid, invalid}, exitUsage},
+		{[]string{"frobnicate"}, exitUsage},
+		{nil, exitUsage},
+	}
+	for _, c := range cases {
+		if code := execute(c.args); code != c.code {
+			t.Errorf("gila %v exited with %d, expected %d", c.args, code, c.code)
+		}
+	}
+	if ir, err := ioutil.ReadFile(out); err != nil || !strings.Contains(string(ir), "define i32 @main()") {
+		t.Errorf("gila emit did not write IR, got %q, %v", ir, err)
+	}
+	if code, err := ioutil.ReadFile(ssa); err != nil || !strings.Contains(string(code), "function main(): void\nb0:\n") {
+		t.Errorf("gila emit --emit=ssa did not write ssa, got %q, %v", code, err)
+	}
+	if module, err := ioutil.ReadFile(wat); err != nil || !strings.Contains(string(module), `(func $main (export "main")`) {
+		t.Errorf("gila build --target=wasm did not write a module, got %q, %v", module, err)
+	}
+	if code, err := ioutil.ReadFile(optimized); err != nil || strings.Contains(string(code), "alloca") {
+		t.Errorf("gila emit -O1 did not promote variables, got %q, %v", code, err)
+	}
+	if code, err := ioutil.ReadFile(debug); err != nil || !strings.Contains(string(code), `!DIFile(filename: "valid.mila"`) {
+		t.Errorf("gila emit -g did not emit debug information, got %q, %v", code, err)
+	}
+	if code, err := ioutil.ReadFile(cross); err != nil || !strings.Contains(string(code), `target triple = "aarch64-unknown-linux-gnu"`) {
+		t.Errorf("gila emit --target did not set the triple, got %q, %v", code, err)
+	}
+	if code, err := ioutil.ReadFile(asm); err != nil || !strings.Contains(string(code), "\t.globl main\n") {
+		t.Errorf("gila emit --backend=amd64 did not write assembly, got %q, %v", code, err)
+	}
+}
+
+func Test_IrOptions(t *testing.T) {
+	if passes := irOptions(buildFlags{optimize: 1}).Passes; len(passes) == 0 || passes[0] != "inline" {
+		t.Errorf("-O1 runs %v", passes)
+	}
+	for _, p := range irOptions(buildFlags{optimize: 1, noInline: true}).Passes {
+		if p == "inline" {
+			t.Error("-fno-inline did not disable inlining.")
+		}
+	}
+}
+
+func Test_OptimizedOutput(t *testing.T) {
+	tools, err := newToolchain(buildFlags{backend: "llvm", target: "native"})
+	if err != nil {
+		t.Skip(err)
+	}
+	tools.close()
+	dir, err := ioutil.TempDir("", "gila")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	loops := filepath.Join(dir, "loops.mila")
+	ioutil.WriteFile(loops, []byte(`program loops;
+var i, j, n, s: integer;
+begin
+	readln(n);
+	for i := 1 to n do
+	begin
+		j := 0;
+		while j < n do
+		begin
+			s := s + i * 4 + n div 2;
+			j := j + 1;
+		end;
+	end;
+	writeln(s);
+	for i := n downto 1 do
+	begin
+		s := s - i * 3;
+	end;
+	writeln(s);
+end.`), 0644)
+	samples, _ := filepath.Glob("../../samples/*.mila")
+	for _, sample := range append(samples, loops) {
+		var outputs []string
+		for _, level := range []string{"-O0", "-O2"} {
+			exe := filepath.Join(dir, "program"+level)
+			if code := execute([]string{"build", level, "-o", exe, sample}); code != 0 {
+				t.Fatalf("gila build %s %s exited with %d", level, sample, code)
+			}
+			cmd := exec.Command(exe)
+			cmd.Stdin = strings.NewReader("7\n7\n7\n")
+			out, _ := cmd.CombinedOutput()
+			outputs = append(outputs, string(out))
+		}
+		if outputs[0] != outputs[1] {
+			t.Errorf("%s prints %q with -O2, expected %q", sample, outputs[1], outputs[0])
+		}
+	}
+}
+
+// Test_RuntimeErrors runs the programs, on which all code generators have to agree, with the llvm backend.
+func Test_RuntimeErrors(t *testing.T) {
+	tools, err := newToolchain(buildFlags{backend: "llvm", target: "native"})
+	if err != nil {
+		t.Skip(err)
+	}
+	tools.close()
+	dir, err := ioutil.TempDir("", "gila")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source, exe := filepath.Join(dir, "e.mila"), filepath.Join(dir, "e")
+	for _, c := range rtl.ErrorCases {
+		if c.Error != nil && !c.OverflowChecks {
+			// llvm checks division by zero only with overflow checks, the other code generators always do
+			continue
+		}
+		ioutil.WriteFile(source, []byte(c.Source), 0644)
+		for _, level := range []string{"-O0", "-O2"} {
+			args := []string{"build", level, "-o", exe, source}
+			if c.OverflowChecks {
+				args = append(args[:1], append([]string{"-checks", "overflow"}, args[1:]...)...)
+			}
+			if code := execute(args); code != 0 {
+				t.Fatalf("gila %s exited with %d", strings.Join(args, " "), code)
+			}
+			var stderr bytes.Buffer
+			cmd := exec.Command(exe)
+			cmd.Stderr = &stderr
+			err := cmd.Run()
+			if message := strings.TrimSpace(stderr.String()); message != c.Message() {
+				t.Errorf("%q reported %q with %s, expected %q", c.Source, message, level, c.Message())
+			}
+			if (err == nil) != (c.Error == nil) {
+				t.Errorf("%q exited with %v with %s", c.Source, err, level)
+			}
+		}
+	}
+}
+
+func Test_ExternalFunctions(t *testing.T) {
+	tools, err := newToolchain(buildFlags{backend: "llvm", target: "native"})
+	if err != nil {
+		t.Skip(err)
+	}
+	tools.close()
+	dir, err := ioutil.TempDir("", "gila")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "ext.mila")
+	ioutil.WriteFile(source, []byte(`program ext;
+function sqrt(x: real): real; external 'm';
+function printf(format: string): integer; cdecl; varargs; external;
+var n: integer;
+begin
+	n := printf('%.3f %s %d|', sqrt(2), 'and', 42);
+	writeln(n);
+end.`), 0644)
+	exe := filepath.Join(dir, "ext")
+	if code := execute([]string{"build", "-O2", "-o", exe, source}); code != 0 {
+		t.Fatalf("gila build exited with %d", code)
+	}
+	out, err := exec.Command(exe).CombinedOutput()
+	if err != nil || string(out) != "1.414 and 42|13\n" {
+		t.Errorf("Program printed %q, %v", out, err)
+	}
+	if code := execute([]string{"run", "-interp", source}); code != exitCompileError {
+		t.Errorf("The interpreter ran external functions with exit code %d", code)
+	}
+}
+
+func Test_Library(t *testing.T) {
+	tools, err := newToolchain(buildFlags{backend: "llvm", target: "native"})
+	if err != nil {
+		t.Skip(err)
+	}
+	tools.close()
+	dir, err := ioutil.TempDir("", "gila")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "numeric.mila")
+	ioutil.WriteFile(source, []byte(`library numeric;
+function gcd(a: integer; b: integer): integer;
+begin
+	if b = 0 then gcd := a else gcd := gcd(b, a mod b);
+end;
+function lcm(a: integer; b: integer): int64;
+begin
+	lcm := a div gcd(a, b) * b;
+end;
+exports lcm;
+end.`), 0644)
+	user := filepath.Join(dir, "user.c")
+	ioutil.WriteFile(user, []byte(`#include <stdio.h>
+#include "numeric.h"
+int main(void) {
+	printf("%lld\n", (long long)lcm(4, 6));
+	return 0;
+}
+`), 0644)
+	lib := filepath.Join(dir, "libnumeric.so")
+	if code := execute([]string{"build", "--emit=lib", "-O1", "-o", lib, source}); code != 0 {
+		t.Fatalf("gila build --emit=lib exited with %d", code)
+	}
+	if code := execute([]string{"build", source}); code != exitCompileError {
+		t.Errorf("gila build of a library exited with %d", code)
+	}
+	exe := filepath.Join(dir, "user")
+	if out, err := exec.Command(tools.cc, "-I", dir, "-o", exe, user, lib).CombinedOutput(); err != nil {
+		t.Fatalf("Could not link a C program with the library: %s", out)
+	}
+	out, err := exec.Command(exe).CombinedOutput()
+	if err != nil || string(out) != "12\n" {
+		t.Errorf("Program printed %q, %v", out, err)
+	}
+}
+
+func Test_Units(t *testing.T) {
+	tools, err := newToolchain(buildFlags{backend: "llvm", target: "native"})
+	if err != nil {
+		t.Skip(err)
+	}
+	tools.close()
+	dir, err := ioutil.TempDir("", "gila")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lib := filepath.Join(dir, "lib")
+	os.Mkdir(lib, 0755)
+	ioutil.WriteFile(filepath.Join(lib, "arith.mila"), []byte(`unit arith;
+interface
+function gcd(a: integer; b: integer): integer;
+implementation
+function gcd(a: integer; b: integer): integer;
+begin
+	if b = 0 then gcd := a else gcd := gcd(b, a mod b);
+end;
+end.`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "fractions.mila"), []byte(`unit fractions;
+interface
+uses arith;
+procedure reduced(n: integer; d: integer);
+implementation
+procedure show(n: integer; d: integer);
+begin
+	write(n, '/');
+	writeln(d);
+end;
+procedure reduced(n: integer; d: integer);
+begin
+	show(n div gcd(n, d), d div gcd(n, d));
+end;
+end.`), 0644)
+	program := filepath.Join(dir, "main.mila")
+	ioutil.WriteFile(program, []byte(`program main;
+uses fractions;
+procedure show(x: integer);
+begin
+	writeln(x);
+end;
+begin
+	reduced(6, 8);
+	show(5);
+end.`), 0644)
+	exe := filepath.Join(dir, "main")
+	cache := filepath.Join(dir, "cache")
+	if code := execute([]string{"build", "-O2", "-I", lib, "--cache-dir", cache, "-o", exe, program}); code != 0 {
+		t.Fatalf("gila build exited with %d", code)
+	}
+	out, err := exec.Command(exe).CombinedOutput()
+	if err != nil || string(out) != "3/4\n5\n" {
+		t.Errorf("Program printed %q, %v", out, err)
+	}
+	// Objects of units, which are not compiled again, keep their time
+	objects, _ := filepath.Glob(filepath.Join(cache, "*.o"))
+	interfaces, _ := filepath.Glob(filepath.Join(cache, "*.gli"))
+	if len(objects) != 2 || len(interfaces) != 2 {
+		t.Fatalf("Cache has objects %v and interfaces %v", objects, interfaces)
+	}
+	past := time.Now().Add(-time.Hour)
+	for _, o := range objects {
+		os.Chtimes(o, past, past)
+	}
+	rebuilt := func() (units []string) {
+		if code := execute([]string{"build", "-O2", "-I", lib, "--cache-dir", cache, "-o", exe, program}); code != 0 {
+			t.Fatalf("gila build exited with %d", code)
+		}
+		for _, o := range objects {
+			if info, err := os.Stat(o); err != nil || info.ModTime().After(past) {
+				units = append(units, strings.SplitN(filepath.Base(o), "-", 2)[0])
+				os.Chtimes(o, past, past)
+			}
+		}
+		return units
+	}
+	if units := rebuilt(); len(units) != 0 {
+		t.Errorf("Units %v were compiled again", units)
+	}
+	ioutil.WriteFile(filepath.Join(lib, "arith.mila"), []byte(`unit arith;
+interface
+function gcd(a: integer; b: integer): integer;
+implementation
+function gcd(a: integer; b: integer): integer;
+begin
+	while b <> 0 do
+	begin
+		gcd := b;
+		b := a mod b;
+		a := gcd;
+	end;
+	gcd := a;
+end;
+end.`), 0644)
+	if units := rebuilt(); strings.Join(units, " ") != "arith" {
+		t.Errorf("Units %v were compiled after arith changed", units)
+	}
+	out, err = exec.Command(exe).CombinedOutput()
+	if err != nil || string(out) != "3/4\n5\n" {
+		t.Errorf("Rebuilt program printed %q, %v", out, err)
+	}
+	cases := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"build", program}, exitCompileError},
+		{[]string{"check", "-I", lib, program}, 0},
+		{[]string{"build", "--emit=obj", "-I", lib, "-o", filepath.Join(dir, "fractions.o"), filepath.Join(dir, "fractions.mila")}, 0},
+		{[]string{"build", "-I", lib, filepath.Join(dir, "fractions.mila")}, exitCompileError},
+		{[]string{"run", "--interp", "-I", lib, program}, exitCompileError},
+		{[]string{"run", "-I", lib, "--cache-dir", cache, program}, 0},
+	}
+	for _, c := range cases {
+		if code := execute(c.args); code != c.code {
+			t.Errorf("gila %v exited with %d, expected %d", c.args, code, c.code)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ir"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// toolchainError is a failure of an external tool, as opposed to an error in the compiled program.
+type toolchainError struct {
+	message string
+}
+
+func (e *toolchainError) Error() string {
+	return e.message
+}
+
+// toolchain turns the output of a native backend into native code and links it with the runtime library
+// using a C compiler. LLVM IR is compiled with llc first, assembly is passed to the C compiler as is.
+// The tools can be overridden by LLC and CC environment variables.
+type toolchain struct {
+	backend string
+	llc     string
+	cc      string
+	// cross is the triple of a target other than native, executables for it are linked by clang
+	cross string
+	cpu   string
+	// libraries are passed to the linker as -l options
+	libraries []string
+	// jobs is the number of modules compiled by llc at the same time
+	jobs int
+	// dir holds intermediate files
+	dir string
+}
+
+// newToolchain finds the tools needed by the backend and the target selected by flags. Only the llvm backend needs llc.
+func newToolchain(f buildFlags) (*toolchain, error) {
+	t := &toolchain{backend: f.backend, cpu: f.cpu, jobs: f.jobs}
+	if triple := targetTriple(f); triple != ir.HostTriple() {
+		t.cross = triple
+	}
+	var err error
+	if t.backend == "llvm" {
+		if t.llc, err = findTool("LLC", "llc"); err != nil {
+			return nil, err
+		}
+	}
+	if t.cc, err = findTool("CC", "clang", "cc"); err != nil {
+		return nil, err
+	}
+	if t.dir, err = ioutil.TempDir("", "gila"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// findTool returns the tool named by an environment variable, or the first of candidates found in PATH.
+func findTool(variable string, candidates ...string) (string, error) {
+	if tool := os.Getenv(variable); tool != "" {
+		candidates = []string{tool}
+	}
+	for _, c := range candidates {
+		if path, err := exec.LookPath(c); err == nil {
+			return path, nil
+		}
+	}
+	return "", &toolchainError{fmt.Sprintf("%s not found, install it or set %s", candidates[0], variable)}
+}
+
+func (t *toolchain) close() {
+	os.RemoveAll(t.dir)
+}
+
+// compile writes the code generated by the backend as an assembly file, an object file or an executable.
+// Executables and shared libraries also contain the units, which the program uses, each compiled from its own module.
+// Units are compiled into the cache, when there is one, and the ones already compiled there are just linked.
+// Modules are compiled in parallel, the error of the first one is reported.
+func (t *toolchain) compile(g *generated, emit, output string) error {
+	if t.backend == "amd64" {
+		return t.assemble(g.code, emit, output)
+	}
+	switch emit {
+	case "asm":
+		return t.llcompile(g.code, "asm", output)
+	case "obj":
+		return t.llcompile(g.code, "obj", output)
+	}
+	modules := append(append([]*generated(nil), g.units...), g)
+	objects := make([]string, len(modules))
+	errs := make([]error, len(modules))
+	ir.Parallel(len(modules), t.jobs, func(i int) {
+		m := modules[i]
+		objects[i] = m.object
+		if objects[i] == "" {
+			objects[i] = filepath.Join(t.dir, fmt.Sprintf("%d-%s.o", i, m.name))
+		}
+		if m.code == "" {
+			return
+		}
+		if errs[i] = t.llcompile(m.code, "obj", objects[i]); errs[i] == nil && g.cache != nil && m.unit != nil {
+			errs[i] = g.cache.Store(m.unit)
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return t.link(objects, output, emit == "lib")
+}
+
+// llcompile compiles LLVM IR into an assembly file or an object file with llc.
+func (t *toolchain) llcompile(code, filetype, output string) error {
+	// Modules are compiled in parallel, each needs its own file
+	file, err := ioutil.TempFile(t.dir, "module-*.ll")
+	if err != nil {
+		return err
+	}
+	ll := file.Name()
+	_, err = file.WriteString(code)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// The module names the triple, so llc only needs to know the processor
+	flags := []string{"-relocation-model=pic", "-filetype=" + filetype}
+	if t.cpu != "" {
+		flags = append(flags, "-mcpu="+t.cpu)
+	}
+	return t.tool(t.llc, append(flags, "-o", output, ll)...)
+}
+
+// assemble builds an object file or an executable from assembly with the C compiler.
+func (t *toolchain) assemble(code, emit, output string) error {
+	s := filepath.Join(t.dir, "program.s")
+	if err := ioutil.WriteFile(s, []byte(code), 0644); err != nil {
+		return err
+	}
+	if emit == "obj" {
+		return t.tool(t.cc, "-c", "-o", output, s)
+	}
+	return t.link([]string{s}, output, emit == "lib")
+}
+
+// link builds an executable or a shared library from compiled modules and the runtime library.
+func (t *toolchain) link(objects []string, output string, shared bool) error {
+	runtime := filepath.Join(t.dir, "fce.c")
+	if err := ioutil.WriteFile(runtime, []byte(rtl.Source), 0644); err != nil {
+		return err
+	}
+	args := append(objects, runtime, "-o", output)
+	if shared {
+		// Only the functions of the library are visible, not the runtime
+		args = append([]string{"-shared", "-fPIC", "-fvisibility=hidden"}, args...)
+	}
+	for _, l := range t.libraries {
+		args = append(args, "-l"+l)
+	}
+	if t.cross == "" {
+		return t.tool(t.cc, args...)
+	}
+	// Other C compilers are built for a single target
+	if !strings.Contains(filepath.Base(t.cc), "clang") {
+		return &toolchainError{fmt.Sprintf("linking for %s needs clang, set CC or use --emit=obj", t.cross)}
+	}
+	return t.tool(t.cc, append([]string{"--target=" + t.cross}, args...)...)
+}
+
+// tool runs an external tool, passing its output through. llc writes to the standard output, when output is -.
+func (t *toolchain) tool(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return &toolchainError{fmt.Sprintf("%s failed: %v", filepath.Base(name), err)}
+	}
+	return nil
+}
+
+// execute runs a built program attached to the standard streams and returns its exit code.
+func (t *toolchain) execute(exe string, args []string) (int, error) {
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode(), nil
+	}
+	return 0, err
+}
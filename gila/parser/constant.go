@@ -0,0 +1,396 @@
+package parser
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"math"
+	"math/big"
+)
+
+// compileTimeFunction parses a call of ord, chr, high, low or sizeof, which is evaluated by the parser.
+// It returns nil for other functions.
+func (p *Parser) compileTimeFunction() ast.Expression {
+	switch p.current.Value {
+	case "ord":
+		p.advance()
+		return p.ord()
+	case "chr":
+		p.advance()
+		return p.chr()
+	case "high":
+		p.advance()
+		return p.high()
+	case "low":
+		p.advance()
+		return p.low()
+	case "sizeof":
+		p.advance()
+		return p.sizeof()
+	}
+	return nil
+}
+
+// constant turns the value of a constant expression into a literal. Integer constants with a declared type
+// keep it, untyped integers get their type from their value, like numbers do.
+func constant(name string, v ast.Value, t ast.Type) ast.Expression {
+	switch x := v.(type) {
+	case ast.MilaInt:
+		if t == ast.REAL {
+			return &ast.RealLiteral{Value: float64(x)}
+		}
+		if t != nil {
+			if low, high := integerRange(t); int64(x) < low || int64(x) > high {
+				panic(fmt.Sprintf("Constant %s = %d does not fit into %v.", name, x, t))
+			}
+		}
+		return &ast.Literal{Value: int64(x), Type: t}
+	case ast.MilaReal:
+		if t == nil || t == ast.REAL {
+			return &ast.RealLiteral{Value: float64(x)}
+		}
+	case ast.MilaString:
+		if t == nil {
+			return ast.StringLiteral{Value: string(x)}
+		}
+	case ast.MilaBoolean:
+		panic(fmt.Sprintf("Constant %s must be a number or a string.", name))
+	}
+	panic(fmt.Sprintf("Can not assign %v to constant %s of type %v.", valueType(v), name, t))
+}
+
+// integerRange returns the smallest and the largest value of an integer type.
+func integerRange(t ast.Type) (int64, int64) {
+	switch t {
+	case ast.BYTE:
+		return 0, math.MaxUint8
+	case ast.SHORTINT:
+		return math.MinInt8, math.MaxInt8
+	case ast.WORD:
+		return 0, math.MaxUint16
+	case ast.INT:
+		return math.MinInt32, math.MaxInt32
+	case ast.CARDINAL:
+		return 0, math.MaxUint32
+	default:
+		return math.MinInt64, math.MaxInt64
+	}
+}
+
+func valueType(v ast.Value) ast.Type {
+	switch v.(type) {
+	case ast.MilaInt:
+		return ast.INT
+	case ast.MilaReal:
+		return ast.REAL
+	case ast.MilaString:
+		return ast.STRING
+	}
+	return ast.BOOLEAN
+}
+
+// evaluate computes the value of a constant expression. Constant expressions are evaluated exactly,
+// integers don't wrap around at the width of their type, but the result must fit into int64.
+func evaluate(e ast.Expression) ast.Value {
+	switch n := e.(type) {
+	case *ast.Literal:
+		return ast.MilaInt(n.Value)
+	case *ast.RealLiteral:
+		return ast.MilaReal(n.Value)
+	case ast.StringLiteral:
+		return ast.MilaString(n.Value)
+	case *ast.Unary:
+		return evaluateUnary(n, evaluate(n.Operand))
+	case *ast.Binary:
+		return evaluateBinary(n, evaluate(n.Left), evaluate(n.Right))
+	case nil:
+		panic("Constant expression refers to an unknown symbol.")
+	}
+	panic(fmt.Sprintf("%v is not a constant expression.", e))
+}
+
+func evaluateUnary(e *ast.Unary, v ast.Value) ast.Value {
+	switch x := v.(type) {
+	case ast.MilaInt:
+		switch e.Operation {
+		case ast.PLUS:
+			return x
+		case ast.MINUS:
+			return exact(e, new(big.Int).Neg(big.NewInt(int64(x))))
+		case ast.NOT:
+			return ^x
+		}
+	case ast.MilaReal:
+		switch e.Operation {
+		case ast.PLUS:
+			return x
+		case ast.MINUS:
+			return -x
+		}
+	case ast.MilaBoolean:
+		if e.Operation == ast.NOT {
+			return !x
+		}
+	}
+	panic(fmt.Sprintf("Operation %v is not defined for %v in %v.", e.Operation, valueType(v), e))
+}
+
+func evaluateBinary(e *ast.Binary, left, right ast.Value) ast.Value {
+	switch x := left.(type) {
+	case ast.MilaInt:
+		switch y := right.(type) {
+		case ast.MilaInt:
+			return evaluateInteger(e, int64(x), int64(y))
+		case ast.MilaReal:
+			return evaluateReal(e, float64(x), float64(y))
+		}
+	case ast.MilaReal:
+		switch y := right.(type) {
+		case ast.MilaInt:
+			return evaluateReal(e, float64(x), float64(y))
+		case ast.MilaReal:
+			return evaluateReal(e, float64(x), float64(y))
+		}
+	case ast.MilaBoolean:
+		if y, ok := right.(ast.MilaBoolean); ok {
+			switch e.Operation {
+			case ast.AND:
+				return x && y
+			case ast.OR:
+				return x || y
+			case ast.XOR, ast.NOTEQUALS:
+				return ast.MilaBoolean(x != y)
+			case ast.EQUALS:
+				return ast.MilaBoolean(x == y)
+			}
+		}
+	case ast.MilaString:
+		if y, ok := right.(ast.MilaString); ok {
+			switch e.Operation {
+			case ast.EQUALS:
+				return ast.MilaBoolean(x == y)
+			case ast.NOTEQUALS:
+				return ast.MilaBoolean(x != y)
+			}
+		}
+	}
+	panic(fmt.Sprintf("Operation %v is not defined for %v and %v in %v.", e.Operation, valueType(left), valueType(right), e))
+}
+
+func evaluateInteger(e *ast.Binary, x, y int64) ast.Value {
+	switch e.Operation {
+	case ast.PLUS:
+		return exact(e, new(big.Int).Add(big.NewInt(x), big.NewInt(y)))
+	case ast.MINUS:
+		return exact(e, new(big.Int).Sub(big.NewInt(x), big.NewInt(y)))
+	case ast.MULTIPLY:
+		return exact(e, new(big.Int).Mul(big.NewInt(x), big.NewInt(y)))
+	case ast.DIV, ast.MOD:
+		if y == 0 {
+			panic(fmt.Sprintf("Division by zero in constant expression %v.", e))
+		}
+		if e.Operation == ast.DIV {
+			return exact(e, new(big.Int).Quo(big.NewInt(x), big.NewInt(y)))
+		}
+		return exact(e, new(big.Int).Rem(big.NewInt(x), big.NewInt(y)))
+	case ast.DIVIDE:
+		return evaluateReal(e, float64(x), float64(y))
+	case ast.AND:
+		return ast.MilaInt(x & y)
+	case ast.OR:
+		return ast.MilaInt(x | y)
+	case ast.XOR:
+		return ast.MilaInt(x ^ y)
+	case ast.SHL, ast.SHR:
+		if y < 0 || y > 63 {
+			panic(fmt.Sprintf("Shift count %d is out of range in constant expression %v.", y, e))
+		}
+		if e.Operation == ast.SHL {
+			return exact(e, new(big.Int).Lsh(big.NewInt(x), uint(y)))
+		}
+		// shr is a logical shift, the sign bit is not extended
+		return ast.MilaInt(uint64(x) >> uint(y))
+	}
+	return compare(e, x < y, x == y, x > y)
+}
+
+func evaluateReal(e *ast.Binary, x, y float64) ast.Value {
+	switch e.Operation {
+	case ast.PLUS:
+		return ast.MilaReal(x + y)
+	case ast.MINUS:
+		return ast.MilaReal(x - y)
+	case ast.MULTIPLY:
+		return ast.MilaReal(x * y)
+	case ast.DIVIDE:
+		return ast.MilaReal(x / y)
+	}
+	return compare(e, x < y, x == y, x > y)
+}
+
+func compare(e *ast.Binary, less, equal, greater bool) ast.Value {
+	switch e.Operation {
+	case ast.EQUALS:
+		return ast.MilaBoolean(equal)
+	case ast.NOTEQUALS:
+		return ast.MilaBoolean(!equal)
+	case ast.LESS:
+		return ast.MilaBoolean(less)
+	case ast.LESSEQ:
+		return ast.MilaBoolean(less || equal)
+	case ast.GREATER:
+		return ast.MilaBoolean(greater)
+	case ast.GREATEREQ:
+		return ast.MilaBoolean(greater || equal)
+	}
+	panic(fmt.Sprintf("Operation %v is not defined for numbers in %v.", e.Operation, e))
+}
+
+// exact returns the result of an integer operation, which must fit into int64.
+func exact(e ast.Expression, x *big.Int) ast.Value {
+	if !x.IsInt64() {
+		panic(fmt.Sprintf("Constant expression %v overflows.", e))
+	}
+	return ast.MilaInt(x.Int64())
+}
+
+// constantArgument parses the only argument of a compile time function and evaluates it.
+func (p *Parser) constantArgument() ast.Value {
+	p.match(token.LPAREN)
+	v := evaluate(p.expr())
+	p.match(token.RPAREN)
+	return v
+}
+
+// typeArgument parses the only argument of high, low or sizeof, which is either a type or a symbol.
+func (p *Parser) typeArgument() ast.Type {
+	p.match(token.LPAREN)
+	var t ast.Type
+	if p.current.Kind == token.IDENT {
+		name := p.advance().Value
+		t = p.symbolType(name)
+		if t == nil {
+			panic(fmt.Sprintf("%s is not a variable, a parameter or a constant.", name))
+		}
+	} else {
+		t = p.typeSpecification()
+	}
+	p.match(token.RPAREN)
+	return t
+}
+
+// symbolType returns the type of a variable, a parameter or a constant in the current function, or nil.
+func (p *Parser) symbolType(name string) ast.Type {
+	if t, ok := p.context.Variables[name]; ok {
+		return t
+	}
+	for _, par := range p.context.Signature.Parameters {
+		if par.Name == name {
+			return par.Type
+		}
+	}
+	switch c := p.context.Constants[name].(type) {
+	case *ast.Literal:
+		if c.Type != nil {
+			return c.Type
+		}
+		return ast.INT
+	case *ast.RealLiteral:
+		return ast.REAL
+	case ast.StringLiteral:
+		return ast.STRING
+	}
+	return nil
+}
+
+func (p *Parser) ord() ast.Expression {
+	switch v := p.constantArgument().(type) {
+	case ast.MilaInt:
+		return &ast.Literal{Value: int64(v)}
+	case ast.MilaBoolean:
+		if v {
+			return &ast.Literal{Value: 1}
+		}
+		return &ast.Literal{Value: 0}
+	case ast.MilaString:
+		if len(v) == 1 {
+			return &ast.Literal{Value: int64(v[0])}
+		}
+	}
+	panic("Argument of ord must be an integer, a boolean or a character constant.")
+}
+
+func (p *Parser) chr() ast.Expression {
+	if v, ok := p.constantArgument().(ast.MilaInt); ok && v >= 0 && v <= math.MaxUint8 {
+		return ast.StringLiteral{Value: string([]byte{byte(v)})}
+	}
+	panic("Argument of chr must be an integer constant from 0 to 255.")
+}
+
+func (p *Parser) high() ast.Expression {
+	t := p.typeArgument()
+	if s, ok := t.(ast.Set); ok {
+		return &ast.Literal{Value: s.High}
+	}
+	if !ast.IsInteger(t) {
+		panic(fmt.Sprintf("high is not defined for %v.", t))
+	}
+	_, high := integerRange(t)
+	return &ast.Literal{Value: high, Type: t}
+}
+
+func (p *Parser) low() ast.Expression {
+	t := p.typeArgument()
+	if s, ok := t.(ast.Set); ok {
+		return &ast.Literal{Value: s.Low}
+	}
+	if !ast.IsInteger(t) {
+		panic(fmt.Sprintf("low is not defined for %v.", t))
+	}
+	low, _ := integerRange(t)
+	return &ast.Literal{Value: low, Type: t}
+}
+
+// sizeof returns the size of a type in bytes, sets take as many bytes as their representation.
+func (p *Parser) sizeof() ast.Expression {
+	switch t := p.typeArgument(); t {
+	case ast.BYTE, ast.SHORTINT:
+		return &ast.Literal{Value: 1}
+	case ast.WORD:
+		return &ast.Literal{Value: 2}
+	case ast.INT, ast.CARDINAL:
+		return &ast.Literal{Value: 4}
+	case ast.INT64, ast.REAL:
+		return &ast.Literal{Value: 8}
+	default:
+		if s, ok := t.(ast.Set); ok {
+			return &ast.Literal{Value: capacity(s) / 8}
+		}
+		panic(fmt.Sprintf("sizeof is not defined for %v.", t))
+	}
+}
+
+// capacity is the number of elements, that the representation of a set type can hold.
+func capacity(s ast.Set) int64 {
+	switch {
+	case s.High < 32:
+		return 32
+	case s.High < 64:
+		return 64
+	default:
+		return ast.MaxSetElement + 1
+	}
+}
+
+// copyConstant returns a new literal for every reference to a constant, since types are recorded per expression.
+func copyConstant(value ast.Expression) ast.Expression {
+	switch c := value.(type) {
+	case *ast.Literal:
+		literal := *c
+		return &literal
+	case *ast.RealLiteral:
+		literal := *c
+		return &literal
+	}
+	return value
+}
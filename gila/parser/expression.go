@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
 	"gitlab.fit.cvut.cz/fedorgle/gila/token"
 	"strconv"
@@ -13,13 +14,14 @@ func (p *Parser) parseExpression() ast.Expression {
 
 func (p *Parser) expr() ast.Expression {
 	res := p.eqExpr()
-	for p.current.Kind == token.AND || p.current.Kind == token.OR {
+	for p.current.Kind == token.AND || p.current.Kind == token.OR || p.current.Kind == token.XOR {
 		op := tokenToOperation(&p.current)
-		p.advance()
+		pos := p.advance().Position
 		res = &ast.Binary{
 			Left:      res,
 			Right:     p.eqExpr(),
 			Operation: op,
+			Position:  pos,
 		}
 	}
 	return res
@@ -34,14 +36,16 @@ func (p *Parser) eqExpr() ast.Expression {
 		token.GREATEREQ: true,
 		token.LESS:      true,
 		token.LESSEQ:    true,
+		token.IN:        true,
 	}
 	for logicalOperators[p.current.Kind] {
 		op := tokenToOperation(&p.current)
-		p.advance()
+		pos := p.advance().Position
 		res = &ast.Binary{
 			Left:      res,
 			Right:     p.pmExpr(),
 			Operation: op,
+			Position:  pos,
 		}
 	}
 	return res
@@ -51,11 +55,12 @@ func (p *Parser) pmExpr() ast.Expression {
 	res := p.term()
 	for p.current.Kind == token.PLUS || p.current.Kind == token.MINUS {
 		op := tokenToOperation(&p.current)
-		p.advance()
+		pos := p.advance().Position
 		res = &ast.Binary{
 			Left:      res,
 			Right:     p.term(),
 			Operation: op,
+			Position:  pos,
 		}
 	}
 	return res
@@ -67,11 +72,14 @@ func (p *Parser) term() ast.Expression {
 		token.MOD:      true,
 		token.DIV:      true,
 		token.MULTIPLY: true,
+		token.SLASH:    true,
+		token.SHL:      true,
+		token.SHR:      true,
 	}
 	for termOperators[p.current.Kind] {
 		op := tokenToOperation(&p.current)
-		p.advance()
-		res = &ast.Binary{Left: res, Right: p.factor(), Operation: op}
+		pos := p.advance().Position
+		res = &ast.Binary{Left: res, Right: p.factor(), Operation: op, Position: pos}
 	}
 	return res
 }
@@ -80,13 +88,29 @@ func (p *Parser) factor() ast.Expression {
 	switch p.current.Kind {
 	case token.NUMBER:
 		return p.number()
+	case token.REALLIT:
+		return p.realNumber()
+	case token.STRLIT:
+		return ast.StringLiteral{Value: p.advance().Value}
 	case token.LPAREN:
 		return p.parens()
+	case token.LBRACKET:
+		return p.setConstructor()
 	case token.MINUS:
 		return p.unary()
 	case token.PLUS:
 		return p.unary()
+	case token.NOT:
+		return p.unary()
 	case token.IDENT:
+		if p.context == nil {
+			panic(fmt.Sprintf("%s is not a constant.", p.current.Value))
+		}
+		if p.peek.Kind == token.LPAREN {
+			if res := p.compileTimeFunction(); res != nil {
+				return res
+			}
+		}
 		if p.peek.Kind != token.LPAREN { // Constant or variable
 			var res ast.Expression
 			if _, ok := p.context.Variables[p.current.Value]; ok {
@@ -94,8 +118,8 @@ func (p *Parser) factor() ast.Expression {
 					Name: p.current.Value,
 				}
 			}
-			if literal, ok := p.context.Constants[p.current.Value]; ok {
-				res = &literal
+			if value, ok := p.context.Constants[p.current.Value]; ok {
+				res = copyConstant(value)
 			}
 			for _, par := range p.context.Signature.Parameters {
 				if par.Name == p.current.Value {
@@ -115,7 +139,7 @@ func (p *Parser) factor() ast.Expression {
 }
 
 func (p *Parser) functionCall() *ast.FunctionCall {
-	procedureName := p.match(token.IDENT).Value
+	function := p.match(token.IDENT)
 	p.match(token.LPAREN)
 	var args []ast.Expression
 	for p.current.Kind != token.RPAREN && p.current.Kind != token.EOF {
@@ -126,8 +150,9 @@ func (p *Parser) functionCall() *ast.FunctionCall {
 	}
 	p.match(token.RPAREN)
 	return &ast.FunctionCall{
-		Name: procedureName,
-		Args: args,
+		Name:     function.Value,
+		Args:     args,
+		Position: function.Position,
 	}
 }
 
@@ -142,19 +167,47 @@ func (p *Parser) parens() ast.Expression {
 }
 
 func (p *Parser) number() *ast.Literal {
-	i, err := strconv.Atoi(p.current.Value)
+	i, err := strconv.ParseInt(p.current.Value, 10, 64)
 	if err != nil {
 		panic("Invalid number token. Failed to convert.")
 	}
 	p.advance()
-	return &ast.Literal{Value: int64(i)}
+	return &ast.Literal{Value: i}
+}
+
+func (p *Parser) realNumber() *ast.RealLiteral {
+	f, err := strconv.ParseFloat(p.current.Value, 64)
+	if err != nil {
+		panic("Invalid real number token. Failed to convert.")
+	}
+	p.advance()
+	return &ast.RealLiteral{Value: f}
 }
 
 func (p *Parser) unary() *ast.Unary {
 	op := tokenToOperation(&p.current)
-	p.advance()
+	pos := p.advance().Position
 	return &ast.Unary{
 		Operand:   p.factor(),
 		Operation: op,
+		Position:  pos,
+	}
+}
+
+func (p *Parser) setConstructor() *ast.SetConstructor {
+	p.match(token.LBRACKET)
+	var elements []ast.SetElement
+	for p.current.Kind != token.RBRACKET && p.current.Kind != token.EOF {
+		element := ast.SetElement{Low: p.expr()}
+		if p.current.Kind == token.DOTDOT {
+			p.advance()
+			element.High = p.expr()
+		}
+		elements = append(elements, element)
+		if p.current.Kind == token.COMA {
+			p.advance()
+		}
 	}
+	p.match(token.RBRACKET)
+	return &ast.SetConstructor{Elements: elements}
 }
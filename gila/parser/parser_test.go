@@ -94,3 +94,134 @@ func Test_ConstsInExpr(t *testing.T) {
 		t.Error("Did not parse the whole input.")
 	}
 }
+
+func Test_SetDeclarationsAndConstructors(t *testing.T) {
+	l := lexer.New(strings.NewReader("program sets; var s: set of 0..31; begin s := [1, 3..5]; if 2 in s + [2] then writeln(1); end."))
+	p := New(l)
+	program := p.Parse()
+	main := program.Functions[0]
+	if main.Variables["s"] != (ast.Set{Low: 0, High: 31}) {
+		t.Errorf("Failed to parse a set type, got %v", main.Variables["s"])
+	}
+	body := main.Body.(*ast.Block).Statements
+	constructor, ok := body[1].(*ast.Assignment).Value.(*ast.SetConstructor)
+	if !ok || len(constructor.Elements) != 2 || constructor.Elements[1].High == nil {
+		t.Errorf("Failed to parse a set constructor, got %v", body[1])
+	}
+	condition, ok := body[2].(*ast.If).Condition.(*ast.Binary)
+	if !ok || condition.Operation != ast.IN {
+		t.Errorf("Failed to parse an in operator, got %v", body[2])
+	}
+}
+
+func Test_BitwiseOperators(t *testing.T) {
+	l := lexer.New(strings.NewReader("1 xor 2 shl 3 or not 4 shr 1"))
+	p := New(l)
+	ex := p.parseExpression()
+	if ex.(fmt.Stringer).String() != "((1 xor (2 shl 3)) or ((not 4) shr 1))" {
+		t.Errorf("Wrong precedence of bitwise operators: %v", ex)
+	}
+}
+
+func Test_WriteArguments(t *testing.T) {
+	l := lexer.New(strings.NewReader("program io; var x: real; begin writeln('x = ', x:10:2, x / 2:8); writeln; end."))
+	p := New(l)
+	body := p.Parse().Functions[0].Body.(*ast.Block).Statements
+	call := body[1].(*ast.ProcedureCall)
+	if len(call.Args) != 3 {
+		t.Fatalf("Expected 3 arguments of writeln, got %v", call.Args)
+	}
+	if _, ok := call.Args[0].(ast.StringLiteral); !ok {
+		t.Errorf("Failed to parse a string argument, got %v", call.Args[0])
+	}
+	if format, ok := call.Args[1].(*ast.Format); !ok || format.Precision == nil {
+		t.Errorf("Failed to parse a width and a precision, got %v", call.Args[1])
+	}
+	if format, ok := call.Args[2].(*ast.Format); !ok || format.Precision != nil || format.Value.(*ast.Binary).Operation != ast.DIVIDE {
+		t.Errorf("Failed to parse a width, got %v", call.Args[2])
+	}
+	if call := body[2].(*ast.ProcedureCall); call.Name != "writeln" || len(call.Args) != 0 {
+		t.Errorf("Failed to parse a call without parentheses, got %v", call)
+	}
+}
+
+func Test_ExternalDeclarations(t *testing.T) {
+	l := lexer.New(strings.NewReader(`program ext;
+function sqrt(x: real): real; external 'm';
+function printf(format: string): integer; cdecl; varargs; external;
+begin
+	writeln(sqrt(2));
+end.`))
+	functions := New(l).Parse().Functions
+	if s := functions[0].Signature; !s.External || s.Library != "m" || s.Cdecl || functions[0].Body != nil {
+		t.Errorf("Failed to parse an external function from a library, got %+v", s)
+	}
+	if s := functions[1].Signature; !s.External || s.Library != "" || !s.Cdecl || !s.Varargs || s.Parameters[0].Type != ast.STRING {
+		t.Errorf("Failed to parse a varargs function, got %+v", s)
+	}
+	invalid := []string{
+		"program e; function printf(format: string): integer; varargs; external; begin end.",
+		"program e; function abs(x: integer): integer; inline; external; begin end.",
+		"program e; function abs(x: integer): integer; external 'c' 'm'; begin end.",
+	}
+	for _, source := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected an error in %q", source)
+				}
+			}()
+			New(lexer.New(strings.NewReader(source))).Parse()
+		}()
+	}
+}
+
+func Test_Library(t *testing.T) {
+	l := lexer.New(strings.NewReader(`library numeric;
+function sq(x: integer): integer;
+begin
+	sq := x * x;
+end;
+procedure hello();
+begin
+	writeln('hello');
+end;
+exports sq, hello;
+exports sq;
+end.`))
+	program := New(l).Parse()
+	if !program.Library || program.Name != "numeric" || len(program.Functions) != 2 {
+		t.Errorf("Failed to parse a library, got %+v", program)
+	}
+	if strings.Join(program.Exports, " ") != "sq hello sq" {
+		t.Errorf("Failed to parse exports, got %v", program.Exports)
+	}
+}
+
+func Test_Unit(t *testing.T) {
+	l := lexer.New(strings.NewReader(`unit arith;
+interface
+uses numbers;
+function sq(x: integer): integer;
+implementation
+function helper(x: integer): integer;
+begin
+	helper := x;
+end;
+function sq(x: integer): integer;
+begin
+	sq := helper(x) * x;
+end;
+end.`))
+	program := New(l).Parse()
+	if !program.Unit || program.Name != "arith" || len(program.Uses) != 1 || program.Uses[0] != "numbers" {
+		t.Errorf("Failed to parse a unit, got %+v", program)
+	}
+	if len(program.Exports) != 1 || program.Exports[0] != "sq" || len(program.Functions) != 3 || program.Functions[0].Body != nil {
+		t.Errorf("Failed to parse the interface of a unit, got %v and %v", program.Exports, program.Functions)
+	}
+	l = lexer.New(strings.NewReader("program p; uses arith, numbers; begin writeln(sq(2)); end."))
+	if uses := New(l).Parse().Uses; strings.Join(uses, " ") != "arith numbers" {
+		t.Errorf("Failed to parse a uses clause, got %v", uses)
+	}
+}
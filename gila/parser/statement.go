@@ -7,9 +7,16 @@ import (
 )
 
 func (p *Parser) Parse() *ast.Program {
+	switch p.current.Kind {
+	case token.LIBRARY:
+		return p.library()
+	case token.UNIT:
+		return p.unit()
+	}
 	p.match(token.PROGRAM)
 	programName := p.match(token.IDENT).Value
 	p.match(token.SEMICOLON)
+	uses := p.uses()
 
 	var functions []*ast.Function
 	for p.current.Kind == token.FUNCTION || p.current.Kind == token.PROCEDURE {
@@ -25,28 +32,127 @@ func (p *Parser) Parse() *ast.Program {
 	mainFunction := &ast.Function{
 		Signature: mainSignature,
 		Body:      nil,
-		Variables: make(map[string]struct{}),
-		Constants: make(map[string]ast.Literal),
+		Variables: make(map[string]ast.Type),
+		Constants: make(map[string]ast.Expression),
+		Position:  p.current.Position,
 	}
 	p.context = mainFunction
 	mainFunction.Body = p.functionBody(mainSignature)
 	p.context = nil
 	functions = append(functions, mainFunction)
-	return &ast.Program{Name: programName, Functions: functions}
+	return &ast.Program{Name: programName, Functions: functions, Uses: uses}
+}
+
+// uses parses an optional uses clause, which lists the units used by a program or a unit.
+func (p *Parser) uses() []string {
+	if p.current.Kind != token.USES {
+		return nil
+	}
+	p.advance()
+	units := []string{p.match(token.IDENT).Value}
+	for p.current.Kind == token.COMA {
+		p.advance()
+		units = append(units, p.match(token.IDENT).Value)
+	}
+	p.match(token.SEMICOLON)
+	return units
+}
+
+// unit parses a unit. Functions declared in its interface section can be called by programs, which use the unit,
+// they are implemented in the implementation section together with functions private to the unit.
+func (p *Parser) unit() *ast.Program {
+	p.match(token.UNIT)
+	program := &ast.Program{Name: p.match(token.IDENT).Value, Unit: true}
+	p.match(token.SEMICOLON)
+	p.match(token.INTERFACE)
+	program.Uses = p.uses()
+	for p.current.Kind == token.FUNCTION || p.current.Kind == token.PROCEDURE {
+		pos := p.current.Position
+		signature := p.functionSignature()
+		program.Functions = append(program.Functions, &ast.Function{
+			Signature: signature,
+			Variables: make(map[string]ast.Type),
+			Constants: make(map[string]ast.Expression),
+			Position:  pos,
+		})
+		program.Exports = append(program.Exports, signature.Name)
+	}
+	p.match(token.IMPLEMENTATION)
+	for p.current.Kind == token.FUNCTION || p.current.Kind == token.PROCEDURE {
+		program.Functions = append(program.Functions, p.toplevelFunctionDeclaration())
+	}
+	p.match(token.END)
+	p.match(token.DOT)
+	return program
+}
+
+// library parses a library, which consists of functions followed by exports clauses and has no main program.
+func (p *Parser) library() *ast.Program {
+	p.match(token.LIBRARY)
+	program := &ast.Program{Name: p.match(token.IDENT).Value, Library: true}
+	p.match(token.SEMICOLON)
+	program.Uses = p.uses()
+	for p.current.Kind == token.FUNCTION || p.current.Kind == token.PROCEDURE {
+		program.Functions = append(program.Functions, p.toplevelFunctionDeclaration())
+	}
+	for p.current.Kind == token.EXPORTS {
+		p.advance()
+		program.Exports = append(program.Exports, p.match(token.IDENT).Value)
+		for p.current.Kind == token.COMA {
+			p.advance()
+			program.Exports = append(program.Exports, p.match(token.IDENT).Value)
+		}
+		p.match(token.SEMICOLON)
+	}
+	p.match(token.END)
+	p.match(token.DOT)
+	return program
 }
 
 func (p *Parser) toplevelFunctionDeclaration() *ast.Function {
+	pos := p.current.Position
 	signature := p.functionSignature()
 	function := &ast.Function{
 		Signature: signature,
 		Body:      nil,
-		Variables: make(map[string]struct{}),
-		Constants: make(map[string]ast.Literal),
+		Variables: make(map[string]ast.Type),
+		Constants: make(map[string]ast.Expression),
+		Position:  pos,
 	}
-	if p.current.Kind == token.FORWARD {
+	for directive := true; directive; {
+		switch p.current.Kind {
+		case token.INLINE:
+			signature.Inline = true
+		case token.CDECL:
+			signature.Cdecl = true
+		case token.VARARGS:
+			signature.Varargs = true
+		default:
+			directive = false
+			continue
+		}
 		p.advance()
 		p.match(token.SEMICOLON)
-	} else {
+	}
+	if signature.Varargs && !signature.Cdecl {
+		panic(fmt.Sprintf("Varargs function %s must be declared cdecl.", signature.Name))
+	}
+	switch p.current.Kind {
+	case token.FORWARD:
+		p.advance()
+		p.match(token.SEMICOLON)
+	case token.EXTERNAL:
+		// The library is optional, functions of the C library are always linked
+		p.advance()
+		if p.current.Kind == token.STRLIT {
+			signature.Library = p.advance().Value
+		}
+		p.match(token.SEMICOLON)
+		signature.External = true
+		if signature.Inline {
+			panic(fmt.Sprintf("External function %s can not be inline.", signature.Name))
+		}
+	default:
 		p.context = function
 		function.Body = p.functionBody(signature)
 		p.context = nil
@@ -71,9 +177,9 @@ func (p *Parser) functionSignature() *ast.Signature {
 	for p.current.Kind != token.RPAREN && p.current.Kind != token.EOF {
 		parName := p.match(token.IDENT).Value
 		p.match(token.COLON)
-		p.match(token.INTEGER)
 		v := ast.Variable{
 			Name: parName,
+			Type: p.typeSpecification(),
 		}
 		signature.Parameters = append(signature.Parameters, v)
 		if p.current.Kind == token.SEMICOLON {
@@ -83,8 +189,7 @@ func (p *Parser) functionSignature() *ast.Signature {
 	p.match(token.RPAREN)
 	if hasReturnType {
 		p.match(token.COLON)
-		returnType := keywordToType(p.match(token.INTEGER))
-		signature.Return = returnType
+		signature.Return = p.typeSpecification()
 	}
 	p.match(token.SEMICOLON)
 	return signature
@@ -94,7 +199,7 @@ func (p *Parser) functionBody(s *ast.Signature) *ast.Block {
 	var statements []ast.Statement
 	// Add return
 	if s.Return != ast.VOID {
-		p.context.Variables[s.Name] = struct{}{}
+		p.context.Variables[s.Name] = s.Return
 		//statements = append(statements, &ast.VariableDeclaration{
 		//	Name: s.Name,
 		//})
@@ -134,9 +239,8 @@ func (p *Parser) statement() ast.Statement {
 	case token.IDENT:
 		if p.peek.Kind == token.ASSIGN {
 			return p.assignment()
-		} else if p.peek.Kind == token.LPAREN {
-			return p.procedureCall()
 		}
+		return p.procedureCall()
 	case token.IF:
 		return p.ifStatement()
 	case token.VAR:
@@ -150,30 +254,34 @@ func (p *Parser) statement() ast.Statement {
 	case token.FOR:
 		return p.forLoop()
 	case token.BREAK:
-		p.advance()
-		return &ast.Break{}
+		return &ast.Break{Position: p.advance().Position}
 	case token.EXIT:
-		p.advance()
-		return &ast.Exit{}
+		return &ast.Exit{Position: p.advance().Position}
 	default:
 		panic("Invalid statement")
 	}
-	panic("Some branch is not implemented!!!")
 }
 
+// constantDeclarations parses `Name = Expression;` or a typed `Name: Type = Expression;`.
+// Expressions are evaluated right away, so that later declarations and set bounds can use the constants.
 func (p *Parser) constantDeclarations() []ast.Statement {
 	p.match(token.CONST)
 	var declarations []ast.Statement
 	for p.current.Kind != token.VAR && p.current.Kind != token.BEGIN && p.current.Kind != token.EOF {
 		name := p.match(token.IDENT).Value
+		var constantType ast.Type
+		if p.current.Kind == token.COLON {
+			p.advance()
+			constantType = p.typeSpecification()
+		}
 		p.match(token.EQUALS)
-		value := p.number()
-		p.context.Constants[name] = *value
+		value := constant(name, evaluate(p.expr()), constantType)
+		p.context.Constants[name] = value
 		declarations = append(
 			declarations,
 			&ast.ConstantDeclaration{
-				Name:    name,
-				Literal: *value,
+				Name:  name,
+				Value: value,
 			},
 		)
 		p.match(token.SEMICOLON)
@@ -193,32 +301,61 @@ func (p *Parser) variableDeclarations() []ast.Statement {
 			}
 		}
 		p.match(token.COLON)
-		p.match(token.INTEGER)
+		variableType := p.typeSpecification()
 		p.match(token.SEMICOLON)
 		for _, name := range names {
 			declarations = append(
 				declarations,
 				&ast.VariableDeclaration{
 					Name: name,
+					Type: variableType,
 				},
 			)
-			p.context.Variables[name] = struct{}{}
+			p.context.Variables[name] = variableType
 		}
 	}
 	return declarations
 }
+
+// typeSpecification parses a type inside a declaration. It is either a type keyword or a `set of Low..High`.
+func (p *Parser) typeSpecification() ast.Type {
+	if p.current.Kind != token.SET {
+		return keywordToType(p.advance())
+	}
+	p.match(token.SET)
+	p.match(token.OF)
+	low := p.ordinalConstant()
+	p.match(token.DOTDOT)
+	high := p.ordinalConstant()
+	if low < 0 || high > ast.MaxSetElement || low > high {
+		errorMessage := fmt.Sprintf("Invalid set range %d..%d, elements must fit into 0..%d.", low, high, ast.MaxSetElement)
+		panic(errorMessage)
+	}
+	return ast.Set{Low: low, High: high}
+}
+
+// ordinalConstant parses a value, that must be known at compile time. It is an integer constant expression
+// without comparisons, so that it can be followed by `=` in a typed constant declaration.
+func (p *Parser) ordinalConstant() int64 {
+	v, ok := evaluate(p.pmExpr()).(ast.MilaInt)
+	if !ok {
+		panic("Set bounds must be integer constants.")
+	}
+	return int64(v)
+}
 func (p *Parser) assignment() *ast.Assignment {
-	variableName := p.match(token.IDENT).Value
+	variable := p.match(token.IDENT)
 	p.match(token.ASSIGN)
 	value := p.expr()
 	return &ast.Assignment{
-		Variable: ast.Variable{Name: variableName},
+		Variable: ast.Variable{Name: variable.Value},
 		Value:    value,
+		Position: variable.Position,
 	}
 }
 
 func (p *Parser) ifStatement() *ast.If {
-	p.match(token.IF)
+	pos := p.match(token.IF).Position
 	condition := p.expr()
 	p.match(token.THEN)
 	thenBranch := p.statement()
@@ -229,6 +366,7 @@ func (p *Parser) ifStatement() *ast.If {
 		Condition: condition,
 		Then:      thenBranch,
 		Else:      nil,
+		Position:  pos,
 	}
 	if p.current.Kind == token.ELSE {
 		p.advance()
@@ -241,7 +379,7 @@ func (p *Parser) ifStatement() *ast.If {
 }
 
 func (p *Parser) whileLoop() *ast.While {
-	p.match(token.WHILE)
+	pos := p.match(token.WHILE).Position
 	condition := p.expr()
 	p.match(token.DO)
 	stmnt := p.statement()
@@ -251,18 +389,20 @@ func (p *Parser) whileLoop() *ast.While {
 	return &ast.While{
 		Condition: condition,
 		Body:      stmnt,
+		Position:  pos,
 	}
 }
 
 func (p *Parser) forLoop() *ast.For {
-	p.match(token.FOR)
-	variableName := p.match(token.IDENT).Value
+	pos := p.match(token.FOR).Position
+	variable := p.match(token.IDENT)
 	p.match(token.ASSIGN)
 	value := p.expr()
 
 	assignment := &ast.Assignment{
-		Variable: ast.Variable{Name: variableName},
+		Variable: ast.Variable{Name: variable.Value},
 		Value:    value,
+		Position: variable.Position,
 	}
 
 	loopDirection := p.advance()
@@ -278,34 +418,48 @@ func (p *Parser) forLoop() *ast.For {
 	}
 
 	return &ast.For{
-		Initial: assignment,
-		Upto:    loopDirection.Kind == token.TO,
-		Target:  target,
-		Body:    body,
+		Initial:  assignment,
+		Upto:     loopDirection.Kind == token.TO,
+		Target:   target,
+		Body:     body,
+		Position: pos,
 	}
 }
 
 func (p *Parser) procedureCall() *ast.ProcedureCall {
-	procedureName := p.match(token.IDENT).Value
-	p.match(token.LPAREN)
-	if p.current.Kind == token.STRLIT {
-		t := p.advance()
-		p.match(token.RPAREN)
-		return &ast.ProcedureCall{
-			Name: procedureName,
-			Args: []ast.Expression{ast.StringLiteral{Value: t.Value}},
-		}
+	procedure := p.match(token.IDENT)
+	procedureName, pos := procedure.Value, procedure.Position
+	// Procedures may be called without parentheses, when there are no arguments, e.g. writeln;
+	if p.current.Kind != token.LPAREN {
+		return &ast.ProcedureCall{Name: procedureName, Position: pos}
 	}
+	p.match(token.LPAREN)
 	var args []ast.Expression
 	for p.current.Kind != token.RPAREN && p.current.Kind != token.EOF {
-		args = append(args, p.expr())
+		args = append(args, p.argument())
 		if p.current.Kind == token.COMA {
 			p.advance()
 		}
 	}
 	p.match(token.RPAREN)
 	return &ast.ProcedureCall{
-		Name: procedureName,
-		Args: args,
+		Name:     procedureName,
+		Args:     args,
+		Position: pos,
+	}
+}
+
+// argument parses an argument of a procedure call, which may have a field width and a precision, e.g. x:10:2.
+func (p *Parser) argument() ast.Expression {
+	arg := p.expr()
+	if p.current.Kind != token.COLON {
+		return arg
+	}
+	p.advance()
+	format := &ast.Format{Value: arg, Width: p.expr()}
+	if p.current.Kind == token.COLON {
+		p.advance()
+		format.Precision = p.expr()
 	}
+	return format
 }
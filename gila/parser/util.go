@@ -8,8 +8,22 @@ import (
 
 func keywordToType(t token.Token) ast.Type {
 	switch t.Kind {
-	case token.INTEGER:
+	case token.INTEGER, token.LONGINT:
 		return ast.INT
+	case token.BYTE:
+		return ast.BYTE
+	case token.SHORTINT:
+		return ast.SHORTINT
+	case token.WORD:
+		return ast.WORD
+	case token.CARDINAL:
+		return ast.CARDINAL
+	case token.INT64:
+		return ast.INT64
+	case token.REAL:
+		return ast.REAL
+	case token.STRING:
+		return ast.STRING
 		// TODO: Add more types
 	default:
 		panic("Trying to get type from an inappropriate token.")
@@ -44,6 +58,18 @@ func tokenToOperation(t *token.Token) ast.Operation {
 		return ast.AND
 	case token.OR:
 		return ast.OR
+	case token.IN:
+		return ast.IN
+	case token.XOR:
+		return ast.XOR
+	case token.SHL:
+		return ast.SHL
+	case token.SHR:
+		return ast.SHR
+	case token.NOT:
+		return ast.NOT
+	case token.SLASH:
+		return ast.DIVIDE
 	default:
 		panic("Trying to create an opeartion from an invalid Token")
 	}
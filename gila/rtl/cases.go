@@ -0,0 +1,40 @@
+package rtl
+
+import "gitlab.fit.cvut.cz/fedorgle/gila/token"
+
+// ErrorCase is a program, that stops with a runtime error, or runs to the end, when Error is nil.
+// The tests of every code generator and of the interpreter and the vm run ErrorCases,
+// so that they all stop programs at the same errors.
+type ErrorCase struct {
+	Source         string
+	OverflowChecks bool
+	Error          *RuntimeError
+}
+
+// ErrorCases are the programs, on which all code generators have to agree.
+var ErrorCases = []ErrorCase{
+	{"program e; var x: integer; begin x := 0; writeln(1 div x); end.", false, at(ErrorDivisionByZero, 52)},
+	{"program e; var x: integer; begin x := 2147483647; x := x + 1; end.", true, at(ErrorOverflow, 58)},
+	{"program e; var x: int64; begin x := 9223372036854775807; x := x * 2; end.", true, at(ErrorOverflow, 65)},
+	{"program e; var c: cardinal; begin c := 0; c := c - 1; end.", true, at(ErrorOverflow, 50)},
+	{"program e; var c: cardinal; begin c := 0; c := c - 1; writeln(c); end.", false, nil},
+	{"program e; var x: integer; begin x := 2147483647; inc(x); end.", true, at(ErrorOverflow, 51)},
+	{"program e; var c: cardinal; begin c := 0; dec(c); end.", true, at(ErrorOverflow, 43)},
+	// A checked byte wraps around, the same way as b := b + 1, so it is 0, when it is divided by
+	{"program e; var b: byte; x: integer; begin b := 255; inc(b); x := 1 div b; end.", true, at(ErrorDivisionByZero, 68)},
+	{"program e; var x, y: integer; begin x := -2147483647 - 1; y := -1; writeln(x div y); end.", true, at(ErrorOverflow, 78)},
+	{"program e; var x, y: integer; begin x := 0; y := -1; writeln(x div y, ' ', x mod y); end.", true, nil},
+}
+
+// Message is what a compiled program of the case prints to the standard error.
+func (c ErrorCase) Message() string {
+	if c.Error == nil {
+		return ""
+	}
+	return c.Error.Error()
+}
+
+// at is a runtime error on the first line, where all the programs of ErrorCases are.
+func at(code, col int) *RuntimeError {
+	return &RuntimeError{code, token.Position{Line: 1, Col: col}}
+}
@@ -0,0 +1,23 @@
+package rtl
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+)
+
+// Runtime error codes, the same as in Free Pascal.
+const (
+	ErrorDivisionByZero = 200
+	ErrorStackOverflow  = 202
+	ErrorOverflow       = 215
+)
+
+// RuntimeError stops a program, the same way as runtime_error does in fce.c. Code is the exit code of the program.
+type RuntimeError struct {
+	Code     int
+	Position token.Position
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("Runtime error %d at line %d, column %d", e.Code, e.Position.Line, e.Position.Col)
+}
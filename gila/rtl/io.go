@@ -0,0 +1,140 @@
+package rtl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The functions below implement the runtime library in Go for the interpreter and the vm.
+// They produce the same output as their counterparts in fce.c.
+
+// WriteInteger is write_integer. Values are right aligned to width, width 0 means no padding.
+func WriteInteger(w io.Writer, x int64, width int32) {
+	fmt.Fprintf(w, "%*d", width, x)
+}
+
+// WriteReal is write_real. Reals without a precision are printed in scientific notation.
+func WriteReal(w io.Writer, x float64, width, precision int32) {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		fmt.Fprintf(w, "%*s", width, nonFinite(x, precision < 0))
+		return
+	}
+	if precision < 0 {
+		fmt.Fprintf(w, "% *.15E", width, x)
+	} else {
+		fmt.Fprintf(w, "%*.*f", width, precision, x)
+	}
+}
+
+// nonFinite formats infinity and NaN the way printf does in C: in upper case in scientific notation,
+// which also puts a space in place of the plus sign, and in lower case otherwise. NaN keeps its sign.
+func nonFinite(x float64, scientific bool) string {
+	s := "inf"
+	if math.IsNaN(x) {
+		s = "nan"
+	}
+	if scientific {
+		s = strings.ToUpper(s)
+	}
+	if math.Signbit(x) {
+		return "-" + s
+	}
+	if scientific {
+		return " " + s
+	}
+	return s
+}
+
+// WriteBoolean is write_boolean.
+func WriteBoolean(w io.Writer, x bool, width int32) {
+	s := "FALSE"
+	if x {
+		s = "TRUE"
+	}
+	fmt.Fprintf(w, "%*s", width, s)
+}
+
+// WriteString is write_string.
+func WriteString(w io.Writer, x string, width int32) {
+	fmt.Fprintf(w, "%*s", width, x)
+}
+
+// Input reads numbers the same way as scanf does in fce.c.
+type Input struct {
+	reader *bufio.Reader
+}
+
+func NewInput(r io.Reader) *Input {
+	return &Input{bufio.NewReader(r)}
+}
+
+func (in *Input) peek() rune {
+	r, _, err := in.reader.ReadRune()
+	if err != nil {
+		return 0
+	}
+	in.reader.UnreadRune()
+	return r
+}
+
+func (in *Input) accept(valid func(rune) bool, text *strings.Builder) bool {
+	if r := in.peek(); r != 0 && valid(r) {
+		in.reader.ReadRune()
+		text.WriteRune(r)
+		return true
+	}
+	return false
+}
+
+func (in *Input) digits(text *strings.Builder) {
+	for in.accept(unicode.IsDigit, text) {
+	}
+}
+
+func isSign(r rune) bool {
+	return r == '+' || r == '-'
+}
+
+func (in *Input) skipSpace() {
+	for r := in.peek(); r != 0 && unicode.IsSpace(r); r = in.peek() {
+		in.reader.ReadRune()
+	}
+}
+
+// Integer is read_integer. It reads a signed decimal integer, skipping whitespace before it.
+// ok is false, when there is no integer to read.
+func (in *Input) Integer() (x int64, ok bool) {
+	var text strings.Builder
+	in.skipSpace()
+	in.accept(isSign, &text)
+	in.digits(&text)
+	x, err := strconv.ParseInt(text.String(), 10, 64)
+	return x, err == nil
+}
+
+// Real is read_real. It reads a number with an optional fraction and exponent, skipping whitespace before it.
+func (in *Input) Real() (x float64, ok bool) {
+	var text strings.Builder
+	in.skipSpace()
+	in.accept(isSign, &text)
+	in.digits(&text)
+	if in.accept(func(r rune) bool { return r == '.' }, &text) {
+		in.digits(&text)
+	}
+	if in.accept(func(r rune) bool { return r == 'e' || r == 'E' }, &text) {
+		in.accept(isSign, &text)
+		in.digits(&text)
+	}
+	x, err := strconv.ParseFloat(text.String(), 64)
+	return x, err == nil
+}
+
+// SkipLine is read_newline. It skips the rest of the current line.
+func (in *Input) SkipLine() {
+	in.reader.ReadString('\n')
+}
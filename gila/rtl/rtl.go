@@ -0,0 +1,16 @@
+// Package rtl holds the runtime library, which is linked into every compiled program.
+package rtl
+
+import _ "embed"
+
+// Source is the C source of the runtime library. It provides the functions, that generated code calls,
+// e.g. write_integer or set_union.
+//
+//go:embed src/fce.c
+var Source string
+
+// Host implements the imports of WebAssembly modules generated by the wasm package in JavaScript.
+// It runs modules in node or in a browser.
+//
+//go:embed src/host.js
+var Host string
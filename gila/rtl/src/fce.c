@@ -0,0 +1,102 @@
+#include <stdio.h>
+#include <stdlib.h>
+
+/* Every argument of write, writeln, read and readln is passed to the function for its type.
+   Values are right aligned to width, width 0 means no padding. */
+int write_integer(long long x, int width) {
+    printf("%*lld", width, x);
+    return 0;
+}
+int write_real(double x, int width, int precision) {
+    if (precision < 0) {
+        printf("% *.15E", width, x);
+    } else {
+        printf("%*.*f", width, precision, x);
+    }
+    return 0;
+}
+int write_boolean(int x, int width) {
+    printf("%*s", width, x ? "TRUE" : "FALSE");
+    return 0;
+}
+int write_string(char *x, int width) {
+    printf("%*s", width, x);
+    return 0;
+}
+int write_newline() {
+    printf("\n");
+    return 0;
+}
+int read_integer(long long *x) {
+    scanf("%lld", x);
+    return 0;
+}
+int read_real(double *x) {
+    scanf("%lf", x);
+    return 0;
+}
+/* Skips the rest of the current input line. */
+int read_newline() {
+    int c;
+    while ((c = getchar()) != EOF && c != '\n') {
+    }
+    return 0;
+}
+
+/* Sets with more than 64 elements are arrays of SET_WORDS 32-bit words. */
+#define SET_WORDS 8
+
+int set_include(unsigned int *s, int low, int high) {
+    for (int i = low; i <= high; i++) {
+        if (i >= 0 && i < SET_WORDS * 32) {
+            s[i / 32] |= 1u << (i % 32);
+        }
+    }
+    return 0;
+}
+int set_in(int x, unsigned int *s) {
+    if (x < 0 || x >= SET_WORDS * 32) {
+        return 0;
+    }
+    return (s[x / 32] >> (x % 32)) & 1;
+}
+int set_union(unsigned int *r, unsigned int *a, unsigned int *b) {
+    for (int i = 0; i < SET_WORDS; i++) {
+        r[i] = a[i] | b[i];
+    }
+    return 0;
+}
+int set_intersection(unsigned int *r, unsigned int *a, unsigned int *b) {
+    for (int i = 0; i < SET_WORDS; i++) {
+        r[i] = a[i] & b[i];
+    }
+    return 0;
+}
+int set_difference(unsigned int *r, unsigned int *a, unsigned int *b) {
+    for (int i = 0; i < SET_WORDS; i++) {
+        r[i] = a[i] & ~b[i];
+    }
+    return 0;
+}
+int set_equal(unsigned int *a, unsigned int *b) {
+    for (int i = 0; i < SET_WORDS; i++) {
+        if (a[i] != b[i]) {
+            return 0;
+        }
+    }
+    return 1;
+}
+int set_subset(unsigned int *a, unsigned int *b) {
+    for (int i = 0; i < SET_WORDS; i++) {
+        if (a[i] & ~b[i]) {
+            return 0;
+        }
+    }
+    return 1;
+}
+
+int runtime_error(int code, int line, int col) {
+    fflush(stdout);
+    fprintf(stderr, "Runtime error %d at line %d, column %d\n", code, line, col);
+    exit(code);
+}
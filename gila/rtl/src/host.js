@@ -0,0 +1,137 @@
+// host.js implements the imports of modules built by gila emit --target=wasm. It produces the same output as fce.c.
+// In a browser, call gila.run(bytes, input) with the bytes of a compiled .wat file and the standard input as a string.
+// In node, run: node host.js program.wasm < input
+"use strict";
+
+class RuntimeError extends Error {
+    constructor(code, line, col) {
+        super(`Runtime error ${code} at line ${line}, column ${col}`);
+        this.code = code;
+    }
+}
+
+// pad right aligns s to width, negative widths align it to the left, the same way as printf does.
+function pad(s, width) {
+    return width >= 0 ? s.padStart(width) : s.padEnd(-width);
+}
+
+// fixed formats x with precision digits after the decimal point, as %.*f does.
+function fixed(x, precision) {
+    if (!isFinite(x)) {
+        return isNaN(x) ? "nan" : x < 0 ? "-inf" : "inf";
+    }
+    const sign = x < 0 || Object.is(x, -0) ? "-" : "";
+    x = Math.abs(x);
+    if (x >= 1e21) {
+        return sign + BigInt(x).toString() + (precision > 0 ? "." + "0".repeat(precision) : "");
+    }
+    return sign + x.toFixed(Math.min(precision, 100));
+}
+
+// scientific formats x as % .15E does.
+function scientific(x) {
+    if (!isFinite(x)) {
+        return isNaN(x) ? " NAN" : x < 0 ? "-INF" : " INF";
+    }
+    const sign = x < 0 || Object.is(x, -0) ? "-" : " ";
+    const [mantissa, exponent] = Math.abs(x).toExponential(15).split("e");
+    const digits = exponent.replace(/^[+-]/, "").padStart(2, "0");
+    return sign + mantissa + "E" + (exponent[0] === "-" ? "-" : "+") + digits;
+}
+
+// imports returns the functions imported by a module. input is a string, output is called with every written string.
+// The exported memory of the module has to be assigned to the memory property, before the program starts.
+function imports(input, output) {
+    let position = 0;
+    const host = {memory: null};
+
+    function skipSpace() {
+        while (position < input.length && /\s/.test(input[position])) {
+            position++;
+        }
+    }
+
+    // scan consumes characters matching a pattern at the current position, the same way as scanf does.
+    function scan(pattern) {
+        pattern.lastIndex = position;
+        const m = pattern.exec(input);
+        position += m[0].length;
+        return m[0];
+    }
+
+    host.gila = {
+        write_integer: (x, width) => output(pad(x.toString(), width)),
+        write_real: (x, width, precision) => output(pad(precision < 0 ? scientific(x) : fixed(x, precision), width)),
+        write_boolean: (x, width) => output(pad(x ? "TRUE" : "FALSE", width)),
+        write_string: (pointer, length, width) => {
+            const bytes = new Uint8Array(host.memory.buffer, pointer, length);
+            output(pad(String.fromCharCode(...bytes), width));
+        },
+        write_newline: () => output("\n"),
+        // Reading functions return the current value of the variable, when there is no number to read
+        read_integer: x => {
+            skipSpace();
+            const text = scan(/[+-]?\d*/y);
+            if (!/\d/.test(text)) {
+                return x;
+            }
+            const value = BigInt(text);
+            return BigInt.asIntN(64, value) === value ? value : x;
+        },
+        read_real: x => {
+            skipSpace();
+            const text = scan(/[+-]?\d*(\.\d*)?([eE][+-]?\d*)?/y);
+            if (!/^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$/.test(text) || !isFinite(Number(text))) {
+                return x;
+            }
+            return Number(text);
+        },
+        read_newline: () => {
+            const end = input.indexOf("\n", position);
+            position = end < 0 ? input.length : end + 1;
+        },
+        runtime_error: (code, line, col) => {
+            throw new RuntimeError(code, line, col);
+        },
+    };
+    return host;
+}
+
+// run executes the main program of a module and returns its exit code. Runtime errors are reported to error.
+async function run(bytes, input, output, error) {
+    const host = imports(input, output);
+    const {instance} = await WebAssembly.instantiate(bytes, {gila: host.gila});
+    host.memory = instance.exports.memory;
+    try {
+        instance.exports.main();
+    } catch (e) {
+        if (!(e instanceof RuntimeError)) {
+            throw e;
+        }
+        error(e.message + "\n");
+        return e.code;
+    }
+    return 0;
+}
+
+if (typeof module !== "undefined" && require.main === module) {
+    const fs = require("fs");
+    const chunks = [];
+    const output = s => chunks.push(s);
+    const flush = () => {
+        fs.writeSync(1, Buffer.from(chunks.join(""), "latin1"));
+        chunks.length = 0;
+    };
+    const bytes = fs.readFileSync(process.argv[2]);
+    run(bytes, fs.readFileSync(0, "latin1"), output, message => {
+        flush();
+        fs.writeSync(2, message);
+    }).then(code => {
+        flush();
+        process.exitCode = code;
+    });
+} else if (typeof module !== "undefined") {
+    module.exports = {imports, run, RuntimeError};
+} else {
+    globalThis.gila = {imports, run, RuntimeError};
+}
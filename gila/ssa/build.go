@@ -0,0 +1,497 @@
+package ssa
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+)
+
+// Options change the way programs are built.
+type Options struct {
+	// OverflowChecks mark integer arithmetic as checked, the same way as ir.Options do.
+	OverflowChecks bool
+}
+
+type scope struct {
+	parent  *scope
+	symbols map[string]Value
+}
+
+func (s *scope) lookup(name string) Value {
+	if v, ok := s.symbols[name]; ok {
+		return v
+	} else if s.parent != nil {
+		return s.parent.lookup(name)
+	}
+	return nil
+}
+
+type builder struct {
+	info      *checker.Info
+	options   Options
+	functions map[string]*Function
+
+	function *Function
+	block    *Block
+	scope    *scope
+	// allocs is the number of slots at the start of the entry block
+	allocs int
+	// breaks are the blocks after the enclosing loops
+	breaks []*Block
+	result Value
+	// position of the statement being built
+	position token.Position
+}
+
+// Build translates a type checked program into ssa. Forward declarations are resolved up front,
+// so calls always refer to the function, that implements them.
+func Build(program *ast.Program, info *checker.Info, options Options) *Program {
+	b := &builder{info: info, options: options, functions: make(map[string]*Function)}
+	p := &Program{Name: program.Name, Library: program.Library, Unit: program.Unit, Exports: program.Exports}
+	bodies := make(map[string]*ast.Function)
+	for _, f := range program.Functions {
+		name := f.Signature.Name
+		function, ok := b.functions[name]
+		if !ok {
+			function = &Function{}
+			b.functions[name] = function
+			p.Functions = append(p.Functions, function)
+		}
+		if !ok || f.Body != nil {
+			// Parameters are named after the implementation, whose body refers to them
+			function.Signature, function.Position, function.Parameters = f.Signature, f.Position, nil
+			for _, param := range f.Signature.Parameters {
+				function.Parameters = append(function.Parameters, &Parameter{Name: param.Name, T: param.Type})
+			}
+		}
+		function.Inline = function.Inline || f.Signature.Inline
+		if f.Body != nil {
+			bodies[name] = f
+		}
+	}
+	for _, f := range p.Functions {
+		if body, ok := bodies[f.Name()]; ok {
+			b.build(f, body)
+		}
+	}
+	return p
+}
+
+func (b *builder) build(f *Function, body *ast.Function) {
+	b.function, b.allocs, b.breaks, b.result = f, 0, nil, nil
+	b.block = f.NewBlock()
+	b.scope = &scope{symbols: make(map[string]Value)}
+	b.position = body.Position
+	if f.Signature.Return != ast.VOID {
+		// The result is assigned to a variable named after the function
+		b.result = b.declare(f.Name(), f.Signature.Return)
+	}
+	for _, p := range f.Parameters {
+		b.emit(Store, ast.VOID, b.alloc(p.Name, p.T), p)
+	}
+	b.statement(body.Body)
+	b.ret()
+	b.function, b.block, b.scope = nil, nil, nil
+}
+
+// emit appends an instruction to the current block.
+func (b *builder) emit(op Op, t ast.Type, args ...Value) *Instruction {
+	return b.block.Append(b.instruction(op, t, args...))
+}
+
+// instruction creates an instruction at the position of the current statement.
+func (b *builder) instruction(op Op, t ast.Type, args ...Value) *Instruction {
+	i := b.function.NewInstruction(op, t, args...)
+	i.Position = b.position
+	return i
+}
+
+// fold appends an instruction, unless all its operands are constants and it can be computed at compile time.
+func (b *builder) fold(i *Instruction) Value {
+	if c := Fold(i); c != nil {
+		return c
+	}
+	return b.block.Append(i)
+}
+
+// alloc creates a slot for a variable. Slots are kept at the start of the entry block, so loops don't grow the stack.
+func (b *builder) alloc(name string, t ast.Type) *Instruction {
+	i := b.function.NewInstruction(Alloc, t)
+	i.Name, i.Position = name, b.position
+	b.function.Entry().Insert(b.allocs, i)
+	b.allocs++
+	b.scope.symbols[name] = i
+	return i
+}
+
+// declare creates a variable, which is zero until it is assigned.
+func (b *builder) declare(name string, t ast.Type) *Instruction {
+	slot := b.alloc(name, t)
+	b.emit(Store, ast.VOID, slot, Zero(t))
+	return slot
+}
+
+func (b *builder) jump(to *Block) {
+	b.emit(Jump, ast.VOID)
+	AddEdge(b.block, to)
+}
+
+func (b *builder) branch(condition Value, then, otherwise *Block) {
+	b.emit(Branch, ast.VOID, condition)
+	AddEdge(b.block, then)
+	AddEdge(b.block, otherwise)
+}
+
+// ret returns from the function with its result.
+func (b *builder) ret() {
+	if b.result != nil {
+		b.emit(Return, ast.VOID, b.emit(Load, b.result.Type(), b.result))
+	} else {
+		b.emit(Return, ast.VOID)
+	}
+}
+
+// unreachable continues in a new block without predecessors, after control has been transferred elsewhere.
+// Statements, that follow break or exit, are built there and never executed.
+func (b *builder) unreachable() {
+	b.block = b.function.NewBlock()
+}
+
+func (b *builder) lookup(name string) Value {
+	if v := b.scope.lookup(name); v != nil {
+		return v
+	}
+	panic(fmt.Sprintf("Undefined symbol %s.", name))
+}
+
+func (b *builder) statement(node ast.Statement) {
+	switch n := node.(type) {
+	case *ast.Block:
+		b.scope = &scope{parent: b.scope, symbols: make(map[string]Value)}
+		for _, s := range n.Statements {
+			b.statement(s)
+		}
+		b.scope = b.scope.parent
+	case *ast.VariableDeclaration:
+		b.declare(n.Name, n.Type)
+	case *ast.ConstantDeclaration:
+		b.scope.symbols[n.Name] = b.expression(n.Value)
+	case *ast.Assignment:
+		b.at(n.Position)
+		b.assign(n)
+	case *ast.ProcedureCall:
+		b.at(n.Position)
+		b.procedureCall(n)
+	case *ast.If:
+		b.at(n.Position)
+		b.ifStatement(n)
+	case *ast.While:
+		b.at(n.Position)
+		b.loop(func() Value { return b.expression(n.Condition) }, n.Body, nil)
+	case *ast.For:
+		b.at(n.Position)
+		b.assign(n.Initial)
+		variable := &n.Initial.Variable
+		// The loop ends, when the variable reaches the target, which is evaluated before every iteration
+		condition := &ast.Binary{Left: variable, Right: n.Target, Operation: ast.NOTEQUALS}
+		step := int64(-1)
+		if n.Upto {
+			step = 1
+		}
+		b.loop(func() Value { return b.expression(condition) }, n.Body, func() {
+			b.position = n.Position
+			b.step(variable, step)
+		})
+	case *ast.Break:
+		// Break outside of a loop is noop
+		if len(b.breaks) > 0 {
+			b.at(n.Position)
+			b.jump(b.breaks[len(b.breaks)-1])
+			b.unreachable()
+		}
+	case *ast.Exit:
+		b.at(n.Position)
+		b.ret()
+		b.unreachable()
+	default:
+		panic("Unknown statement type!")
+	}
+}
+
+// at starts building a statement at the given position.
+func (b *builder) at(pos token.Position) {
+	b.position = pos
+	if b.block.Position == (token.Position{}) {
+		b.block.Position = pos
+	}
+}
+
+func (b *builder) assign(a *ast.Assignment) {
+	slot := b.lookup(a.Variable.Name)
+	b.emit(Store, ast.VOID, slot, b.conversion(a.Value, b.info.TypeOf(&a.Variable)))
+}
+
+func (b *builder) ifStatement(n *ast.If) {
+	condition := b.expression(n.Condition)
+	then := b.function.NewBlock()
+	otherwise := (*Block)(nil)
+	if n.Else != nil {
+		otherwise = b.function.NewBlock()
+	}
+	cont := b.function.NewBlock()
+	if otherwise == nil {
+		b.branch(condition, then, cont)
+	} else {
+		b.branch(condition, then, otherwise)
+	}
+	b.block = then
+	b.statement(n.Then)
+	b.jump(cont)
+	if otherwise != nil {
+		b.block = otherwise
+		b.statement(n.Else)
+		b.jump(cont)
+	}
+	b.block = cont
+}
+
+// loop builds a loop, whose condition is evaluated in a header block before every iteration.
+// update is built after the body, unless it is nil.
+func (b *builder) loop(condition func() Value, body ast.Statement, update func()) {
+	header, bodyBlock, exit := b.function.NewBlock(), b.function.NewBlock(), b.function.NewBlock()
+	b.jump(header)
+	b.block = header
+	b.branch(condition(), bodyBlock, exit)
+	b.block = bodyBlock
+	b.breaks = append(b.breaks, exit)
+	b.statement(body)
+	b.breaks = b.breaks[:len(b.breaks)-1]
+	if update != nil {
+		update()
+	}
+	b.jump(header)
+	b.block = exit
+}
+
+// step adds a constant to an integer variable. The result wraps around at the width of the variable.
+// It is never checked for overflow: the step of a for loop only moves the variable towards the target.
+func (b *builder) step(variable *ast.Variable, delta int64) {
+	slot := b.lookup(variable.Name)
+	t := slot.Type()
+	b.emit(Store, ast.VOID, slot, b.emit(Add, t, b.emit(Load, t, slot), IntConst(t, delta)))
+}
+
+// checkedStep builds inc and dec with overflow checks, in checker.StepType of the variable.
+func (b *builder) checkedStep(pc *ast.ProcedureCall, op Op) {
+	slot := b.lookup(pc.Args[0].(*ast.Variable).Name)
+	vt := slot.Type()
+	t := checker.StepType(vt)
+	i := b.instruction(op, t, b.convert(b.emit(Load, vt, slot), t), IntConst(t, 1))
+	i.Position, i.Checked = pc.Position, true
+	b.emit(Store, ast.VOID, slot, b.convert(b.fold(i), vt))
+}
+
+func (b *builder) procedureCall(pc *ast.ProcedureCall) {
+	if checker.Intrinsics[pc.Name] {
+		b.intrinsic(pc)
+		return
+	}
+	if _, ok := b.functions[pc.Name]; !ok && (pc.Name == "inc" || pc.Name == "dec") {
+		delta, op := int64(1), Add
+		if pc.Name == "dec" {
+			delta, op = -1, Sub
+		}
+		if b.options.OverflowChecks {
+			b.checkedStep(pc, op)
+			return
+		}
+		b.step(pc.Args[0].(*ast.Variable), delta)
+		return
+	}
+	b.call(pc.Name, pc.Args)
+}
+
+// intrinsic builds write, writeln, read and readln. Every argument becomes a separate instruction.
+func (b *builder) intrinsic(pc *ast.ProcedureCall) {
+	switch pc.Name {
+	case "write", "writeln":
+		for _, a := range pc.Args {
+			b.write(a)
+		}
+		if pc.Name == "writeln" {
+			b.emit(WriteNewline, ast.VOID)
+		}
+	case "read", "readln":
+		for _, a := range pc.Args {
+			slot := b.lookup(a.(*ast.Variable).Name)
+			t := slot.Type()
+			b.emit(Store, ast.VOID, slot, b.emit(Read, t, b.emit(Load, t, slot)))
+		}
+		if pc.Name == "readln" {
+			b.emit(ReadNewline, ast.VOID)
+		}
+	}
+}
+
+// write prints a single value. Integers are printed as int64, field widths and precisions are integers.
+func (b *builder) write(a ast.Expression) {
+	format, formatted := a.(*ast.Format)
+	if formatted {
+		a = format.Value
+	}
+	t := b.info.TypeOf(a)
+	var v Value
+	if ast.IsInteger(t) {
+		v = b.conversion(a, ast.INT64)
+	} else {
+		v = b.expression(a)
+	}
+	var width, precision Value = IntConst(ast.INT, 0), IntConst(ast.INT, -1)
+	if formatted {
+		width = b.conversion(format.Width, ast.INT)
+		if format.Precision != nil {
+			precision = b.conversion(format.Precision, ast.INT)
+		}
+	}
+	if t == ast.REAL {
+		b.emit(Write, ast.VOID, v, width, precision)
+	} else {
+		b.emit(Write, ast.VOID, v, width)
+	}
+}
+
+// call builds a call, converting arguments to the types of parameters.
+func (b *builder) call(name string, arguments []ast.Expression) *Instruction {
+	callee, ok := b.functions[name]
+	if !ok {
+		panic(fmt.Sprintf("Call to an undefined function %s.", name))
+	}
+	var args []Value
+	for i, a := range arguments {
+		if i >= len(callee.Parameters) {
+			// Variable arguments of varargs functions
+			args = append(args, b.conversion(a, checker.VariadicType(b.info.TypeOf(a))))
+			continue
+		}
+		args = append(args, b.conversion(a, callee.Parameters[i].T))
+	}
+	call := b.emit(Call, callee.Signature.Return, args...)
+	call.Callee = callee
+	return call
+}
+
+func (b *builder) expression(expression ast.Expression) Value {
+	switch e := expression.(type) {
+	case *ast.Literal:
+		return IntConst(b.info.TypeOf(e), e.Value)
+	case *ast.RealLiteral:
+		return RealConst(e.Value)
+	case ast.StringLiteral:
+		return StringConst(e.Value)
+	case *ast.Variable:
+		v := b.lookup(e.Name)
+		if slot, ok := v.(*Instruction); ok {
+			return b.emit(Load, slot.T, slot)
+		}
+		return v
+	case *ast.Binary:
+		return b.binary(e)
+	case *ast.Unary:
+		return b.unary(e)
+	case *ast.FunctionCall:
+		return b.call(e.Name, e.Args)
+	case *ast.SetConstructor:
+		return b.setConstructor(e)
+	default:
+		panic("Not all expressions are implemented yet!")
+	}
+}
+
+// conversion evaluates an expression, converting the result to the given type.
+func (b *builder) conversion(e ast.Expression, to ast.Type) Value {
+	return b.convert(b.expression(e), to)
+}
+
+func (b *builder) convert(v Value, to ast.Type) Value {
+	if v.Type() == to {
+		return v
+	}
+	if _, ok := to.(ast.Set); ok || ast.IsInteger(to) || to == ast.REAL {
+		return b.fold(b.instruction(Convert, to, v))
+	}
+	return v
+}
+
+var binaryOps = map[ast.Operation]Op{
+	ast.PLUS: Add, ast.MINUS: Sub, ast.MULTIPLY: Mul, ast.DIV: Div, ast.MOD: Mod, ast.DIVIDE: Quo,
+	ast.AND: And, ast.OR: Or, ast.XOR: Xor, ast.SHL: Shl, ast.SHR: Shr,
+	ast.EQUALS: Eq, ast.NOTEQUALS: Ne, ast.LESS: Lt, ast.LESSEQ: Le, ast.GREATER: Gt, ast.GREATEREQ: Ge,
+}
+
+func (b *builder) binary(e *ast.Binary) Value {
+	if e.Operation == ast.IN {
+		element := b.conversion(e.Left, ast.INT)
+		return b.fold(b.instruction(In, ast.BOOLEAN, element, b.expression(e.Right)))
+	}
+	var t ast.Type
+	switch left := b.info.TypeOf(e.Left).(type) {
+	case ast.Set:
+		// Both operands are converted to the representation of the result
+		t = checker.UnionType(left, b.info.TypeOf(e.Right).(ast.Set))
+	default:
+		switch {
+		case e.Operation == ast.SHL || e.Operation == ast.SHR:
+			t = b.info.TypeOf(e)
+		case e.Operation == ast.DIVIDE:
+			t = ast.REAL
+		case left == ast.BOOLEAN:
+			t = left
+		default:
+			t = checker.CommonType(left, b.info.TypeOf(e.Right))
+		}
+	}
+	left, right := b.conversion(e.Left, t), b.conversion(e.Right, t)
+	op := binaryOps[e.Operation]
+	result := t
+	if op.IsComparison() {
+		result = ast.BOOLEAN
+	}
+	i := b.instruction(op, result, left, right)
+	i.Position = e.Position
+	switch op {
+	case Add, Sub, Mul, Div, Mod:
+		i.Checked = b.options.OverflowChecks && ast.IsInteger(t)
+	}
+	return b.fold(i)
+}
+
+func (b *builder) unary(u *ast.Unary) Value {
+	t := b.info.TypeOf(u)
+	switch u.Operation {
+	case ast.PLUS:
+		return b.conversion(u.Operand, t)
+	case ast.MINUS:
+		i := b.instruction(Neg, t, b.conversion(u.Operand, t))
+		i.Position, i.Checked = u.Position, b.options.OverflowChecks && ast.IsInteger(t)
+		return b.fold(i)
+	case ast.NOT:
+		return b.fold(b.instruction(Not, t, b.conversion(u.Operand, t)))
+	default:
+		panic("Invalid operation type inside Unary node.")
+	}
+}
+
+// setConstructor builds a set out of ranges. Constructors, whose elements are known at compile time, are folded.
+func (b *builder) setConstructor(s *ast.SetConstructor) Value {
+	var bounds []Value
+	for _, e := range s.Elements {
+		low := b.conversion(e.Low, ast.INT)
+		high := low
+		if e.High != nil {
+			high = b.conversion(e.High, ast.INT)
+		}
+		bounds = append(bounds, low, high)
+	}
+	return b.fold(b.instruction(SetMake, b.info.TypeOf(s), bounds...))
+}
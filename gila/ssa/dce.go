@@ -0,0 +1,196 @@
+package ssa
+
+import (
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+)
+
+func init() {
+	Register(NewPass("dce", DeadCodeElimination))
+}
+
+// DeadCodeElimination removes blocks, which can't be reached, and instructions, whose results are not used
+// and which have no side effects. Branches on constants become jumps, empty blocks are bypassed and blocks
+// are merged with their successor, when they are its only predecessor.
+//
+// Statements, which can't be reached, because they follow break or exit, are reported as warnings.
+// Code, which is skipped because of a constant condition, is removed silently.
+func DeadCodeElimination(f *Function) {
+	warnUnreachable(f)
+	for _, b := range f.Blocks {
+		if t := b.Terminator(); t.Op == Branch {
+			if c, ok := t.Args[0].(*Const); ok {
+				skipped := b.Succs[1]
+				if !c.Value.(ast.MilaBoolean) {
+					skipped = b.Succs[0]
+				}
+				replaceBranch(b, skipped)
+			}
+		}
+	}
+	removeUnreachable(f)
+	removeDeadInstructions(f)
+	bypassEmptyBlocks(f)
+	removeUnreachable(f)
+	mergeBlocks(f)
+}
+
+// warnUnreachable reports the first statement of every unreachable part of the function. Statements,
+// which are only reachable from code, that was already reported, are not reported again.
+func warnUnreachable(f *Function) {
+	reachable := make(map[*Block]bool)
+	for _, b := range ReversePostorder(f) {
+		reachable[b] = true
+	}
+	reported := make(map[*Block]bool)
+	for _, b := range f.Blocks {
+		if reachable[b] {
+			continue
+		}
+		for _, p := range b.Preds {
+			reported[b] = reported[b] || reported[p]
+		}
+		if !reported[b] && b.Position != (token.Position{}) {
+			f.Warnings = append(f.Warnings, Warning{Position: b.Position, Message: "Unreachable statement"})
+			reported[b] = true
+		}
+	}
+}
+
+// replaceBranch turns the branch at the end of b into a jump, which doesn't go to skipped.
+func replaceBranch(b *Block, skipped *Block) {
+	branch := b.Terminator()
+	b.Remove(branch)
+	jump := b.Function.NewInstruction(Jump, ast.VOID)
+	jump.Position = branch.Position
+	b.Append(jump)
+	RemoveEdge(b, skipped)
+}
+
+// removeUnreachable removes blocks, which can't be reached from the entry, together with their edges.
+func removeUnreachable(f *Function) {
+	reachable := make(map[*Block]bool)
+	for _, b := range ReversePostorder(f) {
+		reachable[b] = true
+	}
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if reachable[b] {
+			blocks = append(blocks, b)
+			continue
+		}
+		for len(b.Succs) > 0 {
+			RemoveEdge(b, b.Succs[0])
+		}
+	}
+	f.Blocks = blocks
+}
+
+// removeDeadInstructions keeps instructions with side effects and the instructions, whose results they use.
+func removeDeadInstructions(f *Function) {
+	live := make(map[*Instruction]bool)
+	var work []*Instruction
+	f.Instructions(func(i *Instruction) {
+		if i.HasSideEffects() {
+			live[i] = true
+			work = append(work, i)
+		}
+	})
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, a := range i.Args {
+			if operand, ok := a.(*Instruction); ok && !live[operand] {
+				live[operand] = true
+				work = append(work, operand)
+			}
+		}
+	}
+	for _, b := range f.Blocks {
+		for _, i := range append([]*Instruction(nil), b.Instructions...) {
+			if !live[i] {
+				b.Remove(i)
+			}
+		}
+	}
+}
+
+// bypassEmptyBlocks makes predecessors of blocks, which only jump elsewhere, jump to the target directly.
+// Targets with phis are kept, since their values depend on the predecessor.
+func bypassEmptyBlocks(f *Function) {
+	for _, b := range f.Blocks[1:] {
+		if len(b.Instructions) != 1 || b.Instructions[0].Op != Jump {
+			continue
+		}
+		target := b.Succs[0]
+		if target == b || len(target.Phis()) > 0 {
+			continue
+		}
+		for len(b.Preds) > 0 {
+			redirect(b.Preds[0], b, target)
+		}
+	}
+}
+
+// redirect moves the edge from p to from, so that it goes to to. A branch, which goes to the same block
+// either way, becomes a jump.
+func redirect(p, from, to *Block) {
+	for i, s := range p.Succs {
+		if s == from {
+			p.Succs[i] = to
+			break
+		}
+	}
+	index := from.PredIndex(p)
+	from.Preds = append(from.Preds[:index], from.Preds[index+1:]...)
+	to.Preds = append(to.Preds, p)
+	if len(p.Succs) == 2 && p.Succs[0] == p.Succs[1] {
+		replaceBranch(p, to)
+	}
+}
+
+// mergeBlocks appends blocks to their only predecessor, when it jumps to them.
+func mergeBlocks(f *Function) {
+	merged := make(map[*Block]bool)
+	for _, b := range f.Blocks {
+		if merged[b] {
+			continue
+		}
+		for {
+			t := b.Terminator()
+			if t.Op != Jump {
+				break
+			}
+			s := b.Succs[0]
+			if s == b || s == f.Entry() || len(s.Preds) != 1 {
+				break
+			}
+			// Phis with a single predecessor have a single value
+			for _, phi := range append([]*Instruction(nil), s.Phis()...) {
+				f.ReplaceUses(phi, phi.Args[0])
+				s.Remove(phi)
+			}
+			b.Remove(t)
+			for _, i := range s.Instructions {
+				b.Append(i)
+			}
+			b.Succs = s.Succs
+			for _, succ := range s.Succs {
+				for j, p := range succ.Preds {
+					if p == s {
+						succ.Preds[j] = b
+					}
+				}
+			}
+			s.Instructions, s.Preds, s.Succs = nil, nil, nil
+			merged[s] = true
+		}
+	}
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if !merged[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	f.Blocks = blocks
+}
@@ -0,0 +1,133 @@
+package ssa
+
+// ReversePostorder returns the blocks, that are reachable from the entry, in reverse postorder.
+// Every block comes after its dominators, so values are defined before they are used.
+func ReversePostorder(f *Function) []*Block {
+	visited := make(map[*Block]bool)
+	var order []*Block
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		visited[b] = true
+		for _, s := range b.Succs {
+			if !visited[s] {
+				visit(s)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(f.Entry())
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// DomTree is the dominator tree of the reachable blocks of a function. A block dominates another block,
+// when every path from the entry to the other block goes through it.
+type DomTree struct {
+	// Blocks are the reachable blocks in reverse postorder
+	Blocks   []*Block
+	order    map[*Block]int
+	idom     map[*Block]*Block
+	children map[*Block][]*Block
+}
+
+// Dominators computes the dominator tree with the iterative algorithm by Cooper, Harvey and Kennedy.
+func Dominators(f *Function) *DomTree {
+	d := &DomTree{
+		Blocks:   ReversePostorder(f),
+		order:    make(map[*Block]int),
+		idom:     make(map[*Block]*Block),
+		children: make(map[*Block][]*Block),
+	}
+	for i, b := range d.Blocks {
+		d.order[b] = i
+	}
+	entry := d.Blocks[0]
+	d.idom[entry] = entry
+	for changed := true; changed; {
+		changed = false
+		for _, b := range d.Blocks[1:] {
+			var idom *Block
+			for _, p := range b.Preds {
+				if _, ok := d.idom[p]; !ok {
+					continue
+				}
+				if idom == nil {
+					idom = p
+				} else {
+					idom = d.intersect(p, idom)
+				}
+			}
+			if d.idom[b] != idom {
+				d.idom[b] = idom
+				changed = true
+			}
+		}
+	}
+	for _, b := range d.Blocks[1:] {
+		d.children[d.idom[b]] = append(d.children[d.idom[b]], b)
+	}
+	return d
+}
+
+func (d *DomTree) intersect(a, b *Block) *Block {
+	for a != b {
+		for d.order[a] > d.order[b] {
+			a = d.idom[a]
+		}
+		for d.order[b] > d.order[a] {
+			b = d.idom[b]
+		}
+	}
+	return a
+}
+
+// Reachable reports whether control can get to the block from the entry.
+func (d *DomTree) Reachable(b *Block) bool {
+	_, ok := d.order[b]
+	return ok
+}
+
+// Idom returns the immediate dominator of a block. The entry and unreachable blocks have none.
+func (d *DomTree) Idom(b *Block) *Block {
+	if b == d.Blocks[0] {
+		return nil
+	}
+	return d.idom[b]
+}
+
+// Children returns the blocks, which are immediately dominated by b.
+func (d *DomTree) Children(b *Block) []*Block {
+	return d.children[b]
+}
+
+// Dominates reports whether a dominates b. Every block dominates itself.
+func (d *DomTree) Dominates(a, b *Block) bool {
+	if !d.Reachable(a) || !d.Reachable(b) {
+		return false
+	}
+	for b != a && b != d.Blocks[0] {
+		b = d.idom[b]
+	}
+	return a == b
+}
+
+// Frontiers returns the dominance frontier of every reachable block: the blocks, where its dominance ends,
+// because they have another predecessor, which it doesn't dominate.
+func (d *DomTree) Frontiers() map[*Block][]*Block {
+	frontiers := make(map[*Block][]*Block)
+	for _, b := range d.Blocks {
+		if len(b.Preds) < 2 {
+			continue
+		}
+		for _, p := range b.Preds {
+			for runner := p; d.Reachable(runner) && runner != d.idom[b]; runner = d.idom[runner] {
+				if !contains(frontiers[runner], b) {
+					frontiers[runner] = append(frontiers[runner], b)
+				}
+			}
+		}
+	}
+	return frontiers
+}
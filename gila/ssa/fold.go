@@ -0,0 +1,272 @@
+package ssa
+
+import (
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"math"
+	"math/big"
+)
+
+func init() {
+	Register(NewPass("constfold", ConstFold))
+}
+
+// ConstFold replaces instructions, whose operands are constants, by their results. Folding an instruction
+// can make its users constant, so it repeats until nothing changes.
+func ConstFold(f *Function) {
+	for changed := true; changed; {
+		changed = false
+		for _, b := range ReversePostorder(f) {
+			for _, i := range append([]*Instruction(nil), b.Instructions...) {
+				if c := Fold(i); c != nil {
+					f.ReplaceUses(i, c)
+					b.Remove(i)
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// Fold computes the result of an instruction at compile time, when all its operands are constants.
+// It returns nil, when the instruction has side effects, or when the result is left to the runtime,
+// because a checked operation overflows or an integer is divided by zero.
+func Fold(i *Instruction) *Const {
+	args := make([]*Const, len(i.Args))
+	for j, a := range i.Args {
+		c, ok := a.(*Const)
+		if !ok {
+			return nil
+		}
+		args[j] = c
+	}
+	switch {
+	case i.Op == Phi:
+		return foldPhi(args)
+	case i.Op == Convert:
+		return convertConst(args[0], i.T)
+	case i.Op == SetMake:
+		var set ast.MilaSet
+		for j := 0; j < len(args); j += 2 {
+			// Elements out of range are ignored, so the bounds are clamped
+			low, high := args[j].Int(), args[j+1].Int()
+			if low < 0 {
+				low = 0
+			}
+			if high > ast.MaxSetElement {
+				high = ast.MaxSetElement
+			}
+			for x := low; x <= high; x++ {
+				set.Include(x)
+			}
+		}
+		return SetConst(i.T.(ast.Set), set)
+	case i.Op == In:
+		return BoolConst(args[1].Value.(ast.MilaSet).Contains(args[0].Int()))
+	case i.Op == Neg || i.Op == Not:
+		return foldUnary(i.Op, args[0], i.T, i.Checked)
+	case i.Op >= Add && i.Op <= Ge:
+		return foldBinary(i.Op, args[0], args[1], i.Checked)
+	}
+	return nil
+}
+
+func foldPhi(args []*Const) *Const {
+	for _, a := range args[1:] {
+		if a.T != args[0].T || a.String() != args[0].String() {
+			return nil
+		}
+	}
+	return args[0]
+}
+
+// convertConst changes the type of a constant the same way as Convert.
+func convertConst(c *Const, to ast.Type) *Const {
+	switch {
+	case c.T == to:
+		return c
+	case ast.IsInteger(to):
+		return IntConst(to, c.Int())
+	case to == ast.REAL:
+		return RealConst(float64(c.Int()))
+	}
+	t := to.(ast.Set)
+	var set ast.MilaSet
+	for x := int64(0); x < capacity(t); x++ {
+		if c.Value.(ast.MilaSet).Contains(x) {
+			set.Include(x)
+		}
+	}
+	return SetConst(t, set)
+}
+
+// capacity is the number of elements, that the representation of a set type can hold.
+func capacity(s ast.Set) int64 {
+	switch {
+	case s.High < 32:
+		return 32
+	case s.High < 64:
+		return 64
+	default:
+		return ast.MaxSetElement + 1
+	}
+}
+
+func foldUnary(op Op, c *Const, t ast.Type, checked bool) *Const {
+	switch {
+	case t == ast.REAL:
+		return RealConst(-float64(c.Value.(ast.MilaReal)))
+	case t == ast.BOOLEAN:
+		return BoolConst(!bool(c.Value.(ast.MilaBoolean)))
+	case op == Not:
+		return IntConst(t, ^c.Int())
+	}
+	return foldInteger(Sub, 0, c.Int(), t, checked)
+}
+
+func foldBinary(op Op, left, right *Const, checked bool) *Const {
+	t := left.T
+	switch l := left.Value.(type) {
+	case ast.MilaBoolean:
+		x, y := bool(l), bool(right.Value.(ast.MilaBoolean))
+		switch op {
+		case And:
+			return BoolConst(x && y)
+		case Or:
+			return BoolConst(x || y)
+		case Xor, Ne:
+			return BoolConst(x != y)
+		case Eq:
+			return BoolConst(x == y)
+		}
+	case ast.MilaReal:
+		x, y := float64(l), float64(right.Value.(ast.MilaReal))
+		switch op {
+		case Add:
+			return RealConst(x + y)
+		case Sub:
+			return RealConst(x - y)
+		case Mul:
+			return RealConst(x * y)
+		case Quo:
+			return RealConst(x / y)
+		}
+		return compare(op, x < y, x == y, x > y)
+	case ast.MilaSet:
+		return foldSet(op, l, right.Value.(ast.MilaSet), t)
+	case ast.MilaInt:
+		x, y := int64(l), right.Int()
+		if op.IsComparison() {
+			return compare(op, x < y, x == y, x > y)
+		}
+		return foldInteger(op, x, y, t, checked)
+	}
+	return nil
+}
+
+// compare evaluates a comparison. NaN is neither less, equal nor greater than anything.
+func compare(op Op, less, equal, greater bool) *Const {
+	switch op {
+	case Eq:
+		return BoolConst(equal)
+	case Ne:
+		return BoolConst(!equal)
+	case Lt:
+		return BoolConst(less)
+	case Le:
+		return BoolConst(less || equal)
+	case Gt:
+		return BoolConst(greater)
+	case Ge:
+		return BoolConst(greater || equal)
+	}
+	return nil
+}
+
+// foldInteger performs an operation on integers of type t. Results of unchecked operations wrap around,
+// checked operations are only folded, when they don't overflow.
+func foldInteger(op Op, x, y int64, t ast.Type, checked bool) *Const {
+	var result int64
+	switch op {
+	case Add, Sub, Mul:
+		exact := new(big.Int)
+		switch op {
+		case Add:
+			result = x + y
+			exact.Add(big.NewInt(x), big.NewInt(y))
+		case Sub:
+			result = x - y
+			exact.Sub(big.NewInt(x), big.NewInt(y))
+		case Mul:
+			result = x * y
+			exact.Mul(big.NewInt(x), big.NewInt(y))
+		}
+		if checked && (!exact.IsInt64() || Wrap(t, exact.Int64()) != exact.Int64()) {
+			return nil
+		}
+	case Div, Mod:
+		if y == 0 {
+			return nil
+		}
+		// The smallest integer divided by -1 doesn't fit into its type
+		if !ast.IsUnsigned(t) && y == -1 && x == MinInt(t) {
+			if checked {
+				return nil
+			}
+			if op == Div {
+				return IntConst(t, x)
+			}
+			return IntConst(t, 0)
+		}
+		if op == Div {
+			result = x / y
+		} else {
+			result = x % y
+		}
+	case And:
+		result = x & y
+	case Or:
+		result = x | y
+	case Xor:
+		result = x ^ y
+	case Shl, Shr:
+		bits := Bits(t)
+		count := uint(y) & (bits - 1)
+		if op == Shl {
+			result = x << count
+		} else {
+			// shr is a logical shift, the sign bit is not extended
+			result = int64((uint64(x) & (math.MaxUint64 >> (64 - bits))) >> count)
+		}
+	default:
+		return nil
+	}
+	return IntConst(t, result)
+}
+
+func foldSet(op Op, x, y ast.MilaSet, t ast.Type) *Const {
+	var result ast.MilaSet
+	subset, superset := true, true
+	for i := range x {
+		switch op {
+		case Add:
+			result[i] = x[i] | y[i]
+		case Mul:
+			result[i] = x[i] & y[i]
+		case Sub:
+			result[i] = x[i] &^ y[i]
+		}
+		subset = subset && x[i]&^y[i] == 0
+		superset = superset && y[i]&^x[i] == 0
+	}
+	switch op {
+	case Eq:
+		return BoolConst(x == y)
+	case Ne:
+		return BoolConst(x != y)
+	case Le:
+		return BoolConst(subset)
+	case Ge:
+		return BoolConst(superset)
+	}
+	return SetConst(t.(ast.Set), result)
+}
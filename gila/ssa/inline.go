@@ -0,0 +1,164 @@
+package ssa
+
+import "gitlab.fit.cvut.cz/fedorgle/gila/ast"
+
+func init() {
+	Register(NewPass("inline", Inline))
+}
+
+// InlineThreshold is the largest number of instructions of a function, which is inlined
+// without the inline directive. Slots are not counted, since mem2reg removes them.
+const InlineThreshold = 30
+
+// Inline replaces calls of small functions by copies of their bodies. Functions, which are declared inline,
+// are inlined regardless of their size. Recursive functions are never inlined. Calls, that come
+// from inlined bodies, are inlined as well.
+func Inline(f *Function) {
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			for _, i := range b.Instructions {
+				if i.Op == Call && i.Callee != f && inlinable(i.Callee) {
+					inlineCall(f, i)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
+
+func inlinable(g *Function) bool {
+	if len(g.Blocks) == 0 || recursive(g) {
+		return false
+	}
+	if g.Inline {
+		return true
+	}
+	size := 0
+	g.Instructions(func(i *Instruction) {
+		if i.Op != Alloc {
+			size++
+		}
+	})
+	return size <= InlineThreshold
+}
+
+// recursive reports whether a function can call itself, directly or through other functions.
+func recursive(g *Function) bool {
+	visited := make(map[*Function]bool)
+	var calls func(f *Function) bool
+	calls = func(f *Function) bool {
+		found := false
+		f.Instructions(func(i *Instruction) {
+			if found || i.Op != Call {
+				return
+			}
+			if i.Callee == g {
+				found = true
+			} else if !visited[i.Callee] {
+				visited[i.Callee] = true
+				found = calls(i.Callee)
+			}
+		})
+		return found
+	}
+	return calls(g)
+}
+
+// inlineCall replaces a call in f by a copy of the body of the callee. The block of the call is split,
+// returns of the copy jump to the instructions after the call and their results are merged by a phi.
+func inlineCall(f *Function, call *Instruction) {
+	g, b := call.Callee, call.Block
+	after := f.NewBlock()
+	for n, i := range b.Instructions {
+		if i == call {
+			for _, rest := range b.Instructions[n+1:] {
+				after.Append(rest)
+			}
+			b.Instructions = b.Instructions[:n]
+			call.Block = nil
+			break
+		}
+	}
+	after.Succs, b.Succs = b.Succs, nil
+	for _, s := range after.Succs {
+		for j, p := range s.Preds {
+			if p == b {
+				s.Preds[j] = after
+			}
+		}
+	}
+
+	blocks := make(map[*Block]*Block)
+	values := make(map[Value]Value)
+	for j, p := range g.Parameters {
+		values[p] = call.Args[j]
+	}
+	for _, gb := range g.Blocks {
+		blocks[gb] = f.NewBlock()
+	}
+	var copies []*Instruction
+	for _, gb := range g.Blocks {
+		copied := blocks[gb]
+		// Edges are copied in order, so phis keep matching their predecessors
+		for _, p := range gb.Preds {
+			copied.Preds = append(copied.Preds, blocks[p])
+		}
+		for _, s := range gb.Succs {
+			copied.Succs = append(copied.Succs, blocks[s])
+		}
+		for _, i := range gb.Instructions {
+			c := f.NewInstruction(i.Op, i.T, append([]Value(nil), i.Args...)...)
+			c.Name, c.Callee, c.Checked, c.Position = i.Name, i.Callee, i.Checked, i.Position
+			values[i] = c
+			copies = append(copies, c)
+			// Slots stay in the entry block of the caller
+			if i.Op == Alloc {
+				f.Entry().Insert(0, c)
+			} else {
+				copied.Append(c)
+			}
+		}
+	}
+	for _, c := range copies {
+		for j, a := range c.Args {
+			if v, ok := values[a]; ok {
+				c.Args[j] = v
+			}
+		}
+	}
+
+	var results []Value
+	for _, gb := range g.Blocks {
+		copied := blocks[gb]
+		ret := copied.Terminator()
+		if ret.Op != Return {
+			continue
+		}
+		if len(ret.Args) > 0 {
+			results = append(results, ret.Args[0])
+		}
+		copied.Remove(ret)
+		jump := f.NewInstruction(Jump, ast.VOID)
+		jump.Position = ret.Position
+		copied.Append(jump)
+		AddEdge(copied, after)
+	}
+	jump := f.NewInstruction(Jump, ast.VOID)
+	jump.Position = call.Position
+	b.Append(jump)
+	AddEdge(b, blocks[g.Entry()])
+
+	if call.T == ast.VOID {
+		return
+	}
+	result := results[0]
+	if len(results) > 1 {
+		phi := f.NewInstruction(Phi, call.T, results...)
+		phi.Position = call.Position
+		after.Insert(0, phi)
+		result = phi
+	}
+	f.ReplaceUses(call, result)
+}
@@ -0,0 +1,53 @@
+package ssa
+
+func init() {
+	Register(NewPass("licm", HoistInvariants))
+}
+
+// HoistInvariants is the loop invariant code motion. Instructions, whose operands don't change in a loop,
+// move in front of it, so they are computed once. Inner loops are processed first, so an invariant can leave
+// several loops. Loops, that are entered from more than one block, are skipped.
+//
+// The loop may not execute the instruction at all, so only instructions, which can't stop the program, move.
+// Loads stay in place, it is meant to run after mem2reg, which leaves none.
+func HoistInvariants(f *Function) {
+	loops := insertPreheaders(f)
+	d := Dominators(f)
+	for _, l := range loops {
+		preheader := l.Preheader()
+		if preheader == nil {
+			continue
+		}
+		for _, b := range d.Blocks {
+			if !l.Blocks[b] {
+				continue
+			}
+			for _, i := range append([]*Instruction(nil), b.Instructions...) {
+				if invariant(l, i) {
+					b.Remove(i)
+					preheader.Insert(len(preheader.Instructions)-1, i)
+				}
+			}
+		}
+	}
+}
+
+// invariant reports whether an instruction computes the same result in every iteration of a loop
+// and can be computed before the loop. Blocks are visited in reverse postorder, so the operands
+// of an instruction are already moved, when they are invariant.
+func invariant(l *Loop, i *Instruction) bool {
+	if i.HasSideEffects() || i.Op == Alloc || i.Op == Load || i.Op == Phi {
+		return false
+	}
+	for _, a := range i.Args {
+		if l.Contains(a) {
+			return false
+		}
+	}
+	if i.Op == Div || i.Op == Mod {
+		// Only constant divisors are known not to stop the program
+		c, ok := i.Args[1].(*Const)
+		return ok && c.Int() != 0 && c.Int() != -1
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package ssa
+
+import (
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"sort"
+)
+
+// Loop is a natural loop: a header, which dominates the loop, and the blocks, from which a back edge
+// to the header can be reached without going through the header.
+type Loop struct {
+	Header *Block
+	Blocks map[*Block]bool
+	// Latches are the blocks of the loop, that continue in the header
+	Latches []*Block
+}
+
+// Loops finds the natural loops of the reachable part of a function. Back edges to the same header
+// form a single loop. Inner loops come before the loops, that contain them.
+func Loops(d *DomTree) []*Loop {
+	var loops []*Loop
+	for _, h := range d.Blocks {
+		l := &Loop{Header: h, Blocks: map[*Block]bool{h: true}}
+		var work []*Block
+		for _, p := range h.Preds {
+			if d.Dominates(h, p) {
+				l.Latches = append(l.Latches, p)
+				work = append(work, p)
+			}
+		}
+		if len(l.Latches) == 0 {
+			continue
+		}
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			if l.Blocks[b] {
+				continue
+			}
+			l.Blocks[b] = true
+			for _, p := range b.Preds {
+				if d.Reachable(p) {
+					work = append(work, p)
+				}
+			}
+		}
+		loops = append(loops, l)
+	}
+	sort.SliceStable(loops, func(i, j int) bool {
+		return len(loops[i].Blocks) < len(loops[j].Blocks)
+	})
+	return loops
+}
+
+// Preheader returns the block, from which control enters the loop. It is the only predecessor
+// of the header outside the loop and it has no other successor. It returns nil, when there is no such block.
+func (l *Loop) Preheader() *Block {
+	var preheader *Block
+	for _, p := range l.Header.Preds {
+		if l.Blocks[p] {
+			continue
+		}
+		if preheader != nil {
+			return nil
+		}
+		preheader = p
+	}
+	if preheader == nil || len(preheader.Succs) != 1 {
+		return nil
+	}
+	return preheader
+}
+
+// Contains reports whether a value is computed inside the loop. Constants and parameters never are.
+func (l *Loop) Contains(v Value) bool {
+	i, ok := v.(*Instruction)
+	return ok && l.Blocks[i.Block]
+}
+
+// insertPreheaders gives every loop of the function, that is entered from a single block, a preheader.
+// The edge into the header is split, when the block, that enters the loop, also goes elsewhere.
+// It returns the loops of the changed function.
+func insertPreheaders(f *Function) []*Loop {
+	for {
+		loops := Loops(Dominators(f))
+		split := false
+		for _, l := range loops {
+			if l.Preheader() != nil {
+				continue
+			}
+			var outside []*Block
+			for _, p := range l.Header.Preds {
+				if !l.Blocks[p] {
+					outside = append(outside, p)
+				}
+			}
+			if len(outside) == 1 {
+				splitEdge(outside[0], l.Header)
+				split = true
+				break
+			}
+		}
+		if !split {
+			return loops
+		}
+	}
+}
+
+// splitEdge puts a new block, which only jumps, between from and to. The new block takes the place
+// of from among the predecessors of to, so phis don't change.
+func splitEdge(from, to *Block) *Block {
+	f := from.Function
+	b := f.NewBlock()
+	jump := f.NewInstruction(Jump, ast.VOID)
+	jump.Position = from.Terminator().Position
+	b.Append(jump)
+	for i, s := range from.Succs {
+		if s == to {
+			from.Succs[i] = b
+			break
+		}
+	}
+	to.Preds[to.PredIndex(from)] = b
+	b.Preds, b.Succs = []*Block{from}, []*Block{to}
+	return b
+}
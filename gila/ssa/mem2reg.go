@@ -0,0 +1,216 @@
+package ssa
+
+func init() {
+	Register(NewPass("mem2reg", Mem2Reg))
+}
+
+// Mem2Reg promotes slots of variables, which are only loaded and stored, to values. Phis are placed
+// at the iterated dominance frontiers of the stores and loads are replaced by the value, that reaches them,
+// as described by Cytron et al. Phis, which turn out to be unused or to merge a single value, are removed.
+func Mem2Reg(f *Function) {
+	slots := promotable(f)
+	if len(slots) == 0 {
+		return
+	}
+	d := Dominators(f)
+	// phis maps the inserted phis to the slots, whose values they merge
+	phis := make(map[*Instruction]*Instruction)
+	frontiers := d.Frontiers()
+	for _, slot := range slots {
+		var work []*Block
+		for _, b := range d.Blocks {
+			for _, i := range b.Instructions {
+				if i.Op == Store && i.Args[0] == slot {
+					work = append(work, b)
+					break
+				}
+			}
+		}
+		placed := make(map[*Block]bool)
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, frontier := range frontiers[b] {
+				if placed[frontier] {
+					continue
+				}
+				placed[frontier] = true
+				phi := f.NewInstruction(Phi, slot.T)
+				phi.Args = make([]Value, len(frontier.Preds))
+				phi.Position = slot.Position
+				frontier.Insert(0, phi)
+				phis[phi] = slot
+				work = append(work, frontier)
+			}
+		}
+	}
+
+	r := &renamer{slots: make(map[*Instruction]bool), phis: phis, replaced: make(map[Value]Value), tree: d}
+	current := make(map[*Instruction]Value)
+	for _, slot := range slots {
+		r.slots[slot] = true
+		// Variables are always initialized, but a slot may still be read on a path, that doesn't store it
+		current[slot] = Zero(slot.T)
+	}
+	r.rename(d.Blocks[0], current)
+	for _, b := range f.Blocks {
+		if d.Reachable(b) {
+			continue
+		}
+		// Unreachable blocks are never executed, so any value will do
+		for _, i := range append([]*Instruction(nil), b.Instructions...) {
+			if i.Op == Load && r.slots[i.Args[0].(*Instruction)] {
+				r.replaced[i] = Zero(i.T)
+				b.Remove(i)
+			} else if i.Op == Store && r.slots[i.Args[0].(*Instruction)] {
+				b.Remove(i)
+			}
+		}
+		for s := range b.Succs {
+			r.fillPhis(b, b.Succs[s], nil)
+		}
+	}
+	for _, slot := range slots {
+		slot.Block.Remove(slot)
+	}
+	f.Instructions(func(i *Instruction) {
+		for j, a := range i.Args {
+			i.Args[j] = r.resolve(a)
+		}
+	})
+	removeTrivialPhis(f, phis)
+}
+
+// promotable returns the slots, whose address is only used by loads and stores.
+func promotable(f *Function) []*Instruction {
+	escapes := make(map[*Instruction]bool)
+	f.Instructions(func(i *Instruction) {
+		for j, a := range i.Args {
+			slot, ok := a.(*Instruction)
+			if ok && slot.Op == Alloc && !(j == 0 && (i.Op == Load || i.Op == Store)) {
+				escapes[slot] = true
+			}
+		}
+	})
+	var slots []*Instruction
+	for _, i := range f.Entry().Instructions {
+		if i.Op == Alloc && !escapes[i] {
+			slots = append(slots, i)
+		}
+	}
+	return slots
+}
+
+type renamer struct {
+	slots map[*Instruction]bool
+	phis  map[*Instruction]*Instruction
+	// replaced maps removed loads to the values, that were stored before them
+	replaced map[Value]Value
+	tree     *DomTree
+}
+
+func (r *renamer) resolve(v Value) Value {
+	for {
+		replacement, ok := r.replaced[v]
+		if !ok {
+			return v
+		}
+		v = replacement
+	}
+}
+
+// rename walks the dominator tree. current holds the value of every slot at the start of b.
+func (r *renamer) rename(b *Block, current map[*Instruction]Value) {
+	values := make(map[*Instruction]Value, len(current))
+	for slot, v := range current {
+		values[slot] = v
+	}
+	for _, i := range append([]*Instruction(nil), b.Instructions...) {
+		switch {
+		case i.Op == Phi && r.phis[i] != nil:
+			values[r.phis[i]] = i
+		case i.Op == Load && r.slots[i.Args[0].(*Instruction)]:
+			r.replaced[i] = values[i.Args[0].(*Instruction)]
+			b.Remove(i)
+		case i.Op == Store && r.slots[i.Args[0].(*Instruction)]:
+			values[i.Args[0].(*Instruction)] = r.resolve(i.Args[1])
+			b.Remove(i)
+		}
+	}
+	for _, s := range b.Succs {
+		r.fillPhis(b, s, values)
+	}
+	for _, child := range r.tree.Children(b) {
+		r.rename(child, values)
+	}
+}
+
+// fillPhis sets the values of inserted phis in s, that come from the predecessor b.
+// Without values, the zero value is used.
+func (r *renamer) fillPhis(b, s *Block, values map[*Instruction]Value) {
+	index := s.PredIndex(b)
+	for _, phi := range s.Phis() {
+		slot, ok := r.phis[phi]
+		if !ok {
+			continue
+		}
+		if v, ok := values[slot]; ok {
+			phi.Args[index] = v
+		} else {
+			phi.Args[index] = Zero(phi.T)
+		}
+	}
+}
+
+// removeTrivialPhis removes inserted phis, which are not used, or which merge a single value with themselves.
+// Removing one phi can make another trivial, so it repeats until nothing changes.
+func removeTrivialPhis(f *Function, phis map[*Instruction]*Instruction) {
+	for changed := true; changed; {
+		changed = false
+		uses := make(map[*Instruction]int)
+		f.Instructions(func(i *Instruction) {
+			for _, a := range i.Args {
+				if operand, ok := a.(*Instruction); ok && operand != i {
+					uses[operand]++
+				}
+			}
+		})
+		for phi := range phis {
+			if phi.Block == nil {
+				continue
+			}
+			if uses[phi] == 0 {
+				phi.Block.Remove(phi)
+				changed = true
+				continue
+			}
+			if v := uniqueValue(phi); v != nil {
+				f.ReplaceUses(phi, v)
+				phi.Block.Remove(phi)
+				changed = true
+			}
+		}
+	}
+}
+
+// uniqueValue returns the only value merged by a phi, other than the phi itself, or nil.
+func uniqueValue(phi *Instruction) Value {
+	var unique Value
+	for _, a := range phi.Args {
+		if a == phi || a == unique {
+			continue
+		}
+		if unique != nil && !sameConst(a, unique) {
+			return nil
+		}
+		unique = a
+	}
+	return unique
+}
+
+// sameConst reports whether two values are equal constants.
+func sameConst(a, b Value) bool {
+	x, ok := a.(*Const)
+	y, ok2 := b.(*Const)
+	return ok && ok2 && x.T == y.T && x.String() == y.String()
+}
@@ -0,0 +1,168 @@
+package ssa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pass analyses or transforms a function in place.
+type Pass interface {
+	Name() string
+	Run(f *Function)
+}
+
+type functionPass struct {
+	name string
+	run  func(f *Function)
+}
+
+func (p *functionPass) Name() string {
+	return p.name
+}
+
+func (p *functionPass) Run(f *Function) {
+	p.run(f)
+}
+
+// NewPass turns a function into a Pass.
+func NewPass(name string, run func(f *Function)) Pass {
+	return &functionPass{name, run}
+}
+
+// registry holds passes, that can be selected by their name.
+var registry = make(map[string]Pass)
+
+// Register makes a pass available to NewPassManager. Passes register themselves in init functions.
+func Register(p Pass) {
+	if _, ok := registry[p.Name()]; ok {
+		panic(fmt.Sprintf("Pass %s is already registered.", p.Name()))
+	}
+	registry[p.Name()] = p
+}
+
+// Passes returns the names of all registered passes in alphabetical order.
+func Passes() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PassManager runs passes over every implemented function of a program, in the order they were added.
+type PassManager struct {
+	passes []Pass
+	// Verify checks functions after every pass, so broken invariants are reported by the pass, that broke them.
+	Verify bool
+}
+
+// NewPassManager creates a pass manager, that runs registered passes with the given names.
+func NewPassManager(names ...string) (*PassManager, error) {
+	m := &PassManager{}
+	for _, name := range names {
+		p, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown pass %s, expected one of %s", name, strings.Join(Passes(), ", "))
+		}
+		m.Add(p)
+	}
+	return m, nil
+}
+
+// Add appends a pass to the pipeline.
+func (m *PassManager) Add(p Pass) {
+	m.passes = append(m.passes, p)
+}
+
+// Run runs the passes one after another. Every pass sees all functions transformed by the previous pass.
+func (m *PassManager) Run(program *Program) {
+	for _, p := range m.passes {
+		for _, f := range program.Functions {
+			if len(f.Blocks) == 0 {
+				continue
+			}
+			p.Run(f)
+			if m.Verify {
+				if err := Verify(f); err != nil {
+					panic(fmt.Sprintf("Pass %s broke function %s: %v\n%v", p.Name(), f.Name(), err, f))
+				}
+			}
+		}
+	}
+}
+
+// Verify checks the structure of a function: every block ends with its only terminator, edges are recorded
+// on both ends, phis come first and have a value for every predecessor, and operands belong to the function.
+func Verify(f *Function) error {
+	blocks := make(map[*Block]bool)
+	for _, b := range f.Blocks {
+		blocks[b] = true
+	}
+	defined := make(map[*Instruction]bool)
+	f.Instructions(func(i *Instruction) {
+		defined[i] = true
+	})
+	for _, b := range f.Blocks {
+		if b.Function != f {
+			return fmt.Errorf("%v belongs to another function", b)
+		}
+		if b.Terminator() == nil {
+			return fmt.Errorf("%v doesn't end with a terminator", b)
+		}
+		successors := 0
+		switch b.Terminator().Op {
+		case Jump:
+			successors = 1
+		case Branch:
+			successors = 2
+		}
+		if len(b.Succs) != successors {
+			return fmt.Errorf("%v has %d successors, expected %d", b, len(b.Succs), successors)
+		}
+		for _, s := range b.Succs {
+			if !blocks[s] || s.PredIndex(b) < 0 {
+				return fmt.Errorf("edge from %v to %v is not recorded in %v", b, s, s)
+			}
+		}
+		for _, p := range b.Preds {
+			if !blocks[p] || p.Terminator() == nil || !contains(p.Succs, b) {
+				return fmt.Errorf("edge from %v to %v is not recorded in %v", p, b, p)
+			}
+		}
+		phis := len(b.Phis())
+		for n, i := range b.Instructions {
+			if i.Block != b {
+				return fmt.Errorf("%s in %v points to another block", i.Format(), b)
+			}
+			if i.Op.IsTerminator() && n != len(b.Instructions)-1 {
+				return fmt.Errorf("%s is in the middle of %v", i.Format(), b)
+			}
+			if i.Op == Phi && n >= phis {
+				return fmt.Errorf("%s follows other instructions in %v", i.Format(), b)
+			}
+			if i.Op == Phi && len(i.Args) != len(b.Preds) {
+				return fmt.Errorf("%s has %d values for %d predecessors", i.Format(), len(i.Args), len(b.Preds))
+			}
+			if i.Op == Call && i.Callee == nil {
+				return fmt.Errorf("%s has no callee", i.Format())
+			}
+			for _, a := range i.Args {
+				if operand, ok := a.(*Instruction); ok && !defined[operand] {
+					return fmt.Errorf("%s uses %v, which is not in the function", i.Format(), operand)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func contains(blocks []*Block, b *Block) bool {
+	for _, x := range blocks {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package ssa
+
+func init() {
+	Register(NewPass("rotate", RotateLoops))
+}
+
+// rotateLimit is the largest number of instructions of a loop condition, which is copied.
+const rotateLimit = 16
+
+// RotateLoops moves the condition of loops to their end. The condition is copied into the blocks,
+// which jump back to the header, so an iteration ends with a branch back into the body instead of a jump
+// to the condition. The header stays in front of the loop and only decides, whether it is entered at all,
+// so code, which licm moves out of the loop, doesn't run for loops without iterations.
+//
+// It is meant to run before mem2reg. Headers with phis or with results used outside of them are not rotated.
+func RotateLoops(f *Function) {
+	rotated := make(map[*Block]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, l := range Loops(Dominators(f)) {
+			if rotated[l.Header] || !rotatable(l) {
+				continue
+			}
+			// The body becomes the header of the rotated loop, which must not be rotated again
+			for _, s := range l.Header.Succs {
+				rotated[s] = true
+			}
+			rotated[l.Header] = true
+			rotate(l)
+			changed = true
+			break
+		}
+	}
+}
+
+func rotatable(l *Loop) bool {
+	h := l.Header
+	f := h.Function
+	if h == f.Entry() || h.Terminator().Op != Branch || len(h.Phis()) > 0 || len(h.Instructions) > rotateLimit {
+		return false
+	}
+	inside := 0
+	for _, s := range h.Succs {
+		if l.Blocks[s] {
+			inside++
+		}
+		if s == h || len(s.Phis()) > 0 {
+			return false
+		}
+	}
+	if inside != 1 {
+		return false
+	}
+	for _, latch := range l.Latches {
+		if latch.Terminator().Op != Jump {
+			return false
+		}
+	}
+	used := false
+	for _, b := range f.Blocks {
+		if b == h {
+			continue
+		}
+		for _, i := range b.Instructions {
+			for _, a := range i.Args {
+				if operand, ok := a.(*Instruction); ok && operand.Block == h {
+					used = true
+				}
+			}
+		}
+	}
+	return !used
+}
+
+// rotate replaces the jumps of the latches to the header by copies of the header.
+func rotate(l *Loop) {
+	h := l.Header
+	f := h.Function
+	for _, latch := range l.Latches {
+		latch.Remove(latch.Terminator())
+		RemoveEdge(latch, h)
+		values := make(map[Value]Value)
+		for _, i := range h.Instructions {
+			c := f.NewInstruction(i.Op, i.T, append([]Value(nil), i.Args...)...)
+			c.Name, c.Callee, c.Checked, c.Position = i.Name, i.Callee, i.Checked, i.Position
+			for j, a := range c.Args {
+				if v, ok := values[a]; ok {
+					c.Args[j] = v
+				}
+			}
+			values[i] = c
+			latch.Append(c)
+		}
+		for _, s := range h.Succs {
+			AddEdge(latch, s)
+		}
+	}
+}
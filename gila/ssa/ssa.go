@@ -0,0 +1,555 @@
+// Package ssa is the mid-level representation of programs, which sits between the ast and the backends.
+//
+// A function is a list of basic blocks. Every block is a sequence of typed instructions, that ends with
+// a terminator: a jump, a conditional branch or a return. Instructions are values in static single
+// assignment form, values coming from different predecessors of a block are merged by phi instructions.
+// Variables start their life as stack slots, which are accessed by loads and stores, so the builder doesn't
+// have to place phis itself.
+//
+// Types of values are the types of the language. Operands of an instruction already have the type,
+// in which the operation is performed, conversions are explicit. Optimizations are passes over functions,
+// which are run by a PassManager.
+package ssa
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"math"
+	"strings"
+)
+
+// Program is a list of functions in the order of their declarations.
+type Program struct {
+	Name      string
+	Functions []*Function
+	// Exports are the functions of a library or a unit, which can be called from other modules,
+	// programs only export main
+	Library bool
+	Unit    bool
+	Exports []string
+}
+
+// Function returns the function with the given name, or nil.
+func (p *Program) Function(name string) *Function {
+	for _, f := range p.Functions {
+		if f.Name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Warning is a problem in the program, which doesn't stop the compilation.
+type Warning struct {
+	Position token.Position
+	Message  string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s at line %d, column %d", w.Message, w.Position.Line, w.Position.Col)
+}
+
+// Warnings returns the warnings of all functions.
+func (p *Program) Warnings() []Warning {
+	var warnings []Warning
+	for _, f := range p.Functions {
+		warnings = append(warnings, f.Warnings...)
+	}
+	return warnings
+}
+
+// Function is a function of the program. Functions, that are declared, but never implemented, have no blocks.
+// The first block is the entry of the function.
+type Function struct {
+	Signature  *ast.Signature
+	Parameters []*Parameter
+	Blocks     []*Block
+	Position   token.Position
+	// Inline is set, when any declaration of the function has the inline directive
+	Inline bool
+	// Warnings are reported by passes
+	Warnings []Warning
+	// values is the number of values created in the function, it is used to number new instructions
+	values int
+	blocks int
+}
+
+func (f *Function) Name() string {
+	return f.Signature.Name
+}
+
+// Entry returns the first block of the function.
+func (f *Function) Entry() *Block {
+	return f.Blocks[0]
+}
+
+// NewBlock appends an empty block to the function.
+func (f *Function) NewBlock() *Block {
+	b := &Block{Function: f, Index: f.blocks}
+	f.blocks++
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// Instructions calls visit for every instruction of the function in order.
+func (f *Function) Instructions(visit func(i *Instruction)) {
+	for _, b := range f.Blocks {
+		for _, i := range b.Instructions {
+			visit(i)
+		}
+	}
+}
+
+// ReplaceUses makes all instructions, that use old, use v instead.
+func (f *Function) ReplaceUses(old, v Value) {
+	f.Instructions(func(i *Instruction) {
+		for j, a := range i.Args {
+			if a == old {
+				i.Args[j] = v
+			}
+		}
+	})
+}
+
+// Block is a basic block. Only the last instruction transfers control, to the successors of the block.
+// Arguments of phi instructions correspond to predecessors.
+type Block struct {
+	Function     *Function
+	Instructions []*Instruction
+	Preds, Succs []*Block
+	// Index identifies the block in the printed function
+	Index int
+	// Position is the position of the first statement in the block, if any
+	Position token.Position
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.Index)
+}
+
+// Terminator returns the last instruction of the block, if it transfers control.
+func (b *Block) Terminator() *Instruction {
+	if n := len(b.Instructions); n > 0 && b.Instructions[n-1].Op.IsTerminator() {
+		return b.Instructions[n-1]
+	}
+	return nil
+}
+
+// Phis returns the phi instructions at the start of the block.
+func (b *Block) Phis() []*Instruction {
+	n := 0
+	for n < len(b.Instructions) && b.Instructions[n].Op == Phi {
+		n++
+	}
+	return b.Instructions[:n]
+}
+
+// PredIndex returns the index of a predecessor, which is also the index of its value in phis, or -1.
+func (b *Block) PredIndex(pred *Block) int {
+	for i, p := range b.Preds {
+		if p == pred {
+			return i
+		}
+	}
+	return -1
+}
+
+// Append adds an instruction to the end of the block.
+func (b *Block) Append(i *Instruction) *Instruction {
+	i.Block = b
+	b.Instructions = append(b.Instructions, i)
+	return i
+}
+
+// Insert adds an instruction at the given index of the block.
+func (b *Block) Insert(index int, i *Instruction) *Instruction {
+	i.Block = b
+	b.Instructions = append(b.Instructions, nil)
+	copy(b.Instructions[index+1:], b.Instructions[index:])
+	b.Instructions[index] = i
+	return i
+}
+
+// Remove deletes an instruction from the block. Its uses have to be replaced first.
+func (b *Block) Remove(i *Instruction) {
+	for j, x := range b.Instructions {
+		if x == i {
+			b.Instructions = append(b.Instructions[:j], b.Instructions[j+1:]...)
+			i.Block = nil
+			return
+		}
+	}
+}
+
+// AddEdge makes to a successor of from. Phis of to have to be extended by the caller.
+func AddEdge(from, to *Block) {
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+// RemoveEdge removes an edge between two blocks together with the values of phis, that come through it.
+func RemoveEdge(from, to *Block) {
+	for i, s := range from.Succs {
+		if s == to {
+			from.Succs = append(from.Succs[:i], from.Succs[i+1:]...)
+			break
+		}
+	}
+	index := to.PredIndex(from)
+	if index < 0 {
+		return
+	}
+	to.Preds = append(to.Preds[:index], to.Preds[index+1:]...)
+	for _, phi := range to.Phis() {
+		phi.Args = append(phi.Args[:index], phi.Args[index+1:]...)
+	}
+}
+
+// Value is an operand of an instruction: a constant, a parameter or a result of an instruction.
+type Value interface {
+	Type() ast.Type
+	String() string
+}
+
+// Const is a constant value. Integers hold the value in the range of their type.
+type Const struct {
+	T     ast.Type
+	Value ast.Value
+}
+
+func (c *Const) Type() ast.Type {
+	return c.T
+}
+
+func (c *Const) String() string {
+	switch v := c.Value.(type) {
+	case ast.MilaString:
+		return fmt.Sprintf("%q", string(v))
+	case ast.MilaReal:
+		return fmt.Sprint(float64(v))
+	case ast.MilaSet:
+		var elements []string
+		for i := int64(0); i <= ast.MaxSetElement; i++ {
+			if v.Contains(i) {
+				elements = append(elements, fmt.Sprint(i))
+			}
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	}
+	return fmt.Sprint(c.Value)
+}
+
+// Int returns the value of an integer constant.
+func (c *Const) Int() int64 {
+	return int64(c.Value.(ast.MilaInt))
+}
+
+// IntConst returns an integer constant of type t. The value is truncated to the width of the type.
+func IntConst(t ast.Type, x int64) *Const {
+	return &Const{T: t, Value: ast.MilaInt(Wrap(t, x))}
+}
+
+func BoolConst(b bool) *Const {
+	return &Const{T: ast.BOOLEAN, Value: ast.MilaBoolean(b)}
+}
+
+func RealConst(x float64) *Const {
+	return &Const{T: ast.REAL, Value: ast.MilaReal(x)}
+}
+
+func StringConst(s string) *Const {
+	return &Const{T: ast.STRING, Value: ast.MilaString(s)}
+}
+
+func SetConst(t ast.Set, s ast.MilaSet) *Const {
+	return &Const{T: t, Value: s}
+}
+
+// Zero returns the value, which variables of type t hold, before they are assigned.
+func Zero(t ast.Type) *Const {
+	switch {
+	case t == ast.REAL:
+		return RealConst(0)
+	case t == ast.BOOLEAN:
+		return BoolConst(false)
+	case t == ast.STRING:
+		return StringConst("")
+	case ast.IsInteger(t):
+		return IntConst(t, 0)
+	}
+	return SetConst(t.(ast.Set), ast.MilaSet{})
+}
+
+// Bits returns the width of an integer type.
+func Bits(t ast.Type) uint {
+	switch t {
+	case ast.BYTE, ast.SHORTINT:
+		return 8
+	case ast.WORD:
+		return 16
+	case ast.INT64:
+		return 64
+	}
+	return 32
+}
+
+// Wrap truncates an integer to the width of type t and extends it according to the sign of the type.
+func Wrap(t ast.Type, x int64) int64 {
+	shift := 64 - Bits(t)
+	if ast.IsUnsigned(t) {
+		return int64(uint64(x<<shift) >> shift)
+	}
+	return x << shift >> shift
+}
+
+// MinInt returns the smallest value of a signed integer type.
+func MinInt(t ast.Type) int64 {
+	if t == ast.INT64 {
+		return math.MinInt64
+	}
+	return -1 << (Bits(t) - 1)
+}
+
+// Parameter is a parameter of a function.
+type Parameter struct {
+	Name string
+	T    ast.Type
+}
+
+func (p *Parameter) Type() ast.Type {
+	return p.T
+}
+
+func (p *Parameter) String() string {
+	return "%" + p.Name
+}
+
+// Op is the operation performed by an instruction.
+type Op int
+
+const (
+	// Alloc is a stack slot of a variable of type T, named Name. Slots are allocated in the entry block.
+	Alloc Op = iota
+	// Load reads the slot Args[0].
+	Load
+	// Store writes Args[1] into the slot Args[0].
+	Store
+
+	// Arithmetic is defined for integers and reals. On sets, Add, Mul and Sub are the union,
+	// the intersection and the difference. Checked instructions stop the program on overflow and Div and Mod
+	// also on division by zero.
+	Add
+	Sub
+	Mul
+	// Div is the integer division, which is signed or unsigned according to the type of operands.
+	Div
+	Mod
+	// Quo is the division of reals.
+	Quo
+	// And, Or and Xor are logical on booleans and bitwise on integers.
+	And
+	Or
+	Xor
+	// Shl and Shr shift by the count masked to the width of the type. Shr is logical.
+	Shl
+	Shr
+	Neg
+	Not
+
+	// Comparisons return a boolean. Le and Ge are the subset and the superset on sets.
+	Eq
+	Ne
+	Lt
+	Le
+	Gt
+	Ge
+
+	// Convert changes the type of Args[0] to T: integers are truncated or extended, integers become reals
+	// and sets drop elements, that don't fit their new representation.
+	Convert
+	// SetMake builds a set out of ranges, given by pairs of integer bounds in Args.
+	SetMake
+	// In tests, whether the integer Args[0] is an element of the set Args[1].
+	In
+
+	// Call calls Callee with Args, the result has the return type of the callee.
+	Call
+	// Write prints Args[0] right aligned to the width Args[1]. Reals use the precision Args[2].
+	Write
+	WriteNewline
+	// Read reads a number of type T. The result is Args[0], when there is nothing to read.
+	Read
+	ReadNewline
+
+	// Phi selects the argument, that corresponds to the predecessor, from which control came.
+	Phi
+	// Jump continues in the only successor of the block.
+	Jump
+	// Branch continues in the first successor, when Args[0] is true, otherwise in the second.
+	Branch
+	// Return leaves the function with the result Args[0], procedures have no arguments.
+	Return
+)
+
+var opNames = [...]string{
+	Alloc: "alloc", Load: "load", Store: "store",
+	Add: "add", Sub: "sub", Mul: "mul", Div: "div", Mod: "mod", Quo: "quo",
+	And: "and", Or: "or", Xor: "xor", Shl: "shl", Shr: "shr", Neg: "neg", Not: "not",
+	Eq: "eq", Ne: "ne", Lt: "lt", Le: "le", Gt: "gt", Ge: "ge",
+	Convert: "convert", SetMake: "setmake", In: "in",
+	Call: "call", Write: "write", WriteNewline: "writeln", Read: "read", ReadNewline: "readln",
+	Phi: "phi", Jump: "jump", Branch: "branch", Return: "return",
+}
+
+func (o Op) String() string {
+	return opNames[o]
+}
+
+// IsTerminator reports whether the operation ends a block.
+func (o Op) IsTerminator() bool {
+	return o == Jump || o == Branch || o == Return
+}
+
+// IsComparison reports whether the operation compares two values.
+func (o Op) IsComparison() bool {
+	return o >= Eq && o <= Ge
+}
+
+// HasSideEffects reports whether the instruction does more than computing its result,
+// so it can't be removed, when the result is not used.
+func (i *Instruction) HasSideEffects() bool {
+	switch i.Op {
+	case Store, Call, Write, WriteNewline, Read, ReadNewline, Jump, Branch, Return:
+		return true
+	case Add, Sub, Mul, Neg, Div, Mod:
+		return i.Checked
+	}
+	return false
+}
+
+// TailKind tells the backend, whether a call is a tail call.
+type TailKind int
+
+const (
+	NoTail TailKind = iota
+	// MayTail calls are followed by a return of their result, so the frame of the caller is not needed anymore.
+	MayTail
+	// MustTail calls are followed by a return of their result in the same block and have the signature
+	// of their caller, so the frame is always reused.
+	MustTail
+)
+
+// Instruction is a single operation. Instructions, that have a result, are values.
+type Instruction struct {
+	Op Op
+	// T is the type of the result, VOID for instructions without a result
+	T     ast.Type
+	Args  []Value
+	Block *Block
+	// ID numbers the results inside the function
+	ID int
+	// Name of the variable of an Alloc
+	Name string
+	// Callee of a Call
+	Callee *Function
+	// Checked arithmetic reports overflow and division by zero as runtime errors
+	Checked bool
+	// Tail tells, whether a Call can reuse the stack frame of the function, that makes it
+	Tail TailKind
+	// Position is the position of the operator or of the statement, that produced the instruction
+	Position token.Position
+}
+
+func (i *Instruction) Type() ast.Type {
+	return i.T
+}
+
+func (i *Instruction) String() string {
+	return fmt.Sprintf("%%%d", i.ID)
+}
+
+// NewInstruction creates an instruction, that is numbered in the function f. It has to be added to a block.
+func (f *Function) NewInstruction(op Op, t ast.Type, args ...Value) *Instruction {
+	i := &Instruction{Op: op, T: t, Args: args}
+	if t != ast.VOID {
+		i.ID = f.values
+		f.values++
+	}
+	return i
+}
+
+// Format prints the instruction.
+func (i *Instruction) Format() string {
+	var args []string
+	for _, a := range i.Args {
+		args = append(args, a.String())
+	}
+	operands := strings.Join(args, ", ")
+	switch i.Op {
+	case Alloc:
+		operands = i.Name
+	case Call:
+		operands = strings.Join(append([]string{i.Callee.Name()}, args...), ", ")
+	case Jump:
+		operands = i.Block.Succs[0].String()
+	case Branch:
+		operands += fmt.Sprintf(", %v, %v", i.Block.Succs[0], i.Block.Succs[1])
+	case Phi:
+		var incoming []string
+		for j, a := range i.Args {
+			incoming = append(incoming, fmt.Sprintf("[%v, %v]", a, i.Block.Preds[j]))
+		}
+		operands = strings.Join(incoming, ", ")
+	}
+	if i.Checked {
+		operands = "checked " + operands
+	}
+	switch i.Tail {
+	case MayTail:
+		operands = "tail " + operands
+	case MustTail:
+		operands = "musttail " + operands
+	}
+	if i.T == ast.VOID {
+		return strings.TrimSpace(fmt.Sprintf("%v %s", i.Op, operands))
+	}
+	return strings.TrimSpace(fmt.Sprintf("%v = %v %v %s", i, i.Op, i.T, operands))
+}
+
+func (f *Function) String() string {
+	var out strings.Builder
+	var params []string
+	for _, p := range f.Parameters {
+		params = append(params, fmt.Sprintf("%v: %v", p, p.T))
+	}
+	fmt.Fprintf(&out, "function %s(%s): %v", f.Name(), strings.Join(params, "; "), f.Signature.Return)
+	if len(f.Blocks) == 0 {
+		out.WriteString(";\n")
+		return out.String()
+	}
+	out.WriteString("\n")
+	for _, b := range f.Blocks {
+		out.WriteString(b.String() + ":")
+		if len(b.Preds) > 0 {
+			var preds []string
+			for _, p := range b.Preds {
+				preds = append(preds, p.String())
+			}
+			out.WriteString(" ; preds " + strings.Join(preds, ", "))
+		}
+		out.WriteString("\n")
+		for _, i := range b.Instructions {
+			out.WriteString("\t" + i.Format() + "\n")
+		}
+	}
+	return out.String()
+}
+
+func (p *Program) String() string {
+	var functions []string
+	for _, f := range p.Functions {
+		functions = append(functions, f.String())
+	}
+	return strings.Join(functions, "\n")
+}
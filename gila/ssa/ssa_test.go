@@ -0,0 +1,493 @@
+package ssa
+
+import (
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"strings"
+	"testing"
+)
+
+func build(source string, options Options) *Program {
+	program := parser.New(lexer.New(strings.NewReader(source))).Parse()
+	return Build(program, checker.Check(program), options)
+}
+
+func Test_Build(t *testing.T) {
+	p := build(`program loops;
+function twice(x: integer): integer; forward;
+function sum(n: byte): integer;
+var i: integer;
+begin
+	sum := 0;
+	for i := 1 to n do
+	begin
+		sum := sum + twice(i);
+		if sum > 100 then exit;
+	end;
+end;
+function twice(x: integer): integer;
+begin
+	twice := x shl 1;
+end;
+var s: set of 0..255; r: real;
+begin
+	while 1 < 2 do
+	begin
+		break;
+		writeln('never');
+	end;
+	s := [1, 3..4];
+	r := sum(10) / 4;
+	writeln(r, 3 in s);
+end.`, Options{})
+	if len(p.Functions) != 3 {
+		t.Fatalf("Expected 3 functions, got:\n%v", p)
+	}
+	for _, f := range p.Functions {
+		if err := Verify(f); err != nil {
+			t.Errorf("%v\n%v", err, f)
+		}
+	}
+	sum := p.Function("sum").String()
+	expected := []string{
+		"%0 = alloc integer sum",
+		"%5 = convert integer %4",
+		// Forward declarations are resolved to the implementation
+		"call integer twice, %",
+		"gt boolean %",
+		"b1: ; preds b0, b",
+	}
+	for _, e := range expected {
+		if !strings.Contains(sum, e) {
+			t.Errorf("Expected %q in:\n%s", e, sum)
+		}
+	}
+	if twice := p.Function("twice"); len(twice.Blocks) == 0 || twice.Parameters[0].Name != "x" {
+		t.Errorf("Function twice is not implemented:\n%v", twice)
+	}
+	main := p.Function("main")
+	// Statements after break are in a block, which is never reached
+	unreachable := 0
+	for _, b := range main.Blocks[1:] {
+		if len(b.Preds) == 0 {
+			unreachable++
+		}
+	}
+	if unreachable != 1 {
+		t.Errorf("Expected one unreachable block in:\n%v", main)
+	}
+	for _, e := range []string{"store %0, [1, 3, 4]", "quo real %", "in boolean 3, %", "write %"} {
+		if !strings.Contains(main.String(), e) {
+			t.Errorf("Expected %q in:\n%v", e, main)
+		}
+	}
+}
+
+func Test_Checked(t *testing.T) {
+	// inc and dec are checked, the step of a for loop isn't
+	source := "program p; var x, i: integer; c: cardinal; begin x := -x * 2; c := c div 3; inc(x); dec(c); for i := 0 to 9 do begin x := i; end; end."
+	checked := 0
+	build(source, Options{OverflowChecks: true}).Functions[0].Instructions(func(i *Instruction) {
+		if i.Checked {
+			checked++
+		}
+	})
+	if checked != 5 {
+		t.Errorf("Expected 5 checked instructions, got %d", checked)
+	}
+	build(source, Options{}).Functions[0].Instructions(func(i *Instruction) {
+		if i.Checked {
+			t.Errorf("%s is checked without OverflowChecks", i.Format())
+		}
+	})
+}
+
+func Test_Constants(t *testing.T) {
+	if c := IntConst(ast.BYTE, 300); c.Int() != 44 {
+		t.Errorf("byte 300 is %d, expected 44", c.Int())
+	}
+	if c := IntConst(ast.SHORTINT, 200); c.Int() != -56 {
+		t.Errorf("shortint 200 is %d, expected -56", c.Int())
+	}
+	if c := IntConst(ast.CARDINAL, -1); c.Int() != 4294967295 {
+		t.Errorf("cardinal -1 is %d, expected 4294967295", c.Int())
+	}
+	if MinInt(ast.INT) != -2147483648 || Wrap(ast.WORD, 65536) != 0 {
+		t.Error("Wrong limits of integer types.")
+	}
+}
+
+func Test_PassManager(t *testing.T) {
+	var order []string
+	Register(NewPass("test-first", func(f *Function) { order = append(order, "first "+f.Name()) }))
+	Register(NewPass("test-second", func(f *Function) { order = append(order, "second "+f.Name()) }))
+	Register(NewPass("test-broken", func(f *Function) {
+		f.Entry().Remove(f.Entry().Terminator())
+	}))
+	p := build("program p; function f(): integer; forward; begin writeln(1); end.", Options{})
+	m, err := NewPassManager("test-second", "test-first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Run(p)
+	// Declared functions are skipped
+	if strings.Join(order, ", ") != "second main, first main" {
+		t.Errorf("Passes ran in a wrong order: %v", order)
+	}
+	if _, err := NewPassManager("no-such-pass"); err == nil {
+		t.Error("Unknown pass was accepted.")
+	}
+	m, _ = NewPassManager("test-broken")
+	m.Verify = true
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "Pass test-broken broke function main") {
+			t.Errorf("Broken function was not reported, got %v", r)
+		}
+	}()
+	m.Run(p)
+}
+
+func Test_Dominators(t *testing.T) {
+	p := build(`program p;
+var x: integer;
+begin
+	if x > 0 then x := 1 else x := 2;
+	while x < 10 do
+	begin
+		x := x + 1;
+		exit;
+	end;
+end.`, Options{})
+	f := p.Functions[0]
+	d := Dominators(f)
+	// b0: if, b1: then, b2: else, b3: after if, b4: while header, b5: body, b6: after the loop, b7: after exit
+	b := f.Blocks
+	if len(b) != 8 || len(d.Blocks) != 7 || d.Reachable(b[7]) {
+		t.Fatalf("Unexpected blocks of:\n%v", f)
+	}
+	idoms := map[*Block]*Block{b[0]: nil, b[1]: b[0], b[2]: b[0], b[3]: b[0], b[4]: b[3], b[5]: b[4], b[6]: b[4]}
+	for block, idom := range idoms {
+		if d.Idom(block) != idom {
+			t.Errorf("Immediate dominator of %v is %v, expected %v", block, d.Idom(block), idom)
+		}
+	}
+	if !d.Dominates(b[3], b[5]) || d.Dominates(b[1], b[3]) || !d.Dominates(b[6], b[6]) {
+		t.Error("Wrong dominance.")
+	}
+	frontiers := d.Frontiers()
+	if len(frontiers[b[1]]) != 1 || frontiers[b[1]][0] != b[3] || len(frontiers[b[0]]) != 0 {
+		t.Errorf("Wrong dominance frontiers: %v", frontiers)
+	}
+	// b7 jumps back to the header, but it is not reachable
+	if len(frontiers[b[5]]) != 0 || len(frontiers[b[7]]) != 0 {
+		t.Errorf("Wrong dominance frontiers: %v", frontiers)
+	}
+}
+
+func Test_Mem2Reg(t *testing.T) {
+	p := build(`program p;
+function f(n: integer): integer;
+var i: integer; s: set of 0..255;
+begin
+	f := 0;
+	for i := 1 to n do
+	begin
+		if i mod 2 = 0 then f := f + i;
+		if f > 100 then
+		begin
+			break;
+			f := -1;
+		end;
+	end;
+	s := [f];
+	readln(n);
+	if n in s then f := f + n;
+end;
+begin
+	writeln(f(10));
+end.`, Options{})
+	m, _ := NewPassManager("mem2reg")
+	m.Verify = true
+	m.Run(p)
+	f := p.Function("f")
+	phis := 0
+	f.Instructions(func(i *Instruction) {
+		switch i.Op {
+		case Alloc, Load, Store:
+			t.Errorf("%s was not removed", i.Format())
+		case Phi:
+			phis++
+		}
+	})
+	// f and i in the loop header, f after the first if and after the loop, f at the end.
+	// n is only stored once and s doesn't live across blocks.
+	if phis != 5 {
+		t.Errorf("Expected 5 phis, got %d in:\n%v", phis, f)
+	}
+	for _, e := range []string{"phi integer [0, b0], [", "read integer %n"} {
+		if !strings.Contains(f.String(), e) {
+			t.Errorf("Expected %q in:\n%v", e, f)
+		}
+	}
+}
+
+func Test_ConstFold(t *testing.T) {
+	p := build(`program p;
+var x: integer; b: byte; s: set of 0..31;
+begin
+	x := 2 + 3 * 4;
+	b := 250 + 10;
+	s := [1..3] - [2];
+	writeln(x, b, 1 in s, (7 div 0) + 1, x * 200000000);
+	if x > 10 then writeln(-x shl 33);
+end.`, Options{OverflowChecks: true})
+	m, _ := NewPassManager("mem2reg", "constfold")
+	m.Verify = true
+	m.Run(p)
+	main := p.Functions[0].String()
+	// b wraps around, shift count is masked
+	for _, e := range []string{"write 14", "write 4", "write true", "branch true", "write -28"} {
+		if !strings.Contains(main, e) {
+			t.Errorf("Expected %q in:\n%v", e, main)
+		}
+	}
+	// Checked overflow and division by zero are left to the runtime
+	for _, e := range []string{"mul integer checked 14, 200000000", "div integer checked 7, 0"} {
+		if !strings.Contains(main, e) {
+			t.Errorf("Expected %q in:\n%v", e, main)
+		}
+	}
+	if c := Fold(&Instruction{Op: Shl, T: ast.INT, Args: []Value{IntConst(ast.INT, 1), IntConst(ast.INT, 33)}}); c == nil || c.Int() != 2 {
+		t.Errorf("1 shl 33 is folded to %v, expected 2", c)
+	}
+	if c := Fold(&Instruction{Op: Div, T: ast.INT, Args: []Value{IntConst(ast.INT, MinInt(ast.INT)), IntConst(ast.INT, -1)}}); c == nil || c.Int() != MinInt(ast.INT) {
+		t.Errorf("Unchecked MinInt div -1 is folded to %v", c)
+	}
+}
+
+func Test_DeadCodeElimination(t *testing.T) {
+	p := build(`program p;
+var i: integer;
+begin
+	while i < 10 do
+	begin
+		i := i + 1;
+		break;
+		writeln('never');
+	end;
+	if i > 100 then exit else exit;
+	writeln(i);
+	if 1 > 2 then writeln('constant');
+end.`, Options{})
+	m, _ := NewPassManager("mem2reg", "constfold", "dce")
+	m.Verify = true
+	m.Run(p)
+	f := p.Functions[0]
+	for _, b := range f.Blocks {
+		if len(b.Preds) == 0 && b != f.Entry() {
+			t.Errorf("Unreachable %v was not removed:\n%v", b, f)
+		}
+	}
+	f.Instructions(func(i *Instruction) {
+		if i.Op == Write {
+			t.Errorf("%s is never executed:\n%v", i.Format(), f)
+		}
+	})
+	// i starts at 0 and the loop body always breaks, so the loop is merged into the entry
+	if len(f.Blocks) != 3 || !strings.Contains(f.String(), "gt boolean 1, 100") {
+		t.Errorf("Expected the entry and two returns, got:\n%v", f)
+	}
+	// The constant condition is not reported
+	expected := []string{"Unreachable statement at line 8, column 3", "Unreachable statement at line 11, column 2"}
+	if len(f.Warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %v", len(expected), f.Warnings)
+	}
+	for i, w := range f.Warnings {
+		if w.String() != expected[i] {
+			t.Errorf("Expected warning %q, got %q", expected[i], w)
+		}
+	}
+}
+
+func Test_Inline(t *testing.T) {
+	p := build(`program p;
+function square(x: integer): integer; inline;
+var i, s: integer;
+begin
+	s := 0;
+	for i := 1 to x do
+	begin
+		s := s + x;
+	end;
+	square := s;
+end;
+function sign(x: integer): integer;
+begin
+	sign := 0;
+	if x < 0 then
+	begin
+		sign := -1;
+		exit;
+	end;
+	if x > 0 then sign := 1;
+end;
+function even(n: integer): integer; inline; forward;
+function odd(n: integer): integer;
+begin
+	if n = 0 then odd := 0 else odd := even(n - 1);
+end;
+function even(n: integer): integer;
+begin
+	if n = 0 then even := 1 else even := odd(n - 1);
+end;
+begin
+	writeln(square(3) + sign(-2), even(4));
+end.`, Options{})
+	m, _ := NewPassManager("inline")
+	m.Verify = true
+	m.Run(p)
+	// Both returns of sign jump to the instructions after the call, which merge their results
+	main := p.Function("main")
+	if !strings.Contains(main.String(), "phi integer [%") {
+		t.Errorf("Results of sign are not merged:\n%v", main)
+	}
+	m, _ = NewPassManager("mem2reg", "constfold", "dce")
+	m.Verify = true
+	m.Run(p)
+	calls := 0
+	main.Instructions(func(i *Instruction) {
+		if i.Op == Call {
+			calls++
+			// Mutually recursive functions are not inlined, even when they are declared inline
+			if i.Callee.Name() != "even" {
+				t.Errorf("%s was not inlined:\n%v", i.Format(), main)
+			}
+		}
+	})
+	if calls != 1 {
+		t.Errorf("Expected a single call in:\n%v", main)
+	}
+	// sign(-2) is folded, once it is inlined
+	if !strings.Contains(main.String(), ", -1\n") {
+		t.Errorf("sign(-2) was not folded:\n%v", main)
+	}
+}
+
+func Test_TailCalls(t *testing.T) {
+	p := build(`program p;
+function sum(n: integer; acc: integer): integer;
+begin
+	if n = 0 then
+	begin
+		sum := acc;
+		exit;
+	end;
+	sum := sum(n - 1, acc + n);
+end;
+function twice(n: integer; acc: integer): integer;
+begin
+	twice := sum(n, acc) * 2;
+end;
+function start(n: integer; acc: integer): integer;
+begin
+	start := sum(n, acc);
+end;
+procedure count(n: byte);
+begin
+	if n > 0 then count(n - 1);
+	writeln(start(n, 0));
+end;
+begin
+	count(3);
+end.`, Options{})
+	m, _ := NewPassManager("mem2reg", "tailcall", "dce")
+	m.Verify = true
+	m.Run(p)
+	sum := p.Function("sum").String()
+	if strings.Contains(sum, "call") || !strings.Contains(sum, "phi integer [%n, b0], [") {
+		t.Errorf("Recursion was not turned into a loop:\n%s", sum)
+	}
+	expected := map[string]string{
+		"twice": "call integer sum",
+		"start": "call integer musttail sum",
+		"count": "call count, %",
+		"main":  "call tail count, 3",
+	}
+	for name, e := range expected {
+		if f := p.Function(name).String(); !strings.Contains(f, e) {
+			t.Errorf("Expected %q in:\n%s", e, f)
+		}
+	}
+}
+
+func Test_Loops(t *testing.T) {
+	p := build(`program p;
+function total(n: integer; k: integer): integer;
+var i, j, s: integer;
+begin
+	s := 0;
+	for i := 1 to n do
+	begin
+		j := 0;
+		while j < k do
+		begin
+			s := s + i * 4 + k div 2;
+			j := j + 1;
+		end;
+	end;
+	total := s;
+end;
+begin
+	writeln(total(3, 4));
+end.`, Options{})
+	loops := Loops(Dominators(p.Function("total")))
+	if len(loops) != 2 || len(loops[0].Blocks) >= len(loops[1].Blocks) || !loops[1].Blocks[loops[0].Header] {
+		t.Fatalf("Expected the inner loop before the outer loop, got %v", loops)
+	}
+	m, _ := NewPassManager("rotate", "mem2reg", "constfold", "licm", "strength", "dce")
+	m.Verify = true
+	m.Run(p)
+	total := p.Function("total")
+	loops = Loops(Dominators(total))
+	if len(loops) != 2 {
+		t.Fatalf("Expected two loops in:\n%s", total)
+	}
+	for _, l := range loops {
+		if l.Preheader() == nil || l.Header.Terminator().Op != Branch {
+			t.Errorf("Loop at %v was not rotated:\n%s", l.Header, total)
+		}
+		for b := range l.Blocks {
+			for _, i := range b.Instructions {
+				if i.Op == Mul || i.Op == Div {
+					t.Errorf("%s was not moved out of the loop at %v:\n%s", i.Format(), l.Header, total)
+				}
+			}
+		}
+	}
+	if s := total.String(); !strings.Contains(s, "div integer %k, 2") || !strings.Contains(s, ", 4\n") {
+		t.Errorf("Expected the division before the loops and a step of 4 in:\n%s", s)
+	}
+}
+
+func Test_StrengthNarrowLoops(t *testing.T) {
+	// Narrower counters are converted after the addition, and negation has a single operand,
+	// neither of them is an induction variable
+	sources := []string{
+		"program p; var b: byte; s: integer; begin b := 1; s := 0; while b <> 0 do begin s := s + b * 4; b := b + 1; end; writeln(s); end.",
+		"program p; var w: word; s: integer; begin w := 1; s := 0; while w <> 0 do begin s := s + w * 2; w := w + 1; end; writeln(s); end.",
+		"program p; var h: shortint; s: integer; begin h := 0; s := 0; while h >= 0 do begin s := s + h * 3; h := h + 1; end; writeln(s); end.",
+		"program p; var i, x: integer; begin x := 1; for i := 0 to 9 do begin x := -x; writeln(x * 4); end; end.",
+		"program p; var i: integer; b: byte; begin b := 0; for i := 0 to 9 do begin b := i; writeln(b * 4); end; end.",
+	}
+	for _, source := range sources {
+		p := build(source, Options{})
+		m, _ := NewPassManager("rotate", "mem2reg", "constfold", "licm", "strength", "dce")
+		m.Verify = true
+		m.Run(p)
+		if !strings.Contains(p.Functions[0].String(), "mul ") {
+			t.Errorf("Multiplication of a value, that isn't an induction variable, was reduced in:\n%s", p.Functions[0])
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ssa
+
+import "gitlab.fit.cvut.cz/fedorgle/gila/ast"
+
+func init() {
+	Register(NewPass("strength", ReduceStrength))
+}
+
+// ReduceStrength replaces multiplications of induction variables by constants with new induction variables.
+// An induction variable is a phi in the header of a loop, which starts with a value from the preheader
+// and changes by a constant step in every iteration, like the variable of a for loop. Its product with
+// a constant changes by the product of the step, so `i * 4` becomes a phi, to which 4 is added, instead
+// of a multiplication in every iteration. Integers wrap around, so the results are the same even on overflow.
+// Checked instructions stay, since they have to report the overflow.
+//
+// It is meant to run after mem2reg, loops with more than one latch are skipped.
+func ReduceStrength(f *Function) {
+	for _, l := range insertPreheaders(f) {
+		preheader := l.Preheader()
+		if preheader == nil || len(l.Latches) != 1 {
+			continue
+		}
+		for _, phi := range append([]*Instruction(nil), l.Header.Phis()...) {
+			step, ok := inductionStep(l, phi)
+			if !ok {
+				continue
+			}
+			for _, b := range f.Blocks {
+				if !l.Blocks[b] {
+					continue
+				}
+				for _, i := range append([]*Instruction(nil), b.Instructions...) {
+					if factor := scaled(i, phi); factor != nil {
+						reduce(l, i, phi, factor, step)
+					}
+				}
+			}
+		}
+	}
+}
+
+// inductionStep returns the constant, which is added to an induction variable in every iteration.
+func inductionStep(l *Loop, phi *Instruction) (int64, bool) {
+	if !ast.IsInteger(phi.T) {
+		return 0, false
+	}
+	next, ok := phi.Args[l.Header.PredIndex(l.Latches[0])].(*Instruction)
+	// Only an unchecked addition or subtraction of a constant steps the variable,
+	// narrower variables are converted after the addition, and negations have a single operand
+	if !ok || next.Checked || (next.Op != Add && next.Op != Sub) || len(next.Args) != 2 {
+		return 0, false
+	}
+	if c, ok := next.Args[1].(*Const); ok && next.Args[0] == phi {
+		switch next.Op {
+		case Add:
+			return c.Int(), true
+		case Sub:
+			return -c.Int(), true
+		}
+	}
+	if c, ok := next.Args[0].(*Const); ok && next.Args[1] == phi && next.Op == Add {
+		return c.Int(), true
+	}
+	return 0, false
+}
+
+// scaled returns the constant factor, when i multiplies the induction variable by a constant.
+func scaled(i *Instruction, phi *Instruction) *Const {
+	if i.Op != Mul || i.Checked {
+		return nil
+	}
+	for n, a := range i.Args {
+		if c, ok := i.Args[1-n].(*Const); ok && a == phi {
+			return c
+		}
+	}
+	return nil
+}
+
+// reduce replaces the multiplication mul of phi by a new phi, which starts with the product of the initial value
+// and grows by the product of the step.
+func reduce(l *Loop, mul, phi *Instruction, factor *Const, step int64) {
+	f, h := mul.Block.Function, l.Header
+	preheader, latch := l.Preheader(), l.Latches[0]
+	start := f.NewInstruction(Mul, phi.T, phi.Args[h.PredIndex(preheader)], factor)
+	start.Position = mul.Position
+	var initial Value = start
+	if c := Fold(start); c != nil {
+		initial = c
+	} else {
+		preheader.Insert(len(preheader.Instructions)-1, start)
+	}
+	product := f.NewInstruction(Phi, phi.T)
+	product.Position = mul.Position
+	product.Args = make([]Value, len(h.Preds))
+	next := f.NewInstruction(Add, phi.T, product, IntConst(phi.T, step*factor.Int()))
+	next.Position = mul.Position
+	latch.Insert(len(latch.Instructions)-1, next)
+	product.Args[h.PredIndex(preheader)] = initial
+	product.Args[h.PredIndex(latch)] = next
+	h.Insert(0, product)
+	f.ReplaceUses(mul, product)
+	mul.Block.Remove(mul)
+}
@@ -0,0 +1,146 @@
+package ssa
+
+import "gitlab.fit.cvut.cz/fedorgle/gila/ast"
+
+func init() {
+	Register(NewPass("tailcall", TailCalls))
+}
+
+// TailCalls turns calls of the function itself, whose result is returned right away, into jumps back
+// to its start, so recursion like `f := f(n - 1)` at the end of f runs in constant stack space.
+// Parameters become phis, which take the arguments of the call. Other calls, that are followed
+// by a return, are marked, so the backend can reuse the stack frame.
+//
+// It expects variables to be promoted by mem2reg, since results, that go through slots,
+// are not recognized.
+func TailCalls(f *Function) {
+	var self []*Instruction
+	f.Instructions(func(i *Instruction) {
+		if i.Op != Call || !tailPosition(i) {
+			return
+		}
+		switch {
+		case i.Callee == f:
+			self = append(self, i)
+		case directReturn(i) && sameSignature(i.Callee.Signature, f.Signature):
+			i.Tail = MustTail
+		default:
+			i.Tail = MayTail
+		}
+	})
+	if len(self) == 0 {
+		return
+	}
+	// The start of the function moves to a loop header, slots stay in the entry block
+	entry, header := f.Entry(), f.NewBlock()
+	copy(f.Blocks[2:], f.Blocks[1:len(f.Blocks)-1])
+	f.Blocks[1] = header
+	for _, i := range append([]*Instruction(nil), entry.Instructions...) {
+		if i.Op != Alloc {
+			entry.Remove(i)
+			header.Append(i)
+		}
+	}
+	header.Succs, entry.Succs = entry.Succs, nil
+	for _, s := range header.Succs {
+		for j, p := range s.Preds {
+			if p == entry {
+				s.Preds[j] = header
+			}
+		}
+	}
+	entry.Append(f.NewInstruction(Jump, ast.VOID))
+	AddEdge(entry, header)
+	var phis []*Instruction
+	for _, p := range f.Parameters {
+		phi := f.NewInstruction(Phi, p.T)
+		phi.Position = f.Position
+		f.ReplaceUses(p, phi)
+		phi.Args = []Value{p}
+		header.Insert(len(phis), phi)
+		phis = append(phis, phi)
+	}
+	for _, call := range self {
+		b := call.Block
+		for i := len(b.Instructions) - 1; i >= 0; i-- {
+			last := b.Instructions[i]
+			b.Remove(last)
+			if last == call {
+				break
+			}
+		}
+		for len(b.Succs) > 0 {
+			RemoveEdge(b, b.Succs[0])
+		}
+		jump := f.NewInstruction(Jump, ast.VOID)
+		jump.Position = call.Position
+		b.Append(jump)
+		AddEdge(b, header)
+		for j, phi := range phis {
+			phi.Args = append(phi.Args, call.Args[j])
+		}
+	}
+}
+
+// tailPosition reports whether the function returns right after a call, with its result, if it has one.
+// Control may pass through jumps and phis, which only forward the result.
+func tailPosition(call *Instruction) bool {
+	result := Value(call)
+	b := call.Block
+	rest := b.Instructions[indexOf(b, call)+1:]
+	visited := make(map[*Block]bool)
+	for !visited[b] {
+		visited[b] = true
+		if len(rest) != 1 {
+			return false
+		}
+		switch t := rest[0]; t.Op {
+		case Return:
+			if len(t.Args) == 0 {
+				return call.T == ast.VOID
+			}
+			return t.Args[0] == result
+		case Jump:
+			s := t.Block.Succs[0]
+			index := s.PredIndex(b)
+			phis := s.Phis()
+			for _, phi := range phis {
+				if phi.Args[index] == result {
+					result = phi
+					break
+				}
+			}
+			b, rest = s, s.Instructions[len(phis):]
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// directReturn reports whether a call is followed by a return of its result in the same block.
+func directReturn(call *Instruction) bool {
+	rest := call.Block.Instructions[indexOf(call.Block, call)+1:]
+	return call.T != ast.VOID && len(rest) == 1 && rest[0].Op == Return && rest[0].Args[0] == call
+}
+
+func sameSignature(a, b *ast.Signature) bool {
+	if a.Return != b.Return || len(a.Parameters) != len(b.Parameters) || a.Varargs != b.Varargs {
+		return false
+	}
+	for i, p := range a.Parameters {
+		if p.Type != b.Parameters[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+func indexOf(b *Block, i *Instruction) int {
+	for n, x := range b.Instructions {
+		if x == i {
+			return n
+		}
+	}
+	return -1
+}
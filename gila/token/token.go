@@ -48,77 +48,162 @@ const (
 	OR
 	AND
 	STRLIT
+	LBRACKET
+	RBRACKET
+	DOTDOT
+	SET
+	OF
+	IN
+	NOT
+	XOR
+	SHL
+	SHR
+	BYTE
+	SHORTINT
+	WORD
+	LONGINT
+	INT64
+	CARDINAL
+	REAL
+	REALLIT
+	SLASH
+	INLINE
+	EXTERNAL
+	CDECL
+	VARARGS
+	STRING
+	LIBRARY
+	EXPORTS
+	UNIT
+	INTERFACE
+	IMPLEMENTATION
+	USES
 )
 
 var tokens = []string{
-	EOF:       "eof",
-	IDENT:     "identifier",
-	NUMBER:    "number",
-	PLUS:      "+",
-	MINUS:     "-",
-	PROGRAM:   "program",
-	BEGIN:     "begin",
-	END:       "end",
-	LPAREN:    "(",
-	RPAREN:    ")",
-	COLON:     ":",
-	SEMICOLON: ";",
-	CONST:     "const",
-	VAR:       "var",
-	DOT:       ".",
-	EQUALS:    "=",
-	LESS:      "<",
-	GREATER:   ">",
-	NOTEQUALS: "<>",
-	LESSEQ:    "<=",
-	GREATEREQ: ">=",
-	MULTIPLY:  "*",
-	COMA:      ",",
-	ASSIGN:    ":=",
-	FUNCTION:  "function",
-	PROCEDURE: "procedure",
-	FORWARD:   "forward",
-	INTEGER:   "integer",
-	IF:        "if",
-	THEN:      "then",
-	ELSE:      "else",
-	DIV:       "div",
-	MOD:       "mod",
-	WHILE:     "while",
-	DO:        "do",
-	BREAK:     "break",
-	EXIT:      "exit",
-	FOR:       "for",
-	TO:        "to",
-	DOWNTO:    "downto",
-	AND:       "and",
-	OR:        "or",
+	EOF:            "eof",
+	IDENT:          "identifier",
+	NUMBER:         "number",
+	PLUS:           "+",
+	MINUS:          "-",
+	PROGRAM:        "program",
+	BEGIN:          "begin",
+	END:            "end",
+	LPAREN:         "(",
+	RPAREN:         ")",
+	COLON:          ":",
+	SEMICOLON:      ";",
+	CONST:          "const",
+	VAR:            "var",
+	DOT:            ".",
+	EQUALS:         "=",
+	LESS:           "<",
+	GREATER:        ">",
+	NOTEQUALS:      "<>",
+	LESSEQ:         "<=",
+	GREATEREQ:      ">=",
+	MULTIPLY:       "*",
+	COMA:           ",",
+	ASSIGN:         ":=",
+	FUNCTION:       "function",
+	PROCEDURE:      "procedure",
+	FORWARD:        "forward",
+	INTEGER:        "integer",
+	IF:             "if",
+	THEN:           "then",
+	ELSE:           "else",
+	DIV:            "div",
+	MOD:            "mod",
+	WHILE:          "while",
+	DO:             "do",
+	BREAK:          "break",
+	EXIT:           "exit",
+	FOR:            "for",
+	TO:             "to",
+	DOWNTO:         "downto",
+	AND:            "and",
+	OR:             "or",
+	LBRACKET:       "[",
+	RBRACKET:       "]",
+	DOTDOT:         "..",
+	SET:            "set",
+	OF:             "of",
+	IN:             "in",
+	NOT:            "not",
+	XOR:            "xor",
+	SHL:            "shl",
+	SHR:            "shr",
+	BYTE:           "byte",
+	SHORTINT:       "shortint",
+	WORD:           "word",
+	LONGINT:        "longint",
+	INT64:          "int64",
+	CARDINAL:       "cardinal",
+	REAL:           "real",
+	REALLIT:        "real number",
+	SLASH:          "/",
+	INLINE:         "inline",
+	EXTERNAL:       "external",
+	CDECL:          "cdecl",
+	VARARGS:        "varargs",
+	STRING:         "string",
+	LIBRARY:        "library",
+	EXPORTS:        "exports",
+	UNIT:           "unit",
+	INTERFACE:      "interface",
+	IMPLEMENTATION: "implementation",
+	USES:           "uses",
 }
 
 var keywords = map[string]Type{
-	tokens[PROGRAM]:   PROGRAM,
-	tokens[BEGIN]:     BEGIN,
-	tokens[END]:       END,
-	tokens[CONST]:     CONST,
-	tokens[VAR]:       VAR,
-	tokens[FUNCTION]:  FUNCTION,
-	tokens[PROCEDURE]: PROCEDURE,
-	tokens[FORWARD]:   FORWARD,
-	tokens[INTEGER]:   INTEGER,
-	tokens[WHILE]:     WHILE,
-	tokens[IF]:        IF,
-	tokens[THEN]:      THEN,
-	tokens[ELSE]:      ELSE,
-	tokens[MOD]:       MOD,
-	tokens[DIV]:       DIV,
-	tokens[DO]:        DO,
-	tokens[BREAK]:     BREAK,
-	tokens[EXIT]:      EXIT,
-	tokens[FOR]:       FOR,
-	tokens[TO]:        TO,
-	tokens[DOWNTO]:    DOWNTO,
-	tokens[AND]:       AND,
-	tokens[OR]:        OR,
+	tokens[PROGRAM]:        PROGRAM,
+	tokens[BEGIN]:          BEGIN,
+	tokens[END]:            END,
+	tokens[CONST]:          CONST,
+	tokens[VAR]:            VAR,
+	tokens[FUNCTION]:       FUNCTION,
+	tokens[PROCEDURE]:      PROCEDURE,
+	tokens[FORWARD]:        FORWARD,
+	tokens[INTEGER]:        INTEGER,
+	tokens[WHILE]:          WHILE,
+	tokens[IF]:             IF,
+	tokens[THEN]:           THEN,
+	tokens[ELSE]:           ELSE,
+	tokens[MOD]:            MOD,
+	tokens[DIV]:            DIV,
+	tokens[DO]:             DO,
+	tokens[BREAK]:          BREAK,
+	tokens[EXIT]:           EXIT,
+	tokens[FOR]:            FOR,
+	tokens[TO]:             TO,
+	tokens[DOWNTO]:         DOWNTO,
+	tokens[AND]:            AND,
+	tokens[OR]:             OR,
+	tokens[SET]:            SET,
+	tokens[OF]:             OF,
+	tokens[IN]:             IN,
+	tokens[NOT]:            NOT,
+	tokens[XOR]:            XOR,
+	tokens[SHL]:            SHL,
+	tokens[SHR]:            SHR,
+	tokens[BYTE]:           BYTE,
+	tokens[SHORTINT]:       SHORTINT,
+	tokens[WORD]:           WORD,
+	tokens[LONGINT]:        LONGINT,
+	tokens[INT64]:          INT64,
+	tokens[CARDINAL]:       CARDINAL,
+	tokens[REAL]:           REAL,
+	tokens[INLINE]:         INLINE,
+	tokens[EXTERNAL]:       EXTERNAL,
+	tokens[CDECL]:          CDECL,
+	tokens[VARARGS]:        VARARGS,
+	tokens[STRING]:         STRING,
+	tokens[LIBRARY]:        LIBRARY,
+	tokens[EXPORTS]:        EXPORTS,
+	tokens[UNIT]:           UNIT,
+	tokens[INTERFACE]:      INTERFACE,
+	tokens[IMPLEMENTATION]: IMPLEMENTATION,
+	tokens[USES]:           USES,
 }
 
 type Type int
@@ -0,0 +1,190 @@
+package units
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// InterfaceExtension is the extension of interface files, which are stored in a Cache next to the objects of units.
+const InterfaceExtension = ".gli"
+
+// interfaceVersion changes with the format of interface files and with the code generated for units,
+// so that a new version of gila doesn't reuse the objects built by an older one.
+const interfaceVersion = 1
+
+// Interface is what programs need to know about a compiled unit: the signatures of the functions it exports
+// and the libraries, it is linked with. It lets them be type checked without parsing the source of the unit.
+type Interface struct {
+	Version int
+	Name    string
+	// Source is the hash of the source file. Hash also covers the options, the unit was compiled with,
+	// and the interfaces of the units it uses, the unit is recompiled, when it changes.
+	Source    string
+	Hash      string
+	Uses      []string
+	Functions []Function
+	Libraries []string
+}
+
+// Function is the signature of an exported function. Types are written the way ast prints them.
+type Function struct {
+	Name       string
+	Parameters []Parameter
+	Return     string
+	Position   token.Position
+}
+
+type Parameter struct {
+	Name string
+	Type string
+}
+
+// NewInterface describes a parsed unit. source and hash become the Source and the Hash of the interface.
+func NewInterface(program *ast.Program, source, hash string) *Interface {
+	i := &Interface{Version: interfaceVersion, Name: program.Name, Source: source, Hash: hash, Uses: program.Uses}
+	for _, name := range program.Exports {
+		f := declaration(program, name)
+		function := Function{Name: name, Return: fmt.Sprint(f.Signature.Return), Position: f.Position}
+		for _, p := range f.Signature.Parameters {
+			function.Parameters = append(function.Parameters, Parameter{p.Name, fmt.Sprint(p.Type)})
+		}
+		i.Functions = append(i.Functions, function)
+	}
+	for _, f := range program.Functions {
+		if library := f.Signature.Library; library != "" && !contains(i.Libraries, library) {
+			i.Libraries = append(i.Libraries, library)
+		}
+	}
+	return i
+}
+
+// Declarations returns body-less declarations of the exported functions, which are imported into programs.
+func (i *Interface) Declarations() ([]*ast.Function, error) {
+	var functions []*ast.Function
+	for _, f := range i.Functions {
+		signature := ast.Signature{Name: f.Name}
+		var err error
+		if signature.Return, err = parseType(f.Return); err != nil {
+			return nil, err
+		}
+		for _, p := range f.Parameters {
+			t, err := parseType(p.Type)
+			if err != nil {
+				return nil, err
+			}
+			signature.Parameters = append(signature.Parameters, ast.Variable{Name: p.Name, Type: t})
+		}
+		functions = append(functions, &ast.Function{
+			Signature: &signature,
+			Variables: make(map[string]ast.Type),
+			Constants: make(map[string]ast.Expression),
+			Position:  f.Position,
+		})
+	}
+	return functions, nil
+}
+
+// exports returns the hash of the exported functions. Units, which use a unit, only depend on its exports,
+// they are not recompiled, when just the implementation of the unit changes, or its declarations move.
+func (i *Interface) exports() string {
+	var signatures []Function
+	for _, f := range i.Functions {
+		f.Position = token.Position{}
+		signatures = append(signatures, f)
+	}
+	data, err := json.Marshal(signatures)
+	if err != nil {
+		panic(err)
+	}
+	return hash(data)
+}
+
+// basicTypes are the types, that can appear in signatures, by their names.
+var basicTypes = map[string]ast.Type{}
+
+func init() {
+	for b := ast.INT; b <= ast.INT64; b++ {
+		basicTypes[b.String()] = b
+	}
+}
+
+func parseType(s string) (ast.Type, error) {
+	if t, ok := basicTypes[s]; ok {
+		return t, nil
+	}
+	var set ast.Set
+	if _, err := fmt.Sscanf(s, "set of %d..%d", &set.Low, &set.High); err != nil {
+		return nil, fmt.Errorf("Invalid type %q.", s)
+	}
+	return set, nil
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache keeps the objects and the interfaces of compiled units in Dir. Options identify the way units are compiled,
+// objects compiled with other options are not reused.
+type Cache struct {
+	Dir     string
+	Options string
+}
+
+// entry returns the path of the files of a unit in the cache without an extension. Units of the same name
+// from different directories get different entries.
+func (c *Cache) entry(name, file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	return filepath.Join(c.Dir, name+"-"+hash([]byte(file))[:16])
+}
+
+// Object returns the path of the object file of a unit.
+func (c *Cache) Object(u *Unit) string {
+	return c.entry(u.Interface.Name, u.File) + ".o"
+}
+
+// Store writes the interface of a unit, whose object has been compiled, so the next build can reuse it.
+func (c *Cache) Store(u *Unit) error {
+	data, err := json.MarshalIndent(u.Interface, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.entry(u.Interface.Name, u.File)+InterfaceExtension, data, 0644)
+}
+
+// load returns the interface of a unit stored in the cache, or nil, when there is none, or when it is unreadable.
+func (c *Cache) load(name, file string) *Interface {
+	data, err := ioutil.ReadFile(c.entry(name, file) + InterfaceExtension)
+	if err != nil {
+		return nil
+	}
+	var i Interface
+	if err := json.Unmarshal(data, &i); err != nil || i.Version != interfaceVersion {
+		return nil
+	}
+	return &i
+}
+
+// remove deletes a stale interface, so it is never paired with the object of a newer version of the unit,
+// when its compilation fails.
+func (c *Cache) remove(name, file string) {
+	os.Remove(c.entry(name, file) + InterfaceExtension)
+}
+
+// compiled reports whether the object of a unit in the cache is up to date with its interface.
+func (c *Cache) compiled(u *Unit) bool {
+	info, err := os.Stat(c.Object(u))
+	return err == nil && !info.IsDir()
+}
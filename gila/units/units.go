@@ -0,0 +1,249 @@
+// Package units finds the units used by a program, so they can be compiled separately and linked with it.
+// A unit named u is read from the file u.mila in the first directory of the search path, that has one.
+package units
+
+import (
+	"bytes"
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Extension is the extension of source files of units.
+const Extension = ".mila"
+
+// Unit is a unit used by a program and the file, from which it was read. Program is nil for units, whose object
+// in the cache is up to date, the program only needs their Interface.
+type Unit struct {
+	File      string
+	Program   *ast.Program
+	Interface *Interface
+	// Compiled units don't have to be compiled again, their object is in the cache
+	Compiled bool
+}
+
+// Error is an error in a unit, or in a uses clause, which names a unit, that can't be used.
+type Error struct {
+	File    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.File, e.Message)
+}
+
+// Resolver finds units in the directories of Paths, which are searched in order. Units compiled into Cache,
+// whose sources and used units didn't change since, are not parsed, when Cache is not nil.
+type Resolver struct {
+	Paths []string
+	Cache *Cache
+}
+
+// Resolve parses the units used by a program, directly or by the units it uses. Every unit comes after the units
+// it uses, so they can be compiled in order. file is the source of the program, errors in its uses clause refer to it.
+func (r *Resolver) Resolve(file string, program *ast.Program) ([]*Unit, error) {
+	res := &resolution{resolver: r, units: make(map[string]*Unit), using: make(map[string]bool)}
+	if program.Unit {
+		res.using[program.Name] = true
+	}
+	err := res.use(file, program.Name, program.Uses)
+	return res.order, err
+}
+
+// resolution is the state of a single Resolve. using are the units, whose uses are being resolved,
+// a unit, which uses one of them, would make a cycle.
+type resolution struct {
+	resolver *Resolver
+	units    map[string]*Unit
+	order    []*Unit
+	using    map[string]bool
+}
+
+// use resolves the units used by the program or the unit name read from file.
+func (r *resolution) use(file, name string, uses []string) error {
+	for _, used := range uses {
+		if r.using[used] {
+			return &Error{file, fmt.Sprintf("Unit %s uses itself through %s.", used, name)}
+		}
+		if _, ok := r.units[used]; ok {
+			continue
+		}
+		path, err := r.resolver.Find(used)
+		if err != nil {
+			return &Error{file, err.Error()}
+		}
+		unit, err := r.unit(used, path)
+		if err != nil {
+			return err
+		}
+		r.units[used] = unit
+		r.order = append(r.order, unit)
+	}
+	return nil
+}
+
+// unit resolves a single unit after the units it uses. The unit is parsed, unless the cache has an interface
+// for the same source, whose hash shows, that neither the options nor the interfaces of the used units changed.
+func (r *resolution) unit(name, path string) (*Unit, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := hash(source)
+	cache := r.resolver.Cache
+	var cached *Interface
+	if cache != nil {
+		if cached = cache.load(name, path); cached != nil && cached.Source != sum {
+			cached = nil
+		}
+	}
+	var program *ast.Program
+	var uses []string
+	if cached != nil {
+		uses = cached.Uses
+	} else {
+		if program, err = parseSource(path, source); err != nil {
+			return nil, err
+		}
+		if !program.Unit || program.Name != name {
+			return nil, &Error{path, fmt.Sprintf("Expected unit %s, found %s.", name, program.Name)}
+		}
+		uses = program.Uses
+	}
+	r.using[name] = true
+	if err := r.use(path, name, uses); err != nil {
+		return nil, err
+	}
+	delete(r.using, name)
+	key := r.hash(sum, uses)
+	unit := &Unit{File: path, Interface: cached}
+	if cached != nil && cached.Hash == key && cache.compiled(unit) {
+		unit.Compiled = true
+		return unit, nil
+	}
+	if program == nil {
+		if program, err = parseSource(path, source); err != nil {
+			return nil, err
+		}
+	}
+	if cache != nil {
+		cache.remove(name, path)
+	}
+	unit.Program, unit.Interface = program, NewInterface(program, sum, key)
+	return unit, nil
+}
+
+// hash identifies a compilation of a unit with the source hashed to sum, which uses units, whose interfaces
+// have already been resolved.
+func (r *resolution) hash(sum string, uses []string) string {
+	h := fmt.Sprintf("gila unit %d\n", interfaceVersion)
+	if r.resolver.Cache != nil {
+		h += r.resolver.Cache.Options + "\n"
+	}
+	h += sum + "\n"
+	for _, name := range uses {
+		h += name + " " + r.units[name].Interface.exports() + "\n"
+	}
+	return hash([]byte(h))
+}
+
+// Find returns the source file of a unit.
+func (r *Resolver) Find(name string) (string, error) {
+	for _, dir := range r.Paths {
+		path := filepath.Join(dir, name+Extension)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("Unit %s not found in %s.", name, strings.Join(r.Paths, ", "))
+}
+
+// Parse reads a source file. Errors in it are returned as an *Error.
+func Parse(file string) (*ast.Program, error) {
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseSource(file, source)
+}
+
+func parseSource(file string, source []byte) (program *ast.Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			program, err = nil, &Error{file, fmt.Sprint(r)}
+		}
+	}()
+	return parser.New(lexer.New(bytes.NewReader(source))).Parse(), nil
+}
+
+// Import declares the functions exported by the units, which a program uses, so that the program can be
+// type checked and compiled on its own. units are the result of Resolve, which also has the units used indirectly,
+// and may contain the program itself, when it is a unit. Functions of the program, that would clash with exported
+// ones when they are linked, are reported by a panic.
+func Import(program *ast.Program, units []*Unit) {
+	exporter := make(map[string]string)
+	byName := make(map[string]*Unit)
+	for _, u := range units {
+		if u.Program == program {
+			continue
+		}
+		byName[u.Interface.Name] = u
+		for _, f := range u.Interface.Functions {
+			if other, ok := exporter[f.Name]; ok {
+				panic(fmt.Sprintf("Function %s is exported by units %s and %s.", f.Name, other, u.Interface.Name))
+			}
+			exporter[f.Name] = u.Interface.Name
+		}
+	}
+	uses := make(map[string]bool)
+	for _, name := range program.Uses {
+		uses[name] = true
+	}
+	for _, f := range program.Functions {
+		name := f.Signature.Name
+		unit, ok := exporter[name]
+		// Functions of units and libraries are private, unless they are exported
+		global := !(program.Unit || program.Library) || contains(program.Exports, name)
+		if ok && (global || uses[unit]) {
+			panic(fmt.Sprintf("Function %s is already exported by unit %s.", name, unit))
+		}
+	}
+	var imported []*ast.Function
+	for _, name := range program.Uses {
+		u := byName[name]
+		declarations, err := u.Interface.Declarations()
+		if err != nil {
+			panic(fmt.Sprintf("Interface of unit %s is invalid: %v", name, err))
+		}
+		imported = append(imported, declarations...)
+	}
+	program.Functions = append(imported, program.Functions...)
+}
+
+// declaration returns the first declaration of a function, which is in the interface section of a unit.
+func declaration(program *ast.Program, name string) *ast.Function {
+	for _, f := range program.Functions {
+		if f.Signature.Name == name {
+			return f
+		}
+	}
+	panic(fmt.Sprintf("Unit %s exports an undeclared function %s.", program.Name, name))
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
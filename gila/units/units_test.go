@@ -0,0 +1,213 @@
+package units
+
+import (
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parse(source string) *ast.Program {
+	return parser.New(lexer.New(strings.NewReader(source))).Parse()
+}
+
+// writeUnits creates a directory with a file for every unit.
+func writeUnits(t *testing.T, sources map[string]string) string {
+	dir, err := ioutil.TempDir("", "units")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, source := range sources {
+		ioutil.WriteFile(filepath.Join(dir, name+Extension), []byte(source), 0644)
+	}
+	return dir
+}
+
+func Test_Resolve(t *testing.T) {
+	dir := writeUnits(t, map[string]string{
+		"a": "unit a; interface uses b, c; procedure pa(); implementation procedure pa(); begin pb(); end; end.",
+		"b": "unit b; interface uses c; procedure pb(); implementation procedure pb(); begin pc(); end; end.",
+		"c": "unit c; interface procedure pc(); implementation procedure pc(); begin writeln(1); end; end.",
+	})
+	defer os.RemoveAll(dir)
+	other := writeUnits(t, map[string]string{
+		"c": "unit c; interface implementation end.",
+		"d": "unit d; interface function d(): integer; implementation function d(): integer; begin d := 1; end; end.",
+	})
+	defer os.RemoveAll(other)
+	r := &Resolver{Paths: []string{dir, other}}
+	program := parse("program p; uses a, d; begin pa(); writeln(d()); end.")
+	units, err := r.Resolve("p.mila", program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, u := range units {
+		order = append(order, u.Program.Name)
+	}
+	if strings.Join(order, " ") != "c b a d" {
+		t.Errorf("Units are resolved in the order %v", order)
+	}
+	if units[0].File != filepath.Join(dir, "c.mila") {
+		t.Errorf("Unit c was read from %s", units[0].File)
+	}
+	Import(program, units)
+	var functions []string
+	for _, f := range program.Functions {
+		functions = append(functions, f.Signature.Name)
+	}
+	if strings.Join(functions, " ") != "pa d main" {
+		t.Errorf("Program declares %v after import", functions)
+	}
+}
+
+func Test_ResolveErrors(t *testing.T) {
+	dir := writeUnits(t, map[string]string{
+		"a":      "unit a; interface uses b; implementation end.",
+		"b":      "unit b; interface uses a; implementation end.",
+		"broken": "unit broken; interface procedure p(); end.",
+		"named":  "unit other; interface implementation end.",
+	})
+	defer os.RemoveAll(dir)
+	r := &Resolver{Paths: []string{dir}}
+	invalid := map[string]string{
+		"program p; uses a; begin end.":       "b.mila: Unit a uses itself through b.",
+		"program p; uses missing; begin end.": "p.mila: Unit missing not found in ",
+		"program p; uses broken; begin end.":  "broken.mila: ",
+		"program p; uses named; begin end.":   "named.mila: Expected unit named, found other.",
+	}
+	for source, message := range invalid {
+		_, err := r.Resolve("p.mila", parse(source))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Resolving %q failed with %v, expected %q", source, err, message)
+		}
+	}
+}
+
+func Test_ImportClashes(t *testing.T) {
+	dir := writeUnits(t, map[string]string{
+		"a": "unit a; interface procedure p(); implementation procedure p(); begin end; end.",
+		"b": "unit b; interface procedure p(); implementation procedure p(); begin end; end.",
+		"c": "unit c; interface procedure q(); implementation procedure p(); begin end; procedure q(); begin end; end.",
+	})
+	defer os.RemoveAll(dir)
+	r := &Resolver{Paths: []string{dir}}
+	cases := []struct {
+		source string
+		clash  bool
+	}{
+		{"program m; uses c; procedure p(); begin end; begin q(); end.", false},
+		{"unit u; interface uses c; procedure q2(); implementation procedure q2(); begin q(); end; end.", false},
+		{"program m; uses a, b; begin end.", true},
+		{"program m; uses a; procedure p(); begin end; begin end.", true},
+		{"unit u; interface uses a; implementation procedure p(); begin end; end.", true},
+	}
+	for _, c := range cases {
+		program := parse(c.source)
+		units, err := r.Resolve("m.mila", program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clash := func() (clash bool) {
+			defer func() {
+				clash = recover() != nil
+			}()
+			Import(program, units)
+			return
+		}()
+		if clash != c.clash {
+			t.Errorf("Import into %q reported a clash: %v", c.source, clash)
+		}
+	}
+}
+
+func Test_Cache(t *testing.T) {
+	dir := writeUnits(t, map[string]string{
+		"a": "unit a; interface uses b; procedure pa(); implementation procedure pa(); begin pb(); end; end.",
+		"b": "unit b; interface function pb(s: set of 1..10): int64; implementation function pb(s: set of 1..10): int64; begin pb := 1; end; end.",
+	})
+	defer os.RemoveAll(dir)
+	cache := &Cache{Dir: filepath.Join(dir, "cache"), Options: "-O1"}
+	os.Mkdir(cache.Dir, 0755)
+	r := &Resolver{Paths: []string{dir}, Cache: cache}
+	// compile stands for the compilation of the parsed units into the cache, it returns their names
+	compile := func(options string) []string {
+		cache.Options = options
+		units, err := r.Resolve("p.mila", parse("program p; uses a, b; begin pa(); end."))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var compiled []string
+		for _, u := range units {
+			if u.Compiled != (u.Program == nil) {
+				t.Errorf("Unit %s is compiled: %v, but it was parsed: %v", u.Interface.Name, u.Compiled, u.Program != nil)
+			}
+			if u.Compiled {
+				continue
+			}
+			compiled = append(compiled, u.Interface.Name)
+			ioutil.WriteFile(cache.Object(u), nil, 0644)
+			if err := cache.Store(u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return compiled
+	}
+	rebuild := func(options string, expected string) {
+		if compiled := strings.Join(compile(options), " "); compiled != expected {
+			t.Errorf("Units %q were compiled, expected %q", compiled, expected)
+		}
+	}
+	rebuild("-O1", "b a")
+	rebuild("-O1", "")
+	rebuild("-O2", "b a")
+	ioutil.WriteFile(filepath.Join(dir, "b.mila"), []byte("unit b; interface function pb(s: set of 1..10): int64; implementation function pb(s: set of 1..10): int64; begin pb := 2; end; end."), 0644)
+	rebuild("-O2", "b")
+	ioutil.WriteFile(filepath.Join(dir, "b.mila"), []byte("unit b; interface function pb(): int64; implementation function pb(): int64; begin pb := 2; end; end."), 0644)
+	rebuild("-O2", "b a")
+	os.Remove(cache.Object(&Unit{File: filepath.Join(dir, "a.mila"), Interface: &Interface{Name: "a"}}))
+	rebuild("-O2", "a")
+
+	program := parse("program p; uses b; begin writeln(pb()); end.")
+	units, err := r.Resolve("p.mila", program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Import(program, units)
+	if s := program.Functions[0].Signature; s.Name != "pb" || s.Return != ast.INT64 || len(s.Parameters) != 0 {
+		t.Errorf("Unit b was imported from its interface as %+v", s)
+	}
+}
+
+func Test_Interface(t *testing.T) {
+	program := parse("unit u; interface function f(s: set of 3..7; b: byte): real; procedure g(); implementation " +
+		"function c(): integer; external 'm'; function f(s: set of 3..7; b: byte): real; begin f := 1.5; end; procedure g(); begin end; end.")
+	i := NewInterface(program, "source", "hash")
+	if strings.Join(i.Libraries, " ") != "m" {
+		t.Errorf("Unit is linked with %v", i.Libraries)
+	}
+	declarations, err := i.Declarations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, d := range declarations {
+		f := program.Functions[n].Signature
+		if d.Signature.Name != f.Name || d.Signature.Return != f.Return || len(d.Signature.Parameters) != len(f.Parameters) {
+			t.Errorf("Declaration %+v differs from %+v", d.Signature, f)
+			continue
+		}
+		for k, p := range d.Signature.Parameters {
+			if p != f.Parameters[k] {
+				t.Errorf("Parameter %+v differs from %+v", p, f.Parameters[k])
+			}
+		}
+	}
+	i.Functions[0].Return = "string of 3"
+	if _, err := i.Declarations(); err == nil {
+		t.Error("Declarations accepted an invalid type")
+	}
+}
@@ -0,0 +1,226 @@
+// Package vm compiles programs into a compact bytecode and executes it on a stack machine.
+// Compiled programs can be serialized into .gbc files and run without the LLVM toolchain,
+// which makes the language embeddable into Go programs:
+//
+//	p := vm.Compile(program, vm.Options{})
+//	err := vm.Run(p, os.Stdin, os.Stdout)
+//
+// The machine follows the semantics of the code emitted by the ir package, the same way as the interp package does.
+package vm
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+)
+
+// Opcode is an instruction of the machine. Values are kept on a stack of 64-bit slots:
+// integers are extended to int64 according to their type, reals are stored as their bits,
+// booleans are 0 or 1 and sets take setSlots slots.
+type Opcode byte
+
+const (
+	// OpConst pushes Arg.
+	OpConst Opcode = iota
+	// OpLoad pushes the local slot Arg, OpStore pops a value into it.
+	OpLoad
+	OpStore
+	// OpLoadSet and OpStoreSet move a set from and to the local slots starting at Arg.
+	OpLoadSet
+	OpStoreSet
+	// OpPop discards Arg slots.
+	OpPop
+	// OpWrap truncates an integer to the Kind in Arg.
+	OpWrap
+	// OpToReal converts an integer to a real.
+	OpToReal
+
+	// Integer operations. Arg holds the Kind of the operands, arithmetic instructions may have the checked flag set.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpNeg
+	OpAnd
+	OpOr
+	OpXor
+	OpNot
+	OpShl
+	OpShr
+
+	// Comparisons of integers and booleans push a boolean.
+	OpEq
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+
+	OpRealAdd
+	OpRealSub
+	OpRealMul
+	OpRealDiv
+	OpRealNeg
+	OpRealEq
+	OpRealNe
+	OpRealLt
+	OpRealLe
+	OpRealGt
+	OpRealGe
+
+	OpBoolNot
+
+	// OpSetEmpty pushes an empty set.
+	OpSetEmpty
+	// OpSetRange pops the high and low bound and includes the range into the set below them.
+	OpSetRange
+	// OpSetTruncate drops elements of a set, which are not below the capacity in Arg.
+	OpSetTruncate
+	// OpSetIn pops a set and an element and pushes whether the set contains the element.
+	OpSetIn
+	OpSetUnion
+	OpSetIntersect
+	OpSetDiff
+	OpSetEq
+	OpSetNe
+	OpSetSubset
+	OpSetSuperset
+
+	// OpJump continues at the instruction Arg, OpJumpIfFalse does so, when the popped boolean is false.
+	OpJump
+	OpJumpIfFalse
+	// OpCall pops the arguments and calls the function Arg. The result of the function is pushed, when it returns.
+	OpCall
+	OpReturn
+
+	// OpWriteInteger, OpWriteBoolean and OpWriteString pop the field width and a value. OpWriteReal pops a precision as well.
+	// The value of OpWriteString is the string Arg, so it is not on the stack.
+	OpWriteInteger
+	OpWriteReal
+	OpWriteBoolean
+	OpWriteString
+	OpWriteNewline
+	// OpReadInteger and OpReadReal replace the current value of a variable on the stack with a number read from the input.
+	// OpReadInteger truncates the number to the Kind in Arg.
+	OpReadInteger
+	OpReadReal
+	OpReadNewline
+
+	opcodeCount
+)
+
+// Kind is the type of integer operands. It is encoded into the bytecode, so it doesn't depend on ast.Basic.
+type Kind byte
+
+const (
+	KindInt Kind = iota
+	KindByte
+	KindShortint
+	KindWord
+	KindCardinal
+	KindInt64
+)
+
+// Checked is set in Arg of arithmetic instructions, that stop the program on overflow.
+const Checked = 1 << 8
+
+// setSlots is the number of slots taken by a set.
+const setSlots = 4
+
+// Instruction is an opcode with its argument. Most opcodes ignore the argument.
+type Instruction struct {
+	Op  Opcode
+	Arg int64
+}
+
+// Position maps the instruction at PC to the position of the source code, that it was compiled from.
+// Positions are only kept for instructions, that can stop the program with a runtime error.
+type Position struct {
+	PC       int
+	Position token.Position
+}
+
+// Function is a compiled function. Its local slots hold the parameters first, followed by the result and variables.
+type Function struct {
+	Name string
+	// Params and Result are the number of slots taken by the parameters and the result.
+	Params, Result int
+	// Locals is the number of all local slots.
+	Locals    int
+	Code      []Instruction
+	Positions []Position
+}
+
+// Program is a compiled program. Functions[Entry] is the main program.
+type Program struct {
+	Strings   []string
+	Functions []*Function
+	Entry     int
+}
+
+// position returns the position of the instruction at pc.
+func (f *Function) position(pc int) token.Position {
+	for _, p := range f.Positions {
+		if p.PC == pc {
+			return p.Position
+		}
+	}
+	return token.Position{}
+}
+
+// width is the number of bits of an integer kind, the same as in its LLVM representation.
+func (k Kind) width() uint {
+	switch k {
+	case KindByte, KindShortint:
+		return 8
+	case KindWord:
+		return 16
+	case KindInt64:
+		return 64
+	default:
+		return 32
+	}
+}
+
+func (k Kind) unsigned() bool {
+	return k == KindByte || k == KindWord || k == KindCardinal
+}
+
+// wrap truncates an integer to the width of kind k and extends it back according to its sign.
+func (k Kind) wrap(x int64) int64 {
+	shift := 64 - k.width()
+	if k.unsigned() {
+		return int64(uint64(x<<shift) >> shift)
+	}
+	return x << shift >> shift
+}
+
+// min returns the smallest value of a signed kind k.
+func (k Kind) min() int64 {
+	return -1 << (k.width() - 1)
+}
+
+func (op Opcode) String() string {
+	if op < opcodeCount {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("Opcode(%d)", byte(op))
+}
+
+var opcodeNames = [...]string{
+	OpConst: "const", OpLoad: "load", OpStore: "store", OpLoadSet: "loadset", OpStoreSet: "storeset",
+	OpPop: "pop", OpWrap: "wrap", OpToReal: "toreal",
+	OpAdd: "add", OpSub: "sub", OpMul: "mul", OpDiv: "div", OpMod: "mod", OpNeg: "neg",
+	OpAnd: "and", OpOr: "or", OpXor: "xor", OpNot: "not", OpShl: "shl", OpShr: "shr",
+	OpEq: "eq", OpNe: "ne", OpLt: "lt", OpLe: "le", OpGt: "gt", OpGe: "ge",
+	OpRealAdd: "fadd", OpRealSub: "fsub", OpRealMul: "fmul", OpRealDiv: "fdiv", OpRealNeg: "fneg",
+	OpRealEq: "feq", OpRealNe: "fne", OpRealLt: "flt", OpRealLe: "fle", OpRealGt: "fgt", OpRealGe: "fge",
+	OpBoolNot:  "boolnot",
+	OpSetEmpty: "setempty", OpSetRange: "setrange", OpSetTruncate: "settruncate", OpSetIn: "setin",
+	OpSetUnion: "setunion", OpSetIntersect: "setintersect", OpSetDiff: "setdiff",
+	OpSetEq: "seteq", OpSetNe: "setne", OpSetSubset: "setsubset", OpSetSuperset: "setsuperset",
+	OpJump: "jump", OpJumpIfFalse: "jumpiffalse", OpCall: "call", OpReturn: "return",
+	OpWriteInteger: "writeinteger", OpWriteReal: "writereal", OpWriteBoolean: "writeboolean",
+	OpWriteString: "writestring", OpWriteNewline: "writenewline",
+	OpReadInteger: "readinteger", OpReadReal: "readreal", OpReadNewline: "readnewline",
+}
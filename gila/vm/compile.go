@@ -0,0 +1,560 @@
+package vm
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"math"
+)
+
+// Options change the generated bytecode.
+type Options struct {
+	// OverflowChecks stop the program on integer overflow, the same way as ir.Options do.
+	// Division by zero is always reported.
+	OverflowChecks bool
+}
+
+// symbol is a variable or a constant of a compiled function.
+type symbol struct {
+	slot     int
+	t        ast.Type
+	constant bool
+	value    int64
+}
+
+type scope struct {
+	parent  *scope
+	symbols map[string]*symbol
+}
+
+func (s *scope) lookup(name string) *symbol {
+	if v, ok := s.symbols[name]; ok {
+		return v
+	} else if s.parent != nil {
+		return s.parent.lookup(name)
+	}
+	return nil
+}
+
+type compiler struct {
+	program   *Program
+	info      *checker.Info
+	options   Options
+	indices   map[string]int
+	functions map[string]*ast.Function
+	strings   map[string]int
+	// function is the function being compiled
+	function *Function
+	scope    *scope
+	// breaks are the jumps out of the enclosing loops, patched at their ends
+	breaks [][]int
+}
+
+// Compile type checks a program and compiles it into bytecode. Errors in the program panic.
+func Compile(program *ast.Program, options Options) *Program {
+	if program.Library {
+		panic(fmt.Sprintf("Library %s can only be compiled by the llvm backend.", program.Name))
+	}
+	if program.Unit || len(program.Uses) > 0 {
+		panic(fmt.Sprintf("Units can only be compiled by the llvm backend, %s is a unit or uses units.", program.Name))
+	}
+	c := &compiler{
+		program:   &Program{},
+		info:      checker.Check(program),
+		options:   options,
+		indices:   make(map[string]int),
+		functions: make(map[string]*ast.Function),
+		strings:   make(map[string]int),
+	}
+	for _, f := range program.Functions {
+		name := f.Signature.Name
+		if _, ok := c.indices[name]; !ok {
+			c.indices[name] = len(c.program.Functions)
+			c.program.Functions = append(c.program.Functions, &Function{Name: name})
+		}
+		if f.Signature.External {
+			panic(fmt.Sprintf("External function %s is only supported by the llvm backend.", f.Signature.Name))
+		}
+		// Forward declarations are replaced by implementations
+		if f.Body != nil || c.functions[name] == nil {
+			c.functions[name] = f
+		}
+	}
+	main, ok := c.indices["main"]
+	if !ok {
+		panic("Program has no main function.")
+	}
+	c.program.Entry = main
+	for _, f := range c.program.Functions {
+		if body := c.functions[f.Name]; body.Body != nil {
+			c.compileFunction(f, body)
+		}
+	}
+	return c.program
+}
+
+// slots is the number of slots taken by a value of type t.
+func slots(t ast.Type) int {
+	if _, ok := t.(ast.Set); ok {
+		return setSlots
+	}
+	if t == ast.VOID {
+		return 0
+	}
+	return 1
+}
+
+func kindOf(t ast.Type) Kind {
+	switch t {
+	case ast.BYTE:
+		return KindByte
+	case ast.SHORTINT:
+		return KindShortint
+	case ast.WORD:
+		return KindWord
+	case ast.CARDINAL:
+		return KindCardinal
+	case ast.INT64:
+		return KindInt64
+	default:
+		return KindInt
+	}
+}
+
+// capacity is the number of elements, that the LLVM representation of a set type can hold.
+func capacity(s ast.Set) int64 {
+	switch {
+	case s.High < 32:
+		return 32
+	case s.High < 64:
+		return 64
+	default:
+		return ast.MaxSetElement + 1
+	}
+}
+
+func (c *compiler) compileFunction(f *Function, function *ast.Function) {
+	c.function, c.breaks = f, nil
+	c.scope = &scope{symbols: make(map[string]*symbol)}
+	for _, p := range function.Signature.Parameters {
+		c.declare(p.Name, p.Type)
+	}
+	f.Params = f.Locals
+	if function.Signature.Return != ast.VOID {
+		c.declare(f.Name, function.Signature.Return)
+	}
+	f.Result = f.Locals - f.Params
+	c.statement(function.Body)
+	c.emit(OpReturn, 0)
+	c.function, c.scope = nil, nil
+}
+
+// declare allocates local slots for a variable.
+func (c *compiler) declare(name string, t ast.Type) *symbol {
+	s := &symbol{slot: c.function.Locals, t: t}
+	c.function.Locals += slots(t)
+	c.scope.symbols[name] = s
+	return s
+}
+
+func (c *compiler) lookup(name string) *symbol {
+	if s := c.scope.lookup(name); s != nil {
+		return s
+	}
+	panic(fmt.Sprintf("Undefined symbol %s.", name))
+}
+
+// emit appends an instruction and returns its address.
+func (c *compiler) emit(op Opcode, arg int64) int {
+	c.function.Code = append(c.function.Code, Instruction{op, arg})
+	return len(c.function.Code) - 1
+}
+
+// emitAt appends an instruction, that can stop the program with a runtime error at pos.
+func (c *compiler) emitAt(op Opcode, arg int64, pos token.Position) {
+	pc := c.emit(op, arg)
+	c.function.Positions = append(c.function.Positions, Position{pc, pos})
+}
+
+// patch makes the jump at pc continue at the next emitted instruction.
+func (c *compiler) patch(pc int) {
+	c.function.Code[pc].Arg = int64(len(c.function.Code))
+}
+
+func (c *compiler) load(s *symbol) {
+	switch {
+	case s.constant:
+		c.emit(OpConst, s.value)
+	case slots(s.t) == setSlots:
+		c.emit(OpLoadSet, int64(s.slot))
+	default:
+		c.emit(OpLoad, int64(s.slot))
+	}
+}
+
+func (c *compiler) store(s *symbol) {
+	if slots(s.t) == setSlots {
+		c.emit(OpStoreSet, int64(s.slot))
+	} else {
+		c.emit(OpStore, int64(s.slot))
+	}
+}
+
+// zero initializes a variable, so that it starts with zero every time its block is entered.
+func (c *compiler) zero(s *symbol) {
+	if slots(s.t) == setSlots {
+		c.emit(OpSetEmpty, 0)
+	} else {
+		c.emit(OpConst, 0)
+	}
+	c.store(s)
+}
+
+func (c *compiler) statement(node ast.Statement) {
+	switch n := node.(type) {
+	case *ast.Block:
+		c.scope = &scope{parent: c.scope, symbols: make(map[string]*symbol)}
+		for _, s := range n.Statements {
+			c.statement(s)
+		}
+		c.scope = c.scope.parent
+	case *ast.VariableDeclaration:
+		c.zero(c.declare(n.Name, n.Type))
+	case *ast.ConstantDeclaration:
+		// Constants are replaced by their values, integer constants can still be referred to by name
+		if l, ok := n.Value.(*ast.Literal); ok {
+			c.scope.symbols[n.Name] = &symbol{t: c.info.TypeOf(l), constant: true, value: l.Value}
+		}
+	case *ast.Assignment:
+		c.assign(n)
+	case *ast.ProcedureCall:
+		c.procedureCall(n)
+	case *ast.If:
+		c.expression(n.Condition)
+		skipThen := c.emit(OpJumpIfFalse, 0)
+		c.statement(n.Then)
+		if n.Else != nil {
+			skipElse := c.emit(OpJump, 0)
+			c.patch(skipThen)
+			c.statement(n.Else)
+			c.patch(skipElse)
+		} else {
+			c.patch(skipThen)
+		}
+	case *ast.While:
+		top := len(c.function.Code)
+		c.expression(n.Condition)
+		c.loop(top, n.Body, nil)
+	case *ast.For:
+		c.assign(n.Initial)
+		variable := c.lookup(n.Initial.Variable.Name)
+		step := int64(-1)
+		if n.Upto {
+			step = 1
+		}
+		// The loop runs while the variable differs from the target, the same way as in the ir package
+		top := len(c.function.Code)
+		c.load(variable)
+		c.expression(n.Target)
+		c.emit(OpNe, 0)
+		c.loop(top, n.Body, func() {
+			c.load(variable)
+			c.emit(OpConst, step)
+			c.emit(OpAdd, int64(kindOf(variable.t)))
+			c.store(variable)
+		})
+	case *ast.Break:
+		// break outside of a loop does nothing
+		if len(c.breaks) > 0 {
+			last := len(c.breaks) - 1
+			c.breaks[last] = append(c.breaks[last], c.emit(OpJump, 0))
+		}
+	case *ast.Exit:
+		c.emit(OpReturn, 0)
+	default:
+		panic("Unknown statement type!")
+	}
+}
+
+// loop emits the body of a loop, whose condition has just been pushed. update is emitted after the body.
+func (c *compiler) loop(top int, body ast.Statement, update func()) {
+	exit := c.emit(OpJumpIfFalse, 0)
+	c.breaks = append(c.breaks, nil)
+	c.statement(body)
+	if update != nil {
+		update()
+	}
+	c.emit(OpJump, int64(top))
+	c.patch(exit)
+	for _, b := range c.breaks[len(c.breaks)-1] {
+		c.patch(b)
+	}
+	c.breaks = c.breaks[:len(c.breaks)-1]
+}
+
+func (c *compiler) assign(a *ast.Assignment) {
+	c.expression(a.Value)
+	c.convert(c.info.TypeOf(a.Value), c.info.TypeOf(&a.Variable))
+	c.store(c.lookup(a.Variable.Name))
+}
+
+// convert changes the value on the stack from type from to type to, the same way an assignment does.
+func (c *compiler) convert(from, to ast.Type) {
+	if s, ok := to.(ast.Set); ok {
+		c.emit(OpSetTruncate, capacity(s))
+	} else if ast.IsInteger(to) {
+		c.emit(OpWrap, int64(kindOf(to)))
+	} else if to == ast.REAL && ast.IsInteger(from) {
+		c.emit(OpToReal, 0)
+	}
+}
+
+func (c *compiler) procedureCall(pc *ast.ProcedureCall) {
+	switch {
+	case checker.Intrinsics[pc.Name]:
+		c.intrinsic(pc)
+	case pc.Name == "inc" || pc.Name == "dec":
+		variable := c.lookup(pc.Args[0].(*ast.Variable).Name)
+		step, op := int64(1), OpAdd
+		if pc.Name == "dec" {
+			step, op = -1, OpSub
+		}
+		c.load(variable)
+		if c.options.OverflowChecks {
+			t := checker.StepType(variable.t)
+			c.convert(variable.t, t)
+			c.emit(OpConst, 1)
+			c.emitAt(op, c.checked(kindOf(t)), pc.Position)
+			c.convert(t, variable.t)
+			c.store(variable)
+			return
+		}
+		c.emit(OpConst, step)
+		c.emit(OpAdd, int64(kindOf(variable.t)))
+		c.store(variable)
+	default:
+		if result := c.call(pc.Name, pc.Args); result > 0 {
+			c.emit(OpPop, int64(result))
+		}
+	}
+}
+
+// call emits a call of a function and returns the number of slots taken by its result.
+func (c *compiler) call(name string, args []ast.Expression) int {
+	f := c.functions[name]
+	if f.Body == nil {
+		panic(fmt.Sprintf("Function %s is declared, but never implemented.", name))
+	}
+	for i, a := range args {
+		c.expression(a)
+		c.convert(c.info.TypeOf(a), f.Signature.Parameters[i].Type)
+	}
+	c.emit(OpCall, int64(c.indices[name]))
+	return slots(f.Signature.Return)
+}
+
+func (c *compiler) intrinsic(pc *ast.ProcedureCall) {
+	switch pc.Name {
+	case "write", "writeln":
+		for _, a := range pc.Args {
+			c.write(a)
+		}
+		if pc.Name == "writeln" {
+			c.emit(OpWriteNewline, 0)
+		}
+	case "read", "readln":
+		for _, a := range pc.Args {
+			variable := c.lookup(a.(*ast.Variable).Name)
+			c.load(variable)
+			if variable.t == ast.REAL {
+				c.emit(OpReadReal, 0)
+			} else {
+				c.emit(OpReadInteger, int64(kindOf(variable.t)))
+			}
+			c.store(variable)
+		}
+		if pc.Name == "readln" {
+			c.emit(OpReadNewline, 0)
+		}
+	}
+}
+
+func (c *compiler) write(a ast.Expression) {
+	format, formatted := a.(*ast.Format)
+	if formatted {
+		a = format.Value
+	}
+	t := c.info.TypeOf(a)
+	if s, ok := a.(ast.StringLiteral); ok {
+		t = ast.STRING
+		if _, ok := c.strings[s.Value]; !ok {
+			c.strings[s.Value] = len(c.program.Strings)
+			c.program.Strings = append(c.program.Strings, s.Value)
+		}
+	} else {
+		c.expression(a)
+	}
+	if formatted {
+		c.expression(format.Width)
+	} else {
+		c.emit(OpConst, 0)
+	}
+	switch {
+	case t == ast.REAL:
+		if formatted && format.Precision != nil {
+			c.expression(format.Precision)
+		} else {
+			c.emit(OpConst, -1)
+		}
+		c.emit(OpWriteReal, 0)
+	case t == ast.BOOLEAN:
+		c.emit(OpWriteBoolean, 0)
+	case t == ast.STRING:
+		c.emit(OpWriteString, int64(c.strings[a.(ast.StringLiteral).Value]))
+	case ast.IsInteger(t):
+		c.emit(OpWriteInteger, 0)
+	default:
+		panic(fmt.Sprintf("Can not write %v.", a))
+	}
+}
+
+func (c *compiler) expression(expression ast.Expression) {
+	switch e := expression.(type) {
+	case *ast.Literal:
+		c.emit(OpConst, e.Value)
+	case *ast.RealLiteral:
+		c.emit(OpConst, int64(math.Float64bits(e.Value)))
+	case *ast.Variable:
+		c.load(c.lookup(e.Name))
+	case *ast.Binary:
+		c.binary(e)
+	case *ast.Unary:
+		c.unary(e)
+	case *ast.FunctionCall:
+		c.call(e.Name, e.Args)
+	case *ast.SetConstructor:
+		c.emit(OpSetEmpty, 0)
+		for _, element := range e.Elements {
+			c.expression(element.Low)
+			if element.High != nil {
+				c.expression(element.High)
+			} else {
+				c.expression(element.Low)
+			}
+			c.emit(OpSetRange, 0)
+		}
+	default:
+		panic("Not all expressions are implemented yet!")
+	}
+}
+
+// checked adds the checked flag to the argument of an arithmetic instruction, when overflow checks are enabled.
+func (c *compiler) checked(k Kind) int64 {
+	if c.options.OverflowChecks {
+		return int64(k) | Checked
+	}
+	return int64(k)
+}
+
+var integerOpcodes = map[ast.Operation]Opcode{
+	ast.PLUS: OpAdd, ast.MINUS: OpSub, ast.MULTIPLY: OpMul, ast.DIV: OpDiv, ast.MOD: OpMod,
+	ast.AND: OpAnd, ast.OR: OpOr, ast.XOR: OpXor, ast.SHL: OpShl, ast.SHR: OpShr,
+	ast.EQUALS: OpEq, ast.NOTEQUALS: OpNe, ast.LESS: OpLt, ast.LESSEQ: OpLe, ast.GREATER: OpGt, ast.GREATEREQ: OpGe,
+}
+
+var realOpcodes = map[ast.Operation]Opcode{
+	ast.PLUS: OpRealAdd, ast.MINUS: OpRealSub, ast.MULTIPLY: OpRealMul, ast.DIVIDE: OpRealDiv,
+	ast.EQUALS: OpRealEq, ast.NOTEQUALS: OpRealNe, ast.LESS: OpRealLt, ast.LESSEQ: OpRealLe, ast.GREATER: OpRealGt, ast.GREATEREQ: OpRealGe,
+}
+
+var setOpcodes = map[ast.Operation]Opcode{
+	ast.PLUS: OpSetUnion, ast.MULTIPLY: OpSetIntersect, ast.MINUS: OpSetDiff,
+	ast.EQUALS: OpSetEq, ast.NOTEQUALS: OpSetNe, ast.LESSEQ: OpSetSubset, ast.GREATEREQ: OpSetSuperset,
+}
+
+func (c *compiler) binary(e *ast.Binary) {
+	leftType, rightType := c.info.TypeOf(e.Left), c.info.TypeOf(e.Right)
+	if e.Operation == ast.IN {
+		c.expression(e.Left)
+		c.expression(e.Right)
+		c.emit(OpSetIn, 0)
+		return
+	}
+	if _, ok := leftType.(ast.Set); ok {
+		// Both operands are truncated to the representation of the result
+		capacity := capacity(checker.UnionType(leftType.(ast.Set), rightType.(ast.Set)))
+		c.expression(e.Left)
+		c.emit(OpSetTruncate, capacity)
+		c.expression(e.Right)
+		c.emit(OpSetTruncate, capacity)
+		op, ok := setOpcodes[e.Operation]
+		if !ok {
+			panic("Invalid operation on sets.")
+		}
+		c.emit(op, 0)
+		return
+	}
+	if leftType == ast.BOOLEAN {
+		// Booleans are 0 or 1, so integer instructions work on them as well
+		c.expression(e.Left)
+		c.expression(e.Right)
+		switch e.Operation {
+		case ast.AND, ast.OR, ast.XOR, ast.EQUALS, ast.NOTEQUALS:
+			c.emit(integerOpcodes[e.Operation], int64(KindInt))
+		default:
+			panic("Invalid operation on booleans.")
+		}
+		return
+	}
+	t := checker.CommonType(leftType, rightType)
+	if t == ast.REAL || e.Operation == ast.DIVIDE {
+		c.expression(e.Left)
+		c.convert(leftType, ast.REAL)
+		c.expression(e.Right)
+		c.convert(rightType, ast.REAL)
+		op, ok := realOpcodes[e.Operation]
+		if !ok {
+			panic("Invalid operation on reals.")
+		}
+		c.emit(op, 0)
+		return
+	}
+	// Integers are kept extended to int64, so they don't need to be converted to the common type
+	c.expression(e.Left)
+	c.expression(e.Right)
+	op, ok := integerOpcodes[e.Operation]
+	if !ok {
+		panic(fmt.Sprintf("Invalid operation %v on integers.", e.Operation))
+	}
+	switch op {
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod:
+		c.emitAt(op, c.checked(kindOf(t)), e.Position)
+	case OpShl, OpShr:
+		c.emit(op, int64(kindOf(c.info.TypeOf(e))))
+	default:
+		c.emit(op, int64(kindOf(t)))
+	}
+}
+
+func (c *compiler) unary(u *ast.Unary) {
+	t := c.info.TypeOf(u)
+	c.expression(u.Operand)
+	switch u.Operation {
+	case ast.PLUS:
+	case ast.MINUS:
+		if t == ast.REAL {
+			c.emit(OpRealNeg, 0)
+		} else {
+			c.emitAt(OpNeg, c.checked(kindOf(t)), u.Position)
+		}
+	case ast.NOT:
+		if t == ast.BOOLEAN {
+			c.emit(OpBoolNot, 0)
+		} else {
+			c.emit(OpNot, int64(kindOf(t)))
+		}
+	default:
+		panic("Invalid operation type inside Unary node.")
+	}
+}
@@ -0,0 +1,208 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"io"
+)
+
+// The .gbc format starts with magic and a version, followed by the strings, the functions and the index of the entry function.
+// Numbers are stored as varints, strings are prefixed by their length.
+const (
+	magic   = "GBC"
+	version = 1
+)
+
+// Encode serializes a program into the .gbc format.
+func (p *Program) Encode(w io.Writer) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.w.WriteString(magic)
+	e.w.WriteByte(version)
+	e.uint(len(p.Strings))
+	for _, s := range p.Strings {
+		e.string(s)
+	}
+	e.uint(len(p.Functions))
+	for _, f := range p.Functions {
+		e.string(f.Name)
+		e.uint(f.Params)
+		e.uint(f.Result)
+		e.uint(f.Locals)
+		e.uint(len(f.Code))
+		for _, in := range f.Code {
+			e.w.WriteByte(byte(in.Op))
+			e.int(in.Arg)
+		}
+		e.uint(len(f.Positions))
+		for _, pos := range f.Positions {
+			e.uint(pos.PC)
+			e.uint(pos.Position.Line)
+			e.uint(pos.Position.Col)
+		}
+	}
+	e.uint(p.Entry)
+	return e.w.Flush()
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) uint(x int) {
+	e.w.Write(e.buf[:binary.PutUvarint(e.buf[:], uint64(x))])
+}
+
+func (e *encoder) int(x int64) {
+	e.w.Write(e.buf[:binary.PutVarint(e.buf[:], x)])
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.w.WriteString(s)
+}
+
+// ErrFormat is returned by Decode, when the input is not a valid .gbc file.
+var ErrFormat = errors.New("invalid bytecode")
+
+// Decode reads a program in the .gbc format and verifies, that it can be executed safely.
+func Decode(r io.Reader) (*Program, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(magic)) || len(data) < len(magic)+1 {
+		return nil, fmt.Errorf("%w: not a .gbc file", ErrFormat)
+	}
+	if v := data[len(magic)]; v != version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrFormat, v)
+	}
+	d := &decoder{r: bytes.NewReader(data[len(magic)+1:])}
+	p := &Program{}
+	p.Strings = make([]string, d.count())
+	for i := range p.Strings {
+		p.Strings[i] = d.string()
+	}
+	p.Functions = make([]*Function, d.count())
+	for i := range p.Functions {
+		f := &Function{Name: d.string(), Params: d.uint(), Result: d.uint(), Locals: d.uint()}
+		f.Code = make([]Instruction, d.count())
+		for j := range f.Code {
+			op, err := d.r.ReadByte()
+			d.fail(err)
+			f.Code[j] = Instruction{Opcode(op), d.int()}
+		}
+		f.Positions = make([]Position, d.count())
+		for j := range f.Positions {
+			f.Positions[j] = Position{PC: d.uint(), Position: token.Position{Line: d.uint(), Col: d.uint()}}
+		}
+		p.Functions[i] = f
+	}
+	p.Entry = d.uint()
+	if d.err == nil && d.r.Len() != 0 {
+		d.err = errors.New("trailing data")
+	}
+	if d.err == nil {
+		d.err = p.verify()
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, d.err)
+	}
+	return p, nil
+}
+
+// decoder remembers the first error, so that a program can be read without checking every number.
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil && err != nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) uint() int {
+	x, err := binary.ReadUvarint(d.r)
+	d.fail(err)
+	// Unsigned numbers are lengths, indices and positions, which fit into int32
+	if x > 1<<31 {
+		d.fail(fmt.Errorf("number %d out of range", x))
+	}
+	if d.err != nil {
+		return 0
+	}
+	return int(x)
+}
+
+// count reads the length of a sequence, whose items take at least one byte each.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > d.r.Len() {
+		d.fail(fmt.Errorf("length %d exceeds the file", n))
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) int() int64 {
+	x, err := binary.ReadVarint(d.r)
+	d.fail(err)
+	return x
+}
+
+func (d *decoder) string() string {
+	b := make([]byte, d.count())
+	_, err := io.ReadFull(d.r, b)
+	d.fail(err)
+	return string(b)
+}
+
+// verify checks, that all operands of instructions refer to existing strings, functions, slots and instructions.
+// It doesn't check the depth of the stack.
+func (p *Program) verify() error {
+	if p.Entry >= len(p.Functions) {
+		return fmt.Errorf("entry function %d doesn't exist", p.Entry)
+	}
+	if p.Functions[p.Entry].Params != 0 {
+		return errors.New("entry function has parameters")
+	}
+	for _, f := range p.Functions {
+		if f.Params+f.Result > f.Locals {
+			return fmt.Errorf("%s has more parameters than slots", f.Name)
+		}
+		if len(f.Code) == 0 || f.Code[len(f.Code)-1].Op != OpReturn {
+			return fmt.Errorf("%s doesn't end with return", f.Name)
+		}
+		for pc, in := range f.Code {
+			var valid bool
+			switch in.Op {
+			case OpLoad, OpStore:
+				valid = in.Arg >= 0 && in.Arg < int64(f.Locals)
+			case OpLoadSet, OpStoreSet:
+				valid = in.Arg >= 0 && in.Arg+setSlots <= int64(f.Locals)
+			case OpJump, OpJumpIfFalse:
+				valid = in.Arg >= 0 && in.Arg < int64(len(f.Code))
+			case OpCall:
+				valid = in.Arg >= 0 && in.Arg < int64(len(p.Functions))
+			case OpWriteString:
+				valid = in.Arg >= 0 && in.Arg < int64(len(p.Strings))
+			case OpPop:
+				valid = in.Arg >= 0
+			case OpWrap, OpAdd, OpSub, OpMul, OpDiv, OpMod, OpNeg, OpAnd, OpOr, OpXor, OpNot, OpShl, OpShr, OpReadInteger:
+				valid = Kind(in.Arg&^Checked) <= KindInt64 && in.Arg&^(Checked|0xff) == 0
+			default:
+				valid = in.Op < opcodeCount
+			}
+			if !valid {
+				return fmt.Errorf("invalid instruction %v %d at %s:%d", in.Op, in.Arg, f.Name, pc)
+			}
+		}
+	}
+	return nil
+}
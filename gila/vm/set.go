@@ -0,0 +1,62 @@
+package vm
+
+import "gitlab.fit.cvut.cz/fedorgle/gila/ast"
+
+// set is a set value on the stack, it takes setSlots slots.
+type set = ast.MilaSet
+
+func includeRange(s *set, low, high int64) {
+	// Elements below zero are ignored
+	if low < 0 {
+		low = 0
+	}
+	for i := low; i <= high && i <= ast.MaxSetElement; i++ {
+		s.Include(i)
+	}
+}
+
+// truncate drops elements, which are not below capacity.
+func truncate(s set, capacity int64) set {
+	var res set
+	for i := range res {
+		if bits := capacity - int64(i)*64; bits >= 64 {
+			res[i] = s[i]
+		} else if bits > 0 {
+			res[i] = s[i] & (1<<uint(bits) - 1)
+		}
+	}
+	return res
+}
+
+func setArithmetic(op Opcode, left, right set) set {
+	var res set
+	for i := range res {
+		switch op {
+		case OpSetUnion:
+			res[i] = left[i] | right[i]
+		case OpSetIntersect:
+			res[i] = left[i] & right[i]
+		default:
+			res[i] = left[i] &^ right[i]
+		}
+	}
+	return res
+}
+
+func setCompare(op Opcode, left, right set) bool {
+	subset, superset := true, true
+	for i := range left {
+		subset = subset && left[i]&^right[i] == 0
+		superset = superset && right[i]&^left[i] == 0
+	}
+	switch op {
+	case OpSetEq:
+		return left == right
+	case OpSetNe:
+		return left != right
+	case OpSetSubset:
+		return subset
+	default:
+		return superset
+	}
+}
@@ -0,0 +1,371 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"io"
+	"math"
+	"runtime"
+)
+
+// frame is an activation of a function.
+type frame struct {
+	function *Function
+	pc       int
+	locals   []int64
+}
+
+type machine struct {
+	program *Program
+	stack   []int64
+	frames  []frame
+	input   *rtl.Input
+	output  *bufio.Writer
+}
+
+// Run executes a program, reading the standard input from in and writing the standard output to out.
+// It returns a *rtl.RuntimeError, when the program is stopped by a runtime error.
+// Decode doesn't verify the depth of the stack, so bytecode, that underflows it, is reported as ErrFormat.
+func Run(p *Program, in io.Reader, out io.Writer) (err error) {
+	m := &machine{
+		program: p,
+		input:   rtl.NewInput(in),
+		output:  bufio.NewWriter(out),
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			switch e := r.(type) {
+			case *rtl.RuntimeError:
+				err = e
+			case runtime.Error:
+				err = fmt.Errorf("%w: %v", ErrFormat, e)
+			default:
+				panic(r)
+			}
+		}
+		if flushErr := m.output.Flush(); err == nil {
+			err = flushErr
+		}
+	}()
+	m.call(p.Functions[p.Entry])
+	m.execute()
+	return nil
+}
+
+func (m *machine) push(x int64) {
+	m.stack = append(m.stack, x)
+}
+
+func (m *machine) pop() int64 {
+	x := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return x
+}
+
+func (m *machine) pushBool(b bool) {
+	if b {
+		m.push(1)
+	} else {
+		m.push(0)
+	}
+}
+
+func (m *machine) pushReal(x float64) {
+	m.push(int64(math.Float64bits(x)))
+}
+
+func (m *machine) popReal() float64 {
+	return math.Float64frombits(uint64(m.pop()))
+}
+
+func (m *machine) pushSet(s set) {
+	m.stack = append(m.stack, int64(s[0]), int64(s[1]), int64(s[2]), int64(s[3]))
+}
+
+func (m *machine) popSet() set {
+	var s set
+	top := len(m.stack) - setSlots
+	for i := range s {
+		s[i] = uint64(m.stack[top+i])
+	}
+	m.stack = m.stack[:top]
+	return s
+}
+
+// call moves the arguments from the stack into the locals of a new frame.
+func (m *machine) call(f *Function) {
+	locals := make([]int64, f.Locals)
+	args := len(m.stack) - f.Params
+	copy(locals, m.stack[args:])
+	m.stack = m.stack[:args]
+	m.frames = append(m.frames, frame{function: f, locals: locals})
+}
+
+func (m *machine) fail(code int, fr *frame) {
+	panic(&rtl.RuntimeError{Code: code, Position: fr.function.position(fr.pc - 1)})
+}
+
+func (m *machine) execute() {
+	for len(m.frames) > 0 {
+		fr := &m.frames[len(m.frames)-1]
+		in := fr.function.Code[fr.pc]
+		fr.pc++
+		switch in.Op {
+		case OpConst:
+			m.push(in.Arg)
+		case OpLoad:
+			m.push(fr.locals[in.Arg])
+		case OpStore:
+			fr.locals[in.Arg] = m.pop()
+		case OpLoadSet:
+			m.stack = append(m.stack, fr.locals[in.Arg:in.Arg+setSlots]...)
+		case OpStoreSet:
+			copy(fr.locals[in.Arg:], m.stack[len(m.stack)-setSlots:])
+			m.stack = m.stack[:len(m.stack)-setSlots]
+		case OpPop:
+			m.stack = m.stack[:len(m.stack)-int(in.Arg)]
+		case OpWrap:
+			m.push(Kind(in.Arg).wrap(m.pop()))
+		case OpToReal:
+			m.pushReal(float64(m.pop()))
+
+		case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpAnd, OpOr, OpXor, OpShl, OpShr:
+			right, left := m.pop(), m.pop()
+			res, code := integerBinary(in.Op, left, right, Kind(in.Arg), in.Arg&Checked != 0)
+			if code != 0 {
+				m.fail(code, fr)
+			}
+			m.push(res)
+		case OpNeg:
+			res, code := integerBinary(OpSub, 0, m.pop(), Kind(in.Arg), in.Arg&Checked != 0)
+			if code != 0 {
+				m.fail(code, fr)
+			}
+			m.push(res)
+		case OpNot:
+			m.push(Kind(in.Arg).wrap(^m.pop()))
+		case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+			right, left := m.pop(), m.pop()
+			m.pushBool(compare(in.Op-OpEq, left < right, left == right))
+
+		case OpRealAdd, OpRealSub, OpRealMul, OpRealDiv:
+			right, left := m.popReal(), m.popReal()
+			m.pushReal(realArithmetic(in.Op, left, right))
+		case OpRealNeg:
+			m.pushReal(-m.popReal())
+		case OpRealEq, OpRealNe, OpRealLt, OpRealLe, OpRealGt, OpRealGe:
+			right, left := m.popReal(), m.popReal()
+			m.pushBool(realCompare(in.Op, left, right))
+		case OpBoolNot:
+			m.push(m.pop() ^ 1)
+
+		case OpSetEmpty:
+			m.pushSet(set{})
+		case OpSetRange:
+			high, low := m.pop(), m.pop()
+			s := m.popSet()
+			includeRange(&s, low, high)
+			m.pushSet(s)
+		case OpSetTruncate:
+			m.pushSet(truncate(m.popSet(), in.Arg))
+		case OpSetIn:
+			s := m.popSet()
+			m.pushBool(s.Contains(m.pop()))
+		case OpSetUnion, OpSetIntersect, OpSetDiff:
+			right, left := m.popSet(), m.popSet()
+			m.pushSet(setArithmetic(in.Op, left, right))
+		case OpSetEq, OpSetNe, OpSetSubset, OpSetSuperset:
+			right, left := m.popSet(), m.popSet()
+			m.pushBool(setCompare(in.Op, left, right))
+
+		case OpJump:
+			fr.pc = int(in.Arg)
+		case OpJumpIfFalse:
+			if m.pop() == 0 {
+				fr.pc = int(in.Arg)
+			}
+		case OpCall:
+			m.call(m.program.Functions[in.Arg])
+		case OpReturn:
+			f := fr.function
+			m.stack = append(m.stack, fr.locals[f.Params:f.Params+f.Result]...)
+			m.frames = m.frames[:len(m.frames)-1]
+
+		case OpWriteInteger:
+			width := int32(m.pop())
+			rtl.WriteInteger(m.output, m.pop(), width)
+		case OpWriteReal:
+			precision, width := int32(m.pop()), int32(m.pop())
+			rtl.WriteReal(m.output, m.popReal(), width, precision)
+		case OpWriteBoolean:
+			width := int32(m.pop())
+			rtl.WriteBoolean(m.output, m.pop() != 0, width)
+		case OpWriteString:
+			rtl.WriteString(m.output, m.program.Strings[in.Arg], int32(m.pop()))
+		case OpWriteNewline:
+			m.output.WriteByte('\n')
+		// A variable stays the same, when there is no number to read
+		case OpReadInteger:
+			if x, ok := m.input.Integer(); ok {
+				m.pop()
+				m.push(Kind(in.Arg).wrap(x))
+			}
+		case OpReadReal:
+			if x, ok := m.input.Real(); ok {
+				m.pop()
+				m.pushReal(x)
+			}
+		case OpReadNewline:
+			m.input.SkipLine()
+		default:
+			panic("Invalid opcode " + in.Op.String() + ".")
+		}
+	}
+}
+
+// compare evaluates a comparison, given as its offset from OpEq, from the results of less and equal.
+func compare(op Opcode, less, equal bool) bool {
+	switch op + OpEq {
+	case OpEq:
+		return equal
+	case OpNe:
+		return !equal
+	case OpLt:
+		return less
+	case OpLe:
+		return less || equal
+	case OpGt:
+		return !less && !equal
+	default:
+		return !less
+	}
+}
+
+// integerBinary performs an operation on integers of kind k. It returns a runtime error code, when the operation fails.
+func integerBinary(op Opcode, left, right int64, k Kind, checked bool) (int64, int) {
+	var res int64
+	switch op {
+	case OpAdd, OpSub, OpMul:
+		res, overflow := arithmetic(op, left, right, k)
+		if overflow && checked {
+			return 0, rtl.ErrorOverflow
+		}
+		return res, 0
+	case OpDiv, OpMod:
+		if right == 0 {
+			return 0, rtl.ErrorDivisionByZero
+		}
+		// The smallest integer divided by -1 doesn't fit into its type
+		if !k.unsigned() && right == -1 && left == k.min() {
+			if checked {
+				return 0, rtl.ErrorOverflow
+			}
+			if op == OpDiv {
+				return left, 0
+			}
+			return 0, 0
+		}
+		if op == OpDiv {
+			res = left / right
+		} else {
+			res = left % right
+		}
+	case OpAnd:
+		res = left & right
+	case OpOr:
+		res = left | right
+	case OpXor:
+		res = left ^ right
+	case OpShl, OpShr:
+		res = shift(op, left, right, k)
+	}
+	return k.wrap(res), 0
+}
+
+// arithmetic adds, subtracts or multiplies integers of kind k and reports, whether the result overflows.
+func arithmetic(op Opcode, left, right int64, k Kind) (int64, bool) {
+	var res int64
+	var overflow bool
+	switch {
+	case k.unsigned():
+		// Unsigned operands are below 2^32, so neither the result nor the borrow is lost in uint64
+		var u uint64
+		switch op {
+		case OpAdd:
+			u = uint64(left) + uint64(right)
+		case OpSub:
+			u = uint64(left) - uint64(right)
+		case OpMul:
+			u = uint64(left) * uint64(right)
+		}
+		res, overflow = int64(u), u > uint64(math.MaxUint64)>>(64-k.width())
+	case k.width() == 64:
+		switch op {
+		case OpAdd:
+			res = left + right
+			overflow = (left >= 0) == (right >= 0) && (res >= 0) != (left >= 0)
+		case OpSub:
+			res = left - right
+			overflow = (left >= 0) != (right >= 0) && (res >= 0) != (left >= 0)
+		case OpMul:
+			res = left * right
+			overflow = left != 0 && (res/left != right || (left == -1 && right == math.MinInt64))
+		}
+	default:
+		// Narrower signed integers don't overflow int64
+		switch op {
+		case OpAdd:
+			res = left + right
+		case OpSub:
+			res = left - right
+		case OpMul:
+			res = left * right
+		}
+		overflow = k.wrap(res) != res
+	}
+	return k.wrap(res), overflow
+}
+
+// shift shifts an integer of kind k. The count is masked to the width of k, the same way as in the ir package.
+func shift(op Opcode, x, count int64, k Kind) int64 {
+	w := k.width()
+	count &= int64(w - 1)
+	if op == OpShl {
+		return x << uint(count)
+	}
+	// shr is a logical shift, the sign bit is not extended
+	mask := uint64(math.MaxUint64) >> (64 - w)
+	return int64((uint64(x) & mask) >> uint(count))
+}
+
+func realArithmetic(op Opcode, left, right float64) float64 {
+	switch op {
+	case OpRealAdd:
+		return left + right
+	case OpRealSub:
+		return left - right
+	case OpRealMul:
+		return left * right
+	default:
+		return left / right
+	}
+}
+
+// realCompare compares reals. Comparisons with NaN are false, except for <>.
+func realCompare(op Opcode, left, right float64) bool {
+	switch op {
+	case OpRealEq:
+		return left == right
+	case OpRealNe:
+		return left != right
+	case OpRealLt:
+		return left < right
+	case OpRealLe:
+		return left <= right
+	case OpRealGt:
+		return left > right
+	default:
+		return left >= right
+	}
+}
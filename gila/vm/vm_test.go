@@ -0,0 +1,164 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"gitlab.fit.cvut.cz/fedorgle/gila/interp"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func compile(source string, options Options) *Program {
+	return Compile(parser.New(lexer.New(strings.NewReader(source))).Parse(), options)
+}
+
+func run(p *Program, input string) (string, error) {
+	var out bytes.Buffer
+	err := Run(p, strings.NewReader(input), &out)
+	return out.String(), err
+}
+
+// Test_Samples compares the output of the vm with the output of the interpreter.
+func Test_Samples(t *testing.T) {
+	samples, _ := filepath.Glob("../../samples/*.mila")
+	if len(samples) == 0 {
+		t.Fatal("No samples found")
+	}
+	for _, sample := range samples {
+		source, _ := ioutil.ReadFile(sample)
+		var expected bytes.Buffer
+		program := parser.New(lexer.New(bytes.NewReader(source))).Parse()
+		if err := interp.Run(program, strings.NewReader("7\n"), &expected, interp.Options{}); err != nil {
+			t.Errorf("%s failed in the interpreter: %v", sample, err)
+		}
+		out, err := run(compile(string(source), Options{}), "7\n")
+		if err != nil {
+			t.Errorf("%s failed: %v", sample, err)
+		}
+		if out != expected.String() {
+			t.Errorf("%s printed %q, the interpreter printed %q", sample, out, expected.String())
+		}
+	}
+}
+
+func Test_Programs(t *testing.T) {
+	cases := []struct {
+		name, source, input, output string
+	}{
+		{"wrapping", `
+program wrap;
+var b: byte; s: shortint; x: integer; c: cardinal;
+begin
+	b := 255; inc(b);
+	s := -128; dec(s);
+	x := 2147483647; x := x + 1;
+	c := 0; dec(c);
+	writeln(b, ' ', s, ' ', x, ' ', c, ' ', -8 shr 28, ' ', 1 shl 33, ' ', not 0);
+end.`, "", "0 127 -2147483648 4294967295 15 2 -1\n"},
+		{"loops", `
+program loops;
+var i, s: integer;
+begin
+	s := 0;
+	for i := 0 to 10 do begin if i = 5 then break; s := s + i; end;
+	while s < 100 do s := s * 2;
+	break;
+	writeln(s, ' ', i);
+end.`, "", "160 5\n"},
+		{"sets", `
+program sets;
+var s: set of 0..31; l: set of 0..255;
+begin
+	s := [1, 3..5];
+	l := s + [200];
+	s := l;
+	writeln(4 in s, ' ', 200 in l, ' ', 200 in s, ' ', [1] <= s, ' ', s = [1, 3, 4, 5], ' ', l - s >= [200]);
+end.`, "", "TRUE TRUE FALSE TRUE TRUE TRUE\n"},
+		{"functions", `
+program functions;
+function half(x: real): real;
+begin
+	half := x / 2;
+	exit;
+	half := 0;
+end;
+function even(n: integer): integer; forward;
+function odd(n: integer): integer;
+begin
+	if n = 0 then odd := 0 else odd := even(n - 1);
+end;
+function even(n: integer): integer;
+begin
+	if n = 0 then even := 1 else even := odd(n - 1);
+end;
+begin
+	writeln(half(3):0:2, ' ', even(10) = 1, ' ', odd(10));
+end.`, "", "1.50 TRUE 0\n"},
+		{"io", `
+program io;
+var x: integer; r: real;
+begin
+	readln(x, r);
+	readln(x);
+	writeln(x:4, r:8:3, r / 4, ' ', x > 2:6);
+end.`, "7 2.5 ignored\n 42\n", "  42   2.500 6.250000000000000E-01   TRUE\n"},
+	}
+	for _, c := range cases {
+		out, err := run(compile(c.source, Options{}), c.input)
+		if err != nil {
+			t.Errorf("%s failed: %v", c.name, err)
+		}
+		if out != c.output {
+			t.Errorf("%s printed %q, expected %q", c.name, out, c.output)
+		}
+	}
+}
+
+func Test_RuntimeErrors(t *testing.T) {
+	for _, c := range rtl.ErrorCases {
+		_, err := run(compile(c.Source, Options{OverflowChecks: c.OverflowChecks}), "")
+		var runtimeError *rtl.RuntimeError
+		if c.Error == nil && err != nil {
+			t.Errorf("%q stopped with %v", c.Source, err)
+		} else if c.Error != nil && (!errors.As(err, &runtimeError) || *runtimeError != *c.Error) {
+			t.Errorf("Expected %v in %q, got %v", c.Error, c.Source, err)
+		}
+	}
+	// Only the smallest integer overflows, when it is divided by -1
+	out, err := run(compile("program e; var x, y: integer; begin x := 0; y := -1; writeln(x div y, ' ', x mod y); end.", Options{OverflowChecks: true}), "")
+	if err != nil || out != "0 0\n" {
+		t.Errorf("Dividing 0 by -1 printed %q, %v", out, err)
+	}
+}
+
+func Test_Encoding(t *testing.T) {
+	source, _ := ioutil.ReadFile("../../samples/formatting.mila")
+	p := compile(string(source), Options{OverflowChecks: true})
+	var encoded bytes.Buffer
+	if err := p.Encode(&encoded); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(bytes.NewReader(encoded.Bytes()))
+	if err != nil {
+		t.Fatalf("Decoding failed: %v", err)
+	}
+	expected, _ := run(p, "")
+	if out, err := run(decoded, ""); err != nil || out != expected {
+		t.Errorf("Decoded program printed %q, expected %q (%v)", out, expected, err)
+	}
+	var again bytes.Buffer
+	decoded.Encode(&again)
+	if !bytes.Equal(encoded.Bytes(), again.Bytes()) {
+		t.Error("Encoding is not stable")
+	}
+	for i := 0; i < encoded.Len(); i++ {
+		if _, err := Decode(bytes.NewReader(encoded.Bytes()[:i])); !errors.Is(err, ErrFormat) {
+			t.Errorf("Truncated bytecode of length %d was accepted: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package wasm
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"gitlab.fit.cvut.cz/fedorgle/gila/token"
+	"math"
+	"strconv"
+)
+
+// Expressions are evaluated on the operand stack from left to right, the same order as in the other backends.
+
+func (g *generator) expression(expression ast.Expression) {
+	switch e := expression.(type) {
+	case *ast.Literal:
+		g.constant(e.Value, g.info.TypeOf(e))
+	case *ast.RealLiteral:
+		g.emit("f64.const %s", realLiteral(e.Value))
+	case ast.StringLiteral:
+		panic(fmt.Sprintf("String %q can only be written.", e.Value))
+	case *ast.Variable:
+		g.load(g.lookup(e.Name))
+	case *ast.Binary:
+		g.binary(e)
+	case *ast.Unary:
+		g.unary(e)
+	case *ast.FunctionCall:
+		g.call(e.Name, e.Args)
+	case *ast.SetConstructor:
+		g.setConstructor(e)
+	default:
+		panic("Not all expressions are implemented yet!")
+	}
+}
+
+// constant pushes an integer of type t.
+func (g *generator) constant(x int64, t ast.Type) {
+	if valueType(t) == "i64" {
+		g.emit("i64.const %d", x)
+	} else {
+		g.emit("i32.const %d", int32(x))
+	}
+}
+
+func realLiteral(x float64) string {
+	switch {
+	case math.IsInf(x, 1):
+		return "inf"
+	case math.IsInf(x, -1):
+		return "-inf"
+	case math.IsNaN(x):
+		return "nan"
+	}
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
+
+// normalize truncates an i32 to the width of a narrow integer type and extends it back according to its sign.
+// Values of narrow types are always kept normalized, so they can be compared and converted like integers.
+func (g *generator) normalize(t ast.Type) {
+	switch t {
+	case ast.BYTE:
+		g.emit("i32.const 255")
+		g.emit("i32.and")
+	case ast.WORD:
+		g.emit("i32.const 65535")
+		g.emit("i32.and")
+	case ast.SHORTINT:
+		g.emit("i32.extend8_s")
+	}
+}
+
+// convert changes the value on top of the stack from type from to type to, the same way an assignment does.
+func (g *generator) convert(from, to ast.Type) {
+	if s, ok := to.(ast.Set); ok {
+		g.truncateSet(s)
+		return
+	}
+	if from == to {
+		return
+	}
+	switch {
+	case ast.IsInteger(to) && ast.IsInteger(from):
+		switch {
+		case valueType(from) == "i32" && valueType(to) == "i64":
+			g.emit("i64.extend_i32_%s", sign(from))
+		case valueType(from) == "i64" && valueType(to) == "i32":
+			g.emit("i32.wrap_i64")
+		}
+		g.normalize(to)
+	case to == ast.REAL && ast.IsInteger(from):
+		g.emit("f64.convert_%s_%s", valueType(from), sign(from))
+	}
+}
+
+// sign is the suffix of instructions, that depend on the sign of an integer type.
+func sign(t ast.Type) string {
+	if ast.IsUnsigned(t) {
+		return "u"
+	}
+	return "s"
+}
+
+var instructions = map[ast.Operation]string{
+	ast.PLUS: "add", ast.MINUS: "sub", ast.MULTIPLY: "mul", ast.DIVIDE: "div",
+	ast.AND: "and", ast.OR: "or", ast.XOR: "xor", ast.SHL: "shl", ast.SHR: "shr_u",
+	ast.EQUALS: "eq", ast.NOTEQUALS: "ne", ast.LESS: "lt", ast.LESSEQ: "le", ast.GREATER: "gt", ast.GREATEREQ: "ge",
+}
+
+func (g *generator) binary(e *ast.Binary) {
+	leftType, rightType := g.info.TypeOf(e.Left), g.info.TypeOf(e.Right)
+	if e.Operation == ast.IN {
+		g.expression(e.Left)
+		g.convert(leftType, ast.INT64)
+		g.expression(e.Right)
+		g.emit("call %s", g.setHelper("in"))
+		return
+	}
+	if _, ok := leftType.(ast.Set); ok {
+		g.setBinary(e, checker.UnionType(leftType.(ast.Set), rightType.(ast.Set)))
+		return
+	}
+	if leftType == ast.BOOLEAN {
+		// Both operands are evaluated, the same way as in the ir package
+		g.expression(e.Left)
+		g.expression(e.Right)
+		switch e.Operation {
+		case ast.AND, ast.OR, ast.XOR, ast.EQUALS, ast.NOTEQUALS:
+			g.emit("i32.%s", instructions[e.Operation])
+		default:
+			panic("Invalid operation on booleans.")
+		}
+		return
+	}
+	t := checker.CommonType(leftType, rightType)
+	if t == ast.REAL || e.Operation == ast.DIVIDE {
+		op, ok := instructions[e.Operation]
+		if !ok || e.Operation == ast.SHL || e.Operation == ast.SHR {
+			panic("Invalid operation on reals.")
+		}
+		g.operands(e, ast.REAL)
+		g.emit("f64.%s", op)
+		return
+	}
+	if e.Operation == ast.SHL || e.Operation == ast.SHR {
+		// The count is masked to the width of the result by the instruction itself, the same way as in the ir package.
+		// shr is a logical shift, the sign bit is not extended.
+		g.operands(e, g.info.TypeOf(e))
+		g.emit("%s.%s", valueType(g.info.TypeOf(e)), instructions[e.Operation])
+		return
+	}
+	g.operands(e, t)
+	vt := valueType(t)
+	switch e.Operation {
+	case ast.PLUS, ast.MINUS, ast.MULTIPLY:
+		if g.options.OverflowChecks {
+			g.checked(e.Operation, t, e.Position)
+		} else {
+			g.emit("%s.%s", vt, instructions[e.Operation])
+		}
+	case ast.DIV, ast.MOD:
+		g.checked(e.Operation, t, e.Position)
+	case ast.AND, ast.OR, ast.XOR, ast.EQUALS, ast.NOTEQUALS:
+		g.emit("%s.%s", vt, instructions[e.Operation])
+	case ast.LESS, ast.LESSEQ, ast.GREATER, ast.GREATEREQ:
+		g.emit("%s.%s_%s", vt, instructions[e.Operation], sign(t))
+	default:
+		panic(fmt.Sprintf("Invalid operation %v on integers.", e.Operation))
+	}
+}
+
+// operands pushes both operands of a binary operation converted to type t.
+func (g *generator) operands(e *ast.Binary, t ast.Type) {
+	g.expression(e.Left)
+	g.convert(g.info.TypeOf(e.Left), t)
+	g.expression(e.Right)
+	g.convert(g.info.TypeOf(e.Right), t)
+}
+
+func (g *generator) unary(u *ast.Unary) {
+	t := g.info.TypeOf(u)
+	switch u.Operation {
+	case ast.PLUS:
+		g.expression(u.Operand)
+	case ast.MINUS:
+		if t == ast.REAL {
+			g.expression(u.Operand)
+			g.emit("f64.neg")
+			return
+		}
+		// Negation is a subtraction from zero, which may overflow
+		g.constant(0, t)
+		g.expression(u.Operand)
+		g.convert(g.info.TypeOf(u.Operand), t)
+		if g.options.OverflowChecks {
+			g.checked(ast.MINUS, t, u.Position)
+		} else {
+			g.emit("%s.sub", valueType(t))
+		}
+	case ast.NOT:
+		g.expression(u.Operand)
+		if t == ast.BOOLEAN {
+			g.emit("i32.eqz")
+			return
+		}
+		g.convert(g.info.TypeOf(u.Operand), t)
+		g.constant(-1, t)
+		g.emit("%s.xor", valueType(t))
+	default:
+		panic("Invalid operation type inside Unary node.")
+	}
+}
+
+// call generates a call of a function, converting its arguments to the types of the parameters.
+// It returns the type of the result.
+func (g *generator) call(name string, args []ast.Expression) ast.Type {
+	f, ok := g.functions[name]
+	if !ok {
+		panic(fmt.Sprintf("Call to an undefined function %s.", name))
+	}
+	for i, a := range args {
+		g.expression(a)
+		g.convert(g.info.TypeOf(a), f.Signature.Parameters[i].Type)
+	}
+	g.emit("call %s", functionName(name))
+	return f.Signature.Return
+}
+
+// checked calls a helper, that performs an operation on the two integers of type t on top of the stack
+// and stops the program with a runtime error at pos.
+func (g *generator) checked(op ast.Operation, t ast.Type, pos token.Position) {
+	g.emit("i32.const %d", pos.Line)
+	g.emit("i32.const %d", pos.Col)
+	g.emit("call %s", g.arithmeticHelper(op, t))
+}
+
+func (g *generator) setConstructor(s *ast.SetConstructor) {
+	g.zero(g.info.TypeOf(s))
+	for _, e := range s.Elements {
+		g.expression(e.Low)
+		g.convert(g.info.TypeOf(e.Low), ast.INT64)
+		if e.High != nil {
+			g.expression(e.High)
+			g.convert(g.info.TypeOf(e.High), ast.INT64)
+			g.emit("call %s", g.setHelper("range"))
+		} else {
+			g.emit("call %s", g.setHelper("element"))
+		}
+	}
+}
+
+// setBinary performs an operation on sets. Both operands are truncated to the representation of the result.
+func (g *generator) setBinary(e *ast.Binary, t ast.Set) {
+	g.operands(e, t)
+	switch e.Operation {
+	case ast.PLUS:
+		g.emit("call %s", g.setHelper("union"))
+	case ast.MULTIPLY:
+		g.emit("call %s", g.setHelper("intersection"))
+	case ast.MINUS:
+		g.emit("call %s", g.setHelper("difference"))
+	case ast.EQUALS:
+		g.emit("call %s", g.setHelper("equal"))
+	case ast.NOTEQUALS:
+		g.emit("call %s", g.setHelper("equal"))
+		g.emit("i32.eqz")
+	case ast.LESSEQ:
+		g.emit("call %s", g.setHelper("subset"))
+	case ast.GREATEREQ:
+		g.emit("call %s", g.setHelper("superset"))
+	default:
+		panic("Invalid operation on sets.")
+	}
+}
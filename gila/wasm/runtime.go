@@ -0,0 +1,391 @@
+package wasm
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"math"
+	"strings"
+)
+
+// setHelpers operate on sets represented by four i64 values, each holding 64 elements.
+var setHelpers = map[string]string{
+	"element": `  (func $gila_set_element (param $a i64) (param $b i64) (param $c i64) (param $d i64) (param $x i64) (result i64 i64 i64 i64)
+    local.get $a
+    local.get $b
+    local.get $c
+    local.get $d
+    local.get $x
+    local.get $x
+    call $gila_set_range
+  )
+`,
+	"range": `  (func $gila_set_range (param $a i64) (param $b i64) (param $c i64) (param $d i64) (param $low i64) (param $high i64) (result i64 i64 i64 i64)
+    (local $bit i64)
+    (local $word i64)
+    local.get $low
+    i64.const 0
+    local.get $low
+    i64.const 0
+    i64.gt_s
+    select
+    local.set $low
+    block $done
+      loop $next
+        local.get $low
+        local.get $high
+        i64.gt_s
+        local.get $low
+        i64.const 255
+        i64.gt_s
+        i32.or
+        br_if $done
+        i64.const 1
+        local.get $low
+        i64.shl
+        local.set $bit
+        local.get $low
+        i64.const 6
+        i64.shr_u
+        local.tee $word
+        i64.eqz
+        if
+          local.get $a
+          local.get $bit
+          i64.or
+          local.set $a
+        end
+        local.get $word
+        i64.const 1
+        i64.eq
+        if
+          local.get $b
+          local.get $bit
+          i64.or
+          local.set $b
+        end
+        local.get $word
+        i64.const 2
+        i64.eq
+        if
+          local.get $c
+          local.get $bit
+          i64.or
+          local.set $c
+        end
+        local.get $word
+        i64.const 3
+        i64.eq
+        if
+          local.get $d
+          local.get $bit
+          i64.or
+          local.set $d
+        end
+        local.get $low
+        i64.const 1
+        i64.add
+        local.set $low
+        br $next
+      end
+    end
+    local.get $a
+    local.get $b
+    local.get $c
+    local.get $d
+  )
+`,
+	"in": `  (func $gila_set_in (param $x i64) (param $a i64) (param $b i64) (param $c i64) (param $d i64) (result i32)
+    local.get $x
+    i64.const 256
+    i64.ge_u
+    if
+      i32.const 0
+      return
+    end
+    local.get $a
+    local.get $b
+    local.get $x
+    i64.const 64
+    i64.lt_u
+    select
+    local.get $c
+    local.get $d
+    local.get $x
+    i64.const 192
+    i64.lt_u
+    select
+    local.get $x
+    i64.const 128
+    i64.lt_u
+    select
+    local.get $x
+    i64.shr_u
+    i64.const 1
+    i64.and
+    i32.wrap_i64
+  )
+`,
+	"union":        wordwise("union", "i64.or"),
+	"intersection": wordwise("intersection", "i64.and"),
+	"difference":   wordwise("difference", "i64.const -1\n    i64.xor\n    i64.and"),
+	"equal": `  (func $gila_set_equal (param $a i64) (param $b i64) (param $c i64) (param $d i64) (param $e i64) (param $f i64) (param $g i64) (param $h i64) (result i32)
+    local.get $a
+    local.get $e
+    i64.xor
+    local.get $b
+    local.get $f
+    i64.xor
+    i64.or
+    local.get $c
+    local.get $g
+    i64.xor
+    i64.or
+    local.get $d
+    local.get $h
+    i64.xor
+    i64.or
+    i64.eqz
+  )
+`,
+	"subset": `  (func $gila_set_subset (param $a i64) (param $b i64) (param $c i64) (param $d i64) (param $e i64) (param $f i64) (param $g i64) (param $h i64) (result i32)
+    local.get $a
+    local.get $b
+    local.get $c
+    local.get $d
+    local.get $e
+    local.get $f
+    local.get $g
+    local.get $h
+    call $gila_set_difference
+    i64.or
+    i64.or
+    i64.or
+    i64.eqz
+  )
+`,
+	"superset": `  (func $gila_set_superset (param $a i64) (param $b i64) (param $c i64) (param $d i64) (param $e i64) (param $f i64) (param $g i64) (param $h i64) (result i32)
+    local.get $e
+    local.get $f
+    local.get $g
+    local.get $h
+    local.get $a
+    local.get $b
+    local.get $c
+    local.get $d
+    call $gila_set_subset
+  )
+`,
+}
+
+// setDependencies are the helpers, that are called by other set helpers.
+var setDependencies = map[string][]string{
+	"element":  {"range"},
+	"subset":   {"difference"},
+	"superset": {"subset"},
+}
+
+// wordwise defines a set operation, that combines the corresponding words of two sets.
+func wordwise(name, instructions string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  (func $gila_set_%s (param $a i64) (param $b i64) (param $c i64) (param $d i64) (param $e i64) (param $f i64) (param $g i64) (param $h i64) (result i64 i64 i64 i64)\n", name)
+	for _, words := range [][2]string{{"a", "e"}, {"b", "f"}, {"c", "g"}, {"d", "h"}} {
+		fmt.Fprintf(&b, "    local.get $%s\n    local.get $%s\n    %s\n", words[0], words[1], instructions)
+	}
+	b.WriteString("  )\n")
+	return b.String()
+}
+
+// setHelper adds a set operation to the module and returns the name of its function.
+func (g *generator) setHelper(name string) string {
+	g.helpers["gila_set_"+name] = setHelpers[name]
+	for _, d := range setDependencies[name] {
+		g.setHelper(d)
+	}
+	return "$gila_set_" + name
+}
+
+// capacity is the number of elements, that the LLVM representation of a set type can hold.
+func capacity(s ast.Set) int64 {
+	switch {
+	case s.High < 32:
+		return 32
+	case s.High < 64:
+		return 64
+	default:
+		return ast.MaxSetElement + 1
+	}
+}
+
+// truncateSet drops elements of the set on top of the stack, that its representation in the ir package can't hold.
+func (g *generator) truncateSet(s ast.Set) {
+	c := capacity(s)
+	if c > ast.MaxSetElement {
+		return
+	}
+	// Sets of up to 64 elements only keep the first word
+	g.emit("drop")
+	g.emit("drop")
+	g.emit("drop")
+	if c < 64 {
+		g.emit("i64.const %d", int64(1)<<c-1)
+		g.emit("i64.and")
+	}
+	g.zero(ast.INT64)
+	g.zero(ast.INT64)
+	g.zero(ast.INT64)
+}
+
+// fail reports a runtime error at the position passed to a helper in the parameters $line and $col.
+func fail(code int) string {
+	return fmt.Sprintf("      i32.const %d\n      local.get $line\n      local.get $col\n      call $gila_runtime_error\n      unreachable\n", code)
+}
+
+var helperNames = map[ast.Operation]string{
+	ast.PLUS: "add", ast.MINUS: "sub", ast.MULTIPLY: "mul", ast.DIV: "div", ast.MOD: "mod",
+}
+
+// helperType is the name of an integer type in the names of helpers, the same as in the cgen package.
+func helperType(t ast.Type) string {
+	switch {
+	case valueType(t) == "i64":
+		return "int64"
+	case ast.IsUnsigned(t):
+		return "uint32"
+	default:
+		return "int32"
+	}
+}
+
+// arithmeticHelper defines a function, that performs an operation on integers of type t and reports runtime errors.
+// It returns the name of the function.
+func (g *generator) arithmeticHelper(op ast.Operation, t ast.Type) string {
+	name := fmt.Sprintf("gila_%s_%s", helperNames[op], helperType(t))
+	if _, ok := g.helpers[name]; ok {
+		return "$" + name
+	}
+	vt := valueType(t)
+	var body string
+	switch op {
+	case ast.DIV, ast.MOD:
+		body = g.divisionBody(op, t)
+	default:
+		body = arithmeticBody(op, t)
+	}
+	g.helpers[name] = fmt.Sprintf("  (func $%s (param $a %s) (param $b %s) (param $line i32) (param $col i32) (result %s)\n%s  )\n",
+		name, vt, vt, vt, body)
+	return "$" + name
+}
+
+func arithmeticBody(op ast.Operation, t ast.Type) string {
+	o := instructions[op]
+	if valueType(t) == "i32" {
+		// The operation can't overflow in 64 bits, the result has to fit into 32 bits
+		s := sign(t)
+		return fmt.Sprintf(`    (local $r i64)
+    local.get $a
+    i64.extend_i32_%s
+    local.get $b
+    i64.extend_i32_%s
+    i64.%s
+    local.tee $r
+    local.get $r
+    i32.wrap_i64
+    i64.extend_i32_%s
+    i64.ne
+    if
+%s    end
+    local.get $r
+    i32.wrap_i64
+`, s, s, o, s, fail(rtl.ErrorOverflow))
+	}
+	var overflow string
+	switch op {
+	case ast.PLUS:
+		// The sign of the result differs from the signs of both operands
+		overflow = `    local.get $a
+    local.get $r
+    i64.xor
+    local.get $b
+    local.get $r
+    i64.xor
+    i64.and
+    i64.const 0
+    i64.lt_s
+`
+	case ast.MINUS:
+		// The operands have different signs and the sign of the result differs from the first one
+		overflow = `    local.get $a
+    local.get $b
+    i64.xor
+    local.get $a
+    local.get $r
+    i64.xor
+    i64.and
+    i64.const 0
+    i64.lt_s
+`
+	default:
+		// Division by -1 would trap on the smallest integer
+		overflow = `    local.get $a
+    i64.const -1
+    i64.eq
+    if (result i32)
+      local.get $b
+      i64.const -9223372036854775808
+      i64.eq
+    else
+      local.get $a
+      i64.eqz
+      if (result i32)
+        i32.const 0
+      else
+        local.get $r
+        local.get $a
+        i64.div_s
+        local.get $b
+        i64.ne
+      end
+    end
+`
+	}
+	return fmt.Sprintf(`    (local $r i64)
+    local.get $a
+    local.get $b
+    i64.%s
+    local.set $r
+%s    if
+%s    end
+    local.get $r
+`, o, overflow, fail(rtl.ErrorOverflow))
+}
+
+func (g *generator) divisionBody(op ast.Operation, t ast.Type) string {
+	vt := valueType(t)
+	o := "div"
+	if op == ast.MOD {
+		o = "rem"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "    local.get $b\n    %s.eqz\n    if\n%s    end\n", vt, fail(rtl.ErrorDivisionByZero))
+	if !ast.IsUnsigned(t) {
+		// The smallest integer divided by -1 doesn't fit into its type
+		min := int64(math.MinInt32)
+		if vt == "i64" {
+			min = math.MinInt64
+		}
+		fmt.Fprintf(&b, "    local.get $b\n    %s.const -1\n    %s.eq\n    local.get $a\n    %s.const %d\n    %s.eq\n    i32.and\n    if\n",
+			vt, vt, vt, min, vt)
+		if g.options.OverflowChecks {
+			b.WriteString(fail(rtl.ErrorOverflow))
+		}
+		if op == ast.DIV {
+			b.WriteString("      local.get $a\n")
+		} else {
+			fmt.Fprintf(&b, "      %s.const 0\n", vt)
+		}
+		b.WriteString("      return\n    end\n")
+	}
+	fmt.Fprintf(&b, "    local.get $a\n    local.get $b\n    %s.%s_%s\n", vt, o, sign(t))
+	return b.String()
+}
@@ -0,0 +1,536 @@
+// Package wasm translates programs to WebAssembly modules in the text format (WAT), so they can run in a browser
+// or a local wasm runtime. The module imports write, writeln, read and readln lowered to the same typed functions
+// as fce.c provides, from the module "gila", and exports its main program and memory, which holds string literals.
+//
+// Integers follow the semantics of the code emitted by the ir package: integer, cardinal and narrower types are
+// i32 values, int64 is i64. Sets are four i64 values, which are truncated to the representation of their type.
+// The multi-value and sign-extension features of WebAssembly 2.0 are required.
+package wasm
+
+import (
+	"fmt"
+	"gitlab.fit.cvut.cz/fedorgle/gila/ast"
+	"gitlab.fit.cvut.cz/fedorgle/gila/checker"
+	"sort"
+	"strings"
+)
+
+// Options change the generated code.
+type Options struct {
+	// OverflowChecks stop the program on integer overflow, the same way as ir.Options do.
+	// Division by zero is always reported.
+	OverflowChecks bool
+}
+
+// imports are the functions provided by the host, the same as in fce.c. Reading functions receive the current
+// value of a variable and return it, when there is no number to read. runtime_error must not return.
+const imports = `  (import "gila" "write_integer" (func $gila_write_integer (param i64 i32)))
+  (import "gila" "write_real" (func $gila_write_real (param f64 i32 i32)))
+  (import "gila" "write_boolean" (func $gila_write_boolean (param i32 i32)))
+  (import "gila" "write_string" (func $gila_write_string (param i32 i32 i32)))
+  (import "gila" "write_newline" (func $gila_write_newline))
+  (import "gila" "read_integer" (func $gila_read_integer (param i64) (result i64)))
+  (import "gila" "read_real" (func $gila_read_real (param f64) (result f64)))
+  (import "gila" "read_newline" (func $gila_read_newline))
+  (import "gila" "runtime_error" (func $gila_runtime_error (param i32 i32 i32)))
+`
+
+// setWords is the number of i64 values, that represent a set.
+const setWords = 4
+
+// symbol is a variable or a constant visible in the function being generated.
+type symbol struct {
+	// local is the name of the local, sets use setWords locals with the suffixes .0 to .3
+	local    string
+	t        ast.Type
+	constant bool
+	value    int64
+}
+
+type scope struct {
+	parent  *scope
+	symbols map[string]*symbol
+}
+
+func (s *scope) lookup(name string) *symbol {
+	if v, ok := s.symbols[name]; ok {
+		return v
+	} else if s.parent != nil {
+		return s.parent.lookup(name)
+	}
+	return nil
+}
+
+type generator struct {
+	info      *checker.Info
+	options   Options
+	functions map[string]*ast.Function
+	helpers   map[string]string
+	// data holds string literals, strings maps them to their offsets
+	data    strings.Builder
+	strings map[string]int
+
+	// The function being generated
+	function *ast.Function
+	code     strings.Builder
+	indent   int
+	locals   []string
+	// used counts the locals declared for each name, so that variables in nested blocks get unique names
+	used   map[string]int
+	scope  *scope
+	labels int
+	// breaks are the labels of the blocks around the enclosing loops
+	breaks []string
+}
+
+// Generate type checks a program and translates it into a WebAssembly module. Errors in the program panic.
+func Generate(program *ast.Program, options Options) string {
+	if program.Library {
+		panic(fmt.Sprintf("Library %s can only be compiled by the llvm backend.", program.Name))
+	}
+	if program.Unit || len(program.Uses) > 0 {
+		panic(fmt.Sprintf("Units can only be compiled by the llvm backend, %s is a unit or uses units.", program.Name))
+	}
+	g := &generator{
+		info:      checker.Check(program),
+		options:   options,
+		functions: make(map[string]*ast.Function),
+		helpers:   make(map[string]string),
+		strings:   make(map[string]int),
+	}
+	var order []string
+	for _, f := range program.Functions {
+		name := f.Signature.Name
+		if _, ok := g.functions[name]; !ok {
+			order = append(order, name)
+		}
+		if f.Signature.External {
+			panic(fmt.Sprintf("External function %s is only supported by the llvm backend.", f.Signature.Name))
+		}
+		// Forward declarations are replaced by implementations
+		if f.Body != nil || g.functions[name] == nil {
+			g.functions[name] = f
+		}
+	}
+	var functions []string
+	for _, name := range order {
+		f := g.functions[name]
+		if f.Body == nil {
+			panic(fmt.Sprintf("Function %s is declared, but never implemented.", name))
+		}
+		functions = append(functions, g.generateFunction(f))
+	}
+
+	var out strings.Builder
+	out.WriteString("(module\n")
+	out.WriteString(imports)
+	pages := g.data.Len()/65536 + 1
+	fmt.Fprintf(&out, "  (memory (export \"memory\") %d)\n", pages)
+	if g.data.Len() > 0 {
+		fmt.Fprintf(&out, "  (data (i32.const 0) %s)\n", quote(g.data.String()))
+	}
+	names := make([]string, 0, len(g.helpers))
+	for name := range g.helpers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out.WriteString(g.helpers[name])
+	}
+	for _, f := range functions {
+		out.WriteString(f)
+	}
+	out.WriteString(")\n")
+	return out.String()
+}
+
+// valueTypes are the wasm types, that represent a value of type t.
+func valueTypes(t ast.Type) []string {
+	if _, ok := t.(ast.Set); ok {
+		return []string{"i64", "i64", "i64", "i64"}
+	}
+	switch t {
+	case ast.VOID:
+		return nil
+	case ast.INT64:
+		return []string{"i64"}
+	case ast.REAL:
+		return []string{"f64"}
+	default:
+		return []string{"i32"}
+	}
+}
+
+// valueType is the wasm type of a scalar type.
+func valueType(t ast.Type) string {
+	return valueTypes(t)[0]
+}
+
+func isSet(t ast.Type) bool {
+	_, ok := t.(ast.Set)
+	return ok
+}
+
+// functionName is the name of a function in the module. Names prefixed with gila_ are used by the generated code.
+func functionName(name string) string {
+	if strings.HasPrefix(name, "gila_") {
+		return "$" + name + "@"
+	}
+	return "$" + name
+}
+
+// quote returns a string in the text format, bytes outside of printable ASCII are escaped.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&b, "\\%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func (g *generator) emit(format string, args ...interface{}) {
+	g.code.WriteString(strings.Repeat("  ", g.indent))
+	fmt.Fprintf(&g.code, format, args...)
+	g.code.WriteString("\n")
+}
+
+// declare adds a local for a variable. Its name is unique within the function.
+func (g *generator) declare(name string, t ast.Type, param bool) *symbol {
+	g.used[name]++
+	local := "$" + name
+	if n := g.used[name]; n > 1 {
+		local = fmt.Sprintf("$%s@%d", name, n)
+	}
+	s := &symbol{local: local, t: t}
+	g.scope.symbols[name] = s
+	if !param {
+		g.addLocal(s)
+	}
+	return s
+}
+
+func (g *generator) addLocal(s *symbol) {
+	for i, vt := range valueTypes(s.t) {
+		g.locals = append(g.locals, fmt.Sprintf("(local %s %s)", s.word(i), vt))
+	}
+}
+
+// word returns the name of the local, that holds the i-th value of a variable.
+func (s *symbol) word(i int) string {
+	if isSet(s.t) {
+		return fmt.Sprintf("%s.%d", s.local, i)
+	}
+	return s.local
+}
+
+func (g *generator) generateFunction(f *ast.Function) string {
+	g.function, g.labels, g.breaks = f, 0, nil
+	g.code.Reset()
+	g.indent = 2
+	g.locals = nil
+	g.used = make(map[string]int)
+	g.scope = &scope{symbols: make(map[string]*symbol)}
+	var header []string
+	for _, p := range f.Signature.Parameters {
+		s := g.declare(p.Name, p.Type, true)
+		for i, vt := range valueTypes(p.Type) {
+			header = append(header, fmt.Sprintf("(param %s %s)", s.word(i), vt))
+		}
+	}
+	if results := valueTypes(f.Signature.Return); len(results) > 0 {
+		header = append(header, fmt.Sprintf("(result %s)", strings.Join(results, " ")))
+		// The result is assigned to a variable named after the function
+		result := &symbol{local: "$result@", t: f.Signature.Return}
+		g.scope.symbols[f.Signature.Name] = result
+		g.addLocal(result)
+	}
+	g.statement(f.Body)
+	g.pushResult()
+
+	var out strings.Builder
+	name := functionName(f.Signature.Name)
+	if f.Signature.Name == "main" {
+		name += ` (export "main")`
+	}
+	fmt.Fprintf(&out, "  (func %s", name)
+	for _, h := range header {
+		out.WriteString(" " + h)
+	}
+	out.WriteString("\n")
+	for _, l := range g.locals {
+		fmt.Fprintf(&out, "    %s\n", l)
+	}
+	out.WriteString(g.code.String())
+	out.WriteString("  )\n")
+	g.function, g.scope = nil, nil
+	return out.String()
+}
+
+// pushResult pushes the result of the current function.
+func (g *generator) pushResult() {
+	if g.function.Signature.Return != ast.VOID {
+		g.load(&symbol{local: "$result@", t: g.function.Signature.Return})
+	}
+}
+
+func (g *generator) lookup(name string) *symbol {
+	if s := g.scope.lookup(name); s != nil {
+		return s
+	}
+	panic(fmt.Sprintf("Undefined symbol %s.", name))
+}
+
+func (g *generator) load(s *symbol) {
+	if s.constant {
+		g.constant(s.value, s.t)
+		return
+	}
+	for i := range valueTypes(s.t) {
+		g.emit("local.get %s", s.word(i))
+	}
+}
+
+func (g *generator) store(s *symbol) {
+	for i := len(valueTypes(s.t)) - 1; i >= 0; i-- {
+		g.emit("local.set %s", s.word(i))
+	}
+}
+
+// zero pushes the value of variables, that have not been assigned yet.
+func (g *generator) zero(t ast.Type) {
+	for _, vt := range valueTypes(t) {
+		g.emit("%s.const 0", vt)
+	}
+}
+
+func (g *generator) statement(node ast.Statement) {
+	switch n := node.(type) {
+	case *ast.Block:
+		g.scope = &scope{parent: g.scope, symbols: make(map[string]*symbol)}
+		for _, s := range n.Statements {
+			g.statement(s)
+		}
+		g.scope = g.scope.parent
+	case *ast.VariableDeclaration:
+		s := g.declare(n.Name, n.Type, false)
+		g.zero(n.Type)
+		g.store(s)
+	case *ast.ConstantDeclaration:
+		// Constants are replaced by their values, integer constants can still be referred to by name
+		if l, ok := n.Value.(*ast.Literal); ok {
+			g.scope.symbols[n.Name] = &symbol{t: g.info.TypeOf(l), constant: true, value: l.Value}
+		}
+	case *ast.Assignment:
+		g.assign(n)
+	case *ast.ProcedureCall:
+		g.procedureCall(n)
+	case *ast.If:
+		g.expression(n.Condition)
+		g.emit("if")
+		g.nested(n.Then)
+		if n.Else != nil {
+			g.emit("else")
+			g.nested(n.Else)
+		}
+		g.emit("end")
+	case *ast.While:
+		g.loop(func() { g.expression(n.Condition) }, n.Body, nil)
+	case *ast.For:
+		g.assign(n.Initial)
+		variable := g.lookup(n.Initial.Variable.Name)
+		step := int64(-1)
+		if n.Upto {
+			step = 1
+		}
+		// The loop runs while the variable differs from the target, the same way as in the ir package
+		condition := func() {
+			targetType := g.info.TypeOf(n.Target)
+			t := variable.t
+			if targetType != t {
+				t = ast.INT64
+			}
+			g.load(variable)
+			g.convert(variable.t, t)
+			g.expression(n.Target)
+			g.convert(targetType, t)
+			g.emit("%s.ne", valueType(t))
+		}
+		update := func() {
+			g.load(variable)
+			g.constant(step, variable.t)
+			g.emit("%s.add", valueType(variable.t))
+			g.normalize(variable.t)
+			g.store(variable)
+		}
+		g.loop(condition, n.Body, update)
+	case *ast.Break:
+		// break outside of a loop does nothing
+		if len(g.breaks) > 0 {
+			g.emit("br %s", g.breaks[len(g.breaks)-1])
+		}
+	case *ast.Exit:
+		g.pushResult()
+		g.emit("return")
+	default:
+		panic("Unknown statement type!")
+	}
+}
+
+// nested emits a statement inside of a structured instruction.
+func (g *generator) nested(s ast.Statement) {
+	g.indent++
+	g.statement(s)
+	g.indent--
+}
+
+// loop emits a loop, that runs the body while the condition holds. update is executed after every iteration,
+// that didn't break.
+func (g *generator) loop(condition func(), body ast.Statement, update func()) {
+	g.labels++
+	exit, top := fmt.Sprintf("$break%d", g.labels), fmt.Sprintf("$loop%d", g.labels)
+	g.emit("block %s", exit)
+	g.indent++
+	g.emit("loop %s", top)
+	g.indent++
+	condition()
+	g.emit("i32.eqz")
+	g.emit("br_if %s", exit)
+	g.breaks = append(g.breaks, exit)
+	g.statement(body)
+	g.breaks = g.breaks[:len(g.breaks)-1]
+	if update != nil {
+		update()
+	}
+	g.emit("br %s", top)
+	g.indent--
+	g.emit("end")
+	g.indent--
+	g.emit("end")
+}
+
+func (g *generator) assign(a *ast.Assignment) {
+	g.expression(a.Value)
+	g.convert(g.info.TypeOf(a.Value), g.info.TypeOf(&a.Variable))
+	g.store(g.lookup(a.Variable.Name))
+}
+
+func (g *generator) procedureCall(pc *ast.ProcedureCall) {
+	switch {
+	case checker.Intrinsics[pc.Name]:
+		g.intrinsic(pc)
+	case pc.Name == "inc" || pc.Name == "dec":
+		variable := g.lookup(pc.Args[0].(*ast.Variable).Name)
+		step, op := int64(1), ast.PLUS
+		if pc.Name == "dec" {
+			step, op = -1, ast.MINUS
+		}
+		g.load(variable)
+		if g.options.OverflowChecks {
+			t := checker.StepType(variable.t)
+			g.convert(variable.t, t)
+			g.constant(1, t)
+			g.checked(op, t, pc.Position)
+			g.convert(t, variable.t)
+			g.store(variable)
+			return
+		}
+		g.constant(step, variable.t)
+		g.emit("%s.add", valueType(variable.t))
+		g.normalize(variable.t)
+		g.store(variable)
+	default:
+		result := g.call(pc.Name, pc.Args)
+		for range valueTypes(result) {
+			g.emit("drop")
+		}
+	}
+}
+
+func (g *generator) intrinsic(pc *ast.ProcedureCall) {
+	switch pc.Name {
+	case "write", "writeln":
+		for _, a := range pc.Args {
+			g.write(a)
+		}
+		if pc.Name == "writeln" {
+			g.emit("call $gila_write_newline")
+		}
+	case "read", "readln":
+		for _, a := range pc.Args {
+			variable := g.lookup(a.(*ast.Variable).Name)
+			g.load(variable)
+			if variable.t == ast.REAL {
+				g.emit("call $gila_read_real")
+			} else {
+				g.convert(variable.t, ast.INT64)
+				g.emit("call $gila_read_integer")
+				g.convert(ast.INT64, variable.t)
+			}
+			g.store(variable)
+		}
+		if pc.Name == "readln" {
+			g.emit("call $gila_read_newline")
+		}
+	}
+}
+
+func (g *generator) write(a ast.Expression) {
+	format, formatted := a.(*ast.Format)
+	if formatted {
+		a = format.Value
+	}
+	t := g.info.TypeOf(a)
+	if s, ok := a.(ast.StringLiteral); ok {
+		t = ast.STRING
+		g.emit("i32.const %d", g.stringOffset(s.Value))
+		g.emit("i32.const %d", len(s.Value))
+	} else {
+		g.expression(a)
+		if ast.IsInteger(t) {
+			g.convert(t, ast.INT64)
+		}
+	}
+	// Field widths are passed to the runtime as 32-bit integers
+	if formatted {
+		g.expression(format.Width)
+		g.convert(g.info.TypeOf(format.Width), ast.INT)
+	} else {
+		g.emit("i32.const 0")
+	}
+	switch {
+	case t == ast.REAL:
+		if formatted && format.Precision != nil {
+			g.expression(format.Precision)
+			g.convert(g.info.TypeOf(format.Precision), ast.INT)
+		} else {
+			g.emit("i32.const -1")
+		}
+		g.emit("call $gila_write_real")
+	case t == ast.BOOLEAN:
+		g.emit("call $gila_write_boolean")
+	case t == ast.STRING:
+		g.emit("call $gila_write_string")
+	case ast.IsInteger(t):
+		g.emit("call $gila_write_integer")
+	default:
+		panic(fmt.Sprintf("Can not write %v.", a))
+	}
+}
+
+// stringOffset returns the offset of a string literal in memory.
+func (g *generator) stringOffset(s string) int {
+	if offset, ok := g.strings[s]; ok {
+		return offset
+	}
+	offset := g.data.Len()
+	g.strings[s] = offset
+	g.data.WriteString(s)
+	return offset
+}
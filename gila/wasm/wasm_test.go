@@ -0,0 +1,132 @@
+package wasm
+
+import (
+	"bytes"
+	"gitlab.fit.cvut.cz/fedorgle/gila/interp"
+	"gitlab.fit.cvut.cz/fedorgle/gila/lexer"
+	"gitlab.fit.cvut.cz/fedorgle/gila/parser"
+	"gitlab.fit.cvut.cz/fedorgle/gila/rtl"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func generate(source string, options Options) string {
+	return Generate(parser.New(lexer.New(strings.NewReader(source))).Parse(), options)
+}
+
+func Test_Generate(t *testing.T) {
+	code := generate(`program loops;
+function gila_twice(x: integer): integer;
+begin
+	gila_twice := x * 2;
+end;
+var i: integer; b: byte; s: set of 0..31;
+begin
+	i := 0;
+	while i < 10 do
+	begin
+		if i = 5 then break;
+		i := i + 1;
+	end;
+	b := i;
+	s := [i];
+	writeln('i = ', gila_twice(i), ' ', 3 in s);
+end.`, Options{})
+	expected := []string{
+		`(memory (export "memory") 1)`,
+		`(data (i32.const 0) "i =  ")`,
+		"(func $gila_twice@ (param $x i32) (result i32)",
+		`(func $main (export "main")`,
+		"(local $s.3 i64)",
+		// Loops are blocks, that are left when the condition doesn't hold
+		"block $break1\n      loop $loop1",
+		"i32.lt_s\n        i32.eqz\n        br_if $break1",
+		"if\n          br $break1\n        end",
+		"br $loop1",
+		// Narrow integers are normalized after every conversion
+		"local.get $i\n    i32.const 255\n    i32.and\n    local.set $b",
+		"call $gila_set_element",
+		"i64.const 4294967295\n    i64.and",
+		"call $gila_write_string",
+	}
+	for _, e := range expected {
+		if !strings.Contains(code, e) {
+			t.Errorf("Expected %q in the generated code:\n%s", e, code)
+		}
+	}
+	if strings.Contains(code, "$gila_add_int32") {
+		t.Error("Overflow checks were generated without OverflowChecks.")
+	}
+	if !strings.Contains(generate("program p; var x: integer; begin x := 1; x := x + 1; end.", Options{OverflowChecks: true}), "call $gila_add_int32") {
+		t.Error("Overflow checks were not generated.")
+	}
+}
+
+// tempDir creates a directory with the host of the runtime library. Modules are assembled by wat2wasm
+// and run by node, the tests are skipped, when they are not installed.
+func tempDir(t *testing.T) string {
+	for _, tool := range []string{"wat2wasm", "node"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	dir, err := ioutil.TempDir("", "wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "host.js"), []byte(rtl.Host), 0644)
+	return dir
+}
+
+// execute assembles a module and runs it with the given input. It returns the output and the error of node.
+func execute(t *testing.T, dir, code, input string) (string, string, error) {
+	wat, module := filepath.Join(dir, "program.wat"), filepath.Join(dir, "program.wasm")
+	ioutil.WriteFile(wat, []byte(code), 0644)
+	if out, err := exec.Command("wat2wasm", "-o", module, wat).CombinedOutput(); err != nil {
+		t.Fatalf("wat2wasm failed: %s\n%s", out, code)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("node", filepath.Join(dir, "host.js"), module)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// Test_Samples compares the output of the modules with the output of the interpreter.
+func Test_Samples(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	samples, _ := filepath.Glob("../../samples/*.mila")
+	for _, sample := range samples {
+		source, _ := ioutil.ReadFile(sample)
+		var expected bytes.Buffer
+		program := parser.New(lexer.New(bytes.NewReader(source))).Parse()
+		interp.Run(program, strings.NewReader("7\n"), &expected, interp.Options{})
+		out, _, err := execute(t, dir, generate(string(source), Options{}), "7\n")
+		if err != nil {
+			t.Errorf("%s failed: %v", sample, err)
+		}
+		if out != expected.String() {
+			t.Errorf("%s printed %q, the interpreter printed %q", sample, out, expected.String())
+		}
+	}
+}
+
+func Test_RuntimeErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, c := range rtl.ErrorCases {
+		_, stderr, err := execute(t, dir, generate(c.Source, Options{OverflowChecks: c.OverflowChecks}), "")
+		if message := strings.TrimSpace(stderr); message != c.Message() {
+			t.Errorf("%q reported %q, expected %q", c.Source, message, c.Message())
+		}
+		if (err == nil) != (c.Error == nil) {
+			t.Errorf("%q exited with %v", c.Source, err)
+		}
+	}
+}